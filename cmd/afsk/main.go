@@ -0,0 +1,65 @@
+// Command afsk converts between text and Bell 202 AFSK recordings as used by
+// the neo146 modem endpoint. Requests are encoded into a WAV file that can
+// be played over a radio or uploaded, and replies are decoded back to text.
+//
+//	afsk -o request.wav weather istanbul
+//	curl --data-binary @request.wav https://example.org/modem -o reply.wav
+//	afsk -d reply.wav
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"neo146/utils"
+)
+
+func main() {
+	decode := flag.String("d", "", "WAV file to decode and print")
+	output := flag.String("o", "request.wav", "WAV file to write the request to")
+	sampleRate := flag.Int("rate", 22050, "sample rate of the request in Hz")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-o file] [-rate hz] <command>\n       %s -d <file>\n\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *decode != "" {
+		data, err := os.ReadFile(*decode)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		samples, rate, err := utils.ReadWAV(data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		frames := utils.DecodeAFSK(samples, rate)
+		if len(frames) == 0 {
+			fmt.Fprintln(os.Stderr, "no valid frames in the recording")
+			os.Exit(1)
+		}
+		// Long replies are split across frames
+		for _, frame := range frames {
+			os.Stdout.Write(frame)
+		}
+		fmt.Println()
+		return
+	}
+
+	if flag.NArg() == 0 || *sampleRate < utils.AFSKMinSampleRate {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	request := []byte(strings.Join(flag.Args(), " "))
+	wav := utils.WriteWAV(utils.EncodeAFSK([][]byte{request}, *sampleRate), *sampleRate)
+	if err := os.WriteFile(*output, wav, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
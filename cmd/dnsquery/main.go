@@ -0,0 +1,55 @@
+// Command dnsquery runs a query through the neo146 DNS gateway, for networks
+// where only DNS resolution works.
+//
+//	dnsquery -zone gw.example.org w istanbul
+//	dnsquery -zone gw.example.org -resolver 127.0.0.1:5353 k tr Ankara
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"neo146/utils"
+)
+
+func main() {
+	zone := flag.String("zone", "", "zone delegated to the gateway, e.g. gw.example.org")
+	resolverAddr := flag.String("resolver", "", "resolver to query (host:port), the system resolver if empty")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of the whole query")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -zone <zone> [-resolver host:port] <w|k|s> <query>\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Services: w weather, k wiki (\"<lang> <query>\"), s search")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *zone == "" || flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var resolver *net.Resolver
+	if *resolverAddr != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "udp", *resolverAddr)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	response, err := utils.QueryDNSGateway(ctx, resolver, *zone, flag.Arg(0), strings.Join(flag.Args()[1:], " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(response)
+}
@@ -0,0 +1,85 @@
+// Command e2esms builds encrypted SMS requests for the neo146 gateway and
+// decrypts their replies. The client key is generated in the key file on
+// first use, and the gateway key is served at /api/e2e/key.
+//
+//	e2esms register
+//	e2esms -server <gateway key> request weather istanbul
+//	e2esms -server <gateway key> -nonce <request nonce> reply 'GW1|...' 'GW2|...'
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"neo146/utils"
+)
+
+func main() {
+	keyFile := flag.String("key", "e2e-client.key", "file holding the private key of the client")
+	serverKey := flag.String("server", "", "base64 public key of the gateway")
+	nonce := flag.String("nonce", "", "nonce printed for the request, checks that a reply answers it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-key file] register\n       %s [-key file] -server <key> request <command>\n       %s [-key file] -server <key> [-nonce nonce] reply <parts...>\n\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	privateKey, err := utils.LoadOrCreateE2EKey(*keyFile)
+	if err != nil {
+		fail(err)
+	}
+	if flag.Arg(0) == "register" {
+		fmt.Println(utils.E2EKeyCommand + " " + utils.FormatE2EKey(utils.E2EPublicKey(privateKey)))
+		return
+	}
+
+	if *serverKey == "" || flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	server, err := utils.ParseE2EKey(*serverKey)
+	if err != nil {
+		fail(err)
+	}
+	client := utils.NewE2EClient(server, privateKey)
+
+	switch flag.Arg(0) {
+	case "request":
+		sms, requestNonce, err := client.Request(strings.Join(flag.Args()[1:], " "))
+		if err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(os.Stderr, "nonce:", base64.StdEncoding.EncodeToString(requestNonce[:]))
+		fmt.Println(sms)
+	case "reply":
+		var requestNonce *[utils.E2ENonceSize]byte
+		if *nonce != "" {
+			data, err := base64.StdEncoding.DecodeString(*nonce)
+			if err != nil || len(data) != utils.E2ENonceSize {
+				fail(fmt.Errorf("invalid nonce"))
+			}
+			requestNonce = (*[utils.E2ENonceSize]byte)(data)
+		}
+		reply, err := client.Response(flag.Args()[1:], requestNonce)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println(reply)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
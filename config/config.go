@@ -2,9 +2,11 @@ package config
 
 import (
 	_ "embed"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -29,13 +31,153 @@ type Config struct {
 	SMSPassword   string
 	SMSSourceAddr string
 	OpenAPISpec   string
+	FeedAliases   map[string]string
+	AdminToken    string
+
+	// LogFormat is text or json, and LogLevel the lowest level logged
+	LogFormat string
+	LogLevel  slog.Level
+
+	// BroadcastInterval is the delay between messages of a broadcast
+	BroadcastInterval time.Duration
+	// SMSSegmentCost is the estimated cost of a single SMS segment
+	SMSSegmentCost float64
+	// SMSPrefixCosts are segment costs for destination prefixes, keyed by
+	// prefix or by provider:prefix
+	SMSPrefixCosts map[string]float64
+	// SMSCostCurrency is the currency of SMS costs and budgets
+	SMSCostCurrency string
+	// SMSDailyBudget and SMSMonthlyBudget cap SMS spending, 0 for no cap
+	SMSDailyBudget   float64
+	SMSMonthlyBudget float64
+	// SMSBudgetWarn is the share of a budget from which replies are
+	// shortened to SMSBudgetLowParts parts
+	SMSBudgetWarn     float64
+	SMSBudgetLowParts int
+	// SMSFreeSegments and SMSSubscriberSegments are the SMS segments a
+	// reply to a user of the tier may take, 0 for no limit
+	SMSFreeSegments       int
+	SMSSubscriberSegments int
+	// SummaryLength is the number of characters "sum" summaries take at
+	// most
+	SummaryLength int
+
+	// MailboxTTL is how long stored messages are kept for their recipient
+	MailboxTTL time.Duration
+	// MailboxSendLimit is the number of messages a handle can send per hour
+	MailboxSendLimit int
+
+	// IdentitySecretFile holds the secrets identities are pseudonymized with
+	// at rest, one per line with the current secret first. It is generated
+	// if it does not exist.
+	IdentitySecretFile string
+
+	// E2EKeyFile holds the private key of encrypted SMS, it is generated if
+	// it does not exist. Encrypted SMS are disabled if it is empty.
+	E2EKeyFile string
+	// E2EMaxAge is how far the time of an encrypted request may be from now
+	E2EMaxAge time.Duration
+
+	// EmailAddress is the gateway mailbox, the email channel is disabled if
+	// it is empty
+	EmailAddress    string
+	EmailListenAddr string
+	EmailRateLimit  int
+	// EmailAuthServID is the authserv-id of the Authentication-Results
+	// headers of the MTA, mailbox and alerts over email are disabled if it is
+	// empty
+	EmailAuthServID string
+	SMTPHost        string
+	SMTPPort        string
+	SMTPUsername    string
+	SMTPPassword    string
+
+	// MatrixAccessToken is the token of the bot account, the Matrix channel
+	// is disabled if it is empty
+	MatrixAccessToken   string
+	MatrixHomeserverURL string
+	MatrixRateLimit     int
+
+	// XMPPJID is the bare JID of the bot account, the XMPP channel is
+	// disabled if it is empty
+	XMPPJID       string
+	XMPPPassword  string
+	XMPPServer    string
+	XMPPRateLimit int
+
+	// GopherListenAddr is the address of the Gopher server, it is disabled
+	// if empty. GopherHostname and GopherPort are the public address used in
+	// menus.
+	GopherListenAddr string
+	GopherHostname   string
+	GopherPort       int
+
+	// GeminiListenAddr is the address of the Gemini server, it is disabled
+	// if empty. A self-signed certificate for GeminiHostname is generated in
+	// GeminiCertFile and GeminiKeyFile if they do not exist.
+	GeminiListenAddr string
+	GeminiHostname   string
+	GeminiCertFile   string
+	GeminiKeyFile    string
+
+	// TelnetListenAddr is the address of the telnet BBS, it is disabled if
+	// empty. TelnetRateLimit is the number of content requests per minute
+	// and session.
+	TelnetListenAddr string
+	TelnetRateLimit  int
+
+	// FingerListenAddr is the address of the finger server, it is disabled
+	// if empty. FingerMaxResponse is the response size limit in bytes.
+	FingerListenAddr  string
+	FingerMaxResponse int
+
+	// DNSListenAddr is the UDP address of the DNS gateway, it is disabled if
+	// empty. DNSZone is the zone delegated to it, DNSTTL how long responses
+	// are cached and DNSRateLimit the number of queries per minute per
+	// resolver.
+	DNSListenAddr string
+	DNSZone       string
+	DNSTTL        time.Duration
+	DNSRateLimit  int
+
+	// APRSCallsign enables the APRS channel. It uses the KISS TNC at
+	// APRSKISSAddr if set, or logs in to the APRS-IS server APRSServer.
+	APRSCallsign  string
+	APRSServer    string
+	APRSPasscode  string
+	APRSKISSAddr  string
+	APRSRateLimit int
+
+	// MeshtasticAddr enables the Meshtastic channel, host[:port] of a node
+	// with the TCP API or the path of a serial device
+	MeshtasticAddr      string
+	MeshtasticRateLimit int
+
+	// SIPListenAddr is the UDP address of the SIP voice channel, it is
+	// disabled if empty. SIPPublicIP is announced for media behind NAT,
+	// SIPMaxCalls limits simultaneous calls and SIPRateLimit is the number
+	// of calls per hour per IP address.
+	SIPListenAddr string
+	SIPPublicIP   string
+	SIPMaxCalls   int
+	SIPRateLimit  int
+
+	// IVRTTSCommand is a speech synthesizer writing WAV to stdout, used to
+	// read out answers. IVRPromptsDir holds pre-recorded word segments,
+	// used if no synthesizer is set.
+	IVRTTSCommand string
+	IVRPromptsDir string
+
+	// ModemMaxResponse is the reply size limit of the AFSK modem endpoint in
+	// bytes
+	ModemMaxResponse int
 }
 
 // NewConfig creates a new Config instance
 func NewConfig() (*Config, error) {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
-		fmt.Println("Warning: Error loading .env file")
+		slog.Warn("Error loading .env file", "error", err)
 	}
 
 	// Set environment
@@ -56,5 +198,157 @@ func NewConfig() (*Config, error) {
 		SMSPassword:   os.Getenv("SMS_PASSWORD"),
 		SMSSourceAddr: os.Getenv("SMS_SOURCE_ADDR"),
 		OpenAPISpec:   openAPISpec,
+		FeedAliases:   parseAliases(os.Getenv("FEED_ALIASES")),
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+		LogFormat:     getEnv("LOG_FORMAT", "text"),
+		LogLevel:      parseLogLevel(os.Getenv("LOG_LEVEL"), slog.LevelInfo),
+
+		BroadcastInterval:     parseDuration(os.Getenv("BROADCAST_INTERVAL"), time.Second),
+		SMSSegmentCost:        parseFloat(os.Getenv("SMS_SEGMENT_COST"), 0.05),
+		SMSPrefixCosts:        parseCosts(os.Getenv("SMS_PREFIX_COSTS")),
+		SMSCostCurrency:       getEnv("SMS_COST_CURRENCY", "EUR"),
+		SMSDailyBudget:        parseFloat(os.Getenv("SMS_DAILY_BUDGET"), 0),
+		SMSMonthlyBudget:      parseFloat(os.Getenv("SMS_MONTHLY_BUDGET"), 0),
+		SMSBudgetWarn:         parseFloat(os.Getenv("SMS_BUDGET_WARN"), 0.8),
+		SMSBudgetLowParts:     parseInt(os.Getenv("SMS_BUDGET_LOW_PARTS"), 2),
+		SMSFreeSegments:       parseInt(os.Getenv("SMS_FREE_SEGMENTS"), 10),
+		SMSSubscriberSegments: parseInt(os.Getenv("SMS_SUBSCRIBER_SEGMENTS"), 30),
+		SummaryLength:         parseInt(os.Getenv("SUMMARY_LENGTH"), 480),
+		MailboxTTL:            parseDuration(os.Getenv("MAILBOX_TTL"), 72*time.Hour),
+		MailboxSendLimit:      parseInt(os.Getenv("MAILBOX_SEND_LIMIT"), 10),
+		IdentitySecretFile:    getEnv("IDENTITY_SECRET_FILE", "identity.secret"),
+		E2EKeyFile:            os.Getenv("E2E_KEY_FILE"),
+		E2EMaxAge:             parseDuration(os.Getenv("E2E_MAX_AGE"), 24*time.Hour),
+
+		EmailAddress:    os.Getenv("EMAIL_ADDRESS"),
+		EmailListenAddr: getEnv("EMAIL_LISTEN_ADDR", ":2525"),
+		EmailRateLimit:  parseInt(os.Getenv("EMAIL_RATE_LIMIT"), 5),
+		EmailAuthServID: os.Getenv("EMAIL_AUTHSERV_ID"),
+		SMTPHost:        os.Getenv("SMTP_HOST"),
+		SMTPPort:        getEnv("SMTP_PORT", "587"),
+		SMTPUsername:    os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:    os.Getenv("SMTP_PASSWORD"),
+
+		MatrixAccessToken:   os.Getenv("MATRIX_ACCESS_TOKEN"),
+		MatrixHomeserverURL: getEnv("MATRIX_HOMESERVER_URL", "https://matrix.org"),
+		MatrixRateLimit:     parseInt(os.Getenv("MATRIX_RATE_LIMIT"), 5),
+
+		XMPPJID:       os.Getenv("XMPP_JID"),
+		XMPPPassword:  os.Getenv("XMPP_PASSWORD"),
+		XMPPServer:    os.Getenv("XMPP_SERVER"),
+		XMPPRateLimit: parseInt(os.Getenv("XMPP_RATE_LIMIT"), 5),
+
+		GopherListenAddr: os.Getenv("GOPHER_LISTEN_ADDR"),
+		GopherHostname:   getEnv("GOPHER_HOSTNAME", "localhost"),
+		GopherPort:       parseInt(os.Getenv("GOPHER_PORT"), 70),
+
+		GeminiListenAddr: os.Getenv("GEMINI_LISTEN_ADDR"),
+		GeminiHostname:   getEnv("GEMINI_HOSTNAME", "localhost"),
+		GeminiCertFile:   getEnv("GEMINI_CERT_FILE", "gemini.crt"),
+		GeminiKeyFile:    getEnv("GEMINI_KEY_FILE", "gemini.key"),
+
+		TelnetListenAddr: os.Getenv("TELNET_LISTEN_ADDR"),
+		TelnetRateLimit:  parseInt(os.Getenv("TELNET_RATE_LIMIT"), 10),
+
+		FingerListenAddr:  os.Getenv("FINGER_LISTEN_ADDR"),
+		FingerMaxResponse: parseInt(os.Getenv("FINGER_MAX_RESPONSE"), 2000),
+
+		DNSListenAddr: os.Getenv("DNS_LISTEN_ADDR"),
+		DNSZone:       os.Getenv("DNS_ZONE"),
+		DNSTTL:        parseDuration(os.Getenv("DNS_TTL"), 5*time.Minute),
+		DNSRateLimit:  parseInt(os.Getenv("DNS_RATE_LIMIT"), 60),
+
+		APRSCallsign:  os.Getenv("APRS_CALLSIGN"),
+		APRSServer:    os.Getenv("APRS_SERVER"),
+		APRSPasscode:  os.Getenv("APRS_PASSCODE"),
+		APRSKISSAddr:  os.Getenv("APRS_KISS_ADDR"),
+		APRSRateLimit: parseInt(os.Getenv("APRS_RATE_LIMIT"), 5),
+
+		MeshtasticAddr:      os.Getenv("MESHTASTIC_ADDR"),
+		MeshtasticRateLimit: parseInt(os.Getenv("MESHTASTIC_RATE_LIMIT"), 5),
+
+		SIPListenAddr: os.Getenv("SIP_LISTEN_ADDR"),
+		SIPPublicIP:   os.Getenv("SIP_PUBLIC_IP"),
+		SIPMaxCalls:   parseInt(os.Getenv("SIP_MAX_CALLS"), 4),
+		SIPRateLimit:  parseInt(os.Getenv("SIP_RATE_LIMIT"), 10),
+		IVRTTSCommand: os.Getenv("IVR_TTS_COMMAND"),
+		IVRPromptsDir: os.Getenv("IVR_PROMPTS_DIR"),
+
+		ModemMaxResponse: parseInt(os.Getenv("MODEM_MAX_RESPONSE"), 2000),
 	}, nil
 }
+
+// getEnv returns the value of an environment variable, or fallback if it
+// is empty
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseDuration parses a duration, returning fallback if value is empty or
+// invalid
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
+
+// parseFloat parses a float, returning fallback if value is empty or invalid
+func parseFloat(value string, fallback float64) float64 {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback
+	}
+	return number
+}
+
+// parseInt parses a positive integer, returning fallback if value is empty
+// or invalid
+func parseInt(value string, fallback int) int {
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return fallback
+	}
+	return number
+}
+
+// parseLogLevel parses a log level (debug, info, warn or error), returning
+// fallback if value is empty or invalid
+func parseLogLevel(value string, fallback slog.Level) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return fallback
+	}
+	return level
+}
+
+// parseAliases parses a comma separated list of name=value pairs
+func parseAliases(value string) map[string]string {
+	aliases := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		name, target, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		target = strings.TrimSpace(target)
+		if !found || name == "" || target == "" {
+			continue
+		}
+		aliases[name] = target
+	}
+	return aliases
+}
+
+// parseCosts parses a comma separated list of name=cost pairs, skipping
+// invalid costs
+func parseCosts(value string) map[string]float64 {
+	costs := make(map[string]float64)
+	for name, cost := range parseAliases(value) {
+		if number, err := strconv.ParseFloat(cost, 64); err == nil && number >= 0 {
+			costs[name] = number
+		}
+	}
+	return costs
+}
@@ -109,3 +109,32 @@ func TestNewConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAliases(t *testing.T) {
+	aliases := parseAliases("bianet=https://bianet.org/rss, medyascope = https://medyascope.tv/feed ,broken,=https://x.org,empty=")
+
+	if len(aliases) != 2 {
+		t.Fatalf("Expected 2 aliases, got %d: %v", len(aliases), aliases)
+	}
+	if aliases["bianet"] != "https://bianet.org/rss" {
+		t.Errorf("Expected bianet alias, got %q", aliases["bianet"])
+	}
+	if aliases["medyascope"] != "https://medyascope.tv/feed" {
+		t.Errorf("Expected trimmed medyascope alias, got %q", aliases["medyascope"])
+	}
+
+	if len(parseAliases("")) != 0 {
+		t.Error("Expected no aliases for empty value")
+	}
+}
+
+func TestParseCosts(t *testing.T) {
+	costs := parseCosts("90=0.04, Verimor:1=0.12,44=abc,49=-1")
+
+	if len(costs) != 2 {
+		t.Fatalf("Expected 2 costs, got %d: %v", len(costs), costs)
+	}
+	if costs["90"] != 0.04 || costs["Verimor:1"] != 0.12 {
+		t.Errorf("Unexpected costs: %v", costs)
+	}
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"neo146/services"
+	"neo146/utils"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminController handles the authenticated operator API
+type AdminController struct {
+	token            string
+	portalService    *services.PortalService
+	broadcastService *services.BroadcastService
+	costService      *services.CostService
+}
+
+// NewAdminController creates a new AdminController. An empty token disables
+// the admin API.
+func NewAdminController(
+	token string,
+	portalService *services.PortalService,
+	broadcastService *services.BroadcastService,
+	costService *services.CostService,
+) *AdminController {
+	return &AdminController{
+		token:            token,
+		portalService:    portalService,
+		broadcastService: broadcastService,
+		costService:      costService,
+	}
+}
+
+// portalEntryRequest is the request body for creating or updating entries
+type portalEntryRequest struct {
+	Section string `json:"section"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+}
+
+// broadcastRequest is the request body for creating broadcasts
+type broadcastRequest struct {
+	Message string `json:"message"`
+}
+
+// Authenticate checks the bearer token of admin API requests
+func (c *AdminController) Authenticate(ctx *fiber.Ctx) error {
+	if c.token == "" {
+		return ctx.Status(403).SendString("Admin API is disabled")
+	}
+
+	token, found := strings.CutPrefix(ctx.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+		return ctx.Status(401).SendString("Invalid admin token")
+	}
+
+	return ctx.Next()
+}
+
+// HandleListPortalEntries lists portal entries, optionally by section
+func (c *AdminController) HandleListPortalEntries(ctx *fiber.Ctx) error {
+	entries, err := c.portalService.ListEntries(ctx.Query("section"))
+	if err != nil {
+		return ctx.Status(500).SendString("Error listing portal entries: " + err.Error())
+	}
+	return ctx.JSON(entries)
+}
+
+// HandleCreatePortalEntry creates a new portal entry
+func (c *AdminController) HandleCreatePortalEntry(ctx *fiber.Ctx) error {
+	var req portalEntryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+
+	entry, err := c.portalService.AddEntry(req.Section, req.Title, req.Body)
+	if err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+	return ctx.Status(201).JSON(entry)
+}
+
+// HandleUpdatePortalEntry updates an existing portal entry
+func (c *AdminController) HandleUpdatePortalEntry(ctx *fiber.Ctx) error {
+	id, err := ctx.ParamsInt("id")
+	if err != nil {
+		return ctx.Status(400).SendString("Invalid entry id")
+	}
+
+	var req portalEntryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+
+	entry, err := c.portalService.UpdateEntry(int64(id), req.Section, req.Title, req.Body)
+	if err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+	return ctx.JSON(entry)
+}
+
+// HandleDeletePortalEntry deletes a portal entry
+func (c *AdminController) HandleDeletePortalEntry(ctx *fiber.Ctx) error {
+	id, err := ctx.ParamsInt("id")
+	if err != nil {
+		return ctx.Status(400).SendString("Invalid entry id")
+	}
+
+	if err := c.portalService.DeleteEntry(int64(id)); err != nil {
+		return ctx.Status(404).SendString(err.Error())
+	}
+	return ctx.SendStatus(204)
+}
+
+// HandleCreateBroadcast creates a draft broadcast and returns its estimated
+// recipients and cost. It must be confirmed with HandleSendBroadcast.
+func (c *AdminController) HandleCreateBroadcast(ctx *fiber.Ctx) error {
+	var req broadcastRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+
+	broadcast, err := c.broadcastService.CreateBroadcast(req.Message)
+	if err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+	return ctx.Status(201).JSON(broadcast)
+}
+
+// HandleSendBroadcast queues a draft broadcast for delivery
+func (c *AdminController) HandleSendBroadcast(ctx *fiber.Ctx) error {
+	id, err := ctx.ParamsInt("id")
+	if err != nil {
+		return ctx.Status(400).SendString("Invalid broadcast id")
+	}
+
+	broadcast, err := c.broadcastService.Send(int64(id))
+	if err != nil {
+		return ctx.Status(409).SendString(err.Error())
+	}
+	return ctx.Status(202).JSON(broadcast)
+}
+
+// HandleGetBroadcast returns a broadcast with its delivery status
+func (c *AdminController) HandleGetBroadcast(ctx *fiber.Ctx) error {
+	id, err := ctx.ParamsInt("id")
+	if err != nil {
+		return ctx.Status(400).SendString("Invalid broadcast id")
+	}
+
+	broadcast, err := c.broadcastService.GetBroadcast(int64(id))
+	if err != nil {
+		return ctx.Status(404).SendString(err.Error())
+	}
+	// Operators see pseudonyms, not the addresses of subscribers
+	for i := range broadcast.Deliveries {
+		broadcast.Deliveries[i].Address = utils.RedactIdentity(broadcast.Deliveries[i].Address)
+	}
+	return ctx.JSON(broadcast)
+}
+
+// HandleCostReport reports the SMS spending of a month, given as YYYY-MM
+// and the current month by default, against the budget and donations
+func (c *AdminController) HandleCostReport(ctx *fiber.Ctx) error {
+	month := time.Now()
+	if value := ctx.Query("month"); value != "" {
+		var err error
+		if month, err = time.Parse("2006-01", value); err != nil {
+			return ctx.Status(400).SendString("Invalid month, expected YYYY-MM")
+		}
+	}
+
+	report, err := c.costService.Report(month)
+	if err != nil {
+		return ctx.Status(500).SendString("Error creating cost report: " + err.Error())
+	}
+	return ctx.JSON(report)
+}
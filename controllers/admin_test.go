@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"neo146/services"
+	"neo146/services/servicestest"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupPortalApp(token string) *fiber.App {
+	portalService := services.NewPortalService(&servicestest.PortalStore{})
+	adminController := NewAdminController(token, portalService, nil, nil)
+	portalController := NewPortalController(portalService)
+
+	app := fiber.New()
+	app.Get("/portal", portalController.HandlePortal)
+	admin := app.Group("/api/admin", adminController.Authenticate)
+	admin.Post("/portal", adminController.HandleCreatePortalEntry)
+	admin.Put("/portal/:id", adminController.HandleUpdatePortalEntry)
+	admin.Delete("/portal/:id", adminController.HandleDeletePortalEntry)
+	return app
+}
+
+func postPortalEntry(app *fiber.App, token string, entry map[string]string) int {
+	body, _ := json.Marshal(entry)
+	req := httptest.NewRequest("POST", "/api/admin/portal", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, _ := app.Test(req)
+	return resp.StatusCode
+}
+
+func TestAdminController_Authenticate(t *testing.T) {
+	entry := map[string]string{"section": "news", "title": "Headline"}
+
+	// Admin API is disabled without a configured token
+	if status := postPortalEntry(setupPortalApp(""), "", entry); status != 403 {
+		t.Errorf("Expected status 403 with disabled admin API, got %d", status)
+	}
+
+	app := setupPortalApp("secret")
+	if status := postPortalEntry(app, "", entry); status != 401 {
+		t.Errorf("Expected status 401 without token, got %d", status)
+	}
+	if status := postPortalEntry(app, "wrong", entry); status != 401 {
+		t.Errorf("Expected status 401 with wrong token, got %d", status)
+	}
+	if status := postPortalEntry(app, "secret", entry); status != 201 {
+		t.Errorf("Expected status 201 with valid token, got %d", status)
+	}
+
+	// The token must be sent as a bearer token
+	body, _ := json.Marshal(entry)
+	req := httptest.NewRequest("POST", "/api/admin/portal", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "secret")
+	if resp, _ := app.Test(req); resp.StatusCode != 401 {
+		t.Errorf("Expected status 401 without the Bearer prefix, got %d", resp.StatusCode)
+	}
+
+	if status := postPortalEntry(app, "secret", map[string]string{"section": "sports", "title": "x"}); status != 400 {
+		t.Errorf("Expected status 400 for invalid section, got %d", status)
+	}
+}
+
+func TestAdminController_HandleDeletePortalEntry(t *testing.T) {
+	app := setupPortalApp("secret")
+	postPortalEntry(app, "secret", map[string]string{"section": "news", "title": "Headline"})
+
+	deleteEntry := func(id string) int {
+		req := httptest.NewRequest("DELETE", "/api/admin/portal/"+id, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, _ := app.Test(req)
+		return resp.StatusCode
+	}
+
+	if status := deleteEntry("1"); status != 204 {
+		t.Errorf("Expected status 204 for an existing entry, got %d", status)
+	}
+	if status := deleteEntry("1"); status != 404 {
+		t.Errorf("Expected status 404 for a deleted entry, got %d", status)
+	}
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/portal", nil))
+	if body, _ := io.ReadAll(resp.Body); strings.Contains(string(body), "Headline") {
+		t.Errorf("Expected the entry to be gone from the portal:\n%s", body)
+	}
+}
+
+func TestPortalController_HandlePortal(t *testing.T) {
+	app := setupPortalApp("secret")
+	postPortalEntry(app, "secret", map[string]string{"section": "emergency", "title": "Assembly <point>", "body": "Go to the park"})
+
+	// Plain text for non-browser clients
+	resp, _ := app.Test(httptest.NewRequest("GET", "/portal", nil))
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "EMERGENCY\n1. Assembly <point>") {
+		t.Errorf("Unexpected text portal:\n%s", body)
+	}
+
+	// HTML on request, with escaped content
+	resp, _ = app.Test(httptest.NewRequest("GET", "/portal?format=html", nil))
+	body, _ = io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "text/html" || !strings.Contains(string(body), "Assembly &lt;point&gt;") {
+		t.Errorf("Unexpected HTML portal:\n%s", body)
+	}
+
+	// Unknown sections are rejected
+	resp, _ = app.Test(httptest.NewRequest("GET", "/portal?section=sports", nil))
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status 400 for unknown section, got %d", resp.StatusCode)
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"encoding/base64"
 	"neo146/services"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -13,6 +14,7 @@ type ContentController struct {
 	twitterService  *services.TwitterService
 	searchService   *services.SearchService
 	weatherService  *services.WeatherService
+	feedService     *services.FeedService
 }
 
 // NewContentController creates a new ContentController
@@ -21,12 +23,14 @@ func NewContentController(
 	twitterService *services.TwitterService,
 	searchService *services.SearchService,
 	weatherService *services.WeatherService,
+	feedService *services.FeedService,
 ) *ContentController {
 	return &ContentController{
 		markdownService: markdownService,
 		twitterService:  twitterService,
 		searchService:   searchService,
 		weatherService:  weatherService,
+		feedService:     feedService,
 	}
 }
 
@@ -134,3 +138,38 @@ func (c *ContentController) HandleWeather(ctx *fiber.Ctx) error {
 	// Weather is sent as is, without base64 encoding
 	return ctx.SendString(forecast)
 }
+
+// HandleFeed handles the RSS/Atom feed reader endpoint
+func (c *ContentController) HandleFeed(ctx *fiber.Ctx) error {
+	source := ctx.Query("src")
+	if source == "" {
+		return ctx.SendString(c.feedService.FormatAliases())
+	}
+
+	var content string
+	var err error
+	if item := ctx.Query("item"); item != "" {
+		n, convErr := strconv.Atoi(item)
+		if convErr != nil {
+			return ctx.Status(400).SendString("Invalid item parameter")
+		}
+		content, err = c.feedService.ReadItem(source, n)
+	} else {
+		count := ctx.QueryInt("n", 10)
+		if count < 1 {
+			return ctx.Status(400).SendString("Invalid n parameter")
+		}
+		content, err = c.feedService.FetchHeadlines(source, count)
+	}
+	if err != nil {
+		return ctx.Status(500).SendString("Error fetching feed: " + err.Error())
+	}
+
+	// Check if base64 encoding is requested
+	if ctx.Query("b64") == "true" {
+		encoded := base64.StdEncoding.EncodeToString([]byte(content))
+		return ctx.SendString(encoded)
+	}
+
+	return ctx.SendString(content)
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LogRequests logs each request with the ID set by the requestid middleware,
+// which must run first. Client IPs and query strings are not logged, as
+// they may identify users or carry their input.
+func LogRequests() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		start := time.Now()
+		err := ctx.Next()
+
+		status := ctx.Response().StatusCode()
+		if fiberErr, ok := err.(*fiber.Error); ok {
+			status = fiberErr.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		requestLogger(ctx).Log(ctx.Context(), level, "Request",
+			"method", ctx.Method(),
+			"path", ctx.Path(),
+			"status", status,
+			"duration", time.Since(start),
+		)
+		return err
+	}
+}
+
+// requestLogger returns the default logger with the ID of a request
+func requestLogger(ctx *fiber.Ctx) *slog.Logger {
+	if id, ok := ctx.Locals("requestid").(string); ok {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
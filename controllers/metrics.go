@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"neo146/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleMetrics serves the metrics of the gateway in the Prometheus text
+// format
+func HandleMetrics(ctx *fiber.Ctx) error {
+	ctx.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	return utils.DefaultMetrics.WritePrometheus(ctx)
+}
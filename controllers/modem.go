@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"neo146/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ModemController handles the AFSK modem endpoint
+type ModemController struct {
+	modemService *services.ModemService
+}
+
+// NewModemController creates a new ModemController
+func NewModemController(modemService *services.ModemService) *ModemController {
+	return &ModemController{
+		modemService: modemService,
+	}
+}
+
+// HandleModem answers the requests of an uploaded WAV recording with a WAV
+// recording of the replies
+func (c *ModemController) HandleModem(ctx *fiber.Ctx) error {
+	body := ctx.Body()
+	if len(body) == 0 {
+		return ctx.Status(400).SendString("Missing WAV body")
+	}
+
+	wav, err := c.modemService.HandleWAV(body, ctx.IP())
+	if err != nil {
+		return ctx.Status(400).SendString("Error decoding request: " + err.Error())
+	}
+
+	ctx.Set("Content-Type", "audio/wav")
+	return ctx.Send(wav)
+}
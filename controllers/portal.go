@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"bytes"
+	"html/template"
+	"neo146/services"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PortalController handles the public portal endpoints
+type PortalController struct {
+	portalService *services.PortalService
+	template      *template.Template
+}
+
+// NewPortalController creates a new PortalController
+func NewPortalController(portalService *services.PortalService) *PortalController {
+	return &PortalController{
+		portalService: portalService,
+		template:      template.Must(template.ParseFS(staticFS, "static/portal.html")),
+	}
+}
+
+// HandlePortal serves the portal as plain text or HTML
+func (c *PortalController) HandlePortal(ctx *fiber.Ctx) error {
+	section := ctx.Query("section")
+
+	if c.wantsHTML(ctx) {
+		sections, err := c.portalService.Sections(section, -1)
+		if err != nil {
+			return ctx.Status(400).SendString(err.Error())
+		}
+
+		var page bytes.Buffer
+		if err := c.template.Execute(&page, sections); err != nil {
+			return ctx.Status(500).SendString("Error rendering portal: " + err.Error())
+		}
+		ctx.Set("Content-Type", "text/html")
+		return ctx.Status(200).Send(page.Bytes())
+	}
+
+	var content string
+	var err error
+	if section == "" {
+		content, err = c.portalService.FormatIndex("Use /portal?section=<section>&n=<n> to read.")
+	} else if n := ctx.QueryInt("n", 0); n > 0 {
+		content, err = c.portalService.FormatEntry(section, n)
+	} else {
+		content, err = c.portalService.FormatSection(section)
+	}
+	if err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+
+	return ctx.SendString(content)
+}
+
+// wantsHTML decides between HTML and text output, honouring an explicit
+// format parameter and falling back to the user agent like HandleRoot
+func (c *PortalController) wantsHTML(ctx *fiber.Ctx) bool {
+	switch ctx.Query("format") {
+	case "html":
+		return true
+	case "text", "txt":
+		return false
+	}
+
+	userAgent := strings.ToLower(ctx.Get("User-Agent"))
+	return strings.Contains(userAgent, "firefox") ||
+		strings.Contains(userAgent, "chrome") ||
+		strings.Contains(userAgent, "safari") ||
+		strings.Contains(userAgent, "edge") ||
+		strings.Contains(userAgent, "opera")
+}
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"neo146/services"
+	"neo146/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimit limits requests per client IP. The limiter is shared with the
+// other protocol listeners, so clients have a single budget across them.
+func RateLimit(limiter *services.RateLimiter) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !limiter.Allow(ctx.IP()) {
+			utils.RateLimitRejections.Inc("http")
+			return ctx.Status(fiber.StatusTooManyRequests).SendString("Too Many Requests")
+		}
+		return ctx.Next()
+	}
+}
@@ -1,12 +1,13 @@
 package controllers
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 	"neo146/models"
 	"neo146/providers"
 	"neo146/services"
 	"neo146/utils"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +23,10 @@ type SMSController struct {
 	searchService       *services.SearchService
 	weatherService      *services.WeatherService
 	subscriptionService *services.SubscriptionService
+	feedService         *services.FeedService
+	portalService       *services.PortalService
+	broadcastService    *services.BroadcastService
+	mailboxService      *services.MailboxService
 }
 
 // Config holds configuration for the SMSController
@@ -38,6 +43,10 @@ func NewSMSController(
 	searchService *services.SearchService,
 	weatherService *services.WeatherService,
 	subscriptionService *services.SubscriptionService,
+	feedService *services.FeedService,
+	portalService *services.PortalService,
+	broadcastService *services.BroadcastService,
+	mailboxService *services.MailboxService,
 ) *SMSController {
 	return &SMSController{
 		config:              config,
@@ -47,6 +56,10 @@ func NewSMSController(
 		searchService:       searchService,
 		weatherService:      weatherService,
 		subscriptionService: subscriptionService,
+		feedService:         feedService,
+		portalService:       portalService,
+		broadcastService:    broadcastService,
+		mailboxService:      mailboxService,
 	}
 }
 
@@ -62,20 +75,42 @@ func (c *SMSController) HandleTest(ctx *fiber.Ctx) error {
 		return ctx.Status(400).SendString(err.Error())
 	}
 
-	// Log received payload
-	receivedJSON, _ := json.MarshalIndent(payload, "", "  ")
-	fmt.Printf("Test endpoint received payload:\n%s\n", string(receivedJSON))
+	logger := requestLogger(ctx)
+	logger.Info("Received test SMS", "count", len(payload))
 
 	// Process the payload and create response
 	var response []providers.Message
 	for _, sms := range payload {
 		content := strings.TrimSpace(sms.Content)
 
+		// Check if content matches "sum <url>"
+		if command, args, _ := strings.Cut(content, " "); strings.EqualFold(command, "sum") && utils.IsURL(args) {
+			summary, err := c.smsService.SummaryService.Summarize(strings.TrimSpace(args))
+			if err != nil {
+				logger.Error("Error summarizing page", "error", err)
+				continue
+			}
+
+			// Split and encode the message
+			encodedParts := utils.SplitAndEncodeMessage(summary, 500)
+
+			// Create response messages
+			for i, encoded := range encodedParts {
+				response = append(response, providers.Message{
+					Msg:  encoded,
+					Dest: sms.SourceAddr,
+					ID:   fmt.Sprintf("%d_%d", time.Now().Unix(), i),
+				})
+			}
+			continue
+		}
+
 		// Check if content is a URL
-		if utils.IsURL(content) {
-			markdown, err := c.markdownService.FetchMarkdown(content)
+		if services.IsURLCommand(content) {
+			target, _ := services.ParseURLCommand(content)
+			markdown, err := c.markdownService.FetchMarkdown(target)
 			if err != nil {
-				fmt.Println("Error fetching markdown:", err)
+				logger.Error("Error fetching markdown", "error", err)
 				continue
 			}
 
@@ -98,7 +133,7 @@ func (c *SMSController) HandleTest(ctx *fiber.Ctx) error {
 			username := strings.TrimSpace(strings.TrimPrefix(content, "twitter user"))
 			tweets, err := c.twitterService.FetchTweets(username, 5)
 			if err != nil {
-				fmt.Println("Error fetching tweets:", err)
+				logger.Error("Error fetching tweets", "error", err)
 				continue
 			}
 
@@ -119,14 +154,44 @@ func (c *SMSController) HandleTest(ctx *fiber.Ctx) error {
 		// Check if content matches "websearch <query>"
 		if strings.HasPrefix(strings.ToLower(content), "websearch ") {
 			query := strings.TrimSpace(strings.TrimPrefix(content, "websearch"))
-			results, err := c.searchService.FetchDuckDuckGoResults(query)
+			results, err := c.searchService.SearchForUser(sms.SourceAddr, query)
 			if err != nil {
-				fmt.Println("Error fetching search results:", err)
+				logger.Error("Error fetching search results", "error", err)
 				continue
 			}
 
 			// Split and encode the message
-			encodedParts := utils.SplitAndEncodeMessage(results, 500)
+			encodedParts := utils.SplitAndEncodeMessage(services.FormatSearchSession(results, "open"), 500)
+
+			// Create response messages
+			for i, encoded := range encodedParts {
+				response = append(response, providers.Message{
+					Msg:  encoded,
+					Dest: sms.SourceAddr,
+					ID:   fmt.Sprintf("%d_%d", time.Now().Unix(), i),
+				})
+			}
+			continue
+		}
+
+		// Check if content matches "open <n>"
+		if strings.HasPrefix(strings.ToLower(content), "open ") {
+			markdown, _, err := c.openSearchResult(sms.SourceAddr, content)
+			if err != nil {
+				logger.Error("Error opening search result", "error", err)
+
+				// The error tells the user how to fix the command, and is
+				// sent without encoding
+				response = append(response, providers.Message{
+					Msg:  "Error: " + err.Error(),
+					Dest: sms.SourceAddr,
+					ID:   fmt.Sprintf("%d_%d", time.Now().Unix(), 0),
+				})
+				continue
+			}
+
+			// Split and encode the message
+			encodedParts := utils.SplitAndEncodeMessage(markdown, 500)
 
 			// Create response messages
 			for i, encoded := range encodedParts {
@@ -151,7 +216,7 @@ func (c *SMSController) HandleTest(ctx *fiber.Ctx) error {
 
 			summary, err := c.searchService.FetchWikipediaSummary(query, langCode)
 			if err != nil {
-				fmt.Println("Error fetching Wikipedia summary:", err)
+				logger.Error("Error fetching Wikipedia summary", "error", err)
 				continue
 			}
 
@@ -169,12 +234,56 @@ func (c *SMSController) HandleTest(ctx *fiber.Ctx) error {
 			continue
 		}
 
+		// Check if content matches "feed [<source> [<n>]]"
+		if strings.EqualFold(content, "feed") || strings.HasPrefix(strings.ToLower(content), "feed ") {
+			feedContent, err := c.fetchFeed(content)
+			if err != nil {
+				logger.Error("Error fetching feed", "error", err)
+				continue
+			}
+
+			// Split and encode the message
+			encodedParts := utils.SplitAndEncodeMessage(feedContent, 500)
+
+			// Create response messages
+			for i, encoded := range encodedParts {
+				response = append(response, providers.Message{
+					Msg:  encoded,
+					Dest: sms.SourceAddr,
+					ID:   fmt.Sprintf("%d_%d", time.Now().Unix(), i),
+				})
+			}
+			continue
+		}
+
+		// Check if content matches "portal [<section> [<n>]]" or "news [<n>]"
+		if isPortalCommand(content) {
+			portalContent, err := c.fetchPortal(content)
+			if err != nil {
+				logger.Error("Error fetching portal", "error", err)
+				continue
+			}
+
+			// Split and encode the message
+			encodedParts := utils.SplitAndEncodeMessage(portalContent, 500)
+
+			// Create response messages
+			for i, encoded := range encodedParts {
+				response = append(response, providers.Message{
+					Msg:  encoded,
+					Dest: sms.SourceAddr,
+					ID:   fmt.Sprintf("%d_%d", time.Now().Unix(), i),
+				})
+			}
+			continue
+		}
+
 		// Check if content matches "weather <location>"
 		if strings.HasPrefix(strings.ToLower(content), "weather ") {
 			location := strings.TrimSpace(strings.TrimPrefix(content, "weather"))
 			forecast, err := c.weatherService.FetchWeatherForecast(location)
 			if err != nil {
-				fmt.Println("Error fetching weather forecast:", err)
+				logger.Error("Error fetching weather forecast", "error", err)
 				continue
 			}
 
@@ -200,23 +309,23 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 		return ctx.Status(400).SendString(err.Error())
 	}
 
-	// Log received payload
-	receivedJSON, _ := json.MarshalIndent(payload, "", "  ")
-	fmt.Printf("Received payload:\n%s\n", string(receivedJSON))
+	logger := requestLogger(ctx)
+	logger.Info("Received SMS", "count", len(payload))
 
 	for _, sms := range payload {
 		content := strings.TrimSpace(sms.Content)
+		utils.InboundMessages.Inc(models.ChannelSMS, services.CommandName(content))
 
 		// Handle subscription command
 		if strings.HasPrefix(strings.ToLower(content), "subscribe ") {
 			email := strings.TrimSpace(strings.TrimPrefix(content, "subscribe"))
 			if err := c.subscriptionService.LinkPhoneToSubscription(sms.SourceAddr, email); err != nil {
-				fmt.Printf("Error linking phone to subscription: %v\n", err)
+				logger.Error("Error linking phone to subscription", "error", err)
 				continue
 			}
 			// Update rate limit for the phone number
 			if err := c.subscriptionService.UpdateRateLimitForPhone(sms.SourceAddr, 20); err != nil {
-				fmt.Printf("Error updating rate limit: %v\n", err)
+				logger.Error("Error updating rate limit", "error", err)
 				continue
 			}
 			// Send confirmation message
@@ -231,7 +340,7 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 					},
 				}
 				if err := c.smsService.SendSMS(smsMessage); err != nil {
-					fmt.Printf("Error sending confirmation message: %v\n", err)
+					logger.Error("Error sending confirmation message", "error", err)
 				}
 			}
 			continue
@@ -240,14 +349,15 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 		// Check rate limit
 		allowed, err := c.subscriptionService.CheckRateLimit(sms.SourceAddr)
 		if err != nil {
-			fmt.Printf("Error checking rate limit: %v\n", err)
+			logger.Error("Error checking rate limit", "error", err)
 			continue
 		}
 
 		if !allowed {
+			utils.RateLimitRejections.Inc(models.ChannelSMS)
 			// Send rate limit notification with source address
 			if sms.SourceAddr == "" {
-				fmt.Println("Error: source address is empty for rate limit notification")
+				logger.Warn("Source address is empty for rate limit notification")
 				continue
 			}
 
@@ -260,22 +370,97 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 				},
 			}
 			if err := c.smsService.SendSMS(smsMessage); err != nil {
-				fmt.Printf("Error sending rate limit notification: %v\n", err)
+				logger.Error("Error sending rate limit notification", "error", err)
 			}
 			continue
 		}
 
-		// Check if content is a URL
-		if utils.IsURL(content) {
-			markdown, err := c.markdownService.FetchMarkdown(content)
+		// Check if content is a key registration or an encrypted request,
+		// e.g. "e2e <base64>"
+		if command, args, _ := strings.Cut(content, " "); c.smsService.E2EService != nil && services.IsE2ECommand(command) {
+			c.handleE2E(logger, sms.SourceAddr, command, args)
+			continue
+		}
+
+		// Check if content matches "join alerts" or "leave alerts"
+		if strings.EqualFold(content, "join alerts") || strings.EqualFold(content, "leave alerts") {
+			var reply string
+			if strings.EqualFold(content, "join alerts") {
+				err = c.broadcastService.Join(models.ChannelSMS, sms.SourceAddr)
+				reply = "You will now receive urgent announcements. Text \"leave alerts\" to stop."
+			} else {
+				err = c.broadcastService.Leave(models.ChannelSMS, sms.SourceAddr)
+				reply = "You will no longer receive alerts."
+			}
+			if err != nil {
+				logger.Error("Error updating alert subscription", "error", err)
+				continue
+			}
+
+			// Confirmation is sent without encoding
+			if err := c.smsService.PrepareAndSendSMS(reply, sms.SourceAddr, false); err != nil {
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content is a mailbox command, e.g. "msg <handle> <text>"
+		if command, args, _ := strings.Cut(content, " "); services.IsMailboxCommand(command) {
+			reply, err := c.mailboxService.Execute(models.ChannelSMS, sms.SourceAddr, command, args)
+			if err != nil {
+				logger.Error("Error handling mailbox command", "error", err)
+				continue
+			}
+
+			// Messages between users are sent without encoding
+			if err := c.smsService.PrepareAndSendSMS(reply, sms.SourceAddr, false); err != nil {
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content is "more", the rest of the last shortened reply
+		if strings.EqualFold(content, "more") {
+			rest, found := c.smsService.TakeRemainder(sms.SourceAddr, false)
+			if !found {
+				err = c.smsService.PrepareAndSendSMS(services.NoRemainderReply, sms.SourceAddr, false)
+			} else {
+				err = c.smsService.SendReply(rest, sms.SourceAddr, 0)
+			}
+			if err != nil {
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content matches "sum <url>"
+		if command, args, _ := strings.Cut(content, " "); strings.EqualFold(command, "sum") && utils.IsURL(args) {
+			summary, err := c.smsService.SummaryService.Summarize(strings.TrimSpace(args))
 			if err != nil {
-				fmt.Println("Error fetching markdown:", err)
+				logger.Error("Error summarizing page", "error", err)
+				continue
+			}
+
+			// Send SMS with the summary
+			if err := c.smsService.PrepareAndSendSMS(summary, sms.SourceAddr, true); err != nil {
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content is a URL, e.g. "url https://... 3" for at most 3
+		// segments
+		if services.IsURLCommand(content) {
+			target, segments := services.ParseURLCommand(content)
+			markdown, err := c.markdownService.FetchMarkdown(target)
+			if err != nil {
+				logger.Error("Error fetching markdown", "error", err)
 				continue
 			}
 
 			// Send SMS with markdown content
-			if err := c.smsService.PrepareAndSendSMS(markdown, sms.SourceAddr, true); err != nil {
-				fmt.Println("Error sending SMS:", err)
+			if err := c.smsService.SendReply(markdown, sms.SourceAddr, segments); err != nil {
+				logger.Error("Error sending SMS", "error", err)
 			}
 			continue
 		}
@@ -285,13 +470,13 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 			username := strings.TrimSpace(strings.TrimPrefix(content, "twitter user"))
 			tweets, err := c.twitterService.FetchTweets(username, 5)
 			if err != nil {
-				fmt.Println("Error fetching tweets:", err)
+				logger.Error("Error fetching tweets", "error", err)
 				continue
 			}
 
 			// Send SMS with tweets content
 			if err := c.smsService.PrepareAndSendSMS(tweets, sms.SourceAddr, true); err != nil {
-				fmt.Println("Error sending SMS:", err)
+				logger.Error("Error sending SMS", "error", err)
 			}
 			continue
 		}
@@ -299,15 +484,36 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 		// Check if content matches "websearch <query>"
 		if strings.HasPrefix(strings.ToLower(content), "websearch ") {
 			query := strings.TrimSpace(strings.TrimPrefix(content, "websearch"))
-			results, err := c.searchService.FetchDuckDuckGoResults(query)
+			results, err := c.searchService.SearchForUser(sms.SourceAddr, query)
 			if err != nil {
-				fmt.Println("Error fetching search results:", err)
+				logger.Error("Error fetching search results", "error", err)
 				continue
 			}
 
-			// Send SMS with search results
-			if err := c.smsService.PrepareAndSendSMS(results, sms.SourceAddr, true); err != nil {
-				fmt.Println("Error sending SMS:", err)
+			// Send SMS with numbered search results
+			if err := c.smsService.PrepareAndSendSMS(services.FormatSearchSession(results, "open"), sms.SourceAddr, true); err != nil {
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content matches "open <n> [<segments>]"
+		if strings.HasPrefix(strings.ToLower(content), "open ") {
+			markdown, segments, err := c.openSearchResult(sms.SourceAddr, content)
+			if err != nil {
+				logger.Error("Error opening search result", "error", err)
+
+				// The error tells the user how to fix the command, and is
+				// sent without encoding
+				if err := c.smsService.PrepareAndSendSMS("Error: "+err.Error(), sms.SourceAddr, false); err != nil {
+					logger.Error("Error sending SMS", "error", err)
+				}
+				continue
+			}
+
+			// Send SMS with markdown content of the result
+			if err := c.smsService.SendReply(markdown, sms.SourceAddr, segments); err != nil {
+				logger.Error("Error sending SMS", "error", err)
 			}
 			continue
 		}
@@ -324,13 +530,43 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 
 			summary, err := c.searchService.FetchWikipediaSummary(query, langCode)
 			if err != nil {
-				fmt.Println("Error fetching Wikipedia summary:", err)
+				logger.Error("Error fetching Wikipedia summary", "error", err)
 				continue
 			}
 
 			// Send SMS with Wikipedia summary
 			if err := c.smsService.PrepareAndSendSMS(summary, sms.SourceAddr, true); err != nil {
-				fmt.Println("Error sending SMS:", err)
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content matches "feed [<source> [<n>]]"
+		if strings.EqualFold(content, "feed") || strings.HasPrefix(strings.ToLower(content), "feed ") {
+			feedContent, err := c.fetchFeed(content)
+			if err != nil {
+				logger.Error("Error fetching feed", "error", err)
+				continue
+			}
+
+			// Send SMS with feed headlines or item
+			if err := c.smsService.PrepareAndSendSMS(feedContent, sms.SourceAddr, true); err != nil {
+				logger.Error("Error sending SMS", "error", err)
+			}
+			continue
+		}
+
+		// Check if content matches "portal [<section> [<n>]]" or "news [<n>]"
+		if isPortalCommand(content) {
+			portalContent, err := c.fetchPortal(content)
+			if err != nil {
+				logger.Error("Error fetching portal", "error", err)
+				continue
+			}
+
+			// Send SMS with portal content
+			if err := c.smsService.PrepareAndSendSMS(portalContent, sms.SourceAddr, true); err != nil {
+				logger.Error("Error sending SMS", "error", err)
 			}
 			continue
 		}
@@ -340,13 +576,13 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 			location := strings.TrimSpace(strings.TrimPrefix(content, "weather"))
 			forecast, err := c.weatherService.FetchWeatherForecast(location)
 			if err != nil {
-				fmt.Println("Error fetching weather forecast:", err)
+				logger.Error("Error fetching weather forecast", "error", err)
 				continue
 			}
 
 			// Weather is sent without encoding
 			if err := c.smsService.PrepareAndSendSMS(forecast, sms.SourceAddr, false); err != nil {
-				fmt.Println("Error sending SMS:", err)
+				logger.Error("Error sending SMS", "error", err)
 			}
 			continue
 		}
@@ -354,6 +590,104 @@ func (c *SMSController) HandleInbound(ctx *fiber.Ctx) error {
 	return ctx.SendStatus(204)
 }
 
+// handleE2E registers the key of a phone number, or answers an encrypted
+// request with an encrypted reply
+func (c *SMSController) handleE2E(logger *slog.Logger, sourceAddr, command, args string) {
+	e2eService := c.smsService.E2EService
+	if strings.EqualFold(command, utils.E2EKeyCommand) {
+		reply, err := e2eService.Register(sourceAddr, args)
+		if err != nil {
+			logger.Error("Error registering key", "error", err)
+			return
+		}
+		if err := c.smsService.PrepareAndSendSMS(reply, sourceAddr, false); err != nil {
+			logger.Error("Error sending SMS", "error", err)
+		}
+		return
+	}
+
+	request, nonce, err := e2eService.Open(sourceAddr, args)
+	if err != nil {
+		logger.Error("Error opening encrypted request", "error", err)
+		// Without a valid request there is nothing to bind a sealed reply to
+		reply := "Your encrypted request could not be read. Register your key with \"key <public key>\" and check the clock of your phone."
+		if err := c.smsService.PrepareAndSendSMS(reply, sourceAddr, false); err != nil {
+			logger.Error("Error sending SMS", "error", err)
+		}
+		return
+	}
+
+	reply, err := services.NewCommandService(c.smsService).Execute(models.ChannelSMS, sourceAddr, request)
+	if err != nil {
+		reply = "Error: " + err.Error()
+	}
+	if err := c.smsService.SendSealedSMS(reply, sourceAddr, nonce); err != nil {
+		logger.Error("Error sending encrypted SMS", "error", err)
+	}
+}
+
+// HandleE2EKey returns the public key encrypted SMS requests are sealed to
+func (c *SMSController) HandleE2EKey(ctx *fiber.Ctx) error {
+	if c.smsService.E2EService == nil {
+		return ctx.Status(404).SendString("Encrypted SMS are not enabled")
+	}
+	return ctx.SendString(c.smsService.E2EService.PublicKey())
+}
+
+// openSearchResult converts the result referenced by an "open <n>" command
+// from the user's last search to Markdown, and returns it with the number
+// of segments requested
+func (c *SMSController) openSearchResult(sourceAddr, content string) (string, int, error) {
+	number, segments := services.ParseSegmentsSuffix(content[len("open"):])
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid result number %q, reply \"open <n>\"", number)
+	}
+
+	result, err := c.searchService.OpenResult(sourceAddr, n)
+	if err != nil {
+		return "", 0, err
+	}
+
+	markdown, err := c.markdownService.FetchMarkdown(result.URL)
+	return markdown, segments, err
+}
+
+// fetchFeed handles a "feed" command: without arguments it lists the feed
+// aliases, with a source the latest headlines and with a number that item
+func (c *SMSController) fetchFeed(content string) (string, error) {
+	source, item := services.ParseFeedCommand(content[len("feed"):])
+	if source == "" {
+		return c.feedService.FormatAliases(), nil
+	}
+
+	if item > 0 {
+		return c.feedService.ReadItem(source, item)
+	}
+
+	headlines, err := c.feedService.FetchHeadlines(source, 5)
+	if err != nil {
+		return "", err
+	}
+	return headlines + "\n\n" + services.FeedReadHint("feed", source), nil
+}
+
+// isPortalCommand checks if content is a "portal" or "news" command
+func isPortalCommand(content string) bool {
+	command := strings.ToLower(strings.SplitN(content, " ", 2)[0])
+	return command == "portal" || command == "news"
+}
+
+// fetchPortal handles "portal" and "news" commands, where "news" is a
+// shortcut for the news section of the portal
+func (c *SMSController) fetchPortal(content string) (string, error) {
+	command, args, _ := strings.Cut(content, " ")
+	if strings.EqualFold(command, "news") {
+		args = models.PortalSectionNews + " " + args
+	}
+	return c.portalService.FormatCommand(args, "Reply \"portal <section> <n>\" to read.")
+}
+
 // HandleTestSubscribe handles the test subscription endpoint
 func (c *SMSController) HandleTestSubscribe(ctx *fiber.Ctx) error {
 	// Deny access in production
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+
+	"neo146/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SSTVController handles the SSTV endpoints
+type SSTVController struct {
+	sstvService *services.SSTVService
+}
+
+// sstvJobRequest is the request body for creating SSTV jobs
+type sstvJobRequest struct {
+	URI  string `json:"uri"`
+	Mode string `json:"mode"`
+}
+
+// NewSSTVController creates a new SSTVController
+func NewSSTVController(sstvService *services.SSTVService) *SSTVController {
+	return &SSTVController{
+		sstvService: sstvService,
+	}
+}
+
+// HandleSSTV renders a web page or image as an SSTV WAV file
+func (c *SSTVController) HandleSSTV(ctx *fiber.Ctx) error {
+	uri := ctx.Query("uri")
+	if uri == "" {
+		return ctx.Status(400).SendString("Missing uri parameter")
+	}
+
+	wav, err := c.sstvService.Render(uri, ctx.Query("mode"))
+	if errors.Is(err, services.ErrSSTVBusy) {
+		return ctx.Status(fiber.StatusServiceUnavailable).SendString(err.Error())
+	}
+	if err != nil {
+		return ctx.Status(500).SendString("Error rendering SSTV: " + err.Error())
+	}
+
+	ctx.Set("Content-Type", "audio/wav")
+	return ctx.Send(wav)
+}
+
+// HandleCreateJob queues an SSTV job for radio operators
+func (c *SSTVController) HandleCreateJob(ctx *fiber.Ctx) error {
+	var req sstvJobRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+
+	job, err := c.sstvService.QueueJob(req.URI, req.Mode)
+	if err != nil {
+		return ctx.Status(400).SendString(err.Error())
+	}
+	return ctx.Status(202).JSON(job)
+}
+
+// HandleGetJob returns the status of an SSTV job
+func (c *SSTVController) HandleGetJob(ctx *fiber.Ctx) error {
+	id, err := ctx.ParamsInt("id")
+	if err != nil {
+		return ctx.Status(400).SendString("Invalid job id")
+	}
+
+	job, err := c.sstvService.Job(int64(id))
+	if err != nil {
+		return ctx.Status(404).SendString(err.Error())
+	}
+	return ctx.JSON(job)
+}
+
+// HandleJobAudio returns the WAV file of a finished SSTV job
+func (c *SSTVController) HandleJobAudio(ctx *fiber.Ctx) error {
+	id, err := ctx.ParamsInt("id")
+	if err != nil {
+		return ctx.Status(400).SendString("Invalid job id")
+	}
+
+	wav, err := c.sstvService.JobAudio(int64(id))
+	if err != nil {
+		return ctx.Status(404).SendString(err.Error())
+	}
+
+	ctx.Set("Content-Type", "audio/wav")
+	return ctx.Send(wav)
+}
@@ -2,7 +2,7 @@ package controllers
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"neo146/services"
 	"os"
 )
@@ -36,7 +36,7 @@ func NewTelegramController(
 
 // StartBot starts the Telegram bot
 func (c *TelegramController) StartBot() error {
-	log.Println("Starting Telegram bot...")
+	slog.Info("Starting Telegram bot")
 	return c.TelegramService.Start()
 }
 
@@ -3,13 +3,18 @@ package test
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"neo146/controllers"
 	"neo146/models"
 	"neo146/providers"
 	"neo146/services"
+	"neo146/utils"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
@@ -29,6 +34,10 @@ func TestSMSController_HandleTest_ProductionEnv(t *testing.T) {
 	searchService := services.NewSearchService(nil)
 	weatherService := services.NewWeatherService(nil)
 	subscriptionService := services.NewSubscriptionService()
+	feedService := services.NewFeedService(nil, markdownService, nil)
+	portalService := services.NewPortalService(nil)
+	broadcastService := services.NewBroadcastService(nil, time.Second, nil, "")
+	mailboxService := services.NewMailboxService(nil, time.Hour, 10)
 
 	// Create SMS controller with production environment
 	controller := controllers.NewSMSController(
@@ -39,6 +48,10 @@ func TestSMSController_HandleTest_ProductionEnv(t *testing.T) {
 		searchService,
 		weatherService,
 		subscriptionService,
+		feedService,
+		portalService,
+		broadcastService,
+		mailboxService,
 	)
 
 	// Setup the route
@@ -88,6 +101,10 @@ func TestSMSController_HandleTest_TestEnv(t *testing.T) {
 	searchService := services.NewSearchService(nil)
 	weatherService := services.NewWeatherService(nil)
 	subscriptionService := services.NewSubscriptionService()
+	feedService := services.NewFeedService(nil, markdownService, nil)
+	portalService := services.NewPortalService(nil)
+	broadcastService := services.NewBroadcastService(nil, time.Second, nil, "")
+	mailboxService := services.NewMailboxService(nil, time.Hour, 10)
 
 	// Create SMS controller with test environment
 	controller := controllers.NewSMSController(
@@ -98,6 +115,10 @@ func TestSMSController_HandleTest_TestEnv(t *testing.T) {
 		searchService,
 		weatherService,
 		subscriptionService,
+		feedService,
+		portalService,
+		broadcastService,
+		mailboxService,
 	)
 
 	// Setup the route
@@ -117,3 +138,137 @@ func TestSMSController_HandleTest_TestEnv(t *testing.T) {
 	// Assert the response
 	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 }
+
+// recordingTransport answers requests with a fixed body and records their
+// hosts
+type recordingTransport struct {
+	body  string
+	hosts []string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.hosts = append(r.hosts, req.URL.Host)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(r.body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func TestSMSController_HandleTest_FeedURL(t *testing.T) {
+	app := fiber.New()
+
+	markdownTransport := &recordingTransport{body: "# Page"}
+	feedTransport := &recordingTransport{body: `<rss version="2.0"><channel><title>News</title>` +
+		`<item><title>Water at noon</title><link>https://example.org/1</link></item></channel></rss>`}
+
+	smsService := services.NewSMSService(providers.NewManager())
+	markdownService := services.NewMarkdownService(&http.Client{Transport: markdownTransport})
+	controller := controllers.NewSMSController(
+		&controllers.Config{Environment: "test"},
+		smsService,
+		markdownService,
+		services.NewTwitterService(nil),
+		services.NewSearchService(nil),
+		services.NewWeatherService(nil),
+		services.NewSubscriptionService(),
+		services.NewFeedService(&http.Client{Transport: feedTransport}, markdownService, nil),
+		services.NewPortalService(nil),
+		services.NewBroadcastService(nil, time.Second, nil, ""),
+		services.NewMailboxService(nil, time.Hour, 10),
+	)
+	app.Post("/test", controller.HandleTest)
+
+	// A feed URL is read as a feed, not converted as a page
+	payload := []models.SMSPayload{
+		{SourceAddr: "+1234567890", Content: "feed https://feeds.example.org/rss"},
+	}
+	jsonData, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/test", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, _ := app.Test(req)
+
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"feeds.example.org"}, feedTransport.hosts)
+	assert.Empty(t, markdownTransport.hosts)
+}
+
+func TestSMSController_HandleTest_OpenError(t *testing.T) {
+	app := fiber.New()
+
+	markdownService := services.NewMarkdownService(nil)
+	controller := controllers.NewSMSController(
+		&controllers.Config{Environment: "test"},
+		services.NewSMSService(providers.NewManager()),
+		markdownService,
+		services.NewTwitterService(nil),
+		services.NewSearchService(nil),
+		services.NewWeatherService(nil),
+		services.NewSubscriptionService(),
+		services.NewFeedService(nil, markdownService, nil),
+		services.NewPortalService(nil),
+		services.NewBroadcastService(nil, time.Second, nil, ""),
+		services.NewMailboxService(nil, time.Hour, 10),
+	)
+	app.Post("/test", controller.HandleTest)
+
+	// Mistakes are answered instead of dropped
+	for content, expected := range map[string]string{
+		"open 1":   "Error: no recent search found",
+		"open two": `Error: invalid result number \"two\"`,
+	} {
+		jsonData, _ := json.Marshal([]models.SMSPayload{{SourceAddr: "+1234567890", Content: content}})
+		req := httptest.NewRequest("POST", "/test", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, _ := app.Test(req)
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(body), expected)
+	}
+}
+
+func TestSMSController_HandleTest_Summary(t *testing.T) {
+	app := fiber.New()
+
+	markdownTransport := &recordingTransport{body: "# Water\n\nWater is handed out at the central square at noon today."}
+	smsService := services.NewSMSService(providers.NewManager())
+	markdownService := services.NewMarkdownService(&http.Client{Transport: markdownTransport})
+	smsService.SummaryService = services.NewSummaryService(markdownService, 480)
+	controller := controllers.NewSMSController(
+		&controllers.Config{Environment: "test"},
+		smsService,
+		markdownService,
+		services.NewTwitterService(nil),
+		services.NewSearchService(nil),
+		services.NewWeatherService(nil),
+		services.NewSubscriptionService(),
+		services.NewFeedService(nil, markdownService, nil),
+		services.NewPortalService(nil),
+		services.NewBroadcastService(nil, time.Second, nil, ""),
+		services.NewMailboxService(nil, time.Hour, 10),
+	)
+	app.Post("/test", controller.HandleTest)
+
+	jsonData, _ := json.Marshal([]models.SMSPayload{{SourceAddr: "+1234567890", Content: "sum https://example.org/water"}})
+	req := httptest.NewRequest("POST", "/test", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, _ := app.Test(req)
+	var result struct {
+		Messages []providers.Message `json:"messages"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	var parts []string
+	for _, message := range result.Messages {
+		parts = append(parts, message.Msg)
+	}
+	summary, err := utils.DecodeGWParts(parts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Water is handed out at the central square at noon today.", string(summary))
+}
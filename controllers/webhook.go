@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"fmt"
+	"log/slog"
 	"neo146/models"
 	"neo146/services"
 	"neo146/utils"
@@ -13,12 +13,15 @@ import (
 // WebhookController handles webhook-related endpoints
 type WebhookController struct {
 	subscriptionService *services.SubscriptionService
+	costService         *services.CostService
 }
 
-// NewWebhookController creates a new WebhookController
-func NewWebhookController(subscriptionService *services.SubscriptionService) *WebhookController {
+// NewWebhookController creates a new WebhookController. Donations are
+// recorded with costService.
+func NewWebhookController(subscriptionService *services.SubscriptionService, costService *services.CostService) *WebhookController {
 	return &WebhookController{
 		subscriptionService: subscriptionService,
+		costService:         costService,
 	}
 }
 
@@ -28,15 +31,6 @@ func (c *WebhookController) HandleBuyMeACoffee(ctx *fiber.Ctx) error {
 	body := ctx.Body()
 
 	// Get the signature from headers
-	fmt.Println("--------------------------------")
-	fmt.Println("Headers:")
-	ctx.Request().Header.VisitAll(func(key, value []byte) {
-		fmt.Printf("%s: %s\n", string(key), string(value))
-	})
-	fmt.Println("X-Bmc-Signature: ", ctx.Get("X-Bmc-Signature"))
-	fmt.Println("X-BMC-Signature: ", ctx.Get("X-BMC-Signature"))
-	fmt.Println("Body: ", string(body))
-	fmt.Println("--------------------------------")
 	signature := ctx.Get("X-Signature-Sha256")
 
 	// Verify the webhook signature
@@ -61,6 +55,9 @@ func (c *WebhookController) HandleBuyMeACoffee(ctx *fiber.Ctx) error {
 		); err != nil {
 			return ctx.Status(500).SendString("Error saving subscription: " + err.Error())
 		}
+		if err := c.costService.RecordDonation("buymeacoffee", webhook.Data.Amount, webhook.Data.Currency); err != nil {
+			slog.Error("Error recording donation", "error", err)
+		}
 
 	case "recurring_donation.updated":
 		// Check if subscription is paused or canceled
@@ -131,6 +128,10 @@ func (c *WebhookController) HandlePayPal(ctx *fiber.Ctx) error {
 		if err := c.subscriptionService.UpdateSubscriptionStatus(ipn.SubscriptionID, "active"); err != nil {
 			return ctx.Status(500).SendString("Error updating subscription")
 		}
+		// Donations are recorded after PayPal's fee
+		if err := c.costService.RecordDonation("paypal", ipn.PaymentGross-ipn.PaymentFee, ipn.Currency); err != nil {
+			slog.Error("Error recording donation", "error", err)
+		}
 
 	case "subscr_cancel":
 		// Subscription cancelled
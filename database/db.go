@@ -3,21 +3,26 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"neo146/models"
+	"neo146/utils"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// DB represents a database connection
+// DB represents a database connection. Identities of users are stored
+// pseudonymized with keys.
 type DB struct {
 	*sql.DB
+	keys *utils.IdentityKeys
 }
 
 var db *DB
 
 // InitDB initializes the database connection
-func InitDB() (*DB, error) {
+func InitDB(keys *utils.IdentityKeys) (*DB, error) {
 	if db != nil {
 		return db, nil
 	}
@@ -28,7 +33,18 @@ func InitDB() (*DB, error) {
 		dbPath = "neo146.db"
 	}
 
-	conn, err := sql.Open("sqlite3", dbPath)
+	newDB, err := openDB(dbPath, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	db = newDB
+	return db, nil
+}
+
+// openDB opens the database at a path, creating and migrating its tables
+func openDB(path string, keys *utils.IdentityKeys) (*DB, error) {
+	conn, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -43,8 +59,14 @@ func InitDB() (*DB, error) {
 		return nil, fmt.Errorf("failed to create tables: %v", err)
 	}
 
-	db = &DB{conn}
-	return db, nil
+	newDB := &DB{conn, keys}
+	if err := newDB.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if err := newDB.resealAddresses(); err != nil {
+		return nil, fmt.Errorf("failed to reseal addresses: %v", err)
+	}
+	return newDB, nil
 }
 
 // createTables creates the necessary tables if they don't exist
@@ -100,15 +122,170 @@ func createTables(db *sql.DB) error {
 		return err
 	}
 
+	// Create portal_entries table for operator-managed portal content
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS portal_entries (
+		id INTEGER PRIMARY KEY,
+		section TEXT NOT NULL,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_portal_entries_section ON portal_entries(section, created_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create tables for opt-in broadcast alerts
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS alert_subscribers (
+		id INTEGER PRIMARY KEY,
+		channel TEXT NOT NULL,
+		address TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS broadcasts (
+		id INTEGER PRIMARY KEY,
+		message TEXT NOT NULL,
+		status TEXT NOT NULL,
+		recipients INTEGER NOT NULL DEFAULT 0,
+		sms_segments INTEGER NOT NULL DEFAULT 0,
+		estimated_cost REAL NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcasts_status ON broadcasts(status);
+
+	CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+		id INTEGER PRIMARY KEY,
+		broadcast_id INTEGER NOT NULL,
+		channel TEXT NOT NULL,
+		address TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (broadcast_id) REFERENCES broadcasts(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcast_deliveries_broadcast ON broadcast_deliveries(broadcast_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create tables for store-and-forward messaging between users
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mailbox_handles (
+		id INTEGER PRIMARY KEY,
+		handle TEXT NOT NULL,
+		link_code_hash TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_mailbox_handles_handle ON mailbox_handles(handle);
+
+	CREATE TABLE IF NOT EXISTS mailbox_identities (
+		id INTEGER PRIMARY KEY,
+		handle_id INTEGER NOT NULL,
+		channel TEXT NOT NULL,
+		address TEXT NOT NULL,
+		FOREIGN KEY (handle_id) REFERENCES mailbox_handles(id)
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_mailbox_identities_address ON mailbox_identities(channel, address);
+
+	CREATE TABLE IF NOT EXISTS mailbox_messages (
+		id INTEGER PRIMARY KEY,
+		recipient_handle_id INTEGER NOT NULL,
+		sender_handle_id INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		read_at TIMESTAMP,
+		FOREIGN KEY (recipient_handle_id) REFERENCES mailbox_handles(id),
+		FOREIGN KEY (sender_handle_id) REFERENCES mailbox_handles(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_mailbox_messages_recipient ON mailbox_messages(recipient_handle_id);
+	CREATE INDEX IF NOT EXISTS idx_mailbox_messages_sender ON mailbox_messages(sender_handle_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_mailbox_messages_expires_at ON mailbox_messages(expires_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create tables for end-to-end encrypted SMS
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS e2e_keys (
+		phone_number TEXT PRIMARY KEY,
+		public_key BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS e2e_nonces (
+		phone_number TEXT NOT NULL,
+		nonce BLOB NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (phone_number, nonce)
+	);
+	CREATE INDEX IF NOT EXISTS idx_e2e_nonces_expires_at ON e2e_nonces(expires_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create tables for SMS spending and donations
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS sms_ledger (
+		day TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		segments INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, provider)
+	);
+
+	CREATE TABLE IF NOT EXISTS donations (
+		id INTEGER PRIMARY KEY,
+		source TEXT NOT NULL,
+		amount REAL NOT NULL,
+		currency TEXT NOT NULL,
+		received_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_donations_received_at ON donations(received_at);
+	`)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// pseudonym returns the keyed hash an identity is stored as in a column.
+// Rows still keyed with a previous identity secret are moved to the current
+// one first.
+func (db *DB) pseudonym(table, column, identity string) (string, error) {
+	hash := db.keys.Hash(identity)
+	previous := db.keys.PreviousHashes(identity)
+	if len(previous) == 0 {
+		return hash, nil
+	}
+
+	args := []interface{}{hash}
+	for _, previousHash := range previous {
+		args = append(args, previousHash)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(previous)), ", ")
+	_, err := db.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s IN (%s)`, table, column, column, placeholders), args...)
+	if err != nil {
+		return "", fmt.Errorf("error moving %s to the current identity secret: %v", table, err)
+	}
+	return hash, nil
+}
+
 // SaveSubscription saves a new subscription
 func (db *DB) SaveSubscription(subscriptionID, email, status string, expiryDate time.Time) error {
 	_, err := db.Exec(`
 	INSERT INTO subscriptions (subscription_id, email, status, expiry_date)
 	VALUES (?, ?, ?, ?)
-	`, subscriptionID, email, status, expiryDate)
+	`, subscriptionID, db.keys.Hash(email), status, expiryDate)
 	return err
 }
 
@@ -124,9 +301,18 @@ func (db *DB) UpdateSubscriptionStatus(subscriptionID, status string) error {
 
 // LinkPhoneToSubscription links a phone number to a subscription
 func (db *DB) LinkPhoneToSubscription(phoneNumber, email string) error {
+	email, err := db.pseudonym("subscriptions", "email", email)
+	if err != nil {
+		return err
+	}
+	phoneNumber, err = db.pseudonym("phone_subscriptions", "phone_number", phoneNumber)
+	if err != nil {
+		return err
+	}
+
 	// First, get the subscription ID
 	var subscriptionID int
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 	SELECT id FROM subscriptions
 	WHERE email = ? AND status = 'active'
 	ORDER BY expiry_date DESC
@@ -148,7 +334,12 @@ func (db *DB) LinkPhoneToSubscription(phoneNumber, email string) error {
 
 // UpdateRateLimitForPhone updates the rate limit for a phone number
 func (db *DB) UpdateRateLimitForPhone(phoneNumber string, limit int) error {
-	_, err := db.Exec(`
+	phoneNumber, err := db.pseudonym("phone_subscriptions", "phone_number", phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
 	INSERT INTO phone_subscriptions (phone_number, rate_limit)
 	VALUES (?, ?)
 	ON CONFLICT(phone_number) DO UPDATE
@@ -164,9 +355,18 @@ func (db *DB) CheckRateLimit(phoneNumber string) (bool, error) {
 		return false, fmt.Errorf("failed to clean up expired entries: %v", err)
 	}
 
+	// Both tables key the phone number with the same hash
+	if _, err := db.pseudonym("message_rate_limit", "phone_number", phoneNumber); err != nil {
+		return false, err
+	}
+	phoneNumber, err := db.pseudonym("phone_subscriptions", "phone_number", phoneNumber)
+	if err != nil {
+		return false, err
+	}
+
 	// Get the rate limit for this phone number
 	var rateLimit int
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 	SELECT COALESCE(rate_limit, 5) FROM phone_subscriptions
 	WHERE phone_number = ?
 	`, phoneNumber).Scan(&rateLimit)
@@ -215,3 +415,538 @@ func (db *DB) PurgeOldMessageData() error {
 	_, err := db.Exec(`DELETE FROM message_rate_limit WHERE sent_at < datetime('now', '-24 hours')`)
 	return err
 }
+
+// SavePortalEntry inserts a new portal entry and sets its ID and timestamps
+func (db *DB) SavePortalEntry(entry *models.PortalEntry) error {
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+	INSERT INTO portal_entries (section, title, body, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	`, entry.Section, entry.Title, entry.Body, now, now)
+	if err != nil {
+		return err
+	}
+
+	entry.ID, err = result.LastInsertId()
+	entry.CreatedAt = now
+	entry.UpdatedAt = now
+	return err
+}
+
+// UpdatePortalEntry updates the section, title and body of a portal entry
+func (db *DB) UpdatePortalEntry(entry *models.PortalEntry) error {
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+	UPDATE portal_entries
+	SET section = ?, title = ?, body = ?, updated_at = ?
+	WHERE id = ?
+	`, entry.Section, entry.Title, entry.Body, now, entry.ID)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	entry.UpdatedAt = now
+	return nil
+}
+
+// DeletePortalEntry deletes a portal entry
+func (db *DB) DeletePortalEntry(id int64) error {
+	result, err := db.Exec(`DELETE FROM portal_entries WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPortalEntry returns a portal entry by ID
+func (db *DB) GetPortalEntry(id int64) (*models.PortalEntry, error) {
+	var entry models.PortalEntry
+	err := db.QueryRow(`
+	SELECT id, section, title, body, created_at, updated_at
+	FROM portal_entries
+	WHERE id = ?
+	`, id).Scan(&entry.ID, &entry.Section, &entry.Title, &entry.Body, &entry.CreatedAt, &entry.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ListPortalEntries returns the newest entries of a section, or of all
+// sections if section is empty
+func (db *DB) ListPortalEntries(section string, limit int) ([]models.PortalEntry, error) {
+	rows, err := db.Query(`
+	SELECT id, section, title, body, created_at, updated_at
+	FROM portal_entries
+	WHERE ? = '' OR section = ?
+	ORDER BY created_at DESC, id DESC
+	LIMIT ?
+	`, section, section, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.PortalEntry
+	for rows.Next() {
+		var entry models.PortalEntry
+		if err := rows.Scan(&entry.ID, &entry.Section, &entry.Title, &entry.Body, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AddAlertSubscriber opts an address on a channel in to broadcast alerts.
+// The address is stored encrypted, subscribers are found by its hash.
+func (db *DB) AddAlertSubscriber(channel, address string) error {
+	sealed, err := db.keys.Seal(address)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO alert_subscribers (channel, address, address_hash)
+	VALUES (?, ?, ?)
+	ON CONFLICT(channel, address_hash) DO NOTHING
+	`, channel, sealed, db.keys.Hash(address))
+	return err
+}
+
+// RemoveAlertSubscriber opts an address on a channel out of broadcast alerts
+func (db *DB) RemoveAlertSubscriber(channel, address string) error {
+	_, err := db.Exec(`DELETE FROM alert_subscribers WHERE channel = ? AND address_hash = ?`, channel, db.keys.Hash(address))
+	return err
+}
+
+// ListAlertSubscribers returns all alert subscribers
+func (db *DB) ListAlertSubscribers() ([]models.AlertSubscriber, error) {
+	rows, err := db.Query(`SELECT channel, address FROM alert_subscribers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []models.AlertSubscriber
+	for rows.Next() {
+		var subscriber models.AlertSubscriber
+		if err := rows.Scan(&subscriber.Channel, &subscriber.Address); err != nil {
+			return nil, err
+		}
+		if subscriber.Address, _, err = db.keys.Open(subscriber.Address); err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers, rows.Err()
+}
+
+// SaveBroadcast inserts a new broadcast and sets its ID
+func (db *DB) SaveBroadcast(broadcast *models.Broadcast) error {
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+	INSERT INTO broadcasts (message, status, recipients, sms_segments, estimated_cost, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`, broadcast.Message, broadcast.Status, broadcast.Recipients, broadcast.SMSSegments, broadcast.EstimatedCost, now)
+	if err != nil {
+		return err
+	}
+
+	broadcast.ID, err = result.LastInsertId()
+	broadcast.CreatedAt = now
+	return err
+}
+
+// GetBroadcast returns a broadcast by ID, without its deliveries
+func (db *DB) GetBroadcast(id int64) (*models.Broadcast, error) {
+	var broadcast models.Broadcast
+	err := db.QueryRow(`
+	SELECT id, message, status, recipients, sms_segments, estimated_cost, created_at
+	FROM broadcasts
+	WHERE id = ?
+	`, id).Scan(&broadcast.ID, &broadcast.Message, &broadcast.Status, &broadcast.Recipients,
+		&broadcast.SMSSegments, &broadcast.EstimatedCost, &broadcast.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &broadcast, nil
+}
+
+// ListBroadcastIDsByStatus returns the IDs of broadcasts with the given status
+func (db *DB) ListBroadcastIDsByStatus(status string) ([]int64, error) {
+	rows, err := db.Query(`SELECT id FROM broadcasts WHERE status = ? ORDER BY id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateBroadcastStatus updates the status of a broadcast
+func (db *DB) UpdateBroadcastStatus(id int64, status string) error {
+	_, err := db.Exec(`UPDATE broadcasts SET status = ? WHERE id = ?`, status, id)
+	return err
+}
+
+// QueueBroadcast moves a draft broadcast to queued and creates its pending
+// deliveries for the given recipients. It reports false, without creating
+// deliveries, if the broadcast is not a draft.
+func (db *DB) QueueBroadcast(broadcastID int64, recipients []models.AlertSubscriber) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE broadcasts SET status = ? WHERE id = ? AND status = ?`,
+		models.BroadcastStatusQueued, broadcastID, models.BroadcastStatusDraft)
+	if err != nil {
+		return false, err
+	}
+	if queued, err := result.RowsAffected(); err != nil || queued == 0 {
+		return false, err
+	}
+
+	for _, recipient := range recipients {
+		sealed, err := db.keys.Seal(recipient.Address)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO broadcast_deliveries (broadcast_id, channel, address, status)
+		VALUES (?, ?, ?, ?)
+		`, broadcastID, recipient.Channel, sealed, models.DeliveryStatusPending)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// ListBroadcastDeliveries returns the deliveries of a broadcast
+func (db *DB) ListBroadcastDeliveries(broadcastID int64) ([]models.BroadcastDelivery, error) {
+	rows, err := db.Query(`
+	SELECT id, broadcast_id, channel, address, status, error, updated_at
+	FROM broadcast_deliveries
+	WHERE broadcast_id = ?
+	ORDER BY id
+	`, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.BroadcastDelivery
+	for rows.Next() {
+		var delivery models.BroadcastDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.BroadcastID, &delivery.Channel, &delivery.Address,
+			&delivery.Status, &delivery.Error, &delivery.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if delivery.Address, _, err = db.keys.Open(delivery.Address); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// UpdateBroadcastDelivery records the outcome of a delivery
+func (db *DB) UpdateBroadcastDelivery(id int64, status, errorMessage string) error {
+	_, err := db.Exec(`
+	UPDATE broadcast_deliveries
+	SET status = ?, error = ?, updated_at = ?
+	WHERE id = ?
+	`, status, errorMessage, time.Now().UTC(), id)
+	return err
+}
+
+// CreateMailboxHandle creates a new mailbox handle and returns its ID
+func (db *DB) CreateMailboxHandle(handle, linkCodeHash string) (int64, error) {
+	result, err := db.Exec(`
+	INSERT INTO mailbox_handles (handle, link_code_hash)
+	VALUES (?, ?)
+	`, handle, linkCodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetMailboxHandle returns a mailbox handle by name
+func (db *DB) GetMailboxHandle(handle string) (*models.MailboxHandle, error) {
+	var mailboxHandle models.MailboxHandle
+	err := db.QueryRow(`
+	SELECT id, handle, link_code_hash, created_at
+	FROM mailbox_handles
+	WHERE handle = ?
+	`, handle).Scan(&mailboxHandle.ID, &mailboxHandle.Handle, &mailboxHandle.LinkCodeHash, &mailboxHandle.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &mailboxHandle, nil
+}
+
+// GetMailboxHandleByIdentity returns the mailbox handle linked to an address
+func (db *DB) GetMailboxHandleByIdentity(channel, address string) (*models.MailboxHandle, error) {
+	address, err := db.pseudonym("mailbox_identities", "address", address)
+	if err != nil {
+		return nil, err
+	}
+
+	var mailboxHandle models.MailboxHandle
+	err = db.QueryRow(`
+	SELECT h.id, h.handle, h.link_code_hash, h.created_at
+	FROM mailbox_handles h
+	JOIN mailbox_identities i ON i.handle_id = h.id
+	WHERE i.channel = ? AND i.address = ?
+	`, channel, address).Scan(&mailboxHandle.ID, &mailboxHandle.Handle, &mailboxHandle.LinkCodeHash, &mailboxHandle.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &mailboxHandle, nil
+}
+
+// LinkMailboxIdentity links an address on a channel to a mailbox handle
+func (db *DB) LinkMailboxIdentity(handleID int64, channel, address string) error {
+	address, err := db.pseudonym("mailbox_identities", "address", address)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO mailbox_identities (handle_id, channel, address)
+	VALUES (?, ?, ?)
+	ON CONFLICT(channel, address) DO UPDATE
+	SET handle_id = ?
+	`, handleID, channel, address, handleID)
+	return err
+}
+
+// SaveMailboxMessage stores a new mailbox message and sets its ID
+func (db *DB) SaveMailboxMessage(message *models.MailboxMessage) error {
+	result, err := db.Exec(`
+	INSERT INTO mailbox_messages (recipient_handle_id, sender_handle_id, body, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?)
+	`, message.RecipientHandleID, message.SenderHandleID, message.Body, message.CreatedAt.UTC(), message.ExpiresAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	message.ID, err = result.LastInsertId()
+	return err
+}
+
+// ListMailboxMessages returns the unexpired messages of a handle, oldest first
+func (db *DB) ListMailboxMessages(handleID int64) ([]models.MailboxMessage, error) {
+	rows, err := db.Query(`
+	SELECT m.id, m.recipient_handle_id, m.sender_handle_id, h.handle, m.body, m.created_at, m.expires_at, m.read_at
+	FROM mailbox_messages m
+	JOIN mailbox_handles h ON h.id = m.sender_handle_id
+	WHERE m.recipient_handle_id = ? AND m.expires_at > ?
+	ORDER BY m.created_at, m.id
+	`, handleID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.MailboxMessage
+	for rows.Next() {
+		var message models.MailboxMessage
+		if err := rows.Scan(&message.ID, &message.RecipientHandleID, &message.SenderHandleID, &message.SenderHandle,
+			&message.Body, &message.CreatedAt, &message.ExpiresAt, &message.ReadAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// MarkMailboxMessageRead marks a mailbox message as read
+func (db *DB) MarkMailboxMessageRead(id int64) error {
+	_, err := db.Exec(`UPDATE mailbox_messages SET read_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// CountMailboxMessagesSince counts the messages a handle sent since a time
+func (db *DB) CountMailboxMessagesSince(senderHandleID int64, since time.Time) (int, error) {
+	var count int
+	err := db.QueryRow(`
+	SELECT COUNT(*) FROM mailbox_messages
+	WHERE sender_handle_id = ? AND created_at > ?
+	`, senderHandleID, since.UTC()).Scan(&count)
+	return count, err
+}
+
+// PurgeExpiredMailboxMessages deletes mailbox messages past their expiry
+func (db *DB) PurgeExpiredMailboxMessages() error {
+	_, err := db.Exec(`DELETE FROM mailbox_messages WHERE expires_at < ?`, time.Now().UTC())
+	return err
+}
+
+// SaveE2EKey saves the public key a phone number encrypts requests with
+func (db *DB) SaveE2EKey(phoneNumber string, publicKey []byte) error {
+	phoneNumber, err := db.pseudonym("e2e_keys", "phone_number", phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO e2e_keys (phone_number, public_key)
+	VALUES (?, ?)
+	ON CONFLICT(phone_number) DO UPDATE
+	SET public_key = ?, created_at = CURRENT_TIMESTAMP
+	`, phoneNumber, publicKey, publicKey)
+	return err
+}
+
+// GetE2EKey returns the public key registered by a phone number
+func (db *DB) GetE2EKey(phoneNumber string) ([]byte, error) {
+	phoneNumber, err := db.pseudonym("e2e_keys", "phone_number", phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKey []byte
+	err = db.QueryRow(`SELECT public_key FROM e2e_keys WHERE phone_number = ?`, phoneNumber).Scan(&publicKey)
+	return publicKey, err
+}
+
+// UseE2ENonce records the nonce of an encrypted request, it returns false if
+// the phone number already used it
+func (db *DB) UseE2ENonce(phoneNumber string, nonce []byte, expiresAt time.Time) (bool, error) {
+	phoneNumber, err := db.pseudonym("e2e_nonces", "phone_number", phoneNumber)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Exec(`
+	INSERT INTO e2e_nonces (phone_number, nonce, expires_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(phone_number, nonce) DO NOTHING
+	`, phoneNumber, nonce, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	return inserted == 1, err
+}
+
+// PurgeExpiredE2ENonces deletes nonces that can no longer be replayed
+func (db *DB) PurgeExpiredE2ENonces() error {
+	_, err := db.Exec(`DELETE FROM e2e_nonces WHERE expires_at < ?`, time.Now().UTC())
+	return err
+}
+
+// IsSubscriber reports whether a phone number is linked to an active
+// subscription
+func (db *DB) IsSubscriber(phoneNumber string) (bool, error) {
+	phoneNumber, err := db.pseudonym("phone_subscriptions", "phone_number", phoneNumber)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = db.QueryRow(`
+	SELECT COUNT(*) FROM phone_subscriptions p
+	JOIN subscriptions s ON s.id = p.subscription_id
+	WHERE p.phone_number = ? AND s.status = 'active' AND datetime(s.expiry_date) > datetime('now')
+	`, phoneNumber).Scan(&count)
+	return count > 0, err
+}
+
+// RecordSMSCost adds SMS segments sent through a provider and their cost to
+// the ledger of a day
+func (db *DB) RecordSMSCost(day, provider string, segments int, cost float64) error {
+	_, err := db.Exec(`
+	INSERT INTO sms_ledger (day, provider, segments, cost)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(day, provider) DO UPDATE
+	SET segments = segments + excluded.segments, cost = cost + excluded.cost
+	`, day, provider, segments, cost)
+	return err
+}
+
+// ListSMSLedger returns the ledger entries of the days from fromDay to toDay
+func (db *DB) ListSMSLedger(fromDay, toDay string) ([]models.SMSLedgerEntry, error) {
+	rows, err := db.Query(`
+	SELECT day, provider, segments, cost FROM sms_ledger
+	WHERE day BETWEEN ? AND ?
+	ORDER BY day, provider
+	`, fromDay, toDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.SMSLedgerEntry
+	for rows.Next() {
+		var entry models.SMSLedgerEntry
+		if err := rows.Scan(&entry.Day, &entry.Provider, &entry.Segments, &entry.Cost); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// SumSMSCost returns the SMS spending of the days from fromDay to toDay
+func (db *DB) SumSMSCost(fromDay, toDay string) (float64, error) {
+	var cost float64
+	err := db.QueryRow(`
+	SELECT COALESCE(SUM(cost), 0) FROM sms_ledger WHERE day BETWEEN ? AND ?
+	`, fromDay, toDay).Scan(&cost)
+	return cost, err
+}
+
+// RecordDonation saves a donation received through a payment service
+func (db *DB) RecordDonation(source string, amount float64, currency string, receivedAt time.Time) error {
+	_, err := db.Exec(`
+	INSERT INTO donations (source, amount, currency, received_at)
+	VALUES (?, ?, ?, ?)
+	`, source, amount, currency, receivedAt.UTC())
+	return err
+}
+
+// SumDonations returns the donations received in [from, to) by currency
+func (db *DB) SumDonations(from, to time.Time) (map[string]float64, error) {
+	rows, err := db.Query(`
+	SELECT currency, SUM(amount) FROM donations
+	WHERE datetime(received_at) >= datetime(?) AND datetime(received_at) < datetime(?)
+	GROUP BY currency
+	`, from.UTC().Format(time.DateTime), to.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	donations := make(map[string]float64)
+	for rows.Next() {
+		var currency string
+		var amount float64
+		if err := rows.Scan(&currency, &amount); err != nil {
+			return nil, err
+		}
+		donations[currency] = amount
+	}
+	return donations, rows.Err()
+}
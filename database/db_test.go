@@ -0,0 +1,328 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+func newTestKeys(t *testing.T, secrets ...string) *utils.IdentityKeys {
+	t.Helper()
+	keys, err := utils.NewIdentityKeys(secrets...)
+	if err != nil {
+		t.Fatalf("Error creating keys: %v", err)
+	}
+	return keys
+}
+
+func openTestDB(t *testing.T, path string, keys *utils.IdentityKeys) *DB {
+	t.Helper()
+	testDB, err := openDB(path, keys)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+	return testDB
+}
+
+func userVersion(t *testing.T, conn *sql.DB) int {
+	t.Helper()
+	var version int
+	if err := conn.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatalf("Error reading user_version: %v", err)
+	}
+	return version
+}
+
+// storedIdentities returns the identity columns as they are stored
+func storedIdentities(t *testing.T, conn *sql.DB) map[string][]string {
+	t.Helper()
+	queries := map[string]string{
+		"subscriptions":        `SELECT email FROM subscriptions ORDER BY id`,
+		"phone_subscriptions":  `SELECT phone_number FROM phone_subscriptions ORDER BY id`,
+		"mailbox_identities":   `SELECT address FROM mailbox_identities ORDER BY id`,
+		"alert_subscribers":    `SELECT address || ' ' || address_hash FROM alert_subscribers ORDER BY id`,
+		"broadcast_deliveries": `SELECT address FROM broadcast_deliveries ORDER BY id`,
+	}
+
+	stored := make(map[string][]string)
+	for table, query := range queries {
+		rows, err := conn.Query(query)
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", table, err)
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				t.Fatalf("Error reading %s: %v", table, err)
+			}
+			stored[table] = append(stored[table], value)
+		}
+		rows.Close()
+	}
+	return stored
+}
+
+// createPreviousSchema creates a database as it was before identities were
+// pseudonymized, with rows in clear text
+func createPreviousSchema(t *testing.T, path string) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer conn.Close()
+
+	if err := createTables(conn); err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+	_, err = conn.Exec(`
+	CREATE UNIQUE INDEX idx_alert_subscribers_address ON alert_subscribers(channel, address);
+
+	INSERT INTO subscriptions (subscription_id, email, status, expiry_date)
+	VALUES ('sub-1', 'user@example.com', 'active', '2030-01-01 00:00:00');
+	INSERT INTO phone_subscriptions (phone_number, rate_limit) VALUES ('+905551112233', 10);
+	INSERT INTO mailbox_handles (handle, link_code_hash) VALUES ('fox', 'code');
+	INSERT INTO mailbox_identities (handle_id, channel, address) VALUES (1, 'sms', '+905551112233');
+	INSERT INTO alert_subscribers (channel, address) VALUES ('sms', '+905551112233');
+	INSERT INTO broadcasts (id, message, status) VALUES (1, 'Alert', 'sent');
+	INSERT INTO broadcast_deliveries (broadcast_id, channel, address, status) VALUES (1, 'sms', '+905551112233', 'sent');
+	`)
+	if err != nil {
+		t.Fatalf("Error inserting rows: %v", err)
+	}
+}
+
+func TestOpenDB_MigratesPreviousSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "neo146.db")
+	createPreviousSchema(t, path)
+	keys := newTestKeys(t, "secret")
+
+	testDB := openTestDB(t, path, keys)
+	if version := userVersion(t, testDB.DB); version != len(migrations) {
+		t.Errorf("Expected user_version %d, got %d", len(migrations), version)
+	}
+
+	stored := storedIdentities(t, testDB.DB)
+	hash := keys.Hash("+905551112233")
+	if stored["subscriptions"][0] != keys.Hash("user@example.com") {
+		t.Errorf("Expected the email to be hashed, got %q", stored["subscriptions"][0])
+	}
+	if stored["phone_subscriptions"][0] != hash {
+		t.Errorf("Expected the phone number to be hashed, got %q", stored["phone_subscriptions"][0])
+	}
+	if stored["mailbox_identities"][0] != hash {
+		t.Errorf("Expected the mailbox identity to be hashed, got %q", stored["mailbox_identities"][0])
+	}
+	for table, values := range stored {
+		for _, value := range values {
+			if value == "+905551112233" || value == "user@example.com" {
+				t.Errorf("Expected no identity in clear text in %s", table)
+			}
+		}
+	}
+
+	// Hashed rows are found by the identity
+	handle, err := testDB.GetMailboxHandleByIdentity("sms", "+905551112233")
+	if err != nil || handle.Handle != "fox" {
+		t.Errorf("Expected the mailbox handle fox, got %v, %v", handle, err)
+	}
+	var rateLimit int
+	if err := testDB.QueryRow(`SELECT rate_limit FROM phone_subscriptions WHERE phone_number = ?`, hash).Scan(&rateLimit); err != nil || rateLimit != 10 {
+		t.Errorf("Expected the rate limit 10, got %d, %v", rateLimit, err)
+	}
+
+	// Sealed addresses are opened
+	subscribers, err := testDB.ListAlertSubscribers()
+	if err != nil {
+		t.Fatalf("Error listing alert subscribers: %v", err)
+	}
+	if len(subscribers) != 1 || subscribers[0].Address != "+905551112233" {
+		t.Errorf("Expected the subscriber address, got %v", subscribers)
+	}
+	deliveries, err := testDB.ListBroadcastDeliveries(1)
+	if err != nil {
+		t.Fatalf("Error listing deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Address != "+905551112233" {
+		t.Errorf("Expected the delivery address, got %v", deliveries)
+	}
+
+	// The subscriber is unique by the hash of the address
+	if err := testDB.AddAlertSubscriber("sms", "+905551112233"); err != nil {
+		t.Fatalf("Error adding alert subscriber: %v", err)
+	}
+	if subscribers, _ := testDB.ListAlertSubscribers(); len(subscribers) != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", len(subscribers))
+	}
+}
+
+func TestOpenDB_Fresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "neo146.db")
+	testDB := openTestDB(t, path, newTestKeys(t, "secret"))
+
+	if version := userVersion(t, testDB.DB); version != len(migrations) {
+		t.Errorf("Expected user_version %d, got %d", len(migrations), version)
+	}
+
+	if err := testDB.AddAlertSubscriber("matrix", "@fox:hs.test"); err != nil {
+		t.Fatalf("Error adding alert subscriber: %v", err)
+	}
+	if err := testDB.AddAlertSubscriber("matrix", "@fox:hs.test"); err != nil {
+		t.Fatalf("Error adding alert subscriber: %v", err)
+	}
+	subscribers, err := testDB.ListAlertSubscribers()
+	if err != nil {
+		t.Fatalf("Error listing alert subscribers: %v", err)
+	}
+	if len(subscribers) != 1 || subscribers[0].Address != "@fox:hs.test" {
+		t.Errorf("Expected one subscriber, got %v", subscribers)
+	}
+}
+
+func TestOpenDB_RotatesSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "neo146.db")
+	oldKeys := newTestKeys(t, "old secret")
+
+	oldDB, err := openDB(path, oldKeys)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	handleID, err := oldDB.CreateMailboxHandle("fox", "code")
+	if err != nil {
+		t.Fatalf("Error creating handle: %v", err)
+	}
+	if err := oldDB.LinkMailboxIdentity(handleID, "sms", "+905551112233"); err != nil {
+		t.Fatalf("Error linking identity: %v", err)
+	}
+	if err := oldDB.AddAlertSubscriber("sms", "+905551112233"); err != nil {
+		t.Fatalf("Error adding alert subscriber: %v", err)
+	}
+	broadcast := &models.Broadcast{Message: "Alert", Status: models.BroadcastStatusDraft}
+	if err := oldDB.SaveBroadcast(broadcast); err != nil {
+		t.Fatalf("Error saving broadcast: %v", err)
+	}
+	if _, err := oldDB.QueueBroadcast(broadcast.ID, []models.AlertSubscriber{{Channel: "sms", Address: "+905551112233"}}); err != nil {
+		t.Fatalf("Error queueing broadcast: %v", err)
+	}
+	oldDB.Close()
+
+	keys := newTestKeys(t, "new secret", "old secret")
+	testDB := openTestDB(t, path, keys)
+	hash := keys.Hash("+905551112233")
+
+	// Alert subscribers are rehashed and resealed when the database is opened
+	var sealed, addressHash string
+	if err := testDB.QueryRow(`SELECT address, address_hash FROM alert_subscribers`).Scan(&sealed, &addressHash); err != nil {
+		t.Fatalf("Error reading alert subscriber: %v", err)
+	}
+	if addressHash != hash {
+		t.Errorf("Expected the address hash of the new secret, got %q", addressHash)
+	}
+	if address, current, err := keys.Open(sealed); err != nil || !current || address != "+905551112233" {
+		t.Errorf("Expected the address sealed with the new secret, got %q, %v, %v", address, current, err)
+	}
+	if err := testDB.RemoveAlertSubscriber("sms", "+905551112233"); err != nil {
+		t.Fatalf("Error removing alert subscriber: %v", err)
+	}
+	if subscribers, _ := testDB.ListAlertSubscribers(); len(subscribers) != 0 {
+		t.Errorf("Expected the subscriber to be found by the new hash, got %v", subscribers)
+	}
+
+	var delivery string
+	if err := testDB.QueryRow(`SELECT address FROM broadcast_deliveries`).Scan(&delivery); err != nil {
+		t.Fatalf("Error reading delivery: %v", err)
+	}
+	if _, current, err := keys.Open(delivery); err != nil || !current {
+		t.Errorf("Expected the delivery sealed with the new secret, got %v, %v", current, err)
+	}
+
+	// Hashed identities move to the new secret when they are looked up
+	var stored string
+	testDB.QueryRow(`SELECT address FROM mailbox_identities`).Scan(&stored)
+	if stored != oldKeys.Hash("+905551112233") {
+		t.Errorf("Expected the identity to keep the old hash until it is looked up, got %q", stored)
+	}
+	handle, err := testDB.GetMailboxHandleByIdentity("sms", "+905551112233")
+	if err != nil || handle.ID != handleID {
+		t.Fatalf("Expected the mailbox handle, got %v, %v", handle, err)
+	}
+	testDB.QueryRow(`SELECT address FROM mailbox_identities`).Scan(&stored)
+	if stored != hash {
+		t.Errorf("Expected the identity to move to the new hash, got %q", stored)
+	}
+}
+
+func TestOpenDB_Idempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "neo146.db")
+	createPreviousSchema(t, path)
+	keys := newTestKeys(t, "new secret", "old secret")
+
+	first, err := openDB(path, keys)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	stored := storedIdentities(t, first.DB)
+	first.Close()
+
+	// Opening the database again neither hashes nor reseals twice
+	testDB := openTestDB(t, path, keys)
+	if err := testDB.migrate(); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+	if err := testDB.resealAddresses(); err != nil {
+		t.Fatalf("Error resealing again: %v", err)
+	}
+	if version := userVersion(t, testDB.DB); version != len(migrations) {
+		t.Errorf("Expected user_version %d, got %d", len(migrations), version)
+	}
+	if again := storedIdentities(t, testDB.DB); !reflect.DeepEqual(again, stored) {
+		t.Errorf("Expected the stored identities not to change, got %v, want %v", again, stored)
+	}
+
+	handle, err := testDB.GetMailboxHandleByIdentity("sms", "+905551112233")
+	if err != nil || handle.Handle != "fox" {
+		t.Errorf("Expected the mailbox handle fox, got %v, %v", handle, err)
+	}
+	subscribers, err := testDB.ListAlertSubscribers()
+	if err != nil || len(subscribers) != 1 || subscribers[0].Address != "+905551112233" {
+		t.Errorf("Expected the subscriber address, got %v, %v", subscribers, err)
+	}
+}
+
+func TestQueueBroadcast(t *testing.T) {
+	testDB := openTestDB(t, filepath.Join(t.TempDir(), "neo146.db"), newTestKeys(t, "secret"))
+	recipients := []models.AlertSubscriber{{Channel: "sms", Address: "+905551112233"}}
+
+	broadcast := &models.Broadcast{Message: "Alert", Status: models.BroadcastStatusDraft}
+	if err := testDB.SaveBroadcast(broadcast); err != nil {
+		t.Fatalf("Error saving broadcast: %v", err)
+	}
+
+	queued, err := testDB.QueueBroadcast(broadcast.ID, recipients)
+	if err != nil || !queued {
+		t.Fatalf("Expected the draft to be queued, got %v, %v", queued, err)
+	}
+
+	// Only drafts are queued
+	queued, err = testDB.QueueBroadcast(broadcast.ID, recipients)
+	if err != nil || queued {
+		t.Errorf("Expected a queued broadcast not to be queued again, got %v, %v", queued, err)
+	}
+	if queued, err := testDB.QueueBroadcast(broadcast.ID+1, recipients); err != nil || queued {
+		t.Errorf("Expected a missing broadcast not to be queued, got %v, %v", queued, err)
+	}
+
+	saved, err := testDB.GetBroadcast(broadcast.ID)
+	if err != nil || saved.Status != models.BroadcastStatusQueued {
+		t.Errorf("Expected the broadcast to be queued, got %v, %v", saved, err)
+	}
+	deliveries, err := testDB.ListBroadcastDeliveries(broadcast.ID)
+	if err != nil || len(deliveries) != 1 {
+		t.Errorf("Expected 1 delivery, got %v, %v", deliveries, err)
+	}
+}
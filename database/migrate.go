@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations upgrade the tables created by createTables. The number of
+// migrations applied to a database is kept in its user_version.
+var migrations = []func(db *DB, tx *sql.Tx) error{
+	pseudonymizeIdentities,
+}
+
+// hashedIdentityColumns are the columns holding identities that are only
+// looked up, stored as keyed hashes
+var hashedIdentityColumns = []struct{ table, column string }{
+	{"subscriptions", "email"},
+	{"phone_subscriptions", "phone_number"},
+	{"message_rate_limit", "phone_number"},
+	{"mailbox_identities", "address"},
+	{"e2e_keys", "phone_number"},
+	{"e2e_nonces", "phone_number"},
+}
+
+// migrate applies the migrations a database is missing, each in its own
+// transaction
+func (db *DB) migrate() error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](db, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", version+1, err)
+		}
+		// PRAGMA does not take parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pseudonymizeIdentities replaces the identities stored in clear text with
+// keyed hashes, and encrypts the addresses alerts are sent to
+func pseudonymizeIdentities(db *DB, tx *sql.Tx) error {
+	for _, c := range hashedIdentityColumns {
+		if err := updateColumn(tx, c.table, c.column, func(identity string) (string, error) {
+			return db.keys.Hash(identity), nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Alert subscribers are looked up by the hash and alerts are sent to the
+	// encrypted address
+	_, err := tx.Exec(`
+	ALTER TABLE alert_subscribers ADD COLUMN address_hash TEXT NOT NULL DEFAULT '';
+	UPDATE alert_subscribers SET address_hash = address;
+	DROP INDEX IF EXISTS idx_alert_subscribers_address;
+	CREATE UNIQUE INDEX idx_alert_subscribers_address_hash ON alert_subscribers(channel, address_hash);
+	`)
+	if err != nil {
+		return err
+	}
+	if err := updateColumn(tx, "alert_subscribers", "address_hash", func(identity string) (string, error) {
+		return db.keys.Hash(identity), nil
+	}); err != nil {
+		return err
+	}
+	for _, table := range []string{"alert_subscribers", "broadcast_deliveries"} {
+		if err := updateColumn(tx, table, "address", db.keys.Seal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateColumn replaces each value of a column with its conversion
+func updateColumn(tx *sql.Tx, table, column string, convert func(string) (string, error)) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT rowid, %s FROM %s`, column, table))
+	if err != nil {
+		return err
+	}
+
+	values := make(map[int64]string)
+	for rows.Next() {
+		var rowID int64
+		var value string
+		if err := rows.Scan(&rowID, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		values[rowID] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for rowID, value := range values {
+		converted, err := convert(value)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column), converted, rowID); err != nil {
+			return fmt.Errorf("error updating %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// resealAddresses moves the encrypted addresses sealed with a previous
+// identity secret, and the hashes of alert subscribers, to the current
+// secret. Hashed identities move when they are next looked up.
+func (db *DB) resealAddresses() error {
+	if !db.keys.Rotated() {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateColumn(tx, "broadcast_deliveries", "address", func(sealed string) (string, error) {
+		address, current, err := db.keys.Open(sealed)
+		if err != nil || current {
+			return sealed, err
+		}
+		return db.keys.Seal(address)
+	}); err != nil {
+		return err
+	}
+
+	// Subscribers are rehashed from their address, which is known here
+	rows, err := tx.Query(`SELECT id, address FROM alert_subscribers`)
+	if err != nil {
+		return err
+	}
+	addresses := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var sealed string
+		if err := rows.Scan(&id, &sealed); err != nil {
+			rows.Close()
+			return err
+		}
+		address, current, err := db.keys.Open(sealed)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if !current {
+			addresses[id] = address
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, address := range addresses {
+		sealed, err := db.keys.Seal(address)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE alert_subscribers SET address = ?, address_hash = ? WHERE id = ?`,
+			sealed, db.keys.Hash(address), id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -2,14 +2,15 @@ package main
 
 import (
 	"embed"
-	"fmt"
-	"log"
+	"log/slog"
 	"neo146/config"
 	"neo146/controllers"
 	"neo146/database"
+	"neo146/models"
 	"neo146/providers"
 	"neo146/routes"
 	"neo146/services"
+	"neo146/utils"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,8 +21,6 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/favicon"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
@@ -33,14 +32,27 @@ func main() {
 	// Initialize config
 	cfg, err := config.NewConfig()
 	if err != nil {
-		fmt.Printf("Error initializing config: %v\n", err)
+		slog.Error("Error initializing config", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(utils.NewLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel))
+
+	// Identities of users are pseudonymized before they are stored
+	identitySecrets, err := utils.LoadOrCreateIdentitySecrets(cfg.IdentitySecretFile)
+	if err != nil {
+		slog.Error("Error loading identity secrets", "error", err)
+		os.Exit(1)
+	}
+	identityKeys, err := utils.NewIdentityKeys(identitySecrets...)
+	if err != nil {
+		slog.Error("Error initializing identity keys", "error", err)
 		os.Exit(1)
 	}
 
 	// Initialize database
-	db, err := database.InitDB()
+	db, err := database.InitDB(identityKeys)
 	if err != nil {
-		fmt.Printf("Error initializing database: %v\n", err)
+		slog.Error("Error initializing database", "error", err)
 		os.Exit(1)
 	}
 	defer db.Close()
@@ -53,21 +65,61 @@ func main() {
 		Timeout: 10 * time.Second,
 	}
 
+	// URLs sent by users are fetched with a client that cannot reach the
+	// gateway itself or the network it runs in
+	publicClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: utils.NewPublicTransport(),
+	}
+
 	// Initialize services
 	providerManager := providers.NewManager()
 	providerManager.RegisterProvider(providers.NewVerimorProvider())
 
-	markdownService := services.NewMarkdownService(httpClient)
-	twitterService := services.NewTwitterService(httpClient)
-	searchService := services.NewSearchService(httpClient)
-	weatherService := services.NewWeatherService(httpClient)
+	// Each upstream service gets its own instrumented client, so its latency
+	// and errors are reported separately
+	markdownService := services.NewMarkdownService(utils.InstrumentClient(httpClient, "markdown"))
+	twitterService := services.NewTwitterService(utils.InstrumentClient(httpClient, "twitter"))
+	searchService := services.NewSearchService(utils.InstrumentClient(httpClient, "search"))
+	weatherService := services.NewWeatherService(utils.InstrumentClient(httpClient, "weather"))
 	subscriptionService := services.NewSubscriptionService()
+	feedService := services.NewFeedService(utils.InstrumentClient(publicClient, "feed"), markdownService, cfg.FeedAliases)
+	portalService := services.NewPortalService(db)
+	mailboxService := services.NewMailboxService(db, cfg.MailboxTTL, cfg.MailboxSendLimit)
+	costService := services.NewCostService(db, services.CostConfig{
+		SegmentCost:        cfg.SMSSegmentCost,
+		PrefixCosts:        cfg.SMSPrefixCosts,
+		Currency:           cfg.SMSCostCurrency,
+		DailyBudget:        cfg.SMSDailyBudget,
+		MonthlyBudget:      cfg.SMSMonthlyBudget,
+		WarnRatio:          cfg.SMSBudgetWarn,
+		LowParts:           cfg.SMSBudgetLowParts,
+		FreeSegments:       cfg.SMSFreeSegments,
+		SubscriberSegments: cfg.SMSSubscriberSegments,
+	})
+	broadcastService := services.NewBroadcastService(db, cfg.BroadcastInterval, costService, providerManager.ProviderName())
 
 	smsService := services.NewSMSService(providerManager)
 	smsService.MarkdownService = markdownService
 	smsService.TwitterService = twitterService
 	smsService.SearchService = searchService
 	smsService.WeatherService = weatherService
+	smsService.FeedService = feedService
+	smsService.PortalService = portalService
+	smsService.BroadcastService = broadcastService
+	smsService.MailboxService = mailboxService
+	smsService.CostService = costService
+	smsService.SummaryService = services.NewSummaryService(markdownService, cfg.SummaryLength)
+	if cfg.E2EKeyFile != "" {
+		e2eKey, err := utils.LoadOrCreateE2EKey(cfg.E2EKeyFile)
+		if err != nil {
+			slog.Error("Failed to load encryption key", "error", err)
+			os.Exit(1)
+		}
+		smsService.E2EService = services.NewE2EService(db, e2eKey, cfg.E2EMaxAge)
+		slog.Info("Encrypted SMS enabled", "public_key", smsService.E2EService.PublicKey())
+	}
+	broadcastService.RegisterSender(models.ChannelSMS, smsService)
 
 	// Initialize controllers
 	docController := controllers.NewDocController(cfg)
@@ -76,8 +128,9 @@ func main() {
 		twitterService,
 		searchService,
 		weatherService,
+		feedService,
 	)
-	webhookController := controllers.NewWebhookController(subscriptionService)
+	webhookController := controllers.NewWebhookController(subscriptionService, costService)
 	smsController := controllers.NewSMSController(
 		&controllers.Config{Environment: string(cfg.Environment)},
 		smsService,
@@ -86,14 +139,262 @@ func main() {
 		searchService,
 		weatherService,
 		subscriptionService,
+		feedService,
+		portalService,
+		broadcastService,
+		mailboxService,
 	)
+	portalController := controllers.NewPortalController(portalService)
+	adminController := controllers.NewAdminController(cfg.AdminToken, portalService, broadcastService, costService)
+	sstvService := services.NewSSTVService(utils.InstrumentClient(publicClient, "sstv"), markdownService)
+	sstvController := controllers.NewSSTVController(sstvService)
+	modemController := controllers.NewModemController(services.NewModemService(services.ModemConfig{
+		MaxResponse: cfg.ModemMaxResponse,
+	}, services.NewCommandService(smsService)))
 
 	// Initialize Telegram bot controller
 	telegramController, err := controllers.NewTelegramController(smsService, subscriptionService)
 	if err != nil {
-		log.Printf("Error initializing Telegram bot: %v", err)
+		slog.Error("Error initializing Telegram bot", "error", err)
+	} else {
+		broadcastService.RegisterSender(models.ChannelTelegram, telegramController.TelegramService)
+	}
+
+	// Initialize the email channel
+	var emailService *services.EmailService
+	if cfg.EmailAddress != "" {
+		emailService = services.NewEmailService(services.EmailConfig{
+			Address:      cfg.EmailAddress,
+			ListenAddr:   cfg.EmailListenAddr,
+			SMTPHost:     cfg.SMTPHost,
+			SMTPPort:     cfg.SMTPPort,
+			SMTPUsername: cfg.SMTPUsername,
+			SMTPPassword: cfg.SMTPPassword,
+			AuthServID:   cfg.EmailAuthServID,
+		}, services.NewCommandService(smsService), services.NewRateLimiter(cfg.EmailRateLimit, time.Hour))
+		broadcastService.RegisterSender(models.ChannelEmail, emailService)
+
+		go func() {
+			if err := emailService.ListenAndServe(); err != nil {
+				slog.Error("Email channel stopped", "error", err)
+			}
+		}()
+	}
+
+	// Initialize the Matrix bot
+	var matrixService *services.MatrixService
+	if cfg.MatrixAccessToken != "" {
+		// Sync requests are long polls, so they need a longer timeout
+		matrixService = services.NewMatrixService(
+			cfg.MatrixHomeserverURL,
+			cfg.MatrixAccessToken,
+			utils.InstrumentClient(&http.Client{Timeout: time.Minute}, "matrix"),
+			services.NewCommandService(smsService),
+			services.NewRateLimiter(cfg.MatrixRateLimit, time.Hour),
+		)
+		broadcastService.RegisterSender(models.ChannelMatrix, matrixService)
+
+		go func() {
+			for {
+				err := matrixService.Start()
+				if err != nil {
+					slog.Error("Failed to start Matrix bot", "error", err)
+					time.Sleep(5 * time.Second) // Wait before retrying
+					continue
+				}
+				break
+			}
+		}()
 	}
 
+	// Initialize the XMPP bot
+	var xmppService *services.XMPPService
+	if cfg.XMPPJID != "" {
+		xmppService = services.NewXMPPService(services.XMPPConfig{
+			JID:      cfg.XMPPJID,
+			Password: cfg.XMPPPassword,
+			Server:   cfg.XMPPServer,
+		}, services.NewCommandService(smsService), services.NewRateLimiter(cfg.XMPPRateLimit, time.Hour))
+		broadcastService.RegisterSender(models.ChannelXMPP, xmppService)
+
+		// Start returns when the connection is lost, so keep reconnecting
+		go func() {
+			for {
+				err := xmppService.Start()
+				if err != nil {
+					slog.Error("XMPP bot disconnected", "error", err)
+					time.Sleep(5 * time.Second) // Wait before retrying
+					continue
+				}
+				break
+			}
+		}()
+	}
+
+	// Initialize the APRS channel
+	var aprsService *services.APRSService
+	if cfg.APRSCallsign != "" {
+		aprsService = services.NewAPRSService(services.APRSConfig{
+			Callsign: cfg.APRSCallsign,
+			Server:   cfg.APRSServer,
+			Passcode: cfg.APRSPasscode,
+			KISSAddr: cfg.APRSKISSAddr,
+		}, services.NewCommandService(smsService), services.NewRateLimiter(cfg.APRSRateLimit, time.Hour))
+
+		// Start returns when the connection is lost, so keep reconnecting
+		go func() {
+			for {
+				err := aprsService.Start()
+				if err != nil {
+					slog.Error("APRS gateway disconnected", "error", err)
+					time.Sleep(5 * time.Second) // Wait before retrying
+					continue
+				}
+				break
+			}
+		}()
+	}
+
+	// Initialize the Meshtastic channel
+	var meshtasticService *services.MeshtasticService
+	if cfg.MeshtasticAddr != "" {
+		meshtasticService = services.NewMeshtasticService(services.MeshtasticConfig{
+			Addr: cfg.MeshtasticAddr,
+		}, services.NewCommandService(smsService), services.NewRateLimiter(cfg.MeshtasticRateLimit, time.Hour))
+		broadcastService.RegisterSender(models.ChannelMeshtastic, meshtasticService)
+
+		// Start returns when the connection is lost, so keep reconnecting
+		go func() {
+			for {
+				err := meshtasticService.Start()
+				if err != nil {
+					slog.Error("Meshtastic channel disconnected", "error", err)
+					time.Sleep(5 * time.Second) // Wait before retrying
+					continue
+				}
+				break
+			}
+		}()
+	}
+
+	// Requests over HTTP and the other protocol listeners share the limit
+	// per client IP
+	requestLimiter := services.NewRateLimiter(100, time.Minute)
+
+	// Initialize the Gopher server
+	var gopherServer *services.GopherServer
+	if cfg.GopherListenAddr != "" {
+		gopherServer = services.NewGopherServer(services.GopherConfig{
+			ListenAddr: cfg.GopherListenAddr,
+			Hostname:   cfg.GopherHostname,
+			Port:       cfg.GopherPort,
+		}, smsService, requestLimiter)
+
+		go func() {
+			if err := gopherServer.ListenAndServe(); err != nil {
+				slog.Error("Gopher server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Initialize the Gemini server
+	var geminiServer *services.GeminiServer
+	if cfg.GeminiListenAddr != "" {
+		geminiServer = services.NewGeminiServer(services.GeminiConfig{
+			ListenAddr: cfg.GeminiListenAddr,
+			Hostname:   cfg.GeminiHostname,
+			CertFile:   cfg.GeminiCertFile,
+			KeyFile:    cfg.GeminiKeyFile,
+		}, smsService, requestLimiter)
+
+		go func() {
+			if err := geminiServer.ListenAndServe(); err != nil {
+				slog.Error("Gemini server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Initialize the telnet BBS
+	var telnetServer *services.TelnetServer
+	if cfg.TelnetListenAddr != "" {
+		telnetServer = services.NewTelnetServer(services.TelnetConfig{
+			ListenAddr: cfg.TelnetListenAddr,
+		}, smsService, services.NewRateLimiter(cfg.TelnetRateLimit, time.Minute))
+
+		go func() {
+			if err := telnetServer.ListenAndServe(); err != nil {
+				slog.Error("Telnet server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Initialize the finger server
+	var fingerServer *services.FingerServer
+	if cfg.FingerListenAddr != "" {
+		fingerServer = services.NewFingerServer(services.FingerConfig{
+			ListenAddr:  cfg.FingerListenAddr,
+			MaxResponse: cfg.FingerMaxResponse,
+		}, smsService, requestLimiter)
+
+		go func() {
+			if err := fingerServer.ListenAndServe(); err != nil {
+				slog.Error("Finger server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Initialize the DNS gateway
+	var dnsServer *services.DNSServer
+	if cfg.DNSListenAddr != "" && cfg.DNSZone != "" {
+		dnsServer = services.NewDNSServer(services.DNSConfig{
+			ListenAddr: cfg.DNSListenAddr,
+			Zone:       cfg.DNSZone,
+			TTL:        cfg.DNSTTL,
+		}, smsService, services.NewRateLimiter(cfg.DNSRateLimit, time.Minute))
+
+		go func() {
+			if err := dnsServer.ListenAndServe(); err != nil {
+				slog.Error("DNS gateway stopped", "error", err)
+			}
+		}()
+	}
+
+	// Initialize the SIP voice channel
+	var sipServer *services.SIPServer
+	if cfg.SIPListenAddr != "" {
+		var speaker services.Speaker
+		if cfg.IVRTTSCommand != "" {
+			speaker = services.NewCommandSpeaker(cfg.IVRTTSCommand)
+		} else if cfg.IVRPromptsDir != "" {
+			segmentSpeaker, err := services.NewSegmentSpeaker(cfg.IVRPromptsDir)
+			if err != nil {
+				slog.Error("Error loading IVR prompts", "error", err)
+			} else {
+				speaker = segmentSpeaker
+			}
+		}
+
+		if speaker == nil {
+			slog.Warn("SIP server not started: set IVR_TTS_COMMAND or IVR_PROMPTS_DIR")
+		} else {
+			sipServer = services.NewSIPServer(services.SIPConfig{
+				ListenAddr: cfg.SIPListenAddr,
+				PublicIP:   cfg.SIPPublicIP,
+				MaxCalls:   cfg.SIPMaxCalls,
+			}, smsService, speaker, services.NewRateLimiter(cfg.SIPRateLimit, time.Hour))
+
+			go func() {
+				if err := sipServer.ListenAndServe(); err != nil {
+					slog.Error("SIP server stopped", "error", err)
+				}
+			}()
+		}
+	}
+
+	// Start delivering queued broadcasts
+	go broadcastService.Start()
+	go sstvService.Start()
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:      "neo146 - infogate",
@@ -102,13 +403,10 @@ func main() {
 
 	// Middleware
 	app.Use(cors.New())
-	app.Use(logger.New())
 	app.Use(recover.New())
 	app.Use(requestid.New())
-	app.Use(limiter.New(limiter.Config{
-		Max:        100,
-		Expiration: 1 * time.Minute,
-	}))
+	app.Use(controllers.LogRequests())
+	app.Use(controllers.RateLimit(requestLimiter))
 	app.Use("/static", filesystem.New(filesystem.Config{
 		Root:       http.FS(embedDirStatic),
 		PathPrefix: "static",
@@ -120,14 +418,14 @@ func main() {
 	}))
 
 	// Setup routes
-	routes.SetupRoutes(app, docController, contentController, webhookController, smsController)
+	routes.SetupRoutes(app, docController, contentController, webhookController, smsController, portalController, adminController, modemController, sstvController)
 
 	// Start Telegram bot in a goroutine
 	go func() {
 		for {
 			err := telegramController.StartBot()
 			if err != nil {
-				log.Printf("Failed to start Telegram bot: %v", err)
+				slog.Error("Failed to start Telegram bot", "error", err)
 				time.Sleep(5 * time.Second) // Wait before retrying
 				continue
 			}
@@ -141,14 +439,50 @@ func main() {
 
 	go func() {
 		<-quit
-		log.Println("Shutting down server...")
+		slog.Info("Shutting down server")
+		broadcastService.Stop()
+		sstvService.Stop()
+		if emailService != nil {
+			emailService.Close()
+		}
+		if matrixService != nil {
+			matrixService.Stop()
+		}
+		if xmppService != nil {
+			xmppService.Stop()
+		}
+		if aprsService != nil {
+			aprsService.Stop()
+		}
+		if meshtasticService != nil {
+			meshtasticService.Stop()
+		}
+		if gopherServer != nil {
+			gopherServer.Close()
+		}
+		if geminiServer != nil {
+			geminiServer.Close()
+		}
+		if telnetServer != nil {
+			telnetServer.Close()
+		}
+		if fingerServer != nil {
+			fingerServer.Close()
+		}
+		if dnsServer != nil {
+			dnsServer.Close()
+		}
+		if sipServer != nil {
+			sipServer.Close()
+		}
 		telegramController.Cleanup()
 		app.Shutdown()
 	}()
 
 	// Start server
 	if err := app.Listen(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -159,7 +493,13 @@ func startPrivacyCleanupJob(db *database.DB) {
 
 	for range ticker.C {
 		if err := db.PurgeOldMessageData(); err != nil {
-			log.Printf("Error purging old message data: %v", err)
+			slog.Error("Error purging old message data", "error", err)
+		}
+		if err := db.PurgeExpiredMailboxMessages(); err != nil {
+			slog.Error("Error purging expired mailbox messages", "error", err)
+		}
+		if err := db.PurgeExpiredE2ENonces(); err != nil {
+			slog.Error("Error purging expired encryption nonces", "error", err)
 		}
 	}
 }
@@ -0,0 +1,20 @@
+package models
+
+// APRSPacket is an APRS packet in TNC2 form, e.g.
+// SOURCE>DEST,PATH1,PATH2:info
+type APRSPacket struct {
+	Source      string
+	Destination string
+	// Path lists the digipeaters, repeated ones are marked with a "*"
+	Path []string
+	Info string
+}
+
+// APRSMessage is the content of an APRS message packet
+type APRSMessage struct {
+	Addressee string
+	Text      string
+	// ID is the message number the receiver acknowledges, empty for
+	// messages that need no acknowledgement
+	ID string
+}
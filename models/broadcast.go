@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Channels users can be reached on
+const (
+	ChannelSMS        = "sms"
+	ChannelTelegram   = "telegram"
+	ChannelEmail      = "email"
+	ChannelMatrix     = "matrix"
+	ChannelXMPP       = "xmpp"
+	ChannelAPRS       = "aprs"
+	ChannelMeshtastic = "meshtastic"
+)
+
+// Broadcast statuses
+const (
+	BroadcastStatusDraft   = "draft"
+	BroadcastStatusQueued  = "queued"
+	BroadcastStatusSending = "sending"
+	BroadcastStatusDone    = "done"
+)
+
+// Delivery statuses
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+// AlertSubscriber represents a user who opted in to broadcast alerts
+type AlertSubscriber struct {
+	Channel string `json:"channel"`
+	Address string `json:"address"`
+}
+
+// Broadcast represents an announcement sent to all alert subscribers
+type Broadcast struct {
+	ID            int64               `json:"id"`
+	Message       string              `json:"message"`
+	Status        string              `json:"status"`
+	Recipients    int                 `json:"recipients"`
+	SMSSegments   int                 `json:"sms_segments"`
+	EstimatedCost float64             `json:"estimated_cost"`
+	CreatedAt     time.Time           `json:"created_at"`
+	Deliveries    []BroadcastDelivery `json:"deliveries,omitempty"`
+}
+
+// BroadcastDelivery tracks the delivery of a broadcast to one recipient
+type BroadcastDelivery struct {
+	ID          int64     `json:"id"`
+	BroadcastID int64     `json:"broadcast_id"`
+	Channel     string    `json:"channel"`
+	Address     string    `json:"address"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
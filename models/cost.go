@@ -0,0 +1,32 @@
+package models
+
+// Budget levels of SMS spending
+const (
+	BudgetNormal    = "normal"
+	BudgetLow       = "low"
+	BudgetExhausted = "exhausted"
+)
+
+// SMSLedgerEntry is the SMS spending of a provider on a day
+type SMSLedgerEntry struct {
+	Day      string  `json:"day"`
+	Provider string  `json:"provider"`
+	Segments int     `json:"segments"`
+	Cost     float64 `json:"cost"`
+}
+
+// CostReport compares the SMS spending of a month with its budget and the
+// donations received
+type CostReport struct {
+	Month         string             `json:"month"`
+	Currency      string             `json:"currency"`
+	Days          []SMSLedgerEntry   `json:"days"`
+	Segments      int                `json:"segments"`
+	Spent         float64            `json:"spent"`
+	SpentToday    float64            `json:"spent_today"`
+	DailyBudget   float64            `json:"daily_budget"`
+	MonthlyBudget float64            `json:"monthly_budget"`
+	Level         string             `json:"level"`
+	Donations     map[string]float64 `json:"donations"`
+	Balance       float64            `json:"balance"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// MailboxHandle is a pseudonymous handle users receive messages on
+type MailboxHandle struct {
+	ID           int64
+	Handle       string
+	LinkCodeHash string
+	CreatedAt    time.Time
+}
+
+// MailboxMessage is a stored message waiting to be read by its recipient
+type MailboxMessage struct {
+	ID                int64
+	RecipientHandleID int64
+	SenderHandleID    int64
+	SenderHandle      string
+	Body              string
+	CreatedAt         time.Time
+	ExpiresAt         time.Time
+	ReadAt            *time.Time
+}
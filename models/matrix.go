@@ -0,0 +1,52 @@
+package models
+
+// MatrixSyncResponse is the response of the Matrix /sync endpoint
+type MatrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join   map[string]MatrixJoinedRoom  `json:"join"`
+		Invite map[string]MatrixInvitedRoom `json:"invite"`
+	} `json:"rooms"`
+}
+
+// MatrixJoinedRoom holds the new events of a joined room
+type MatrixJoinedRoom struct {
+	Timeline struct {
+		Events []MatrixEvent `json:"events"`
+	} `json:"timeline"`
+}
+
+// MatrixInvitedRoom holds the state shared with an invite to a room
+type MatrixInvitedRoom struct {
+	InviteState struct {
+		Events []MatrixStateEvent `json:"events"`
+	} `json:"invite_state"`
+}
+
+// MatrixStateEvent is a stripped state event of a room
+type MatrixStateEvent struct {
+	Type     string `json:"type"`
+	Sender   string `json:"sender"`
+	StateKey string `json:"state_key"`
+	Content  struct {
+		Membership string `json:"membership"`
+		IsDirect   bool   `json:"is_direct"`
+	} `json:"content"`
+}
+
+// MatrixEvent is a Matrix room event
+type MatrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	EventID string `json:"event_id"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+// MatrixMessage is the content of an m.room.message event
+type MatrixMessage struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// MeshtasticBroadcast is the node number packets to all nodes are sent to
+const MeshtasticBroadcast = 0xffffffff
+
+// Meshtastic port numbers of the applications carried in packets
+const (
+	MeshtasticPortText     = 1
+	MeshtasticPortPosition = 3
+)
+
+// MeshtasticPacket is a decoded Meshtastic mesh packet
+type MeshtasticPacket struct {
+	ID      uint32
+	From    uint32
+	To      uint32
+	Channel uint32
+	PortNum uint32
+	Payload []byte
+	WantAck bool
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Portal sections
+const (
+	PortalSectionEmergency     = "emergency"
+	PortalSectionNews          = "news"
+	PortalSectionAnnouncements = "announcements"
+)
+
+// PortalSections lists the portal sections in display order
+var PortalSections = []string{
+	PortalSectionEmergency,
+	PortalSectionNews,
+	PortalSectionAnnouncements,
+}
+
+// PortalEntry represents an operator-managed portal headline
+type PortalEntry struct {
+	ID        int64     `json:"id"`
+	Section   string    `json:"section"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -9,6 +9,7 @@ type RSS struct {
 }
 
 type Channel struct {
+	Title string `xml:"title"`
 	Items []Item `xml:"item"`
 }
 
@@ -18,3 +19,37 @@ type Item struct {
 	Description string `xml:"description"`
 	PubDate     string `xml:"pubDate"`
 }
+
+// Atom feed structures
+type Atom struct {
+	XMLName xml.Name    `xml:"feed"`
+	Title   string      `xml:"title"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	Title   string     `xml:"title"`
+	Links   []AtomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+	Content string     `xml:"content"`
+	Updated string     `xml:"updated"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// Feed is a format-independent representation of an RSS or Atom feed
+type Feed struct {
+	Title string
+	Items []FeedItem
+}
+
+// FeedItem is a single entry of a Feed
+type FeedItem struct {
+	Title       string
+	Link        string
+	Description string
+	Published   string
+}
@@ -0,0 +1,8 @@
+package models
+
+// SearchResult represents a single web search result
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// SSTV job statuses
+const (
+	SSTVJobStatusQueued = "queued"
+	SSTVJobStatusDone   = "done"
+	SSTVJobStatusFailed = "failed"
+)
+
+// SSTVJob is a picture rendered to SSTV audio for a radio operator to
+// transmit
+type SSTVJob struct {
+	ID         int64      `json:"id"`
+	URI        string     `json:"uri"`
+	Mode       string     `json:"mode"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "encoding/xml"
+
+// XMPPStreamFeatures are the features a server offers after opening a stream
+type XMPPStreamFeatures struct {
+	XMLName    xml.Name  `xml:"features"`
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// XMPPMessage is an XMPP message stanza
+type XMPPMessage struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	Body    string   `xml:"body"`
+}
+
+// XMPPPresence is an XMPP presence stanza
+type XMPPPresence struct {
+	XMLName xml.Name `xml:"presence"`
+	From    string   `xml:"from,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+// XMPPIQ is an XMPP info/query stanza
+type XMPPIQ struct {
+	XMLName xml.Name `xml:"iq"`
+	ID      string   `xml:"id,attr"`
+	From    string   `xml:"from,attr"`
+	Type    string   `xml:"type,attr"`
+	Bind    *struct {
+		JID string `xml:"jid"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Ping *struct{} `xml:"urn:xmpp:ping ping"`
+}
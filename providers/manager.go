@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"sync"
+
+	"neo146/utils"
 )
 
 // Manager handles SMS providers
@@ -38,17 +40,32 @@ func (m *Manager) GetProvider(name string) (Provider, error) {
 	return provider, nil
 }
 
-// SendMessage sends a message using the configured provider
-func (m *Manager) SendMessage(messages []Message) error {
+// ProviderName returns the name of the configured provider
+func (m *Manager) ProviderName() string {
 	providerName := os.Getenv("SMS_PROVIDER")
 	if providerName == "" {
 		providerName = "Verimor" // Default provider
 	}
+	return providerName
+}
 
+// SendMessage sends a message using the configured provider
+func (m *Manager) SendMessage(messages []Message) error {
+	providerName := m.ProviderName()
 	provider, err := m.GetProvider(providerName)
 	if err != nil {
 		return fmt.Errorf("error getting provider: %v", err)
 	}
 
-	return provider.Send(messages)
+	err = provider.Send(messages)
+	segments := 0
+	for _, message := range messages {
+		segments += utils.CountSMSSegments(message.Msg)
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	utils.OutboundSMSSegments.Add(float64(segments), providerName, result)
+	return err
 }
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"time"
+
+	"neo146/utils"
 )
 
 // VerimorProvider implements the Provider interface for Verimor SMS service
@@ -18,9 +21,9 @@ type VerimorProvider struct {
 // NewVerimorProvider creates a new VerimorProvider instance
 func NewVerimorProvider() *VerimorProvider {
 	return &VerimorProvider{
-		client: &http.Client{
+		client: utils.InstrumentClient(&http.Client{
 			Timeout: 10 * time.Second,
-		},
+		}, "verimor"),
 	}
 }
 
@@ -82,9 +85,6 @@ func (v *VerimorProvider) Send(messages []Message) error {
 		return fmt.Errorf("error marshaling SMS request: %v", err)
 	}
 
-	// Log sent payload
-	fmt.Printf("Sending SMS payload:\n%s\n", string(jsonData))
-
 	resp, err := v.client.Post(
 		"https://sms.verimor.com.tr/v2/send.json",
 		"application/json",
@@ -95,13 +95,11 @@ func (v *VerimorProvider) Send(messages []Message) error {
 	}
 	defer resp.Body.Close()
 
-	// Log response
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Printf("SMS API Response (Status: %d):\n%s\n", resp.StatusCode, string(body))
-
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("SMS API error: %s", string(body))
 	}
+	slog.Info("Sent SMS", "provider", v.Name(), "count", len(messages), "datacoding", datacoding, "status", resp.StatusCode)
 
 	return nil
 }
@@ -13,6 +13,10 @@ func SetupRoutes(
 	contentController *controllers.ContentController,
 	webhookController *controllers.WebhookController,
 	smsController *controllers.SMSController,
+	portalController *controllers.PortalController,
+	adminController *controllers.AdminController,
+	modemController *controllers.ModemController,
+	sstvController *controllers.SSTVController,
 ) {
 	// Documentation routes
 	app.Get("/", docController.HandleRoot)
@@ -22,12 +26,25 @@ func SetupRoutes(
 	app.Get("/api/docs", docController.HandleAPIDocs)
 	app.Get("/api/docs/ui", docController.HandleAPIDocsUI)
 
+	// Metrics route
+	app.Get("/metrics", controllers.HandleMetrics)
+
 	// Content routes
 	app.Get("/uri2md", contentController.HandleURI2MD)
 	app.Get("/twitter", contentController.HandleTwitter)
 	app.Get("/ddg", contentController.HandleDuckDuckGo)
 	app.Get("/wiki", contentController.HandleWikipedia)
 	app.Get("/weather", contentController.HandleWeather)
+	app.Get("/feed", contentController.HandleFeed)
+
+	// Portal routes
+	app.Get("/portal", portalController.HandlePortal)
+
+	// Modem routes
+	app.Post("/modem", modemController.HandleModem)
+
+	// SSTV routes
+	app.Get("/sstv", sstvController.HandleSSTV)
 
 	// Webhook routes
 	app.Post("/webhook/buymeacoffee", webhookController.HandleBuyMeACoffee)
@@ -37,4 +54,19 @@ func SetupRoutes(
 	app.Post("/api/inbound", smsController.HandleInbound)
 	app.Post("/api/test", smsController.HandleTest)
 	app.Post("/api/test/subscribe", smsController.HandleTestSubscribe)
+	app.Get("/api/e2e/key", smsController.HandleE2EKey)
+
+	// Admin routes
+	admin := app.Group("/api/admin", adminController.Authenticate)
+	admin.Get("/portal", adminController.HandleListPortalEntries)
+	admin.Post("/portal", adminController.HandleCreatePortalEntry)
+	admin.Put("/portal/:id", adminController.HandleUpdatePortalEntry)
+	admin.Delete("/portal/:id", adminController.HandleDeletePortalEntry)
+	admin.Post("/broadcasts", adminController.HandleCreateBroadcast)
+	admin.Get("/broadcasts/:id", adminController.HandleGetBroadcast)
+	admin.Post("/broadcasts/:id/send", adminController.HandleSendBroadcast)
+	admin.Get("/costs", adminController.HandleCostReport)
+	admin.Post("/sstv", sstvController.HandleCreateJob)
+	admin.Get("/sstv/:id", sstvController.HandleGetJob)
+	admin.Get("/sstv/:id/audio", sstvController.HandleJobAudio)
 }
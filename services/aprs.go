@@ -0,0 +1,538 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// aprsMessageLength is the maximum length of APRS message text
+	aprsMessageLength = 67
+	// aprsMaxSegments caps the messages sent for a reply, to keep the
+	// channel clear
+	aprsMaxSegments = 5
+	// aprsDestination is the destination (tocall) of packets sent by the
+	// gateway, APZ marks experimental software
+	aprsDestination = "APZ146"
+	// aprsDuplicateWindow is how long message numbers are remembered, so
+	// retransmitted messages are acknowledged but not run again
+	aprsDuplicateWindow = 30 * time.Minute
+	// aprsDefaultServer is the APRS-IS server used if none is configured
+	aprsDefaultServer = "rotate.aprs2.net:14580"
+)
+
+const aprsHelp = "Cmds: wx <location>, wiki <lang> <query>, news, news <n>"
+
+// aprsTransport exchanges APRS packets with APRS-IS or a TNC
+type aprsTransport interface {
+	ReadPacket() (models.APRSPacket, error)
+	WritePacket(packet models.APRSPacket) error
+	Close() error
+}
+
+// APRSConfig holds the settings of the APRS channel
+type APRSConfig struct {
+	// Callsign is the callsign of the gateway with optional SSID, e.g.
+	// TA1ABC-10
+	Callsign string
+	// Server is the APRS-IS server (host:port) to log in to. Passcode is
+	// computed from the callsign if empty.
+	Server   string
+	Passcode string
+	// KISSAddr is a KISS TNC to use instead of APRS-IS, host:port for KISS
+	// over TCP or the path of a serial device
+	KISSAddr string
+}
+
+// APRSService handles the APRS message channel. It answers short commands
+// sent as APRS messages to the gateway callsign.
+type APRSService struct {
+	config   APRSConfig
+	commands *CommandService
+	limiter  *RateLimiter
+
+	// ackTimeout and retries control retransmission of unacknowledged
+	// messages
+	ackTimeout time.Duration
+	retries    int
+
+	mu        sync.Mutex
+	transport aprsTransport
+	pending   map[string]chan struct{}
+	seen      map[string]time.Time
+	nextID    int
+	writeMu   sync.Mutex
+	stop      chan struct{}
+	stopped   sync.Once
+}
+
+// NewAPRSService creates a new APRS channel. The limiter is keyed by
+// callsign without SSID.
+func NewAPRSService(config APRSConfig, commands *CommandService, limiter *RateLimiter) *APRSService {
+	return &APRSService{
+		config:     config,
+		commands:   commands,
+		limiter:    limiter,
+		ackTimeout: 30 * time.Second,
+		retries:    3,
+		pending:    make(map[string]chan struct{}),
+		seen:       make(map[string]time.Time),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start connects to APRS-IS or the TNC and handles packets until the
+// connection is lost or Stop is called. It returns nil only after Stop, so
+// it can be called in a loop to reconnect.
+func (s *APRSService) Start() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+
+	transport, err := s.connect()
+	if err != nil {
+		return err
+	}
+	if !s.setTransport(transport) {
+		transport.Close()
+		return nil
+	}
+	defer s.setTransport(nil)
+	defer transport.Close()
+
+	for {
+		packet, err := transport.ReadPacket()
+		if err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+				return fmt.Errorf("APRS connection lost: %v", err)
+			}
+		}
+		s.handlePacket(packet)
+	}
+}
+
+// Stop disconnects and stops handling messages
+func (s *APRSService) Stop() {
+	s.stopped.Do(func() {
+		close(s.stop)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.transport != nil {
+			s.transport.Close()
+		}
+	})
+}
+
+// connect opens the KISS TNC or logs in to APRS-IS
+func (s *APRSService) connect() (aprsTransport, error) {
+	if address := s.config.KISSAddr; address != "" {
+		var conn io.ReadWriteCloser
+		var err error
+		if strings.Contains(address, ":") && !strings.HasPrefix(address, "/") {
+			conn, err = net.DialTimeout("tcp", address, 10*time.Second)
+		} else {
+			// Serial devices must be set up beforehand, e.g. with stty
+			conn, err = os.OpenFile(address, os.O_RDWR, 0)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to KISS TNC: %v", err)
+		}
+		slog.Info("APRS gateway connected to KISS TNC", "gateway", s.config.Callsign, "tnc", address)
+		return newKISSTransport(conn), nil
+	}
+
+	server := s.config.Server
+	if server == "" {
+		server = aprsDefaultServer
+	}
+	conn, err := net.DialTimeout("tcp", server, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to APRS-IS: %v", err)
+	}
+
+	transport := newAPRSISTransport(conn)
+	if err := transport.login(s.config.Callsign, s.passcode()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	slog.Info("APRS gateway logged in to APRS-IS", "gateway", s.config.Callsign, "server", server)
+	return transport, nil
+}
+
+// passcode returns the configured APRS-IS passcode or computes it
+func (s *APRSService) passcode() string {
+	if s.config.Passcode != "" {
+		return s.config.Passcode
+	}
+	return strconv.Itoa(aprsPasscode(s.config.Callsign))
+}
+
+// handlePacket acknowledges messages addressed to the gateway and runs
+// their commands
+func (s *APRSService) handlePacket(packet models.APRSPacket) {
+	// Packets relayed from another network are wrapped as third-party
+	// traffic
+	if strings.HasPrefix(packet.Info, "}") {
+		if inner, err := parseTNC2(packet.Info[1:]); err == nil {
+			s.handlePacket(inner)
+		}
+		return
+	}
+
+	message, ok := parseAPRSMessage(packet.Info)
+	if !ok || !strings.EqualFold(message.Addressee, s.config.Callsign) {
+		return
+	}
+
+	lower := strings.ToLower(message.Text)
+	if message.ID == "" && (strings.HasPrefix(lower, "ack") || strings.HasPrefix(lower, "rej")) {
+		s.acknowledge(strings.TrimSpace(message.Text[3:]))
+		return
+	}
+
+	if message.ID != "" {
+		s.write(packet.Source, "ack"+message.ID)
+		if s.isDuplicate(packet.Source, message.ID) {
+			return
+		}
+	}
+
+	go s.handleMessage(packet.Source, strings.TrimSpace(message.Text))
+}
+
+// handleMessage runs a command and replies to the sender
+func (s *APRSService) handleMessage(from, text string) {
+	utils.InboundMessages.Inc(models.ChannelAPRS, CommandName(text))
+	command, args, _ := strings.Cut(text, " ")
+	command = strings.ToLower(command)
+	args = strings.TrimSpace(args)
+
+	switch {
+	case command == "wx" && args != "":
+		text = "weather " + args
+	case command == "weather" && args != "", command == "wiki" && args != "", command == "news":
+	default:
+		s.reply(from, aprsHelp)
+		return
+	}
+
+	if !s.limiter.Allow(aprsBaseCallsign(from)) {
+		utils.RateLimitRejections.Inc(models.ChannelAPRS)
+		s.reply(from, "Rate limit reached, try again later")
+		return
+	}
+
+	content, err := s.commands.Execute(models.ChannelAPRS, from, text)
+	if err != nil {
+		content = fmt.Sprintf("Error: %v", err)
+	}
+	s.reply(from, content)
+}
+
+// reply sends text as numbered message segments, waiting for each to be
+// acknowledged
+func (s *APRSService) reply(to, text string) {
+	for _, segment := range aprsSegments(text) {
+		if err := s.sendMessage(to, segment); err != nil {
+			slog.Error("Error sending APRS message", "callsign", to, "error", err)
+			return
+		}
+	}
+}
+
+// sendMessage sends a message and retransmits it until it is acknowledged
+func (s *APRSService) sendMessage(to, text string) error {
+	s.mu.Lock()
+	s.nextID = s.nextID%99999 + 1
+	id := strconv.Itoa(s.nextID)
+	acked := make(chan struct{})
+	s.pending[id] = acked
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	for attempt := 0; attempt < s.retries; attempt++ {
+		if err := s.write(to, text+"{"+id); err != nil {
+			return err
+		}
+
+		select {
+		case <-acked:
+			return nil
+		case <-s.stop:
+			return errors.New("APRS gateway stopped")
+		case <-time.After(s.ackTimeout):
+		}
+	}
+	return fmt.Errorf("message %s not acknowledged", id)
+}
+
+// acknowledge marks a sent message as acknowledged
+func (s *APRSService) acknowledge(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if acked, ok := s.pending[id]; ok {
+		close(acked)
+		delete(s.pending, id)
+	}
+}
+
+// isDuplicate reports whether a message number from a station was seen
+// recently, and remembers it
+func (s *APRSService) isDuplicate(from, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, seen := range s.seen {
+		if now.Sub(seen) > aprsDuplicateWindow {
+			delete(s.seen, key)
+		}
+	}
+
+	key := strings.ToUpper(from) + "\x00" + id
+	if _, ok := s.seen[key]; ok {
+		return true
+	}
+	s.seen[key] = now
+	return false
+}
+
+// write sends a message packet with raw message text to a station
+func (s *APRSService) write(to, text string) error {
+	s.mu.Lock()
+	transport := s.transport
+	s.mu.Unlock()
+	if transport == nil {
+		return errors.New("not connected to APRS")
+	}
+
+	path := []string{"WIDE2-1"}
+	if _, ok := transport.(*aprsISTransport); ok {
+		path = []string{"TCPIP*"}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return transport.WritePacket(models.APRSPacket{
+		Source:      strings.ToUpper(s.config.Callsign),
+		Destination: aprsDestination,
+		Path:        path,
+		Info:        formatAPRSMessage(to, text),
+	})
+}
+
+// setTransport replaces the current transport. It reports false after Stop.
+func (s *APRSService) setTransport(transport aprsTransport) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.stop:
+		return false
+	default:
+	}
+	s.transport = transport
+	return true
+}
+
+// aprsISTransport exchanges packets in TNC2 form with an APRS-IS server
+type aprsISTransport struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newAPRSISTransport creates a transport on a connection to APRS-IS
+func newAPRSISTransport(conn net.Conn) *aprsISTransport {
+	return &aprsISTransport{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// login logs in with a filter for packets addressed to the callsign
+func (t *aprsISTransport) login(callsign, passcode string) error {
+	t.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer t.conn.SetDeadline(time.Time{})
+
+	// The server greets with a comment line first
+	if _, err := t.reader.ReadString('\n'); err != nil {
+		return fmt.Errorf("error reading APRS-IS banner: %v", err)
+	}
+	if _, err := fmt.Fprintf(t.conn, "user %s pass %s vers neo146 1.0 filter g/%s\r\n", callsign, passcode, aprsBaseCallsign(callsign)+"*"); err != nil {
+		return err
+	}
+
+	line, err := t.reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading APRS-IS login response: %v", err)
+	}
+	if !strings.Contains(line, "logresp") || strings.Contains(line, "unverified") {
+		return fmt.Errorf("APRS-IS login failed: %s", strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// ReadPacket returns the next packet, skipping server comments
+func (t *aprsISTransport) ReadPacket() (models.APRSPacket, error) {
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil {
+			return models.APRSPacket{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if packet, err := parseTNC2(line); err == nil {
+			return packet, nil
+		}
+	}
+}
+
+// WritePacket sends a packet as a TNC2 line
+func (t *aprsISTransport) WritePacket(packet models.APRSPacket) error {
+	t.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := io.WriteString(t.conn, formatTNC2(packet)+"\r\n")
+	return err
+}
+
+// Close closes the connection to APRS-IS
+func (t *aprsISTransport) Close() error {
+	return t.conn.Close()
+}
+
+// parseTNC2 parses a packet in TNC2 form, SOURCE>DEST,PATH:info
+func parseTNC2(line string) (models.APRSPacket, error) {
+	header, info, found := strings.Cut(line, ":")
+	source, rest, found2 := strings.Cut(header, ">")
+	if !found || !found2 || source == "" || rest == "" {
+		return models.APRSPacket{}, fmt.Errorf("invalid TNC2 packet: %s", line)
+	}
+
+	addresses := strings.Split(rest, ",")
+	return models.APRSPacket{
+		Source:      source,
+		Destination: addresses[0],
+		Path:        addresses[1:],
+		Info:        info,
+	}, nil
+}
+
+// formatTNC2 formats a packet in TNC2 form
+func formatTNC2(packet models.APRSPacket) string {
+	addresses := append([]string{packet.Destination}, packet.Path...)
+	return packet.Source + ">" + strings.Join(addresses, ",") + ":" + packet.Info
+}
+
+// parseAPRSMessage parses the info field of a message packet,
+// :ADDRESSEE:text{id
+func parseAPRSMessage(info string) (models.APRSMessage, bool) {
+	if len(info) < 11 || info[0] != ':' || info[10] != ':' {
+		return models.APRSMessage{}, false
+	}
+
+	message := models.APRSMessage{
+		Addressee: strings.TrimSpace(info[1:10]),
+		Text:      info[11:],
+	}
+	if i := strings.LastIndex(message.Text, "{"); i >= 0 {
+		// Reply-ack capable stations send {id}ack
+		message.ID, _, _ = strings.Cut(message.Text[i+1:], "}")
+		message.Text = message.Text[:i]
+	}
+	return message, true
+}
+
+// formatAPRSMessage formats the info field of a message packet
+func formatAPRSMessage(to, text string) string {
+	return fmt.Sprintf(":%-9s:%s", strings.ToUpper(to), text)
+}
+
+// aprsSegments turns a reply into message texts of up to 67 ASCII
+// characters, on a single line each
+func aprsSegments(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(aprsASCII(text), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	var segments []string
+	for _, segment := range wrapText(strings.Join(lines, " "), aprsMessageLength) {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) > aprsMaxSegments {
+		segments = segments[:aprsMaxSegments]
+		last := segments[aprsMaxSegments-1]
+		if len(last) > aprsMessageLength-3 {
+			last = last[:aprsMessageLength-3]
+		}
+		segments[aprsMaxSegments-1] = last + "..."
+	}
+	return segments
+}
+
+// aprsTransliterations replaces common non-ASCII letters and the characters
+// message text must not contain
+var aprsTransliterations = strings.NewReplacer(
+	"ç", "c", "Ç", "C", "ğ", "g", "Ğ", "G", "ı", "i", "İ", "I",
+	"ö", "o", "Ö", "O", "ş", "s", "Ş", "S", "ü", "u", "Ü", "U",
+	"â", "a", "ä", "a", "à", "a", "á", "a", "é", "e", "è", "e", "ê", "e",
+	"î", "i", "ô", "o", "û", "u", "ñ", "n", "ß", "ss", "°", " deg",
+	"{", "(", "}", ")", "|", "/", "~", "-",
+)
+
+// aprsASCII converts text to printable ASCII, dropping characters without
+// a replacement
+func aprsASCII(text string) string {
+	text = aprsTransliterations.Replace(text)
+
+	var b strings.Builder
+	for _, r := range text {
+		if r == '\n' || (r >= ' ' && r <= '~') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// aprsBaseCallsign returns a callsign without SSID
+func aprsBaseCallsign(callsign string) string {
+	base, _, _ := strings.Cut(strings.ToUpper(callsign), "-")
+	return base
+}
+
+// aprsPasscode computes the APRS-IS passcode of a callsign
+func aprsPasscode(callsign string) int {
+	call := aprsBaseCallsign(callsign)
+	hash := 0x73e2
+	for i := 0; i < len(call); i += 2 {
+		hash ^= int(call[i]) << 8
+		if i+1 < len(call) {
+			hash ^= int(call[i+1])
+		}
+	}
+	return hash & 0x7fff
+}
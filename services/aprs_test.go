@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAPRSIS is a local APRS-IS server that records the packets it receives
+type fakeAPRSIS struct {
+	listener net.Listener
+	login    chan string
+	packets  chan string
+	conn     chan net.Conn
+}
+
+func newFakeAPRSIS(t *testing.T) *fakeAPRSIS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake APRS-IS: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeAPRSIS{
+		listener: listener,
+		login:    make(chan string, 1),
+		packets:  make(chan string, 100),
+		conn:     make(chan net.Conn, 1),
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		fmt.Fprint(conn, "# aprsc 2.1.14\r\n")
+
+		reader := bufio.NewReader(conn)
+		login, _ := reader.ReadString('\n')
+		server.login <- login
+		fmt.Fprint(conn, "# logresp N0CALL-10 verified, server T2TEST\r\n")
+		server.conn <- conn
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			server.packets <- strings.TrimRight(line, "\r\n")
+		}
+	}()
+	return server
+}
+
+// next returns the next packet sent by the gateway
+func (f *fakeAPRSIS) next(t *testing.T) string {
+	select {
+	case packet := <-f.packets:
+		return packet
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for a packet")
+		return ""
+	}
+}
+
+func TestAPRSService(t *testing.T) {
+	forecast := "İstanbul:\n☀️ +21°C\n" + strings.Repeat("Clear skies over the Bosphorus all day. ", 3)
+	weatherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, forecast)
+	}))
+	defer weatherServer.Close()
+
+	weatherService := NewWeatherService(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", weatherServer.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+
+	aprsIS := newFakeAPRSIS(t)
+	service := NewAPRSService(APRSConfig{Callsign: "N0CALL-10", Server: aprsIS.listener.Addr().String()},
+		NewCommandService(&SMSService{WeatherService: weatherService}), NewRateLimiter(5, time.Minute))
+	service.ackTimeout = 100 * time.Millisecond
+
+	done := make(chan error)
+	go func() { done <- service.Start() }()
+
+	login := <-aprsIS.login
+	if login != "user N0CALL-10 pass 13023 vers neo146 1.0 filter g/N0CALL*\r\n" {
+		t.Errorf("Unexpected login: %q", login)
+	}
+	conn := <-aprsIS.conn
+
+	// Relayed from RF as third-party traffic
+	fmt.Fprint(conn, "TA1ABC>APRS,TCPIP*,qAR,T2TEST:}TA1ABC-7>APDR16,TCPIP,N0CALL-10*::N0CALL-10:wx istanbul{42\r\n")
+
+	if packet := aprsIS.next(t); packet != "N0CALL-10>APZ146,TCPIP*::TA1ABC-7 :ack42" {
+		t.Errorf("Expected ack, got %q", packet)
+	}
+
+	var segments []string
+	for i := 0; ; i++ {
+		packet := aprsIS.next(t)
+		info := strings.TrimPrefix(packet, "N0CALL-10>APZ146,TCPIP*::TA1ABC-7 :")
+		text, id, found := strings.Cut(info, "{")
+		if !found || info == packet {
+			t.Fatalf("Unexpected message packet %q", packet)
+		}
+		if len(text) > 67 {
+			t.Errorf("Segment longer than 67 characters: %q", text)
+		}
+
+		// Let the first segment be retransmitted once
+		if i == 0 {
+			if retry := aprsIS.next(t); retry != packet {
+				t.Fatalf("Expected retransmission of %q, got %q", packet, retry)
+			}
+		}
+		segments = append(segments, text)
+		fmt.Fprintf(conn, "TA1ABC-7>APDR16,TCPIP*,qAC,T2TEST::N0CALL-10:ack%s\r\n", id)
+
+		if strings.HasSuffix(text, "day.") {
+			break
+		}
+	}
+
+	reply := strings.Join(segments, " ")
+	if !strings.HasPrefix(reply, "Istanbul: +21 degC Clear skies") {
+		t.Errorf("Expected ASCII reply, got %q", reply)
+	}
+	if len(segments) != 3 {
+		t.Errorf("Expected 3 segments, got %d: %q", len(segments), segments)
+	}
+
+	// A retransmitted message is acknowledged again but not run twice, and
+	// unknown commands get the help text
+	fmt.Fprint(conn, "TA1ABC-7>APDR16,TCPIP*,qAC,T2TEST::N0CALL-10:wx istanbul{42\r\n")
+	fmt.Fprint(conn, "TA1ABC-7>APDR16,TCPIP*,qAC,T2TEST::N0CALL-10:search neo146{43\r\n")
+	if packet := aprsIS.next(t); !strings.HasSuffix(packet, ":ack42") {
+		t.Errorf("Expected ack, got %q", packet)
+	}
+	if packet := aprsIS.next(t); !strings.HasSuffix(packet, ":ack43") {
+		t.Errorf("Expected ack, got %q", packet)
+	}
+	if packet := aprsIS.next(t); !strings.Contains(packet, ":Cmds: wx <location>") {
+		t.Errorf("Expected help, got %q", packet)
+	}
+
+	service.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Expected Start to return nil after Stop, got %v", err)
+	}
+}
+
+func TestParseAPRSMessage(t *testing.T) {
+	testCases := []struct {
+		info      string
+		ok        bool
+		addressee string
+		text      string
+		id        string
+	}{
+		{":N0CALL-10:news{7", true, "N0CALL-10", "news", "7"},
+		{":N0CALL   :wx ankara{AB}12", true, "N0CALL", "wx ankara", "AB"},
+		{":N0CALL   :ack5", true, "N0CALL", "ack5", ""},
+		{"!4100.00N/02900.00E-", false, "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		message, ok := parseAPRSMessage(tc.info)
+		if ok != tc.ok || message.Addressee != tc.addressee || message.Text != tc.text || message.ID != tc.id {
+			t.Errorf("%q: unexpected message %+v (ok %v)", tc.info, message, ok)
+		}
+	}
+}
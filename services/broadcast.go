@@ -0,0 +1,293 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+// BroadcastStore persists alert subscribers, broadcasts and deliveries
+type BroadcastStore interface {
+	AddAlertSubscriber(channel, address string) error
+	RemoveAlertSubscriber(channel, address string) error
+	ListAlertSubscribers() ([]models.AlertSubscriber, error)
+	SaveBroadcast(broadcast *models.Broadcast) error
+	GetBroadcast(id int64) (*models.Broadcast, error)
+	ListBroadcastIDsByStatus(status string) ([]int64, error)
+	UpdateBroadcastStatus(id int64, status string) error
+	QueueBroadcast(broadcastID int64, recipients []models.AlertSubscriber) (bool, error)
+	ListBroadcastDeliveries(broadcastID int64) ([]models.BroadcastDelivery, error)
+	UpdateBroadcastDelivery(id int64, status, errorMessage string) error
+}
+
+// AlertSender delivers broadcast messages to an address on a channel
+type AlertSender interface {
+	SendAlert(address, message string) error
+}
+
+// BroadcastService handles opt-in alert subscriptions and fans broadcasts out
+// to subscribers through the registered senders
+type BroadcastService struct {
+	store    BroadcastStore
+	interval time.Duration
+	costs    *CostService
+	provider string
+	queue    chan int64
+	overflow chan struct{}
+	senders  map[string]AlertSender
+	mu       sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewBroadcastService creates a new broadcast service. Messages are sent one
+// at a time with the given interval between them. SMS costs are estimated by
+// costs for each subscriber as sent through provider, and are 0 without it.
+func NewBroadcastService(store BroadcastStore, interval time.Duration, costs *CostService, provider string) *BroadcastService {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return &BroadcastService{
+		store:    store,
+		interval: interval,
+		costs:    costs,
+		provider: provider,
+		queue:    make(chan int64, 100),
+		overflow: make(chan struct{}, 1),
+		senders:  make(map[string]AlertSender),
+		stop:     make(chan struct{}),
+	}
+}
+
+// RegisterSender registers the sender used for a channel
+func (s *BroadcastService) RegisterSender(channel string, sender AlertSender) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.senders[channel] = sender
+}
+
+// Join opts a user in to broadcast alerts
+func (s *BroadcastService) Join(channel, address string) error {
+	if address == "" {
+		return fmt.Errorf("address cannot be empty")
+	}
+	return s.store.AddAlertSubscriber(channel, address)
+}
+
+// Leave opts a user out of broadcast alerts
+func (s *BroadcastService) Leave(channel, address string) error {
+	return s.store.RemoveAlertSubscriber(channel, address)
+}
+
+// CreateBroadcast creates a draft broadcast with a cost estimate for the
+// current subscribers. It is not sent until Send is called.
+func (s *BroadcastService) CreateBroadcast(message string) (*models.Broadcast, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, fmt.Errorf("message cannot be empty")
+	}
+
+	subscribers, err := s.store.ListAlertSubscribers()
+	if err != nil {
+		return nil, fmt.Errorf("error listing alert subscribers: %v", err)
+	}
+
+	broadcast := &models.Broadcast{
+		Message:    message,
+		Status:     models.BroadcastStatusDraft,
+		Recipients: len(subscribers),
+	}
+
+	// Only SMS deliveries cost money, depending on the destination
+	segments := utils.CountSMSSegments(message)
+	for _, subscriber := range subscribers {
+		if subscriber.Channel != models.ChannelSMS {
+			continue
+		}
+		broadcast.SMSSegments += segments
+		if s.costs != nil {
+			broadcast.EstimatedCost += s.costs.EstimateCost(s.provider, subscriber.Address, segments)
+		}
+	}
+
+	if err := s.store.SaveBroadcast(broadcast); err != nil {
+		return nil, fmt.Errorf("error saving broadcast: %v", err)
+	}
+	return broadcast, nil
+}
+
+// Send confirms a draft broadcast and queues it for delivery to the current
+// subscribers
+func (s *BroadcastService) Send(id int64) (*models.Broadcast, error) {
+	broadcast, err := s.store.GetBroadcast(id)
+	if err != nil {
+		return nil, fmt.Errorf("broadcast %d not found", id)
+	}
+	if broadcast.Status != models.BroadcastStatusDraft {
+		return nil, fmt.Errorf("broadcast %d is already %s", id, broadcast.Status)
+	}
+
+	subscribers, err := s.store.ListAlertSubscribers()
+	if err != nil {
+		return nil, fmt.Errorf("error listing alert subscribers: %v", err)
+	}
+	// The store only queues drafts, so a broadcast sent twice at once is
+	// delivered once
+	queued, err := s.store.QueueBroadcast(id, subscribers)
+	if err != nil {
+		return nil, fmt.Errorf("error queueing broadcast: %v", err)
+	}
+	if !queued {
+		return nil, fmt.Errorf("broadcast %d is no longer a draft", id)
+	}
+
+	select {
+	case s.queue <- id:
+		utils.QueueDepth.Set(float64(len(s.queue)), "broadcast")
+	default:
+		// The broadcast stays queued in the store, which is read again when
+		// the queue overflows
+		slog.Warn("Broadcast queue is full, broadcast will be read from the store", "broadcast", id)
+		select {
+		case s.overflow <- struct{}{}:
+		default:
+		}
+	}
+
+	broadcast.Status = models.BroadcastStatusQueued
+	return broadcast, nil
+}
+
+// GetBroadcast returns a broadcast with its per-recipient delivery status
+func (s *BroadcastService) GetBroadcast(id int64) (*models.Broadcast, error) {
+	broadcast, err := s.store.GetBroadcast(id)
+	if err != nil {
+		return nil, fmt.Errorf("broadcast %d not found", id)
+	}
+
+	broadcast.Deliveries, err = s.store.ListBroadcastDeliveries(id)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deliveries: %v", err)
+	}
+	return broadcast, nil
+}
+
+// Start processes queued broadcasts until Stop is called. Broadcasts left
+// unfinished by a previous run are resumed first.
+func (s *BroadcastService) Start() {
+	s.resume(models.BroadcastStatusSending, models.BroadcastStatusQueued)
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case id := <-s.queue:
+			utils.QueueDepth.Set(float64(len(s.queue)), "broadcast")
+			s.deliver(id)
+		case <-s.overflow:
+			// Broadcasts still in the queue are done by then and skipped
+			s.resume(models.BroadcastStatusQueued)
+		}
+	}
+}
+
+// resume delivers the broadcasts of the store with the given statuses
+func (s *BroadcastService) resume(statuses ...string) {
+	for _, status := range statuses {
+		ids, err := s.store.ListBroadcastIDsByStatus(status)
+		if err != nil {
+			slog.Error("Error listing broadcasts", "status", status, "error", err)
+			continue
+		}
+		for _, id := range ids {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			s.deliver(id)
+		}
+	}
+}
+
+// Stop stops processing broadcasts
+func (s *BroadcastService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// deliver sends a broadcast to all of its pending recipients, throttled by
+// the configured interval
+func (s *BroadcastService) deliver(id int64) {
+	broadcast, err := s.store.GetBroadcast(id)
+	if err != nil {
+		slog.Error("Error loading broadcast", "broadcast", id, "error", err)
+		return
+	}
+	if broadcast.Status == models.BroadcastStatusDone {
+		return
+	}
+
+	if err := s.store.UpdateBroadcastStatus(id, models.BroadcastStatusSending); err != nil {
+		slog.Error("Error updating broadcast", "broadcast", id, "error", err)
+		return
+	}
+
+	deliveries, err := s.store.ListBroadcastDeliveries(id)
+	if err != nil {
+		slog.Error("Error listing deliveries of broadcast", "broadcast", id, "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	attempted := 0
+	for _, delivery := range deliveries {
+		if delivery.Status != models.DeliveryStatusPending {
+			continue
+		}
+
+		// Throttle all but the first message
+		if attempted > 0 {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+
+		attempted++
+
+		status, errorMessage := models.DeliveryStatusSent, ""
+		if err := s.sendAlert(delivery.Channel, delivery.Address, broadcast.Message); err != nil {
+			status, errorMessage = models.DeliveryStatusFailed, err.Error()
+		}
+		if err := s.store.UpdateBroadcastDelivery(delivery.ID, status, errorMessage); err != nil {
+			slog.Error("Error updating delivery", "delivery", delivery.ID, "error", err)
+		}
+	}
+
+	if err := s.store.UpdateBroadcastStatus(id, models.BroadcastStatusDone); err != nil {
+		slog.Error("Error updating broadcast", "broadcast", id, "error", err)
+	}
+}
+
+// sendAlert sends a message through the sender registered for a channel
+func (s *BroadcastService) sendAlert(channel, address, message string) error {
+	s.mu.RLock()
+	sender, exists := s.senders[channel]
+	s.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("no sender registered for channel %s", channel)
+	}
+	return sender.SendAlert(address, message)
+}
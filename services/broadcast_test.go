@@ -0,0 +1,310 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/models"
+)
+
+// mockBroadcastStore implements BroadcastStore in memory for testing
+type mockBroadcastStore struct {
+	subscribers []models.AlertSubscriber
+	broadcasts  map[int64]*models.Broadcast
+	deliveries  []models.BroadcastDelivery
+}
+
+func newMockBroadcastStore() *mockBroadcastStore {
+	return &mockBroadcastStore{broadcasts: make(map[int64]*models.Broadcast)}
+}
+
+func (m *mockBroadcastStore) AddAlertSubscriber(channel, address string) error {
+	for _, subscriber := range m.subscribers {
+		if subscriber.Channel == channel && subscriber.Address == address {
+			return nil
+		}
+	}
+	m.subscribers = append(m.subscribers, models.AlertSubscriber{Channel: channel, Address: address})
+	return nil
+}
+
+func (m *mockBroadcastStore) RemoveAlertSubscriber(channel, address string) error {
+	for i, subscriber := range m.subscribers {
+		if subscriber.Channel == channel && subscriber.Address == address {
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *mockBroadcastStore) ListAlertSubscribers() ([]models.AlertSubscriber, error) {
+	return m.subscribers, nil
+}
+
+func (m *mockBroadcastStore) SaveBroadcast(broadcast *models.Broadcast) error {
+	broadcast.ID = int64(len(m.broadcasts) + 1)
+	saved := *broadcast
+	m.broadcasts[broadcast.ID] = &saved
+	return nil
+}
+
+func (m *mockBroadcastStore) GetBroadcast(id int64) (*models.Broadcast, error) {
+	broadcast, exists := m.broadcasts[id]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	copied := *broadcast
+	return &copied, nil
+}
+
+func (m *mockBroadcastStore) ListBroadcastIDsByStatus(status string) ([]int64, error) {
+	var ids []int64
+	for id, broadcast := range m.broadcasts {
+		if broadcast.Status == status {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *mockBroadcastStore) UpdateBroadcastStatus(id int64, status string) error {
+	m.broadcasts[id].Status = status
+	return nil
+}
+
+func (m *mockBroadcastStore) QueueBroadcast(broadcastID int64, recipients []models.AlertSubscriber) (bool, error) {
+	if m.broadcasts[broadcastID].Status != models.BroadcastStatusDraft {
+		return false, nil
+	}
+	m.broadcasts[broadcastID].Status = models.BroadcastStatusQueued
+
+	for _, recipient := range recipients {
+		m.deliveries = append(m.deliveries, models.BroadcastDelivery{
+			ID:          int64(len(m.deliveries) + 1),
+			BroadcastID: broadcastID,
+			Channel:     recipient.Channel,
+			Address:     recipient.Address,
+			Status:      models.DeliveryStatusPending,
+		})
+	}
+	return true, nil
+}
+
+func (m *mockBroadcastStore) ListBroadcastDeliveries(broadcastID int64) ([]models.BroadcastDelivery, error) {
+	var deliveries []models.BroadcastDelivery
+	for _, delivery := range m.deliveries {
+		if delivery.BroadcastID == broadcastID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *mockBroadcastStore) UpdateBroadcastDelivery(id int64, status, errorMessage string) error {
+	m.deliveries[id-1].Status = status
+	m.deliveries[id-1].Error = errorMessage
+	return nil
+}
+
+// mockAlertSender records sent alerts and fails for the configured address
+type mockAlertSender struct {
+	sent     []string
+	failFor  string
+	sentTime []time.Time
+}
+
+func (m *mockAlertSender) SendAlert(address, message string) error {
+	if address == m.failFor {
+		return errors.New("delivery failed")
+	}
+	m.sent = append(m.sent, address)
+	m.sentTime = append(m.sentTime, time.Now())
+	return nil
+}
+
+func TestBroadcastService_CreateBroadcast(t *testing.T) {
+	store := newMockBroadcastStore()
+	costs := newTestCostService(newMemoryCostStore(), CostConfig{
+		SegmentCost: 0.05,
+		PrefixCosts: map[string]float64{"2": 0.10, "Verimor:2": 0.08},
+	})
+	service := NewBroadcastService(store, time.Millisecond, costs, "Verimor")
+
+	service.Join(models.ChannelSMS, "+1111111111")
+	service.Join(models.ChannelSMS, "+1111111111") // duplicate join
+	service.Join(models.ChannelSMS, "+2222222222")
+	service.Join(models.ChannelTelegram, "12345")
+
+	if _, err := service.CreateBroadcast("  "); err == nil {
+		t.Error("Expected error for empty message, got nil")
+	}
+
+	// Two SMS segments per SMS recipient, Telegram is free
+	broadcast, err := service.CreateBroadcast(strings.Repeat("a", 200))
+	if err != nil {
+		t.Fatalf("Expected broadcast to be created, got error: %v", err)
+	}
+	if broadcast.Status != models.BroadcastStatusDraft || broadcast.Recipients != 3 {
+		t.Errorf("Unexpected broadcast: %+v", broadcast)
+	}
+	if broadcast.SMSSegments != 4 {
+		t.Errorf("Expected 4 SMS segments, got %d", broadcast.SMSSegments)
+	}
+	// Each SMS recipient costs as much as its destination does
+	if broadcast.EstimatedCost < 0.2599 || broadcast.EstimatedCost > 0.2601 {
+		t.Errorf("Expected estimated cost 0.26, got %f", broadcast.EstimatedCost)
+	}
+
+	// Nothing is delivered before sending
+	if len(store.deliveries) != 0 {
+		t.Errorf("Expected no deliveries for a draft, got %d", len(store.deliveries))
+	}
+}
+
+func TestBroadcastService_Deliver(t *testing.T) {
+	store := newMockBroadcastStore()
+	service := NewBroadcastService(store, 20*time.Millisecond, nil, "")
+
+	smsSender := &mockAlertSender{failFor: "+2222222222"}
+	service.RegisterSender(models.ChannelSMS, smsSender)
+
+	service.Join(models.ChannelSMS, "+1111111111")
+	service.Join(models.ChannelSMS, "+2222222222")
+	service.Join(models.ChannelSMS, "+3333333333")
+	service.Join(models.ChannelTelegram, "12345") // no sender registered
+	service.Leave(models.ChannelSMS, "+3333333333")
+
+	broadcast, _ := service.CreateBroadcast("Network shutdown expected tonight")
+	if _, err := service.Send(broadcast.ID); err != nil {
+		t.Fatalf("Expected broadcast to be queued, got error: %v", err)
+	}
+	if _, err := service.Send(broadcast.ID); err == nil {
+		t.Error("Expected error when sending a broadcast twice, got nil")
+	}
+
+	service.deliver(<-service.queue)
+
+	result, err := service.GetBroadcast(broadcast.ID)
+	if err != nil {
+		t.Fatalf("Expected broadcast, got error: %v", err)
+	}
+	if result.Status != models.BroadcastStatusDone {
+		t.Errorf("Expected broadcast to be done, got %s", result.Status)
+	}
+
+	expected := map[string]string{
+		"+1111111111": models.DeliveryStatusSent,
+		"+2222222222": models.DeliveryStatusFailed,
+		"12345":       models.DeliveryStatusFailed,
+	}
+	if len(result.Deliveries) != len(expected) {
+		t.Fatalf("Expected %d deliveries, got %d", len(expected), len(result.Deliveries))
+	}
+	for _, delivery := range result.Deliveries {
+		if delivery.Status != expected[delivery.Address] {
+			t.Errorf("Expected %s delivery to %s, got %s", expected[delivery.Address], delivery.Address, delivery.Status)
+		}
+		if delivery.Status == models.DeliveryStatusFailed && delivery.Error == "" {
+			t.Errorf("Expected error message for failed delivery to %s", delivery.Address)
+		}
+	}
+
+	if len(smsSender.sent) != 1 || smsSender.sent[0] != "+1111111111" {
+		t.Errorf("Unexpected sent alerts: %v", smsSender.sent)
+	}
+}
+
+// staleBroadcastStore returns every broadcast as a draft, as a Send racing
+// with another one reads it
+type staleBroadcastStore struct {
+	*mockBroadcastStore
+}
+
+func (s staleBroadcastStore) GetBroadcast(id int64) (*models.Broadcast, error) {
+	broadcast, err := s.mockBroadcastStore.GetBroadcast(id)
+	if err == nil {
+		broadcast.Status = models.BroadcastStatusDraft
+	}
+	return broadcast, err
+}
+
+func TestBroadcastService_SendOnce(t *testing.T) {
+	store := newMockBroadcastStore()
+	service := NewBroadcastService(staleBroadcastStore{store}, time.Millisecond, nil, "")
+	service.Join(models.ChannelSMS, "+1111111111")
+
+	broadcast, _ := service.CreateBroadcast("Network shutdown expected tonight")
+	if _, err := service.Send(broadcast.ID); err != nil {
+		t.Fatalf("Expected broadcast to be queued, got error: %v", err)
+	}
+	if _, err := service.Send(broadcast.ID); err == nil {
+		t.Error("Expected error when the broadcast was queued in between, got nil")
+	}
+
+	if len(store.deliveries) != 1 {
+		t.Errorf("Expected 1 delivery, got %d", len(store.deliveries))
+	}
+	if len(service.queue) != 1 {
+		t.Errorf("Expected the broadcast to be queued once, got %d", len(service.queue))
+	}
+}
+
+func TestBroadcastService_QueueOverflow(t *testing.T) {
+	store := newMockBroadcastStore()
+	service := NewBroadcastService(store, time.Millisecond, nil, "")
+	service.queue = make(chan int64, 1)
+
+	sender := &mockAlertSender{}
+	service.RegisterSender(models.ChannelSMS, sender)
+	service.Join(models.ChannelSMS, "+1111111111")
+
+	first, _ := service.CreateBroadcast("First alert")
+	second, _ := service.CreateBroadcast("Second alert")
+	service.Send(first.ID)
+	if _, err := service.Send(second.ID); err != nil {
+		t.Fatalf("Expected broadcast to be queued, got error: %v", err)
+	}
+
+	// The broadcast that did not fit the queue is read from the store
+	select {
+	case <-service.overflow:
+	default:
+		t.Fatal("Expected a full queue to signal an overflow")
+	}
+	service.resume(models.BroadcastStatusQueued)
+	service.deliver(<-service.queue)
+
+	for _, id := range []int64{first.ID, second.ID} {
+		if result, _ := service.GetBroadcast(id); result.Status != models.BroadcastStatusDone {
+			t.Errorf("Expected broadcast %d to be done, got %s", id, result.Status)
+		}
+	}
+	if len(sender.sent) != 2 {
+		t.Errorf("Expected each broadcast to be sent once, got %v", sender.sent)
+	}
+}
+
+func TestBroadcastService_Throttle(t *testing.T) {
+	store := newMockBroadcastStore()
+	service := NewBroadcastService(store, 30*time.Millisecond, nil, "")
+
+	sender := &mockAlertSender{}
+	service.RegisterSender(models.ChannelSMS, sender)
+	service.Join(models.ChannelSMS, "+1111111111")
+	service.Join(models.ChannelSMS, "+2222222222")
+
+	broadcast, _ := service.CreateBroadcast("Safe assembly point: central park")
+	service.Send(broadcast.ID)
+	service.deliver(<-service.queue)
+
+	if len(sender.sentTime) != 2 {
+		t.Fatalf("Expected 2 alerts, got %d", len(sender.sentTime))
+	}
+	if gap := sender.sentTime[1].Sub(sender.sentTime[0]); gap < 25*time.Millisecond {
+		t.Errorf("Expected alerts to be throttled, got gap of %v", gap)
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+// commandHelp lists the commands available through CommandService
+const commandHelp = `Available commands:
+[url] https://... [n] - Convert a webpage to Markdown, in n SMS at most
+sum https://... - Summarize a webpage
+twitter [user] <username> - Get the last 5 tweets of a user
+websearch <query> - Search the web
+open <n> [m] - Open result n of your last search, in m SMS at most
+more - Read the rest of a shortened SMS reply
+wiki <lang> <query> - Get a Wikipedia summary
+weather <location> - Get the weather forecast
+feed <name-or-url> [n] - Read feed headlines or item n
+portal [<section> [<n>]] - Read the neo146 portal, "news" for news
+join alerts / leave alerts - Receive urgent announcements or stop
+register <handle> - Register a handle to receive messages
+link <handle> <code> - Read the messages of your handle here
+msg <handle> <text> - Leave a message for another user
+inbox / read [n] - List and read your messages`
+
+// CommandService runs the text commands shared by channels that have no
+// command handling of their own. Replies are plain text.
+type CommandService struct {
+	smsService *SMSService
+}
+
+// NewCommandService creates a new command service using the services
+// registered on smsService
+func NewCommandService(smsService *SMSService) *CommandService {
+	return &CommandService{
+		smsService: smsService,
+	}
+}
+
+// Help returns the list of available commands
+func (s *CommandService) Help() string {
+	return commandHelp
+}
+
+// Execute runs a command sent by an address on a channel and returns the
+// reply. Unknown commands are answered with the help text.
+func (s *CommandService) Execute(channel, address, text string) (string, error) {
+	// Chat clients commonly prefix commands, e.g. "/search" or "!search"
+	content := strings.TrimLeft(strings.TrimSpace(text), "/!")
+	command, args, _ := strings.Cut(content, " ")
+	command = strings.ToLower(command)
+	args = strings.TrimSpace(args)
+
+	// Search sessions of different channels must not collide
+	identity := channel + ":" + address
+
+	switch {
+	case command == "sum" && utils.IsURL(args):
+		return s.smsService.SummaryService.Summarize(args)
+	case IsURLCommand(content):
+		// Only SMS replies have a segment budget
+		target, _ := ParseURLCommand(content)
+		return s.smsService.MarkdownService.FetchMarkdown(target)
+	case command == "twitter" && strings.HasPrefix(strings.ToLower(args), "user "):
+		return s.smsService.TwitterService.FetchTweets(strings.TrimSpace(args[len("user"):]), 5)
+	case command == "twitter" && args != "":
+		return s.smsService.TwitterService.FetchTweets(args, 5)
+	case (command == "websearch" || command == "search") && args != "":
+		results, err := s.smsService.SearchService.SearchForUser(identity, args)
+		if err != nil {
+			return "", err
+		}
+		return FormatSearchSession(results, "open"), nil
+	case command == "open" && args != "":
+		number, _ := ParseSegmentsSuffix(args)
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return "", fmt.Errorf("invalid result number: %v", err)
+		}
+		result, err := s.smsService.SearchService.OpenResult(identity, n)
+		if err != nil {
+			return "", err
+		}
+		return s.smsService.MarkdownService.FetchMarkdown(result.URL)
+	case command == "wiki":
+		parts := strings.SplitN(args, " ", 2)
+		if len(parts) != 2 {
+			return "Usage: wiki <lang> <query>", nil
+		}
+		return s.smsService.SearchService.FetchWikipediaSummary(strings.TrimSpace(parts[1]), strings.TrimSpace(parts[0]))
+	case command == "weather" && args != "":
+		return s.smsService.WeatherService.FetchWeatherForecast(args)
+	case command == "feed":
+		return s.feed(args)
+	case command == "portal":
+		return s.smsService.PortalService.FormatCommand(args, "Reply \"portal <section> <n>\" to read.")
+	case command == "news":
+		return s.smsService.PortalService.FormatCommand(models.PortalSectionNews+" "+args, "")
+	case strings.EqualFold(content, "join alerts"):
+		if err := s.smsService.BroadcastService.Join(channel, address); err != nil {
+			return "", err
+		}
+		return "You will now receive urgent announcements. Send \"leave alerts\" to stop.", nil
+	case strings.EqualFold(content, "leave alerts"):
+		if err := s.smsService.BroadcastService.Leave(channel, address); err != nil {
+			return "", err
+		}
+		return "You will no longer receive alerts.", nil
+	case command == "more" && channel == models.ChannelSMS:
+		// SMS only reach this service as encrypted requests
+		if rest, found := s.smsService.TakeRemainder(address, true); found {
+			return rest, nil
+		}
+		return NoRemainderReply, nil
+	case IsMailboxCommand(command):
+		return s.smsService.MailboxService.Execute(channel, address, command, args)
+	}
+
+	return commandHelp, nil
+}
+
+// IsStatefulCommand checks if text is a command that keeps state for its
+// sender, i.e. the mailbox and alert subscriptions, which must not be run for
+// senders that can be forged
+func IsStatefulCommand(text string) bool {
+	content := strings.TrimLeft(strings.TrimSpace(text), "/!")
+	command, _, _ := strings.Cut(content, " ")
+	return IsMailboxCommand(command) || strings.HasSuffix(strings.ToLower(content), " alerts")
+}
+
+// IsURLCommand checks if text asks for a page, either starting with a URL
+// or as "url <url>". URLs within other commands, e.g. "feed https://...",
+// are arguments of those commands.
+func IsURLCommand(text string) bool {
+	command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	command = strings.ToLower(command)
+	if command == "url" {
+		return utils.IsURL(args)
+	}
+	return strings.HasPrefix(command, "http://") || strings.HasPrefix(command, "https://")
+}
+
+// feed answers a feed command: without arguments it lists the feed aliases,
+// with a source the latest headlines and with a number that item
+func (s *CommandService) feed(args string) (string, error) {
+	source, item := ParseFeedCommand(args)
+	if source == "" {
+		return s.smsService.FeedService.FormatAliases(), nil
+	}
+	if item > 0 {
+		return s.smsService.FeedService.ReadItem(source, item)
+	}
+
+	headlines, err := s.smsService.FeedService.FetchHeadlines(source, 5)
+	if err != nil {
+		return "", err
+	}
+	return headlines + "\n\n" + FeedReadHint("feed", source), nil
+}
+
+// commandNames are the commands counted under their own name in metrics
+var commandNames = map[string]bool{
+	"url": true, "sum": true, "twitter": true, "search": true, "open": true, "wiki": true,
+	"weather": true, "feed": true, "portal": true, "news": true, "alerts": true,
+	"register": true, "link": true, "msg": true, "inbox": true, "read": true,
+	"subscribe": true, "help": true, "start": true, "more": true,
+	utils.E2EKeyCommand: true, utils.E2ERequestCommand: true,
+}
+
+// CommandName returns the name of the command in a message for metrics, or
+// "other" if it is not a known command, so labels stay bounded
+func CommandName(text string) string {
+	content := strings.TrimLeft(strings.TrimSpace(text), "/!")
+	command, args, _ := strings.Cut(content, " ")
+	command = strings.ToLower(command)
+
+	switch {
+	case utils.IsURL(command):
+		return "url"
+	case command == "websearch":
+		return "search"
+	case command == "wx":
+		return "weather"
+	case command == "join" || command == "leave":
+		if strings.EqualFold(strings.TrimSpace(args), "alerts") {
+			return "alerts"
+		}
+	case commandNames[command]:
+		return command
+	}
+	return "other"
+}
@@ -0,0 +1,42 @@
+package services
+
+import "testing"
+
+func TestCommandName(t *testing.T) {
+	tests := map[string]string{
+		"https://example.org":      "url",
+		"url https://example.org":  "url",
+		"sum https://example.org":  "sum",
+		"/search istanbul":         "search",
+		"websearch istanbul":       "search",
+		"wx istanbul":              "weather",
+		"join alerts":              "alerts",
+		"Leave Alerts":             "alerts",
+		"msg ayse hello":           "msg",
+		"e2e AQID":                 "e2e",
+		"call me at +905551112233": "other",
+		"":                         "other",
+	}
+	for text, expected := range tests {
+		if name := CommandName(text); name != expected {
+			t.Errorf("CommandName(%q) = %q, expected %q", text, name, expected)
+		}
+	}
+}
+
+func TestIsURLCommand(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.org":               true,
+		"HTTP://example.org 3":              true,
+		"url https://example.org":           true,
+		"url example":                       false,
+		"feed https://example.org/rss":      false,
+		"msg alice see https://example.org": false,
+		"sum https://example.org":           false,
+	}
+	for text, expected := range tests {
+		if isURL := IsURLCommand(text); isURL != expected {
+			t.Errorf("IsURLCommand(%q) = %v, expected %v", text, isURL, expected)
+		}
+	}
+}
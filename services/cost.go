@@ -0,0 +1,281 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"neo146/models"
+	"neo146/providers"
+	"neo146/utils"
+)
+
+// budgetExhaustedReply answers the long requests of non-subscribers once the
+// SMS budget is used up
+const budgetExhaustedReply = "!: The SMS budget of neo146 is used up for now, only short replies and subscribers are served. Please try again later or support the service: https://buymeacoffee.com/ooguz"
+
+// budgetLowNote is appended to replies shortened because the budget is low
+const budgetLowNote = "\n[Shortened: SMS budget is low]"
+
+// CostStore persists the SMS ledger and donations
+type CostStore interface {
+	IsSubscriber(phoneNumber string) (bool, error)
+	RecordSMSCost(day, provider string, segments int, cost float64) error
+	ListSMSLedger(fromDay, toDay string) ([]models.SMSLedgerEntry, error)
+	SumSMSCost(fromDay, toDay string) (float64, error)
+	RecordDonation(source string, amount float64, currency string, receivedAt time.Time) error
+	SumDonations(from, to time.Time) (map[string]float64, error)
+}
+
+// CostConfig holds the SMS costs and budgets
+type CostConfig struct {
+	// SegmentCost is the cost of a segment without a matching prefix
+	SegmentCost float64
+	// PrefixCosts are segment costs for destination prefixes, keyed by
+	// prefix, e.g. "90", or by provider and prefix, e.g. "Verimor:90"
+	PrefixCosts map[string]float64
+	// Currency is the currency of costs and budgets
+	Currency string
+	// DailyBudget and MonthlyBudget cap spending, 0 for no cap
+	DailyBudget   float64
+	MonthlyBudget float64
+	// WarnRatio is the share of a budget from which the budget is low
+	WarnRatio float64
+	// LowParts is the number of parts replies are shortened to when the
+	// budget is low
+	LowParts int
+	// FreeSegments and SubscriberSegments are the SMS segments a reply to
+	// a user of the tier may take, 0 for no limit
+	FreeSegments       int
+	SubscriberSegments int
+}
+
+// ReplyBudget limits an SMS reply
+type ReplyBudget struct {
+	// Level is the budget level, see models.BudgetNormal
+	Level string
+	// MaxParts is the maximum number of parts of the reply, 0 for no limit
+	MaxParts int
+	// MaxSegments is the maximum number of SMS segments of the reply, 0 for
+	// no limit
+	MaxSegments int
+	// Refuse is set if long replies must not be sent
+	Refuse bool
+}
+
+// CostService estimates and records the cost of SMS, and degrades replies
+// when spending nears the budget
+type CostService struct {
+	store  CostStore
+	config CostConfig
+	now    func() time.Time
+}
+
+// NewCostService creates a new cost service
+func NewCostService(store CostStore, config CostConfig) *CostService {
+	if config.LowParts <= 0 {
+		config.LowParts = 1
+	}
+	return &CostService{
+		store:  store,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// EstimateCost returns the cost of sending segments to a destination
+// through a provider. The longest matching prefix is used, and a prefix of
+// the provider wins over the same prefix for all providers.
+func (s *CostService) EstimateCost(provider, destination string, segments int) float64 {
+	number := strings.TrimPrefix(strings.TrimPrefix(destination, "+"), "00")
+
+	cost := s.config.SegmentCost
+	matched := -1
+	for key, prefixCost := range s.config.PrefixCosts {
+		keyProvider, prefix, found := strings.Cut(key, ":")
+		if !found {
+			keyProvider, prefix = "", key
+		} else if !strings.EqualFold(keyProvider, provider) {
+			continue
+		}
+		if !strings.HasPrefix(number, prefix) {
+			continue
+		}
+		// Provider prefixes rank above general ones of the same length
+		rank := 2 * len(prefix)
+		if keyProvider != "" {
+			rank++
+		}
+		if rank > matched {
+			cost, matched = prefixCost, rank
+		}
+	}
+	return cost * float64(segments)
+}
+
+// Record adds messages sent through a provider to today's ledger
+func (s *CostService) Record(provider string, messages []providers.Message) error {
+	segments := 0
+	cost := 0.0
+	for _, message := range messages {
+		messageSegments := utils.CountSMSSegments(message.Msg)
+		segments += messageSegments
+		cost += s.EstimateCost(provider, message.Dest, messageSegments)
+	}
+	utils.SMSCost.Add(cost, provider)
+
+	if err := s.store.RecordSMSCost(ledgerDay(s.now()), provider, segments, cost); err != nil {
+		return fmt.Errorf("error recording SMS cost: %v", err)
+	}
+	return nil
+}
+
+// RecordDonation records a donation received through a payment service
+func (s *CostService) RecordDonation(source string, amount float64, currency string) error {
+	if err := s.store.RecordDonation(source, amount, strings.ToUpper(currency), s.now()); err != nil {
+		return fmt.Errorf("error recording donation: %v", err)
+	}
+	return nil
+}
+
+// Level returns the budget level of today's and this month's spending
+func (s *CostService) Level() (string, error) {
+	today, month, err := s.spent(s.now())
+	if err != nil {
+		return "", err
+	}
+	return s.level(today, month), nil
+}
+
+// spent returns the spending of the day and month of now
+func (s *CostService) spent(now time.Time) (float64, float64, error) {
+	today, err := s.store.SumSMSCost(ledgerDay(now), ledgerDay(now))
+	if err != nil {
+		return 0, 0, fmt.Errorf("error summing SMS cost: %v", err)
+	}
+	first, last := monthDays(now)
+	month, err := s.store.SumSMSCost(first, last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error summing SMS cost: %v", err)
+	}
+	return today, month, nil
+}
+
+// level returns the budget level of spending, by the cap it is closest to
+func (s *CostService) level(today, month float64) string {
+	ratio := 0.0
+	if s.config.DailyBudget > 0 {
+		ratio = today / s.config.DailyBudget
+	}
+	if s.config.MonthlyBudget > 0 {
+		ratio = max(ratio, month/s.config.MonthlyBudget)
+	}
+
+	switch {
+	case ratio >= 1:
+		return models.BudgetExhausted
+	case s.config.WarnRatio > 0 && ratio >= s.config.WarnRatio:
+		return models.BudgetLow
+	}
+	return models.BudgetNormal
+}
+
+// ReplyBudget returns the limits of a long reply to a phone number. Replies
+// take at most the segments of the user's tier. When the budget is low
+// replies are shortened further, and when it is exhausted only subscribers
+// get a short reply.
+func (s *CostService) ReplyBudget(phoneNumber string) (ReplyBudget, error) {
+	level, err := s.Level()
+	if err != nil {
+		return ReplyBudget{}, err
+	}
+	subscriber, err := s.store.IsSubscriber(phoneNumber)
+	if err != nil {
+		return ReplyBudget{}, fmt.Errorf("error checking subscription: %v", err)
+	}
+
+	budget := ReplyBudget{Level: level, MaxSegments: s.config.FreeSegments}
+	if subscriber {
+		budget.MaxSegments = s.config.SubscriberSegments
+	}
+	switch level {
+	case models.BudgetLow:
+		budget.MaxParts = s.config.LowParts
+	case models.BudgetExhausted:
+		if !subscriber {
+			return ReplyBudget{Level: level, Refuse: true}, nil
+		}
+		budget.MaxParts = 1
+	}
+	return budget, nil
+}
+
+// Report returns the spending of the month of t against the budget and the
+// donations received in it
+func (s *CostService) Report(t time.Time) (*models.CostReport, error) {
+	first, last := monthDays(t)
+	days, err := s.store.ListSMSLedger(first, last)
+	if err != nil {
+		return nil, fmt.Errorf("error listing SMS ledger: %v", err)
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	donations, err := s.store.SumDonations(start, start.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, fmt.Errorf("error summing donations: %v", err)
+	}
+	today, month, err := s.spent(s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.CostReport{
+		Month:         start.Format("2006-01"),
+		Currency:      s.config.Currency,
+		Days:          days,
+		DailyBudget:   s.config.DailyBudget,
+		MonthlyBudget: s.config.MonthlyBudget,
+		Level:         s.level(today, month),
+		Donations:     donations,
+	}
+	if report.Days == nil {
+		report.Days = []models.SMSLedgerEntry{}
+	}
+	for _, day := range days {
+		report.Segments += day.Segments
+		report.Spent += day.Cost
+	}
+	if report.Month == ledgerDay(s.now())[:7] {
+		report.SpentToday = today
+	}
+	report.Balance = donations[strings.ToUpper(s.config.Currency)] - report.Spent
+	return report, nil
+}
+
+// ledgerDay returns the day of the ledger t falls on
+func ledgerDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// monthDays returns the first and last days of the month of t
+func monthDays(t time.Time) (string, string) {
+	first := time.Date(t.UTC().Year(), t.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	return ledgerDay(first), ledgerDay(first.AddDate(0, 1, -1))
+}
+
+// shortenText cuts text to at most limit characters, at the end of a
+// paragraph, sentence or word where possible
+func shortenText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	cut := string(runes[:max(limit, 0)])
+
+	// Cut at a boundary in the second half, so most of the budget is used
+	for _, boundary := range []string{"\n\n", ". ", "\n", " "} {
+		if i := strings.LastIndex(cut, boundary); i >= len(cut)/2 {
+			return strings.TrimSpace(cut[:i+len(strings.TrimRight(boundary, " \n"))])
+		}
+	}
+	return cut
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/models"
+	"neo146/providers"
+)
+
+// memoryCostStore implements CostStore in memory for testing
+type memoryCostStore struct {
+	ledger      map[string]models.SMSLedgerEntry
+	donations   map[string]float64
+	subscribers map[string]bool
+}
+
+func newMemoryCostStore() *memoryCostStore {
+	return &memoryCostStore{
+		ledger:      make(map[string]models.SMSLedgerEntry),
+		donations:   make(map[string]float64),
+		subscribers: make(map[string]bool),
+	}
+}
+
+func (m *memoryCostStore) IsSubscriber(phoneNumber string) (bool, error) {
+	return m.subscribers[phoneNumber], nil
+}
+
+func (m *memoryCostStore) RecordSMSCost(day, provider string, segments int, cost float64) error {
+	entry := m.ledger[day+provider]
+	entry.Day, entry.Provider = day, provider
+	entry.Segments += segments
+	entry.Cost += cost
+	m.ledger[day+provider] = entry
+	return nil
+}
+
+func (m *memoryCostStore) ListSMSLedger(fromDay, toDay string) ([]models.SMSLedgerEntry, error) {
+	var entries []models.SMSLedgerEntry
+	for _, entry := range m.ledger {
+		if entry.Day >= fromDay && entry.Day <= toDay {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryCostStore) SumSMSCost(fromDay, toDay string) (float64, error) {
+	entries, _ := m.ListSMSLedger(fromDay, toDay)
+	cost := 0.0
+	for _, entry := range entries {
+		cost += entry.Cost
+	}
+	return cost, nil
+}
+
+func (m *memoryCostStore) RecordDonation(source string, amount float64, currency string, receivedAt time.Time) error {
+	m.donations[currency] += amount
+	return nil
+}
+
+func (m *memoryCostStore) SumDonations(from, to time.Time) (map[string]float64, error) {
+	return m.donations, nil
+}
+
+func newTestCostService(store CostStore, config CostConfig) *CostService {
+	service := NewCostService(store, config)
+	service.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+	return service
+}
+
+func TestCostService_EstimateCost(t *testing.T) {
+	service := newTestCostService(newMemoryCostStore(), CostConfig{
+		SegmentCost: 0.10,
+		PrefixCosts: map[string]float64{"90": 0.05, "905": 0.04, "Verimor:90": 0.03, "Other:905": 0.01},
+	})
+
+	tests := []struct {
+		provider, destination string
+		expected              float64
+	}{
+		{"Verimor", "+905551112233", 0.04},
+		{"Verimor", "902121112233", 0.03},
+		{"Other", "00905551112233", 0.01},
+		{"Other", "902121112233", 0.05},
+		{"Verimor", "4915511122233", 0.10},
+	}
+	for _, test := range tests {
+		if cost := service.EstimateCost(test.provider, test.destination, 1); cost != test.expected {
+			t.Errorf("EstimateCost(%s, %s) = %v, expected %v", test.provider, test.destination, cost, test.expected)
+		}
+	}
+	if cost := service.EstimateCost("Verimor", "4915511122233", 3); cost < 0.2999 || cost > 0.3001 {
+		t.Errorf("Expected the cost of 3 segments, got %v", cost)
+	}
+}
+
+func TestCostService_Budget(t *testing.T) {
+	store := newMemoryCostStore()
+	store.subscribers["905550000000"] = true
+	service := newTestCostService(store, CostConfig{
+		SegmentCost:        0.10,
+		Currency:           "EUR",
+		DailyBudget:        1,
+		MonthlyBudget:      20,
+		WarnRatio:          0.8,
+		LowParts:           2,
+		FreeSegments:       10,
+		SubscriberSegments: 30,
+	})
+
+	budget, err := service.ReplyBudget("905551112233")
+	if err != nil || budget.Level != models.BudgetNormal || budget.MaxParts != 0 || budget.MaxSegments != 10 || budget.Refuse {
+		t.Fatalf("Expected the free tier limit, got %+v, %v", budget, err)
+	}
+	if budget, _ := service.ReplyBudget("905550000000"); budget.MaxSegments != 30 {
+		t.Errorf("Expected the subscriber tier limit, got %+v", budget)
+	}
+
+	// 9 segments pass 80% of the daily budget
+	message := providers.Message{Msg: "hello", Dest: "905551112233"}
+	if err := service.Record("Verimor", []providers.Message{message, message, message, message, message, message, message, message, message}); err != nil {
+		t.Fatalf("Error recording cost: %v", err)
+	}
+	budget, _ = service.ReplyBudget("905551112233")
+	if budget.Level != models.BudgetLow || budget.MaxParts != 2 || budget.Refuse {
+		t.Errorf("Expected a low budget, got %+v", budget)
+	}
+
+	service.Record("Verimor", []providers.Message{message, message})
+	budget, _ = service.ReplyBudget("905551112233")
+	if budget.Level != models.BudgetExhausted || !budget.Refuse {
+		t.Errorf("Expected non-subscribers to be refused, got %+v", budget)
+	}
+	budget, _ = service.ReplyBudget("905550000000")
+	if budget.Refuse || budget.MaxParts != 1 || budget.MaxSegments != 30 {
+		t.Errorf("Expected subscribers to get one part, got %+v", budget)
+	}
+
+	// The monthly budget also counts earlier days
+	store.RecordSMSCost("2026-10-01", "Verimor", 190, 19)
+	service.now = func() time.Time { return time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) }
+	if level, _ := service.Level(); level != models.BudgetExhausted {
+		t.Errorf("Expected the monthly budget to be exhausted, got %s", level)
+	}
+}
+
+func TestCostService_Report(t *testing.T) {
+	store := newMemoryCostStore()
+	service := newTestCostService(store, CostConfig{SegmentCost: 0.10, Currency: "EUR", MonthlyBudget: 20})
+	store.RecordSMSCost("2026-09-30", "Verimor", 10, 1)
+	store.RecordSMSCost("2026-10-01", "Verimor", 20, 2)
+	store.RecordSMSCost("2026-10-18", "Verimor", 5, 0.5)
+	service.RecordDonation("buymeacoffee", 5, "eur")
+	service.RecordDonation("paypal", 3, "USD")
+
+	report, err := service.Report(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Error creating report: %v", err)
+	}
+	if report.Month != "2026-10" || len(report.Days) != 2 || report.Segments != 25 || report.Spent != 2.5 {
+		t.Errorf("Unexpected spending: %+v", report)
+	}
+	if report.SpentToday != 0.5 || report.Level != models.BudgetNormal {
+		t.Errorf("Unexpected budget: %+v", report)
+	}
+	if report.Donations["USD"] != 3 || report.Balance != 2.5 {
+		t.Errorf("Expected the balance in EUR, got %+v", report)
+	}
+}
+
+func TestSMSService_ApplyBudget(t *testing.T) {
+	store := newMemoryCostStore()
+	service := newTestCostService(store, CostConfig{SegmentCost: 1, DailyBudget: 10, WarnRatio: 0.5, LowParts: 1})
+	smsService := &SMSService{CostService: service}
+
+	long := strings.Repeat("First sentence here. ", 50)
+	if content, refused := smsService.applyBudget(long, "905551112233", 0, false); content != long || refused {
+		t.Errorf("Expected the reply to be unchanged, got %q", content)
+	}
+
+	store.RecordSMSCost("2026-10-18", "Verimor", 5, 5)
+	content, refused := smsService.applyBudget(long, "905551112233", 0, false)
+	if refused || len(content) > smsPartLength || !strings.HasSuffix(content, "sentence here."+budgetLowNote) {
+		t.Errorf("Expected the reply to be shortened at a sentence, got %q", content)
+	}
+
+	store.RecordSMSCost("2026-10-18", "Verimor", 5, 5)
+	if content, refused := smsService.applyBudget(long, "905551112233", 0, false); content != budgetExhaustedReply || !refused {
+		t.Errorf("Expected the reply to be refused, got %q", content)
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/utils"
+)
+
+const (
+	// dnsPageSize is the number of response bytes per TXT record. It keeps
+	// responses with the longest possible question within the classic
+	// 512-byte UDP limit, so resolvers never have to retry over TCP.
+	dnsPageSize = 180
+	// dnsMaxMessage is the maximum size of a query that is read
+	dnsMaxMessage = 1500
+	// dnsMaxHandlers is the number of queries answered at once, further
+	// queries are dropped
+	dnsMaxHandlers = 64
+)
+
+// DNS message constants (RFC 1035)
+const (
+	dnsTypeTXT    = 16
+	dnsTypeANY    = 255
+	dnsClassIN    = 1
+	dnsFlagQR     = 0x8000
+	dnsFlagAA     = 0x0400
+	dnsFlagRD     = 0x0100
+	dnsOpcodeMask = 0x7800
+
+	dnsRcodeFormErr  = 1
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+)
+
+// DNSConfig holds the settings of the DNS gateway
+type DNSConfig struct {
+	ListenAddr string
+	// Zone is the domain delegated to the gateway, e.g. gw.example.org
+	Zone string
+	// TTL is how long resolvers and the gateway cache responses
+	TTL time.Duration
+}
+
+// DNSServer is an authoritative DNS server answering TXT queries for names
+// like <base32 query>.<service>.<zone> from the gateway services
+type DNSServer struct {
+	config     DNSConfig
+	zone       string
+	smsService *SMSService
+	limiter    *RateLimiter
+	now        func() time.Time
+	handlers   chan struct{}
+
+	mu     sync.Mutex
+	cache  map[string]dnsCacheEntry
+	conns  []net.PacketConn
+	closed bool
+}
+
+// dnsCacheEntry holds the pages of a response, so fetching the following
+// pages of a query does not fetch the content again
+type dnsCacheEntry struct {
+	pages   []string
+	expires time.Time
+}
+
+// NewDNSServer creates a new DNS gateway using the services registered on
+// smsService. The limiter is keyed by resolver IP.
+func NewDNSServer(config DNSConfig, smsService *SMSService, limiter *RateLimiter) *DNSServer {
+	return &DNSServer{
+		config:     config,
+		zone:       strings.ToLower(strings.Trim(config.Zone, ".")),
+		smsService: smsService,
+		limiter:    limiter,
+		now:        time.Now,
+		handlers:   make(chan struct{}, dnsMaxHandlers),
+		cache:      make(map[string]dnsCacheEntry),
+	}
+}
+
+// ListenAndServe listens on the configured UDP address and answers queries
+// until Close is called
+func (s *DNSServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for dns: %v", err)
+	}
+	slog.Info("DNS gateway listening", "zone", s.zone, "listen_addr", s.config.ListenAddr)
+	return s.Serve(conn)
+}
+
+// Serve answers queries received on conn until Close is called. Queries
+// received while dnsMaxHandlers are being answered are dropped.
+func (s *DNSServer) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("server closed")
+	}
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	buf := make([]byte, dnsMaxMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case s.handlers <- struct{}{}:
+		default:
+			// Resolvers retry queries that are not answered
+			slog.Debug("DNS gateway is busy, dropping query")
+			continue
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			defer func() { <-s.handlers }()
+			if response := s.Handle(query, addrIP(addr)); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+// Close stops answering queries
+func (s *DNSServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// Handle returns the response to a DNS query from resolver, or nil if the
+// query is too malformed to answer
+func (s *DNSServer) Handle(query []byte, resolver string) []byte {
+	name, qtype, qclass, questionEnd, err := parseDNSQuestion(query)
+	if err != nil {
+		if len(query) < 12 {
+			return nil
+		}
+		return dnsResponse(query, 12, dnsRcodeFormErr, 0, nil)
+	}
+	if binary.BigEndian.Uint16(query[2:])&(dnsFlagQR|dnsOpcodeMask) != 0 {
+		return dnsResponse(query, questionEnd, dnsRcodeNotImp, 0, nil)
+	}
+	if qclass != dnsClassIN || (name != s.zone && !strings.HasSuffix(name, "."+s.zone)) {
+		return dnsResponse(query, questionEnd, dnsRcodeRefused, 0, nil)
+	}
+	if name == s.zone {
+		// The zone apex has no records of its own
+		return dnsResponse(query, questionEnd, 0, 0, nil)
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, "."+s.zone), ".")
+	service := labels[len(labels)-1]
+	labels = labels[:len(labels)-1]
+
+	page := 1
+	if len(labels) > 0 && strings.HasPrefix(labels[0], utils.DNSPagePrefix) {
+		page, err = strconv.Atoi(strings.TrimPrefix(labels[0], utils.DNSPagePrefix))
+		if err != nil || page < 2 {
+			return dnsResponse(query, questionEnd, dnsRcodeNXDomain, 0, nil)
+		}
+		labels = labels[1:]
+	}
+
+	text, err := utils.DecodeDNSGatewayLabels(labels)
+	if err != nil || text == "" || !isDNSService(service) {
+		return dnsResponse(query, questionEnd, dnsRcodeNXDomain, 0, nil)
+	}
+	if qtype != dnsTypeTXT && qtype != dnsTypeANY {
+		return dnsResponse(query, questionEnd, 0, 0, nil)
+	}
+
+	if !s.limiter.Allow(resolver) {
+		utils.RateLimitRejections.Inc("dns")
+		return dnsResponse(query, questionEnd, dnsRcodeRefused, 0, nil)
+	}
+
+	pages := s.pages(service, text)
+	if page > len(pages) {
+		return dnsResponse(query, questionEnd, dnsRcodeNXDomain, 0, nil)
+	}
+
+	record := utils.FormatDNSGatewayRecord(page, len(pages), pages[page-1])
+	return dnsResponse(query, questionEnd, 0, uint32(s.config.TTL/time.Second), []string{record})
+}
+
+// pages returns the pages of the response to a query, from the cache if
+// possible
+func (s *DNSServer) pages(service, query string) []string {
+	key := service + "\x00" + query
+	now := s.now()
+
+	s.mu.Lock()
+	entry, found := s.cache[key]
+	s.mu.Unlock()
+	if found && now.Before(entry.expires) {
+		utils.CacheRequests.Inc("dns", "hit")
+		return entry.pages
+	}
+	utils.CacheRequests.Inc("dns", "miss")
+
+	content, err := s.fetch(service, query)
+	if err != nil {
+		content = "Error: " + err.Error()
+	}
+	pages := splitDNSPages(content)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for cachedKey, cached := range s.cache {
+		if !now.Before(cached.expires) {
+			delete(s.cache, cachedKey)
+		}
+	}
+	// Errors are not cached, so the next page query can try again
+	if err == nil {
+		s.cache[key] = dnsCacheEntry{pages: pages, expires: now.Add(s.config.TTL)}
+	}
+	return pages
+}
+
+// fetch returns the content for a query of a service
+func (s *DNSServer) fetch(service, query string) (string, error) {
+	switch service {
+	case "w", "weather":
+		return s.smsService.WeatherService.FetchWeatherForecast(query)
+	case "k", "wiki":
+		lang, term, found := strings.Cut(query, " ")
+		if !found {
+			lang, term = "en", query
+		}
+		return s.smsService.SearchService.FetchWikipediaSummary(strings.TrimSpace(term), lang)
+	case "s", "search":
+		results, err := s.smsService.SearchService.Search(query)
+		if err != nil {
+			return "", err
+		}
+		if len(results) == 0 {
+			return "No results found.", nil
+		}
+		return FormatSearchResults(results), nil
+	}
+	return "", fmt.Errorf("unknown service: %s", service)
+}
+
+// isDNSService reports whether a label names a gateway service
+func isDNSService(label string) bool {
+	switch label {
+	case "w", "weather", "k", "wiki", "s", "search":
+		return true
+	}
+	return false
+}
+
+// splitDNSPages splits content into pages that fit in a TXT record
+func splitDNSPages(content string) []string {
+	if content == "" {
+		return []string{""}
+	}
+
+	var pages []string
+	for len(content) > dnsPageSize {
+		pages = append(pages, content[:dnsPageSize])
+		content = content[dnsPageSize:]
+	}
+	return append(pages, content)
+}
+
+// parseDNSQuestion parses the header and the single question of a query. It
+// returns the lower-cased name without the trailing dot, the type and class
+// of the question and where it ends.
+func parseDNSQuestion(msg []byte) (string, uint16, uint16, int, error) {
+	if len(msg) < 12 {
+		return "", 0, 0, 0, errors.New("message too short")
+	}
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return "", 0, 0, 0, errors.New("expected one question")
+	}
+
+	var labels []string
+	offset := 12
+	for {
+		if offset >= len(msg) {
+			return "", 0, 0, 0, errors.New("truncated name")
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		// Compression is not used in the question of a query
+		if length > 63 || offset+length > len(msg) {
+			return "", 0, 0, 0, errors.New("invalid label")
+		}
+		labels = append(labels, strings.ToLower(string(msg[offset:offset+length])))
+		offset += length
+	}
+
+	if offset+4 > len(msg) {
+		return "", 0, 0, 0, errors.New("truncated question")
+	}
+	qtype := binary.BigEndian.Uint16(msg[offset:])
+	qclass := binary.BigEndian.Uint16(msg[offset+2:])
+
+	return strings.Join(labels, "."), qtype, qclass, offset + 4, nil
+}
+
+// dnsResponse builds an authoritative response to a query, repeating its
+// question and answering with a TXT record per string in txt
+func dnsResponse(query []byte, questionEnd int, rcode uint16, ttl uint32, txt []string) []byte {
+	hasQuestion := questionEnd > 12
+
+	response := make([]byte, 12, 512)
+	copy(response, query[:2])
+	binary.BigEndian.PutUint16(response[2:], dnsFlagQR|dnsFlagAA|binary.BigEndian.Uint16(query[2:])&dnsFlagRD|rcode)
+	if hasQuestion {
+		binary.BigEndian.PutUint16(response[4:], 1)
+		response = append(response, query[12:questionEnd]...)
+	}
+	binary.BigEndian.PutUint16(response[6:], uint16(len(txt)))
+
+	for _, record := range txt {
+		// The name points to the question
+		response = append(response, 0xc0, 12)
+		response = binary.BigEndian.AppendUint16(response, dnsTypeTXT)
+		response = binary.BigEndian.AppendUint16(response, dnsClassIN)
+		response = binary.BigEndian.AppendUint32(response, ttl)
+
+		var rdata []byte
+		for len(record) > 255 {
+			rdata = append(rdata, 255)
+			rdata = append(rdata, record[:255]...)
+			record = record[255:]
+		}
+		rdata = append(rdata, byte(len(record)))
+		rdata = append(rdata, record...)
+
+		response = binary.BigEndian.AppendUint16(response, uint16(len(rdata)))
+		response = append(response, rdata...)
+	}
+
+	return response
+}
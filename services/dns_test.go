@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"neo146/utils"
+)
+
+// dnsTestForecast is long enough to need several pages
+var dnsTestForecast = strings.Repeat("İstanbul: ☀️ +21°C, rüzgâr ↙12km/h\n", 12)
+
+// roundTripFunc is an http.RoundTripper calling a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func newTestDNSServer(t *testing.T, limit int) (*DNSServer, *atomic.Int32) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, duckDuckGoLiteFixture)
+		} else {
+			fmt.Fprint(w, dnsTestForecast)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	searchService := NewSearchService(server.Client())
+	searchService.searchURL = server.URL
+
+	// Send weather requests to the test server
+	weatherService := NewWeatherService(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", server.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+
+	smsService := &SMSService{SearchService: searchService, WeatherService: weatherService}
+	config := DNSConfig{Zone: "gw.example.org.", TTL: 5 * time.Minute}
+	return NewDNSServer(config, smsService, NewRateLimiter(limit, time.Minute)), &fetches
+}
+
+// dnsQuery builds a query for a TXT record
+func dnsQuery(name string) []byte {
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, dnsTypeTXT)
+	return binary.BigEndian.AppendUint16(query, dnsClassIN)
+}
+
+func TestDNSServer_Resolver(t *testing.T) {
+	server, fetches := newTestDNSServer(t, 20)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting dns server: %v", err)
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	// The system resolver code, pointed at the gateway
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := utils.QueryDNSGateway(ctx, resolver, "gw.example.org", "w", "istanbul")
+	if err != nil {
+		t.Fatalf("Expected query to succeed, got error: %v", err)
+	}
+	if response != dnsTestForecast {
+		t.Errorf("Expected reassembled forecast:\n%s\ngot:\n%s", dnsTestForecast, response)
+	}
+	if len(dnsTestForecast) <= 2*dnsPageSize {
+		t.Errorf("Expected a response of several pages")
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected pages to be served from the cache, got %d fetches", n)
+	}
+
+	response, err = utils.QueryDNSGateway(ctx, resolver, "gw.example.org", "s", "neo146 gateway")
+	if expected := FormatSearchResults(parseDuckDuckGoResults(duckDuckGoLiteFixture)); err != nil || response != expected {
+		t.Errorf("Expected search results:\n%s\ngot:\n%s (error: %v)", expected, response, err)
+	}
+}
+
+func TestDNSServer_DropsWhenBusy(t *testing.T) {
+	server, _ := newTestDNSServer(t, 20)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting dns server: %v", err)
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Error dialing dns server: %v", err)
+	}
+	defer client.Close()
+
+	query := func() bool {
+		if _, err := client.Write(dnsQuery("example.com")); err != nil {
+			t.Fatalf("Error sending query: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err := client.Read(make([]byte, dnsMaxMessage))
+		return err == nil
+	}
+
+	// Queries are dropped while every handler is busy
+	for i := 0; i < dnsMaxHandlers; i++ {
+		server.handlers <- struct{}{}
+	}
+	if query() {
+		t.Error("Expected the query to be dropped")
+	}
+
+	for i := 0; i < dnsMaxHandlers; i++ {
+		<-server.handlers
+	}
+	if !query() {
+		t.Error("Expected the query to be answered")
+	}
+}
+
+func TestDNSServer_Handle(t *testing.T) {
+	server, _ := newTestDNSServer(t, 1)
+
+	name, _ := utils.DNSGatewayName("gw.example.org", "s", "neo146", 1)
+	rcode := func(response []byte) int {
+		return int(binary.BigEndian.Uint16(response[2:]) & 0xf)
+	}
+
+	testCases := []struct {
+		name  string
+		rcode int
+	}{
+		{"example.com", dnsRcodeRefused},
+		{"gw.example.org", 0},
+		{"x.s.gw.example.org", dnsRcodeNXDomain},
+		{strings.Replace(name, ".s.", ".q.", 1), dnsRcodeNXDomain},
+		{"p-1." + name, dnsRcodeNXDomain},
+		{strings.ToUpper(name), 0},
+		// The limit is reached, other resolvers still get answers
+		{name, dnsRcodeRefused},
+	}
+
+	for _, tc := range testCases {
+		response := server.Handle(dnsQuery(tc.name), "192.0.2.1")
+		if response[0] != 0x12 || response[1] != 0x34 || response[2]&0x84 != 0x84 {
+			t.Errorf("%s: expected an authoritative response with the query ID", tc.name)
+		}
+		if got := rcode(response); got != tc.rcode {
+			t.Errorf("%s: expected rcode %d, got %d", tc.name, tc.rcode, got)
+		}
+	}
+
+	response := server.Handle(dnsQuery(name), "192.0.2.2")
+	if rcode(response) != 0 || binary.BigEndian.Uint16(response[6:]) != 1 {
+		t.Errorf("Expected an answer for another resolver")
+	}
+	if len(response) > 512 {
+		t.Errorf("Expected response to fit in 512 bytes, got %d", len(response))
+	}
+
+	if response := server.Handle([]byte{1, 2, 3}, "192.0.2.1"); response != nil {
+		t.Errorf("Expected no answer to a truncated message")
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"neo146/utils"
+)
+
+// E2EStore persists the keys registered by phone numbers and the nonces of
+// their encrypted requests
+type E2EStore interface {
+	SaveE2EKey(phoneNumber string, publicKey []byte) error
+	GetE2EKey(phoneNumber string) ([]byte, error)
+	// UseE2ENonce records a nonce until expiresAt, it returns false if the
+	// nonce was already used
+	UseE2ENonce(phoneNumber string, nonce []byte, expiresAt time.Time) (bool, error)
+}
+
+// E2EService opens encrypted SMS requests and seals their replies, so that
+// the SMS provider only carries ciphertext. See utils.E2EClient for the
+// client side.
+type E2EService struct {
+	store      E2EStore
+	privateKey *[utils.E2EKeySize]byte
+	publicKey  *[utils.E2EKeySize]byte
+	// maxAge is how far the time of a request nonce may be from now
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewE2EService creates a new encryption service with the private key of the
+// gateway. Requests whose nonce is more than maxAge away from the current
+// time are refused.
+func NewE2EService(store E2EStore, privateKey *[utils.E2EKeySize]byte, maxAge time.Duration) *E2EService {
+	return &E2EService{
+		store:      store,
+		privateKey: privateKey,
+		publicKey:  utils.E2EPublicKey(privateKey),
+		maxAge:     maxAge,
+		now:        time.Now,
+	}
+}
+
+// IsE2ECommand checks if a command registers a key or carries an encrypted
+// request
+func IsE2ECommand(command string) bool {
+	return strings.EqualFold(command, utils.E2EKeyCommand) || strings.EqualFold(command, utils.E2ERequestCommand)
+}
+
+// PublicKey returns the base64 public key clients encrypt requests to
+func (s *E2EService) PublicKey() string {
+	return utils.FormatE2EKey(s.publicKey)
+}
+
+// Register saves the public key of a phone number and returns the reply
+func (s *E2EService) Register(phoneNumber, key string) (string, error) {
+	publicKey, err := utils.ParseE2EKey(key)
+	if err != nil {
+		return "Usage: key <base64 public key>", nil
+	}
+	if err := s.store.SaveE2EKey(phoneNumber, publicKey[:]); err != nil {
+		return "", fmt.Errorf("error saving key: %v", err)
+	}
+	return "Your key is registered, encrypted requests are now answered encrypted.", nil
+}
+
+// Open decrypts an encrypted request of a phone number and returns the
+// command with the nonce its reply is bound to. A nonce is accepted once.
+func (s *E2EService) Open(phoneNumber, request string) (string, *[utils.E2ENonceSize]byte, error) {
+	publicKey, err := s.clientKey(phoneNumber)
+	if err != nil {
+		return "", nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(request))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid request encoding: %v", err)
+	}
+	command, nonce, err := utils.OpenE2E(sealed, publicKey, s.privateKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("error opening request: %v", err)
+	}
+
+	sent := utils.E2ENonceTime(nonce)
+	if age := s.now().Sub(sent); age > s.maxAge || age < -s.maxAge {
+		return "", nil, fmt.Errorf("request nonce from %v is out of range", sent)
+	}
+	// Past its expiry the nonce is refused for its time anyway
+	fresh, err := s.store.UseE2ENonce(phoneNumber, nonce[:], sent.Add(s.maxAge))
+	if err != nil {
+		return "", nil, fmt.Errorf("error recording nonce: %v", err)
+	}
+	if !fresh {
+		return "", nil, fmt.Errorf("replayed request")
+	}
+
+	return string(command), nonce, nil
+}
+
+// Seal encrypts the reply to a request for a phone number and returns it in
+// GW parts
+func (s *E2EService) Seal(phoneNumber string, requestNonce *[utils.E2ENonceSize]byte, reply string) ([]string, error) {
+	publicKey, err := s.clientKey(phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := utils.NewE2ENonce(s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	message := append(requestNonce[:], reply...)
+	sealed := utils.SealE2E(message, nonce, publicKey, s.privateKey)
+	return utils.SplitAndEncodeBytes(sealed, utils.E2EPartSize), nil
+}
+
+// clientKey returns the key registered by a phone number
+func (s *E2EService) clientKey(phoneNumber string) (*[utils.E2EKeySize]byte, error) {
+	key, err := s.store.GetE2EKey(phoneNumber)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no key registered")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading key: %v", err)
+	}
+	if len(key) != utils.E2EKeySize {
+		return nil, fmt.Errorf("invalid stored key")
+	}
+	return (*[utils.E2EKeySize]byte)(key), nil
+}
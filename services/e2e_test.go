@@ -0,0 +1,114 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/utils"
+)
+
+// mockE2EStore implements E2EStore in memory for testing
+type mockE2EStore struct {
+	keys   map[string][]byte
+	nonces map[string]time.Time
+}
+
+func newMockE2EStore() *mockE2EStore {
+	return &mockE2EStore{keys: make(map[string][]byte), nonces: make(map[string]time.Time)}
+}
+
+func (m *mockE2EStore) SaveE2EKey(phoneNumber string, publicKey []byte) error {
+	m.keys[phoneNumber] = publicKey
+	return nil
+}
+
+func (m *mockE2EStore) GetE2EKey(phoneNumber string) ([]byte, error) {
+	key, exists := m.keys[phoneNumber]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (m *mockE2EStore) UseE2ENonce(phoneNumber string, nonce []byte, expiresAt time.Time) (bool, error) {
+	key := phoneNumber + ":" + string(nonce)
+	if _, used := m.nonces[key]; used {
+		return false, nil
+	}
+	m.nonces[key] = expiresAt
+	return true, nil
+}
+
+func TestE2EService(t *testing.T) {
+	_, serverPrivate, err := utils.GenerateE2EKey()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	service := NewE2EService(newMockE2EStore(), serverPrivate, time.Hour)
+	serverPublic, err := utils.ParseE2EKey(service.PublicKey())
+	if err != nil {
+		t.Fatalf("Error parsing server key: %v", err)
+	}
+
+	_, clientPrivate, _ := utils.GenerateE2EKey()
+	client := utils.NewE2EClient(serverPublic, clientPrivate)
+	request, requestNonce, err := client.Request("weather istanbul")
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	command, data, _ := strings.Cut(request, " ")
+	if !IsE2ECommand(command) {
+		t.Fatalf("Expected %q to be an encryption command", command)
+	}
+
+	if _, _, err := service.Open("+905550000001", data); err == nil {
+		t.Error("Expected an error for an unregistered number")
+	}
+
+	keyCommand, key, _ := strings.Cut(client.RegisterCommand(), " ")
+	if !IsE2ECommand(keyCommand) {
+		t.Fatalf("Expected %q to be an encryption command", keyCommand)
+	}
+	if reply, err := service.Register("+905550000001", "not a key"); err != nil || !strings.HasPrefix(reply, "Usage:") {
+		t.Errorf("Expected usage for an invalid key, got %q, %v", reply, err)
+	}
+	if _, err := service.Register("+905550000001", key); err != nil {
+		t.Fatalf("Error registering key: %v", err)
+	}
+
+	opened, nonce, err := service.Open("+905550000001", data)
+	if err != nil {
+		t.Fatalf("Error opening request: %v", err)
+	}
+	if opened != "weather istanbul" || *nonce != *requestNonce {
+		t.Errorf("Expected the command, got %q", opened)
+	}
+	if _, _, err := service.Open("+905550000001", data); err == nil {
+		t.Error("Expected an error for a replayed request")
+	}
+	// The request was sealed for the key of another number
+	if _, err := service.Register("+905550000002", utils.FormatE2EKey(serverPublic)); err != nil {
+		t.Fatalf("Error registering key: %v", err)
+	}
+	if _, _, err := service.Open("+905550000002", data); err == nil {
+		t.Error("Expected an error for a request of another key")
+	}
+
+	parts, err := service.Seal("+905550000001", nonce, "İstanbul: 18°C")
+	if err != nil {
+		t.Fatalf("Error sealing reply: %v", err)
+	}
+	if reply, err := client.Response(parts, requestNonce); err != nil || reply != "İstanbul: 18°C" {
+		t.Errorf("Expected the reply, got %q, %v", reply, err)
+	}
+
+	// Requests older than the maximum age are refused
+	oldNonce, _ := utils.NewE2ENonce(time.Now().Add(-2 * time.Hour))
+	sealed := utils.SealE2E([]byte("news"), oldNonce, serverPublic, clientPrivate)
+	if _, _, err := service.Open("+905550000001", base64.StdEncoding.EncodeToString(sealed)); err == nil {
+		t.Error("Expected an error for an expired request")
+	}
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"time"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+// replyPrefixPattern matches reply and forward prefixes of a subject
+var replyPrefixPattern = regexp.MustCompile(`(?i)^((re|fwd?|aw|ynt|ilt)\s*:\s*)+`)
+
+// EmailConfig holds the settings of the email channel
+type EmailConfig struct {
+	// Address is the gateway mailbox, commands are sent to and answered from it
+	Address string
+	// ListenAddr is the address of the SMTP listener for incoming mail
+	ListenAddr string
+	// SMTPHost and SMTPPort point to the server replies are sent through
+	SMTPHost string
+	SMTPPort string
+	// SMTPUsername and SMTPPassword authenticate with the SMTP server, if set
+	SMTPUsername string
+	SMTPPassword string
+	// AuthServID is the authserv-id of the Authentication-Results header the
+	// MTA in front of the listener adds. Mailbox and alert commands are only
+	// run for senders it verified, and not at all if it is empty.
+	AuthServID string
+}
+
+// EmailService answers commands sent by email. Incoming mail is accepted
+// by a local SMTP listener and replies are sent through an SMTP server.
+type EmailService struct {
+	config   EmailConfig
+	commands *CommandService
+	limiter  *RateLimiter
+	server   *smtpServer
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailService creates a new email service. The limiter is keyed by
+// sender address.
+func NewEmailService(config EmailConfig, commands *CommandService, limiter *RateLimiter) *EmailService {
+	s := &EmailService{
+		config:   config,
+		commands: commands,
+		limiter:  limiter,
+		sendMail: smtp.SendMail,
+	}
+	s.server = newSMTPServer(smtpDomain(config.Address), s.acceptRecipient, s.handleEmail)
+	return s
+}
+
+// ListenAndServe listens on the configured address and handles incoming mail
+// until Close is called
+func (s *EmailService) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for email: %v", err)
+	}
+	slog.Info("Email channel listening", "listen_addr", s.config.ListenAddr, "account", s.config.Address)
+	return s.Serve(listener)
+}
+
+// Serve handles incoming mail on listener until Close is called
+func (s *EmailService) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Close stops accepting mail
+func (s *EmailService) Close() error {
+	return s.server.Close()
+}
+
+// SendAlert sends a broadcast alert to an email address
+func (s *EmailService) SendAlert(address, message string) error {
+	return s.send(address, "neo146 alert", message, "")
+}
+
+// acceptRecipient checks if mail for recipient is accepted
+func (s *EmailService) acceptRecipient(recipient string) bool {
+	return strings.EqualFold(recipient, s.config.Address)
+}
+
+// handleEmail runs the command of an incoming email and replies to the sender
+func (s *EmailService) handleEmail(envelopeFrom string, to []string, data []byte) {
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		slog.Error("Error parsing email", "error", err)
+		return
+	}
+
+	// Never answer bounces, auto-replies or mailing lists to avoid mail loops
+	if isAutomatedEmail(envelopeFrom, message.Header) {
+		return
+	}
+
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		slog.Error("Error parsing email sender", "error", err)
+		return
+	}
+	sender := strings.ToLower(from.Address)
+	if strings.EqualFold(sender, s.config.Address) {
+		return
+	}
+
+	// Rate limited senders are not answered, so forged senders cannot be
+	// flooded with replies
+	if !s.limiter.Allow(sender) {
+		utils.RateLimitRejections.Inc(models.ChannelEmail)
+		slog.Warn("Email rate limit reached, dropping message")
+		return
+	}
+
+	subject := decodeEmailHeader(message.Header.Get("Subject"))
+	command, err := parseEmailCommand(subject, message)
+	if err != nil {
+		slog.Error("Error reading email body", "error", err)
+		return
+	}
+
+	utils.InboundMessages.Inc(models.ChannelEmail, CommandName(command))
+	var reply string
+	switch {
+	case IsStatefulCommand(command) && s.config.AuthServID == "":
+		reply = "Alerts and messages are not available over email."
+	case IsStatefulCommand(command) && !isVerifiedSender(message.Header, s.config.AuthServID, sender):
+		// Anyone can write a From header, so the mailbox and alerts of an
+		// address need proof that the mail comes from its domain
+		reply = "Alerts and messages need an email signed by the domain of your address (DKIM)."
+	default:
+		reply, err = s.commands.Execute(models.ChannelEmail, sender, command)
+		if err != nil {
+			reply = fmt.Sprintf("Error: %v", err)
+		}
+	}
+
+	replySubject := "Re: " + replyPrefixPattern.ReplaceAllString(subject, "")
+	if strings.TrimSpace(replySubject) == "Re:" {
+		replySubject = "Re: " + command
+	}
+	if err := s.send(sender, replySubject, reply, message.Header.Get("Message-ID")); err != nil {
+		slog.Error("Error sending email reply", "error", err)
+	}
+}
+
+// send sends a plain text email from the gateway address
+func (s *EmailService) send(to, subject, body, inReplyTo string) error {
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		// Header values must not break out of their line
+		value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+	}
+
+	header("From", s.config.Address)
+	header("To", to)
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", newMessageID(smtpDomain(s.config.Address)))
+	if inReplyTo != "" {
+		header("In-Reply-To", inReplyTo)
+		header("References", inReplyTo)
+	}
+	header("Auto-Submitted", "auto-replied")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	msg.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&msg)
+	if _, err := writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
+	}
+	return s.sendMail(net.JoinHostPort(s.config.SMTPHost, s.config.SMTPPort), auth, s.config.Address, []string{to}, msg.Bytes())
+}
+
+// isVerifiedSender checks if the MTA identified by authServID verified that
+// an email comes from the domain of sender, with DMARC or an aligned DKIM
+// signature (RFC 8601). Only the topmost header of the MTA is read, as it
+// removes headers with its authserv-id from incoming mail.
+func isVerifiedSender(header mail.Header, authServID, sender string) bool {
+	if authServID == "" {
+		return false
+	}
+	domain := strings.ToLower(sender[strings.LastIndex(sender, "@")+1:])
+
+	for _, value := range header["Authentication-Results"] {
+		id, results, _ := strings.Cut(value, ";")
+		if fields := strings.Fields(id); len(fields) == 0 || !strings.EqualFold(fields[0], authServID) {
+			continue
+		}
+
+		for _, result := range strings.Split(results, ";") {
+			fields := strings.Fields(strings.ToLower(result))
+			if len(fields) == 0 {
+				continue
+			}
+			// The property that names the domain each method verified
+			property := map[string]string{"dmarc=pass": "header.from=", "dkim=pass": "header.d="}[fields[0]]
+			if property == "" {
+				continue
+			}
+			for _, field := range fields[1:] {
+				if field == property+domain {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// isAutomatedEmail checks if an email is a bounce, an automatic reply or
+// mailing list traffic (RFC 3834)
+func isAutomatedEmail(envelopeFrom string, header mail.Header) bool {
+	if envelopeFrom == "" {
+		return true
+	}
+	if autoSubmitted := header.Get("Auto-Submitted"); autoSubmitted != "" && !strings.EqualFold(autoSubmitted, "no") {
+		return true
+	}
+	switch strings.ToLower(header.Get("Precedence")) {
+	case "bulk", "junk", "list":
+		return true
+	}
+	return header.Get("List-Id") != ""
+}
+
+// parseEmailCommand returns the command of an email: the first line of the
+// body written by the sender, or the subject if the body is empty
+func parseEmailCommand(subject string, message *mail.Message) (string, error) {
+	body, err := emailText(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// Stop at quoted text and signatures
+		if strings.HasPrefix(line, ">") || line == "--" {
+			break
+		}
+		return line, nil
+	}
+
+	return strings.TrimSpace(replyPrefixPattern.ReplaceAllString(subject, "")), nil
+}
+
+// emailText returns the plain text of an email body, picking the text part
+// of multipart messages
+func emailText(contentType, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var html string
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+
+			text, err := emailText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType == "text/html" {
+				html = text
+				continue
+			}
+			if text != "" {
+				return text, nil
+			}
+		}
+		return html, nil
+	}
+
+	switch strings.ToLower(transferEncoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxEmailSize))
+	if err != nil {
+		return "", err
+	}
+
+	switch mediaType {
+	case "text/plain":
+		return string(data), nil
+	case "text/html":
+		return stripHTML(string(data)), nil
+	}
+	return "", nil
+}
+
+// decodeEmailHeader decodes RFC 2047 encoded words in a header value
+func decodeEmailHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// newMessageID returns a unique Message-ID for the domain
+func newMessageID(domain string) string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
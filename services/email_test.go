@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedEmail is an email captured by the fake SMTP server
+type receivedEmail struct {
+	from    string
+	to      []string
+	message *mail.Message
+	body    string
+}
+
+// startFakeSMTPServer starts an in-process SMTP server capturing all mail
+func startFakeSMTPServer(t *testing.T) (string, chan receivedEmail) {
+	received := make(chan receivedEmail, 10)
+	server := newSMTPServer("smtp.test", func(string) bool { return true }, func(from string, to []string, data []byte) {
+		message, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("Fake SMTP server received invalid email: %v", err)
+			return
+		}
+		body, _ := emailText(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+		received <- receivedEmail{from: from, to: to, message: message, body: body}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake SMTP server: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return listener.Addr().String(), received
+}
+
+// startTestEmailService starts an email service whose replies are captured
+func startTestEmailService(t *testing.T, rateLimit int) (string, chan receivedEmail) {
+	smtpAddr, replies := startFakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(smtpAddr)
+
+	now := time.Date(2025, 3, 19, 10, 0, 0, 0, time.UTC)
+	smsService := &SMSService{
+		MailboxService: NewMailboxService(newMockMailboxStore(func() time.Time { return now }), time.Hour, 10),
+	}
+
+	service := NewEmailService(EmailConfig{
+		Address:    "gateway@neo146.test",
+		SMTPHost:   host,
+		SMTPPort:   port,
+		AuthServID: "mx.neo146.test",
+	}, NewCommandService(smsService), NewRateLimiter(rateLimit, time.Hour))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting email service: %v", err)
+	}
+	go service.Serve(listener)
+	t.Cleanup(func() { service.Close() })
+
+	return listener.Addr().String(), replies
+}
+
+func sendTestEmail(t *testing.T, addr, from string, headers map[string]string, body string) {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: gateway@neo146.test\r\n", from)
+	for name, value := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+	}
+	msg.WriteString("\r\n" + body)
+
+	if err := smtp.SendMail(addr, nil, from, []string{"gateway@neo146.test"}, []byte(msg.String())); err != nil {
+		t.Fatalf("Error sending email: %v", err)
+	}
+}
+
+func waitForReply(t *testing.T, replies chan receivedEmail) receivedEmail {
+	select {
+	case reply := <-replies:
+		return reply
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for reply")
+		return receivedEmail{}
+	}
+}
+
+func expectNoReply(t *testing.T, replies chan receivedEmail) {
+	select {
+	case reply := <-replies:
+		t.Errorf("Expected no reply, got: %s", reply.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestEmailService_RepliesToCommands(t *testing.T) {
+	addr, replies := startTestEmailService(t, 5)
+
+	sendTestEmail(t, addr, "Alice <Alice@example.org>", map[string]string{
+		"Subject":                "register alice",
+		"Message-ID":             "<1@example.org>",
+		"Authentication-Results": "mx.neo146.test; dkim=pass header.d=example.org; dmarc=pass header.from=example.org",
+	}, "")
+
+	reply := waitForReply(t, replies)
+	if reply.from != "gateway@neo146.test" || len(reply.to) != 1 || reply.to[0] != "alice@example.org" {
+		t.Errorf("Unexpected envelope: %s -> %v", reply.from, reply.to)
+	}
+	if subject := reply.message.Header.Get("Subject"); subject != "Re: register alice" {
+		t.Errorf("Unexpected subject: %s", subject)
+	}
+	if reply.message.Header.Get("In-Reply-To") != "<1@example.org>" {
+		t.Errorf("Expected reply to reference the command email")
+	}
+	if !strings.Contains(reply.body, "You are now alice") {
+		t.Errorf("Unexpected reply:\n%s", reply.body)
+	}
+
+	// The first line of the body takes precedence over the subject
+	sendTestEmail(t, addr, "alice@example.org", map[string]string{
+		"Subject":                "Re: register alice",
+		"Authentication-Results": "mx.neo146.test; dkim=pass (2048-bit key) header.d=example.org header.s=mail",
+	}, "inbox\r\n\r\n> You are now alice\r\n")
+
+	reply = waitForReply(t, replies)
+	if !strings.Contains(reply.body, "No messages for alice.") {
+		t.Errorf("Unexpected reply:\n%s", reply.body)
+	}
+
+	// Unknown commands are answered with the help text
+	sendTestEmail(t, addr, "alice@example.org", map[string]string{"Subject": "hello"}, "")
+
+	reply = waitForReply(t, replies)
+	if !strings.Contains(reply.body, "Available commands:") {
+		t.Errorf("Unexpected reply:\n%s", reply.body)
+	}
+}
+
+func TestEmailService_RequiresVerifiedSender(t *testing.T) {
+	addr, replies := startTestEmailService(t, 5)
+
+	// Headers of other servers and signatures of other domains are not
+	// proof of the sender
+	for _, results := range []string{
+		"",
+		"mx.attacker.test; dkim=pass header.d=example.org",
+		"mx.neo146.test; dkim=pass header.d=attacker.test",
+		"mx.neo146.test; dkim=fail header.d=example.org",
+	} {
+		sendTestEmail(t, addr, "alice@example.org", map[string]string{
+			"Subject":                "join alerts",
+			"Authentication-Results": results,
+		}, "")
+
+		reply := waitForReply(t, replies)
+		if !strings.Contains(reply.body, "signed by the domain") {
+			t.Errorf("Expected %q to be refused, got:\n%s", results, reply.body)
+		}
+	}
+
+	// Commands without state are answered for anyone
+	sendTestEmail(t, addr, "alice@example.org", map[string]string{"Subject": "hello"}, "")
+	if reply := waitForReply(t, replies); !strings.Contains(reply.body, "Available commands:") {
+		t.Errorf("Unexpected reply:\n%s", reply.body)
+	}
+}
+
+func TestEmailService_RateLimit(t *testing.T) {
+	addr, replies := startTestEmailService(t, 2)
+
+	for i := 0; i < 2; i++ {
+		sendTestEmail(t, addr, "bob@example.org", map[string]string{"Subject": "inbox"}, "")
+		waitForReply(t, replies)
+	}
+
+	sendTestEmail(t, addr, "bob@example.org", map[string]string{"Subject": "inbox"}, "")
+	expectNoReply(t, replies)
+
+	// Other senders are not affected
+	sendTestEmail(t, addr, "carol@example.org", map[string]string{"Subject": "inbox"}, "")
+	waitForReply(t, replies)
+}
+
+func TestEmailService_IgnoresAutomatedEmail(t *testing.T) {
+	addr, replies := startTestEmailService(t, 5)
+
+	sendTestEmail(t, addr, "bob@example.org", map[string]string{
+		"Subject":        "Out of office",
+		"Auto-Submitted": "auto-replied",
+	}, "")
+	expectNoReply(t, replies)
+}
+
+func TestEmailService_RejectsOtherRecipients(t *testing.T) {
+	addr, _ := startTestEmailService(t, 5)
+
+	err := smtp.SendMail(addr, nil, "bob@example.org", []string{"someone@neo146.test"}, []byte("Subject: inbox\r\n\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Expected recipient to be rejected, got: %v", err)
+	}
+}
+
+func TestParseEmailCommand(t *testing.T) {
+	testCases := []struct {
+		name     string
+		email    string
+		expected string
+	}{
+		{
+			name:     "subject only",
+			email:    "Subject: Fwd: Re: weather Istanbul\r\n\r\n",
+			expected: "weather Istanbul",
+		},
+		{
+			name:     "body with signature",
+			email:    "Subject: hi\r\n\r\n\r\nwiki en Gopher\r\n-- \r\nAlice\r\n",
+			expected: "wiki en Gopher",
+		},
+		{
+			name: "multipart with quoted-printable text",
+			email: "Subject: hi\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>ignored</p>\r\n" +
+				"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+				"weather =C4=B0stanbul\r\n--b--\r\n",
+			expected: "weather İstanbul",
+		},
+	}
+
+	for _, tc := range testCases {
+		message, err := mail.ReadMessage(strings.NewReader(tc.email))
+		if err != nil {
+			t.Fatalf("%s: invalid email: %v", tc.name, err)
+		}
+		command, err := parseEmailCommand(decodeEmailHeader(message.Header.Get("Subject")), message)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if command != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, command)
+		}
+	}
+}
+
+func TestIsVerifiedSender(t *testing.T) {
+	results := func(values ...string) mail.Header {
+		return mail.Header{"Authentication-Results": values}
+	}
+
+	if !isVerifiedSender(results("MX.neo146.test 1; spf=pass; dmarc=pass (p=reject) header.from=Example.org"), "mx.neo146.test", "alice@example.org") {
+		t.Error("Expected DMARC pass to verify the sender")
+	}
+	if isVerifiedSender(results("mx.neo146.test; dkim=pass header.d=example.org"), "", "alice@example.org") {
+		t.Error("Expected no sender to be verified without an authserv-id")
+	}
+	// Only the topmost header of the MTA counts
+	if isVerifiedSender(results("mx.neo146.test; dkim=none", "mx.neo146.test; dkim=pass header.d=example.org"), "mx.neo146.test", "alice@example.org") {
+		t.Error("Expected a forged header below the one of the MTA to be ignored")
+	}
+}
+
+func TestIsAutomatedEmail(t *testing.T) {
+	header := func(name, value string) mail.Header {
+		return mail.Header{name: []string{value}}
+	}
+
+	if !isAutomatedEmail("", mail.Header{}) {
+		t.Error("Expected bounce to be automated")
+	}
+	if !isAutomatedEmail("list@example.org", header("List-Id", "<list.example.org>")) {
+		t.Error("Expected mailing list email to be automated")
+	}
+	if !isAutomatedEmail("bob@example.org", header("Precedence", "bulk")) {
+		t.Error("Expected bulk email to be automated")
+	}
+	if isAutomatedEmail("bob@example.org", header("Auto-Submitted", "no")) {
+		t.Error("Expected Auto-Submitted: no to be answered")
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"neo146/models"
+	"neo146/utils"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// maxFeedSize is the maximum number of bytes read from a feed
+const maxFeedSize = 2 << 20
+
+// FeedService handles fetching generic RSS 2.0 and Atom feeds
+type FeedService struct {
+	httpClient      *http.Client
+	markdownService *MarkdownService
+	aliases         map[string]string
+}
+
+// NewFeedService creates a new instance of FeedService. Aliases map short
+// names configured by the operator to feed URLs.
+func NewFeedService(httpClient *http.Client, markdownService *MarkdownService, aliases map[string]string) *FeedService {
+	normalized := make(map[string]string, len(aliases))
+	for alias, feedURL := range aliases {
+		normalized[strings.ToLower(alias)] = feedURL
+	}
+
+	return &FeedService{
+		httpClient:      httpClient,
+		markdownService: markdownService,
+		aliases:         normalized,
+	}
+}
+
+// ResolveSource returns the feed URL for an alias or URL
+func (s *FeedService) ResolveSource(source string) (string, error) {
+	source = strings.TrimSpace(source)
+	if feedURL, ok := s.aliases[strings.ToLower(source)]; ok {
+		return feedURL, nil
+	}
+	if utils.IsURL(source) {
+		return source, nil
+	}
+	return "", fmt.Errorf("unknown feed: %s", source)
+}
+
+// FetchFeed fetches and parses the feed for an alias or URL
+func (s *FeedService) FetchFeed(source string) (*models.Feed, error) {
+	feedURL, err := s.ResolveSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Get(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching feed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading feed: %v", err)
+	}
+
+	return ParseFeed(body)
+}
+
+// FetchHeadlines fetches a feed and formats its latest headlines
+func (s *FeedService) FetchHeadlines(source string, count int) (string, error) {
+	feed, err := s.FetchFeed(source)
+	if err != nil {
+		return "", err
+	}
+	return FormatHeadlines(feed, count), nil
+}
+
+// ReadItem fetches the nth (1-based) item of a feed as Markdown
+func (s *FeedService) ReadItem(source string, n int) (string, error) {
+	feed, err := s.FetchFeed(source)
+	if err != nil {
+		return "", err
+	}
+
+	if n < 1 || n > len(feed.Items) {
+		return "", fmt.Errorf("item number must be between 1 and %d", len(feed.Items))
+	}
+	item := feed.Items[n-1]
+
+	// Prefer the full article, fall back to the description in the feed
+	if item.Link != "" && s.markdownService != nil {
+		markdown, err := s.markdownService.FetchMarkdown(item.Link)
+		if err == nil {
+			return markdown, nil
+		}
+	}
+
+	return fmt.Sprintf("# %s\n\n%s\n%s", item.Title, stripHTML(item.Description), item.Link), nil
+}
+
+// FormatAliases returns the list of configured feed aliases
+func (s *FeedService) FormatAliases() string {
+	if len(s.aliases) == 0 {
+		return "No feed aliases configured. Use feed <url> instead."
+	}
+
+	aliases := make([]string, 0, len(s.aliases))
+	for alias := range s.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	return "Feeds: " + strings.Join(aliases, ", ")
+}
+
+// ParseFeedCommand splits feed command arguments into the feed source and
+// an optional item number, e.g. "bianet 3" returns ("bianet", 3)
+func ParseFeedCommand(args string) (string, int) {
+	return parseNumberedArgs(args)
+}
+
+// parseNumberedArgs splits command arguments into a name and an optional
+// trailing number
+func parseNumberedArgs(args string) (string, int) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", 0
+	}
+	if len(fields) > 1 {
+		if n, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			return fields[0], n
+		}
+	}
+	return fields[0], 0
+}
+
+// FeedReadHint returns a hint on how to read an item of the given feed
+func FeedReadHint(command, source string) string {
+	return fmt.Sprintf("Reply \"%s %s <n>\" to read an item.", command, source)
+}
+
+// ParseFeed parses an RSS 2.0 or Atom document
+func ParseFeed(data []byte) (*models.Feed, error) {
+	root, err := feedRootElement(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root {
+	case "rss":
+		var rss models.RSS
+		if err := newFeedDecoder(data).Decode(&rss); err != nil {
+			return nil, fmt.Errorf("error parsing RSS: %v", err)
+		}
+		feed := &models.Feed{Title: strings.TrimSpace(rss.Channel.Title)}
+		for _, item := range rss.Channel.Items {
+			feed.Items = append(feed.Items, models.FeedItem{
+				Title:       cleanFeedText(item.Title),
+				Link:        strings.TrimSpace(item.Link),
+				Description: item.Description,
+				Published:   item.PubDate,
+			})
+		}
+		return feed, nil
+	case "feed":
+		var atom models.Atom
+		if err := newFeedDecoder(data).Decode(&atom); err != nil {
+			return nil, fmt.Errorf("error parsing Atom: %v", err)
+		}
+		feed := &models.Feed{Title: strings.TrimSpace(atom.Title)}
+		for _, entry := range atom.Entries {
+			description := entry.Summary
+			if description == "" {
+				description = entry.Content
+			}
+			feed.Items = append(feed.Items, models.FeedItem{
+				Title:       cleanFeedText(entry.Title),
+				Link:        atomEntryLink(entry),
+				Description: description,
+				Published:   entry.Updated,
+			})
+		}
+		return feed, nil
+	default:
+		return nil, fmt.Errorf("unsupported feed format: %s", root)
+	}
+}
+
+// FormatHeadlines formats the latest count headlines of a feed compactly
+func FormatHeadlines(feed *models.Feed, count int) string {
+	if len(feed.Items) == 0 {
+		return "No items found in feed."
+	}
+
+	var lines []string
+	if feed.Title != "" {
+		lines = append(lines, fmt.Sprintf("# %s", feed.Title))
+	}
+	for i, item := range feed.Items {
+		if i >= count {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, item.Title))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// feedRootElement returns the name of the root XML element
+func feedRootElement(data []byte) (string, error) {
+	decoder := newFeedDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("error parsing feed: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// newFeedDecoder creates an XML decoder that tolerates non UTF-8 charsets
+func newFeedDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Feeds in the wild declare many legacy encodings, read them as is
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder
+}
+
+// atomEntryLink returns the alternate link of an Atom entry
+func atomEntryLink(entry models.AtomEntry) string {
+	for _, link := range entry.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	if len(entry.Links) > 0 {
+		return strings.TrimSpace(entry.Links[0].Href)
+	}
+	return ""
+}
+
+// cleanFeedText removes markup and collapses whitespace in a feed text field
+func cleanFeedText(text string) string {
+	return strings.Join(strings.Fields(stripHTML(text)), " ")
+}
+
+// stripHTML returns the text content of an HTML fragment
+func stripHTML(fragment string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return fragment
+	}
+	return strings.TrimSpace(doc.Text())
+}
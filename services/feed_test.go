@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"neo146/utils"
+)
+
+const rssFixture = `<?xml version="1.0" encoding="ISO-8859-9"?>
+<rss version="2.0"><channel>
+<title>Independent News</title>
+<item><title>First &lt;b&gt;headline&lt;/b&gt;</title><link>https://news.example/1</link><description>&lt;p&gt;First story&lt;/p&gt;</description></item>
+<item><title>Second headline</title><link>https://news.example/2</link><description>Second story</description></item>
+<item><title>Third headline</title><link></link><description>&lt;p&gt;Third story body&lt;/p&gt;</description></item>
+</channel></rss>`
+
+const atomFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Atom News</title>
+<entry><title>Atom entry</title><link rel="self" href="https://atom.example/self"/><link href="https://atom.example/1"/><summary>Atom summary</summary><updated>2025-03-19T10:00:00Z</updated></entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	feed, err := ParseFeed([]byte(rssFixture))
+	if err != nil {
+		t.Fatalf("Expected RSS to parse, got error: %v", err)
+	}
+	if feed.Title != "Independent News" || len(feed.Items) != 3 {
+		t.Fatalf("Unexpected RSS feed: %+v", feed)
+	}
+	if feed.Items[0].Title != "First headline" {
+		t.Errorf("Expected markup to be removed from title, got %q", feed.Items[0].Title)
+	}
+
+	atom, err := ParseFeed([]byte(atomFixture))
+	if err != nil {
+		t.Fatalf("Expected Atom to parse, got error: %v", err)
+	}
+	if atom.Title != "Atom News" || len(atom.Items) != 1 {
+		t.Fatalf("Unexpected Atom feed: %+v", atom)
+	}
+	if atom.Items[0].Link != "https://atom.example/1" {
+		t.Errorf("Expected alternate link, got %q", atom.Items[0].Link)
+	}
+
+	if _, err := ParseFeed([]byte(`<html></html>`)); err == nil {
+		t.Error("Expected error for unsupported document, got nil")
+	}
+}
+
+func TestFormatHeadlines(t *testing.T) {
+	feed, _ := ParseFeed([]byte(rssFixture))
+
+	expected := "# Independent News\n1. First headline\n2. Second headline"
+	if got := FormatHeadlines(feed, 2); got != expected {
+		t.Errorf("Unexpected headlines:\n%s", got)
+	}
+}
+
+func TestParseFeedCommand(t *testing.T) {
+	testCases := []struct {
+		args   string
+		source string
+		item   int
+	}{
+		{"", "", 0},
+		{" bianet ", "bianet", 0},
+		{"bianet 3", "bianet", 3},
+		{"https://example.org/rss 2", "https://example.org/rss", 2},
+	}
+
+	for _, tc := range testCases {
+		source, item := ParseFeedCommand(tc.args)
+		if source != tc.source || item != tc.item {
+			t.Errorf("ParseFeedCommand(%q) = (%q, %d), expected (%q, %d)", tc.args, source, item, tc.source, tc.item)
+		}
+	}
+}
+
+func TestFeedService_Aliases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rssFixture)
+	}))
+	defer server.Close()
+
+	service := NewFeedService(server.Client(), nil, map[string]string{"News": server.URL})
+
+	headlines, err := service.FetchHeadlines("news", 5)
+	if err != nil {
+		t.Fatalf("Expected alias to resolve, got error: %v", err)
+	}
+	if !strings.Contains(headlines, "3. Third headline") {
+		t.Errorf("Expected all headlines, got:\n%s", headlines)
+	}
+
+	// Items without a link fall back to the feed description
+	item, err := service.ReadItem("news", 3)
+	if err != nil {
+		t.Fatalf("Expected to read item, got error: %v", err)
+	}
+	if !strings.Contains(item, "Third story body") {
+		t.Errorf("Expected item description, got:\n%s", item)
+	}
+
+	if _, err := service.ReadItem("news", 4); err == nil {
+		t.Error("Expected error for out of range item, got nil")
+	}
+	if _, err := service.FetchHeadlines("unknown", 5); err == nil {
+		t.Error("Expected error for unknown alias, got nil")
+	}
+	if service.FormatAliases() != "Feeds: news" {
+		t.Errorf("Unexpected aliases: %s", service.FormatAliases())
+	}
+}
+
+func TestFeedService_RefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request to reach the loopback address")
+		fmt.Fprint(w, rssFixture)
+	}))
+	defer server.Close()
+
+	service := NewFeedService(&http.Client{Transport: utils.NewPublicTransport()}, nil, nil)
+	if _, err := service.FetchFeed(server.URL + "/metrics"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected feed on 127.0.0.1 to be refused, got: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// fingerRequestTimeout is how long the server waits for a query
+	fingerRequestTimeout = 30 * time.Second
+	// fingerMaxQuery is the maximum length of a query line
+	fingerMaxQuery = 512
+)
+
+const fingerHelp = `neo146 - infogate
+
+Queries:
+  finger weather/<location>@host     Weather forecast
+  finger wiki/<lang>/<query>@host    Wikipedia summary, e.g. wiki/tr/Ankara
+  finger news@host                   News headlines
+  finger news/<n>@host               Read news item n`
+
+// FingerConfig holds the settings of the finger server
+type FingerConfig struct {
+	ListenAddr string
+	// MaxResponse is the maximum size of a response in bytes, longer
+	// responses are truncated
+	MaxResponse int
+}
+
+// FingerServer answers one-shot queries over the finger protocol (RFC 1288)
+type FingerServer struct {
+	tcpServer
+	config     FingerConfig
+	smsService *SMSService
+	limiter    *RateLimiter
+}
+
+// NewFingerServer creates a new finger server using the services registered
+// on smsService. The limiter is keyed by client IP.
+func NewFingerServer(config FingerConfig, smsService *SMSService, limiter *RateLimiter) *FingerServer {
+	s := &FingerServer{
+		config:     config,
+		smsService: smsService,
+		limiter:    limiter,
+	}
+	s.tcpServer.handler = s.handleConn
+	return s
+}
+
+// ListenAndServe listens on the configured address and serves queries
+// until Close is called
+func (s *FingerServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for finger: %v", err)
+	}
+	slog.Info("Finger server listening", "listen_addr", s.config.ListenAddr)
+	return s.Serve(listener)
+}
+
+// handleConn answers a single finger query
+func (s *FingerServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(fingerRequestTimeout))
+
+	reader := bufio.NewReaderSize(conn, fingerMaxQuery)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return
+	}
+	query := strings.TrimRight(string(line), "\r\n")
+
+	var response string
+	if s.limiter.Allow(remoteIP(conn)) {
+		response = s.Handle(query)
+	} else {
+		utils.RateLimitRejections.Inc("finger")
+		response = "Rate limit reached, please try again later."
+	}
+
+	// Fetching content can take long, give the client time to read it
+	conn.SetDeadline(time.Now().Add(fingerRequestTimeout))
+	conn.Write([]byte(strings.ReplaceAll(s.truncate(response), "\n", "\r\n") + "\r\n"))
+}
+
+// Handle returns the response for a query line
+func (s *FingerServer) Handle(query string) string {
+	query = strings.TrimSpace(query)
+	// The verbose flag makes no difference here
+	if query == "/W" || strings.HasPrefix(query, "/W ") {
+		query = strings.TrimSpace(strings.TrimPrefix(query, "/W"))
+	}
+
+	if query == "" {
+		return fingerHelp
+	}
+	// Forwarding queries to other hosts is refused, as RFC 1288 recommends
+	if strings.Contains(query, "@") {
+		return "Finger forwarding is not supported."
+	}
+
+	command, args, _ := strings.Cut(query, "/")
+	switch strings.ToLower(command) {
+	case "weather":
+		if args == "" {
+			return "Usage: weather/<location>"
+		}
+		return fingerText(s.smsService.WeatherService.FetchWeatherForecast(args))
+	case "wiki":
+		lang, term, found := strings.Cut(args, "/")
+		if !found || lang == "" || term == "" {
+			return "Usage: wiki/<lang>/<query>"
+		}
+		return fingerText(s.smsService.SearchService.FetchWikipediaSummary(term, lang))
+	case "news":
+		if args == "" {
+			return fingerText(s.smsService.PortalService.FormatSection(models.PortalSectionNews))
+		}
+		n, err := strconv.Atoi(args)
+		if err != nil {
+			return "Usage: news/<n>"
+		}
+		return fingerText(s.smsService.PortalService.FormatEntry(models.PortalSectionNews, n))
+	}
+
+	return fingerHelp
+}
+
+// truncate shortens a response to the configured size
+func (s *FingerServer) truncate(response string) string {
+	const marker = "\n[truncated]"
+	if s.config.MaxResponse <= 0 || len(response) <= s.config.MaxResponse {
+		return response
+	}
+
+	cut := s.config.MaxResponse - len(marker)
+	if cut < 0 {
+		cut = 0
+	}
+	// Do not split a multibyte character
+	for cut > 0 && !utf8.RuneStart(response[cut]) {
+		cut--
+	}
+	return response[:cut] + marker
+}
+
+// fingerText returns content, or the error message
+func fingerText(content string, err error) string {
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	return content
+}
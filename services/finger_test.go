@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/services/servicestest"
+)
+
+func newTestFingerServer(maxResponse int) *FingerServer {
+	portalService := NewPortalService(&servicestest.PortalStore{})
+	portalService.AddEntry("news", "Bridge closed", "The bridge is closed.")
+	portalService.AddEntry("news", "Ferry schedule", "Ferries run every hour. Çay is served on board.")
+
+	return NewFingerServer(FingerConfig{MaxResponse: maxResponse}, &SMSService{PortalService: portalService}, NewRateLimiter(2, time.Minute))
+}
+
+func TestFingerServer_Handle(t *testing.T) {
+	server := newTestFingerServer(0)
+
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"", "neo146 - infogate"},
+		{"/W", "neo146 - infogate"},
+		{"news", "# NEWS"},
+		{"/W news/1", "# Ferry schedule"},
+		{"news/x", "Usage: news/<n>"},
+		{"news/9", "Error: news entry 9 not found"},
+		{"wiki/tr", "Usage: wiki/<lang>/<query>"},
+		{"weather/", "Usage: weather/<location>"},
+		{"news@other.example.org", "Finger forwarding is not supported."},
+		{"root", "neo146 - infogate"},
+	}
+
+	for _, tc := range testCases {
+		if response := server.Handle(tc.query); !strings.HasPrefix(response, tc.expected) {
+			t.Errorf("%q: expected %q, got %q", tc.query, tc.expected, response)
+		}
+	}
+}
+
+func TestFingerServer_Truncate(t *testing.T) {
+	server := newTestFingerServer(37)
+
+	response := server.truncate("Ferries run every hour. Çay is served on board.")
+	if response != "Ferries run every hour. \n[truncated]" {
+		t.Errorf("Unexpected truncated response: %q", response)
+	}
+	if response := server.truncate("short"); response != "short" {
+		t.Errorf("Expected short response to be kept, got %q", response)
+	}
+}
+
+func TestFingerServer_Serve(t *testing.T) {
+	server := newTestFingerServer(100)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting finger server: %v", err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	query := func(q string) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "%s\r\n", q)
+		response, _ := io.ReadAll(conn)
+		return string(response)
+	}
+
+	response := query("news")
+	if !strings.HasPrefix(response, "# NEWS\r\n\r\n1. Ferry schedule\r\n") {
+		t.Errorf("Expected news with CRLF line endings, got:\n%s", response)
+	}
+	if text := strings.TrimSuffix(strings.ReplaceAll(response, "\r\n", "\n"), "\n"); len(text) > 100 || !strings.HasSuffix(text, "[truncated]") {
+		t.Errorf("Expected response to be truncated to 100 bytes, got %d", len(text))
+	}
+
+	query("news/1")
+	if response := query("news"); !strings.HasPrefix(response, "Rate limit reached") {
+		t.Errorf("Expected rate limit message, got:\n%s", response)
+	}
+}
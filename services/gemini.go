@@ -0,0 +1,290 @@
+package services
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"neo146/utils"
+)
+
+const (
+	// geminiRequestTimeout is how long the server waits for a request
+	geminiRequestTimeout = 30 * time.Second
+	// geminiMaxRequest is the maximum length of a request URL (without CRLF)
+	geminiMaxRequest = 1024
+	// geminiCertificateValidity is the lifetime of generated certificates.
+	// Clients pin certificates on first use, so they should live long.
+	geminiCertificateValidity = 5 * 365 * 24 * time.Hour
+)
+
+// GeminiConfig holds the settings of the Gemini server
+type GeminiConfig struct {
+	ListenAddr string
+	// Hostname is the host clients request, requests for other hosts are
+	// refused
+	Hostname string
+	// CertFile and KeyFile hold the TLS certificate. A self-signed
+	// certificate is generated and saved there if they do not exist.
+	CertFile string
+	KeyFile  string
+}
+
+// GeminiServer serves gateway content over the Gemini protocol
+type GeminiServer struct {
+	tcpServer
+	config     GeminiConfig
+	smsService *SMSService
+	limiter    *RateLimiter
+}
+
+// NewGeminiServer creates a new Gemini server using the services registered
+// on smsService. The limiter is keyed by client IP.
+func NewGeminiServer(config GeminiConfig, smsService *SMSService, limiter *RateLimiter) *GeminiServer {
+	s := &GeminiServer{
+		config:     config,
+		smsService: smsService,
+		limiter:    limiter,
+	}
+	s.tcpServer.handler = s.handleConn
+	return s
+}
+
+// ListenAndServe listens on the configured address and serves requests
+// until Close is called
+func (s *GeminiServer) ListenAndServe() error {
+	certificate, err := LoadOrCreateCertificate(s.config.CertFile, s.config.KeyFile, s.config.Hostname)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for gemini: %v", err)
+	}
+	slog.Info("Gemini server listening", "listen_addr", s.config.ListenAddr)
+
+	return s.Serve(tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}))
+}
+
+// handleConn answers a single Gemini request
+func (s *GeminiServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(geminiRequestTimeout))
+
+	reader := bufio.NewReaderSize(conn, geminiMaxRequest+2)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			conn.Write([]byte("59 Request too long\r\n"))
+		}
+		return
+	}
+	request := strings.TrimRight(string(line), "\r\n")
+
+	var response string
+	if s.limiter.Allow(remoteIP(conn)) {
+		response = s.Handle(request)
+	} else {
+		utils.RateLimitRejections.Inc("gemini")
+		response = "44 60\r\n"
+	}
+
+	// Fetching content can take long, give the client time to read it
+	conn.SetDeadline(time.Now().Add(geminiRequestTimeout))
+	conn.Write([]byte(response))
+}
+
+// Handle returns the response for a request URL
+func (s *GeminiServer) Handle(request string) string {
+	requestURL, err := url.Parse(request)
+	if err != nil || requestURL.Scheme != "gemini" || requestURL.Host == "" {
+		return "59 Bad request\r\n"
+	}
+	if !strings.EqualFold(requestURL.Hostname(), s.config.Hostname) {
+		return "53 Proxy request refused\r\n"
+	}
+
+	query, err := url.PathUnescape(requestURL.RawQuery)
+	if err != nil {
+		return "59 Bad request\r\n"
+	}
+	query = strings.TrimSpace(query)
+
+	segments := strings.SplitN(strings.Trim(requestURL.Path, "/"), "/", 3)
+	switch segments[0] {
+	case "":
+		return geminiPage(geminiIndex)
+	case "uri2md":
+		if query == "" {
+			return "10 Enter a URL\r\n"
+		}
+		return geminiContent(s.smsService.MarkdownService.FetchMarkdown(query))
+	case "search":
+		if query == "" {
+			return "10 Search the web\r\n"
+		}
+		return s.search(query)
+	case "wiki":
+		if len(segments) == 1 || segments[1] == "" {
+			return geminiPage(geminiWikiIndex)
+		}
+		term := query
+		if len(segments) == 3 {
+			term = segments[2]
+		}
+		if term == "" {
+			return "10 Search Wikipedia\r\n"
+		}
+		return geminiContent(s.smsService.SearchService.FetchWikipediaSummary(term, segments[1]))
+	case "weather":
+		location := query
+		if len(segments) > 1 {
+			location = strings.Join(segments[1:], "/")
+		}
+		if location == "" {
+			return "10 Enter a location\r\n"
+		}
+		return geminiContent(s.smsService.WeatherService.FetchWeatherForecast(location))
+	}
+
+	return "51 Not found\r\n"
+}
+
+// search formats search results as links to their gemtext conversion
+func (s *GeminiServer) search(query string) string {
+	results, err := s.smsService.SearchService.Search(query)
+	if err != nil {
+		return "40 Error searching: " + geminiMeta(err.Error()) + "\r\n"
+	}
+
+	lines := []string{"# Results for: " + query, ""}
+	if len(results) == 0 {
+		lines = append(lines, "No results found.")
+	}
+	for _, result := range results {
+		lines = append(lines, "=> /uri2md?"+url.PathEscape(result.URL)+" "+result.Title)
+		if result.Snippet != "" {
+			lines = append(lines, result.Snippet)
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, "=> /search New search")
+
+	return geminiPage(strings.Join(lines, "\n"))
+}
+
+// geminiIndex is the main page of the Gemini server
+const geminiIndex = `# neo146 - infogate
+
+=> /search Search the web
+=> /uri2md Convert a web page to gemtext
+=> /wiki Wikipedia summaries
+=> /weather Weather forecast`
+
+// geminiWikiIndex lists Wikipedia languages
+const geminiWikiIndex = `# Wikipedia
+
+=> /wiki/en English
+=> /wiki/tr Türkçe
+=> /wiki/de Deutsch
+=> /wiki/fr Français
+
+Other languages: /wiki/<lang>/<query>`
+
+// geminiContent converts Markdown content to a gemtext response, or an error
+// response
+func geminiContent(markdown string, err error) string {
+	if err != nil {
+		return "40 " + geminiMeta(err.Error()) + "\r\n"
+	}
+	return geminiPage(utils.MarkdownToGemtext(markdown))
+}
+
+// geminiPage returns a successful gemtext response
+func geminiPage(gemtext string) string {
+	return "20 text/gemini; charset=utf-8\r\n" + gemtext + "\n"
+}
+
+// geminiMeta makes text safe for the meta field of a response header
+func geminiMeta(text string) string {
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+	if len(text) > 1024 {
+		text = text[:1024]
+	}
+	return text
+}
+
+// LoadOrCreateCertificate loads a TLS certificate, or generates a
+// self-signed one for hostname and saves it if the files do not exist. With
+// empty paths the certificate is only kept in memory.
+func LoadOrCreateCertificate(certFile, keyFile, hostname string) (tls.Certificate, error) {
+	if certFile != "" && keyFile != "" {
+		if _, err := os.Stat(certFile); err == nil {
+			certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("error loading certificate: %v", err)
+			}
+			return certificate, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error generating key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error generating serial number: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(geminiCertificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error encoding key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if certFile != "" && keyFile != "" {
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, fmt.Errorf("error saving key: %v", err)
+		}
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, fmt.Errorf("error saving certificate: %v", err)
+		}
+		slog.Info("Generated self-signed certificate", "hostname", hostname, "file", certFile)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
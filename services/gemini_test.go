@@ -0,0 +1,99 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGeminiServer(t *testing.T) *GeminiServer {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, duckDuckGoLiteFixture)
+	}))
+	t.Cleanup(server.Close)
+
+	searchService := NewSearchService(server.Client())
+	searchService.searchURL = server.URL
+
+	return NewGeminiServer(GeminiConfig{Hostname: "localhost"}, &SMSService{SearchService: searchService}, NewRateLimiter(5, time.Minute))
+}
+
+func TestGeminiServer_Handle(t *testing.T) {
+	server := newTestGeminiServer(t)
+
+	testCases := []struct {
+		request  string
+		expected string
+	}{
+		{"gemini://localhost/", "20 text/gemini; charset=utf-8\r\n# neo146"},
+		{"gemini://localhost/search", "10 Search the web\r\n"},
+		{"gemini://localhost/uri2md", "10 Enter a URL\r\n"},
+		{"gemini://localhost/wiki/en", "10 Search Wikipedia\r\n"},
+		{"gemini://localhost/weather", "10 Enter a location\r\n"},
+		{"gemini://localhost/missing", "51 Not found\r\n"},
+		{"gemini://example.org/", "53 Proxy request refused\r\n"},
+		{"https://localhost/", "59 Bad request\r\n"},
+	}
+
+	for _, tc := range testCases {
+		if response := server.Handle(tc.request); !strings.HasPrefix(response, tc.expected) {
+			t.Errorf("%s: expected %q, got %q", tc.request, tc.expected, response)
+		}
+	}
+
+	results := server.Handle("gemini://localhost/search?neo146%20gateway")
+	if !strings.Contains(results, "# Results for: neo146 gateway\n") {
+		t.Errorf("Expected decoded query in results:\n%s", results)
+	}
+	if !strings.Contains(results, "=> /uri2md?https:%2F%2Fexample.org%2Fsecond Second Result Title\nSecond snippet.\n") {
+		t.Errorf("Expected results as links:\n%s", results)
+	}
+}
+
+func TestGeminiServer_TLS(t *testing.T) {
+	server := newTestGeminiServer(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "gemini.crt"), filepath.Join(dir, "gemini.key")
+	certificate, err := LoadOrCreateCertificate(certFile, keyFile, "localhost")
+	if err != nil {
+		t.Fatalf("Expected certificate to be created, got error: %v", err)
+	}
+
+	// The saved certificate is reused
+	reloaded, err := LoadOrCreateCertificate(certFile, keyFile, "localhost")
+	if err != nil || string(reloaded.Certificate[0]) != string(certificate.Certificate[0]) {
+		t.Fatalf("Expected saved certificate to be loaded, got error: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting gemini server: %v", err)
+	}
+	go server.Serve(tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}}))
+	defer server.Close()
+
+	// Gemini clients trust certificates on first use
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	if names := conn.ConnectionState().PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != "localhost" {
+		t.Errorf("Unexpected certificate names: %v", names)
+	}
+
+	fmt.Fprint(conn, "gemini://localhost/\r\n")
+	response, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(response), "20 text/gemini") {
+		t.Errorf("Unexpected response: %q", response)
+	}
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"neo146/utils"
+)
+
+const (
+	// gopherRequestTimeout is how long the server waits for a selector
+	gopherRequestTimeout = 30 * time.Second
+	// gopherMaxSelector is the maximum length of a selector and its query
+	gopherMaxSelector = 1024
+)
+
+// Gopher item types (RFC 1436)
+const (
+	gopherText   = '0'
+	gopherMenu   = '1'
+	gopherError  = '3'
+	gopherSearch = '7'
+	gopherInfo   = 'i'
+)
+
+// GopherConfig holds the settings of the Gopher server
+type GopherConfig struct {
+	ListenAddr string
+	// Hostname and Port are used in menu items, so they must be the address
+	// clients reach the server at
+	Hostname string
+	Port     int
+}
+
+// GopherServer serves gateway content over the Gopher protocol (RFC 1436)
+type GopherServer struct {
+	tcpServer
+	config     GopherConfig
+	smsService *SMSService
+	limiter    *RateLimiter
+}
+
+// NewGopherServer creates a new Gopher server using the services registered
+// on smsService. The limiter is keyed by client IP.
+func NewGopherServer(config GopherConfig, smsService *SMSService, limiter *RateLimiter) *GopherServer {
+	s := &GopherServer{
+		config:     config,
+		smsService: smsService,
+		limiter:    limiter,
+	}
+	s.tcpServer.handler = s.handleConn
+	return s
+}
+
+// ListenAndServe listens on the configured address and serves requests
+// until Close is called
+func (s *GopherServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for gopher: %v", err)
+	}
+	slog.Info("Gopher server listening", "listen_addr", s.config.ListenAddr)
+	return s.Serve(listener)
+}
+
+// handleConn answers a single Gopher request
+func (s *GopherServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(gopherRequestTimeout))
+
+	reader := bufio.NewReaderSize(conn, gopherMaxSelector)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return
+	}
+	request := strings.TrimRight(string(line), "\r\n")
+
+	var response string
+	if s.limiter.Allow(remoteIP(conn)) {
+		response = s.Handle(request)
+	} else {
+		utils.RateLimitRejections.Inc("gopher")
+		response = s.menu(s.errorItem("Rate limit reached, please try again later."))
+	}
+
+	// Fetching content can take long, give the client time to read it
+	conn.SetDeadline(time.Now().Add(gopherRequestTimeout))
+	conn.Write([]byte(response))
+}
+
+// Handle returns the response for a request line, a selector optionally
+// followed by a tab and the query of a search item
+func (s *GopherServer) Handle(request string) string {
+	selector, query, _ := strings.Cut(request, "\t")
+	query = strings.TrimSpace(query)
+
+	switch {
+	case selector == "" || selector == "/":
+		return s.rootMenu()
+	case selector == "/search":
+		return s.searchMenu(query)
+	case selector == "/uri2md":
+		return s.text(s.smsService.MarkdownService.FetchMarkdown(query))
+	case strings.HasPrefix(selector, "/uri2md/"):
+		return s.text(s.smsService.MarkdownService.FetchMarkdown(strings.TrimPrefix(selector, "/uri2md/")))
+	case selector == "/weather":
+		return s.text(s.smsService.WeatherService.FetchWeatherForecast(query))
+	case selector == "/wiki":
+		lang, term, found := strings.Cut(query, " ")
+		if !found {
+			return s.text("", fmt.Errorf("usage: <lang> <query>, e.g. en Gopher"))
+		}
+		return s.text(s.smsService.SearchService.FetchWikipediaSummary(strings.TrimSpace(term), lang))
+	case selector == "/portal" || strings.HasPrefix(selector, "/portal/"):
+		return s.portal(strings.Trim(strings.TrimPrefix(selector, "/portal"), "/"))
+	}
+
+	return s.menu(s.errorItem("Not found: " + selector))
+}
+
+// rootMenu lists the available content
+func (s *GopherServer) rootMenu() string {
+	return s.menu(
+		s.infoItem("neo146 - infogate"),
+		s.infoItem(""),
+		s.item(gopherMenu, "Portal: emergency info, news and announcements", "/portal"),
+		s.item(gopherSearch, "Search the web", "/search"),
+		s.item(gopherSearch, "Convert a web page to text (enter URL)", "/uri2md"),
+		s.item(gopherSearch, "Wikipedia summary (enter: <lang> <query>)", "/wiki"),
+		s.item(gopherSearch, "Weather forecast (enter location)", "/weather"),
+	)
+}
+
+// searchMenu lists search results as text items
+func (s *GopherServer) searchMenu(query string) string {
+	if query == "" {
+		return s.menu(s.errorItem("Please enter a search query."))
+	}
+
+	results, err := s.smsService.SearchService.Search(query)
+	if err != nil {
+		return s.menu(s.errorItem("Error searching: " + err.Error()))
+	}
+	if len(results) == 0 {
+		return s.menu(s.infoItem("No results found."))
+	}
+
+	items := []string{s.infoItem("Results for: " + query), s.infoItem("")}
+	for _, result := range results {
+		items = append(items, s.item(gopherText, result.Title, "/uri2md/"+result.URL))
+		if result.Snippet != "" {
+			items = append(items, s.infoItem("  "+result.Snippet))
+		}
+	}
+	return s.menu(items...)
+}
+
+// portal serves the portal index, a section menu or an entry
+func (s *GopherServer) portal(path string) string {
+	section, number, _ := strings.Cut(path, "/")
+
+	if number != "" {
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return s.menu(s.errorItem("Invalid entry number: " + number))
+		}
+		return s.text(s.smsService.PortalService.FormatEntry(section, n))
+	}
+
+	sections, err := s.smsService.PortalService.Sections(section, portalSectionEntries)
+	if err != nil {
+		return s.menu(s.errorItem(err.Error()))
+	}
+	if len(sections) == 0 {
+		return s.menu(s.infoItem("The portal has no content yet."))
+	}
+
+	var items []string
+	for _, portalSection := range sections {
+		items = append(items, s.infoItem(strings.ToUpper(portalSection.Name)))
+		for i, entry := range portalSection.Entries {
+			items = append(items, s.item(gopherText, entry.Title, fmt.Sprintf("/portal/%s/%d", portalSection.Name, i+1)))
+		}
+		items = append(items, s.infoItem(""))
+	}
+	return s.menu(items...)
+}
+
+// item formats a menu item pointing to a selector on this server
+func (s *GopherServer) item(itemType byte, display, selector string) string {
+	return fmt.Sprintf("%c%s\t%s\t%s\t%d", itemType, gopherField(display), gopherField(selector), s.config.Hostname, s.config.Port)
+}
+
+// infoItem formats an informational menu line
+func (s *GopherServer) infoItem(text string) string {
+	return fmt.Sprintf("%c%s\t\terror.host\t1", gopherInfo, gopherField(text))
+}
+
+// errorItem formats an error menu line
+func (s *GopherServer) errorItem(text string) string {
+	return fmt.Sprintf("%c%s\t\terror.host\t1", gopherError, gopherField(text))
+}
+
+// menu joins menu items into a menu response
+func (s *GopherServer) menu(items ...string) string {
+	return strings.Join(items, "\r\n") + "\r\n.\r\n"
+}
+
+// text formats a text response, or an error
+func (s *GopherServer) text(content string, err error) string {
+	if err != nil {
+		content = "Error: " + err.Error()
+	}
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		// Lines starting with a period would end the response early
+		if strings.HasPrefix(line, ".") {
+			lines[i] = "." + line
+		}
+	}
+	return strings.Join(lines, "\r\n") + "\r\n.\r\n"
+}
+
+// gopherField removes the characters that would break a menu line
+func gopherField(value string) string {
+	return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/services/servicestest"
+)
+
+func newTestGopherServer(t *testing.T) *GopherServer {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, duckDuckGoLiteFixture)
+	}))
+	t.Cleanup(server.Close)
+
+	searchService := NewSearchService(server.Client())
+	searchService.searchURL = server.URL
+
+	portalService := NewPortalService(&servicestest.PortalStore{})
+	portalService.AddEntry("news", "Bridge closed", "The bridge is closed.\n.hidden line")
+
+	smsService := &SMSService{
+		SearchService: searchService,
+		PortalService: portalService,
+	}
+	return NewGopherServer(GopherConfig{Hostname: "gopher.test", Port: 70}, smsService, NewRateLimiter(2, time.Minute))
+}
+
+func TestGopherServer_Menus(t *testing.T) {
+	server := newTestGopherServer(t)
+
+	root := server.Handle("")
+	if !strings.Contains(root, "7Search the web\t/search\tgopher.test\t70\r\n") {
+		t.Errorf("Expected search item in root menu:\n%s", root)
+	}
+	if !strings.HasSuffix(root, "\r\n.\r\n") {
+		t.Errorf("Expected menu to end with a period line:\n%s", root)
+	}
+
+	results := server.Handle("/search\tneo146")
+	if !strings.Contains(results, "0Second Result Title\t/uri2md/https://example.org/second\tgopher.test\t70\r\n") {
+		t.Errorf("Expected search results as text items:\n%s", results)
+	}
+	if !strings.Contains(results, "i  Second snippet.\t") {
+		t.Errorf("Expected snippets as info lines:\n%s", results)
+	}
+
+	portal := server.Handle("/portal")
+	if !strings.Contains(portal, "0Bridge closed\t/portal/news/1\tgopher.test\t70\r\n") {
+		t.Errorf("Expected portal entry item:\n%s", portal)
+	}
+
+	// Lines starting with a period are escaped in text responses
+	entry := server.Handle("/portal/news/1")
+	if !strings.Contains(entry, "\r\n..hidden line\r\n") {
+		t.Errorf("Expected period to be escaped:\n%s", entry)
+	}
+
+	if notFound := server.Handle("/missing"); !strings.HasPrefix(notFound, "3Not found: /missing") {
+		t.Errorf("Expected error item, got:\n%s", notFound)
+	}
+}
+
+func TestGopherServer_RateLimit(t *testing.T) {
+	server := newTestGopherServer(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting gopher server: %v", err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	request := func() string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "/\r\n")
+		response, _ := io.ReadAll(conn)
+		return string(response)
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := request(); !strings.Contains(response, "neo146") {
+			t.Fatalf("Expected root menu, got:\n%s", response)
+		}
+	}
+	if response := request(); !strings.HasPrefix(response, "3Rate limit reached") {
+		t.Errorf("Expected rate limit error, got:\n%s", response)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"neo146/models"
+)
+
+// IVR menu levels
+const (
+	ivrMain = iota
+	ivrWeather
+	ivrNews
+)
+
+// ivrNewsEntries is the number of headlines read out
+const ivrNewsEntries = 5
+
+// IVR prompts
+const (
+	ivrMainMenu      = "For the weather, press 1. For news, press 2. To hear this menu again, press 0."
+	ivrWeatherPrompt = "Enter the two digit city code, for example 0 6 for Ankara, or press star for the main menu."
+	ivrNewsHint      = "Press the number of a headline to hear it, 0 to hear the headlines again, or star for the main menu."
+)
+
+// ivrMenu walks callers through a numbered menu with their key presses.
+// Cities are chosen by their Turkish licence plate code, which most callers
+// know by heart.
+type ivrMenu struct {
+	smsService *SMSService
+	level      int
+	input      string
+}
+
+// newIVRMenu creates a menu using the services registered on smsService
+func newIVRMenu(smsService *SMSService) *ivrMenu {
+	return &ivrMenu{smsService: smsService}
+}
+
+// Welcome returns the greeting of a new call
+func (m *ivrMenu) Welcome() string {
+	return "Welcome to neo146. " + ivrMainMenu
+}
+
+// Press handles a key and returns what to say, or "" while more keys are
+// needed
+func (m *ivrMenu) Press(key byte) string {
+	if key == '*' {
+		m.level = ivrMain
+		m.input = ""
+		return ivrMainMenu
+	}
+
+	switch m.level {
+	case ivrWeather:
+		if key == '#' {
+			if m.input == "" {
+				return ivrWeatherPrompt
+			}
+			return m.weather()
+		}
+		if key < '0' || key > '9' {
+			return ""
+		}
+		m.input += string(key)
+		if len(m.input) < 2 {
+			return ""
+		}
+		return m.weather()
+	case ivrNews:
+		if key == '0' {
+			return m.headlines()
+		}
+		if key >= '1' && key <= '9' {
+			return m.newsEntry(int(key - '0'))
+		}
+		return ivrNewsHint
+	}
+
+	switch key {
+	case '1':
+		m.level = ivrWeather
+		return ivrWeatherPrompt
+	case '2':
+		m.level = ivrNews
+		return m.headlines()
+	case '0':
+		return ivrMainMenu
+	}
+	return "Invalid choice. " + ivrMainMenu
+}
+
+// weather reads the forecast of the city code entered
+func (m *ivrMenu) weather() string {
+	code, _ := strconv.Atoi(m.input)
+	m.input = ""
+
+	if code < 1 || code >= len(turkishProvinces) {
+		return fmt.Sprintf("There is no city with code %d. %s", code, ivrWeatherPrompt)
+	}
+	forecast, err := m.smsService.WeatherService.FetchWeatherForecast(turkishProvinces[code])
+	if err != nil {
+		return "Sorry, the weather is not available right now. Press star for the main menu."
+	}
+	return forecast + "\nEnter another city code, or press star for the main menu."
+}
+
+// headlines reads the latest news headlines
+func (m *ivrMenu) headlines() string {
+	sections, err := m.smsService.PortalService.Sections(models.PortalSectionNews, ivrNewsEntries)
+	if err != nil {
+		return "Sorry, the news is not available right now. Press star for the main menu."
+	}
+	if len(sections) == 0 {
+		return "There is no news at the moment. Press star for the main menu."
+	}
+
+	parts := []string{"News headlines."}
+	for i, entry := range sections[0].Entries {
+		parts = append(parts, fmt.Sprintf("%d: %s.", i+1, entry.Title))
+	}
+	parts = append(parts, ivrNewsHint)
+	return strings.Join(parts, "\n")
+}
+
+// newsEntry reads the nth news entry in full
+func (m *ivrMenu) newsEntry(n int) string {
+	sections, err := m.smsService.PortalService.Sections(models.PortalSectionNews, ivrNewsEntries)
+	if err != nil || len(sections) == 0 || n > len(sections[0].Entries) {
+		return fmt.Sprintf("There is no headline number %d. %s", n, ivrNewsHint)
+	}
+
+	entry := sections[0].Entries[n-1]
+	return fmt.Sprintf("%s.\n%s\n%s", entry.Title, entry.Body, ivrNewsHint)
+}
+
+// turkishProvinces lists the provinces by licence plate code
+var turkishProvinces = [...]string{
+	"",
+	"Adana", "Adıyaman", "Afyonkarahisar", "Ağrı", "Amasya", "Ankara", "Antalya", "Artvin", "Aydın", "Balıkesir",
+	"Bilecik", "Bingöl", "Bitlis", "Bolu", "Burdur", "Bursa", "Çanakkale", "Çankırı", "Çorum", "Denizli",
+	"Diyarbakır", "Edirne", "Elazığ", "Erzincan", "Erzurum", "Eskişehir", "Gaziantep", "Giresun", "Gümüşhane", "Hakkari",
+	"Hatay", "Isparta", "Mersin", "İstanbul", "İzmir", "Kars", "Kastamonu", "Kayseri", "Kırklareli", "Kırşehir",
+	"Kocaeli", "Konya", "Kütahya", "Malatya", "Manisa", "Kahramanmaraş", "Mardin", "Muğla", "Muş", "Nevşehir",
+	"Niğde", "Ordu", "Rize", "Sakarya", "Samsun", "Siirt", "Sinop", "Sivas", "Tekirdağ", "Tokat",
+	"Trabzon", "Tunceli", "Şanlıurfa", "Uşak", "Van", "Yozgat", "Zonguldak", "Aksaray", "Bayburt", "Karaman",
+	"Kırıkkale", "Batman", "Şırnak", "Bartın", "Ardahan", "Iğdır", "Yalova", "Karabük", "Kilis", "Osmaniye",
+	"Düzce",
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIVRMenu(t *testing.T) {
+	menu := newIVRMenu(&SMSService{})
+
+	if answer := menu.Press('7'); !strings.HasPrefix(answer, "Invalid choice") {
+		t.Errorf("Expected invalid choice, got %q", answer)
+	}
+	if answer := menu.Press('1'); answer != ivrWeatherPrompt {
+		t.Errorf("Expected weather prompt, got %q", answer)
+	}
+	if answer := menu.Press('9'); answer != "" {
+		t.Errorf("Expected to wait for the second digit, got %q", answer)
+	}
+	if answer := menu.Press('9'); !strings.HasPrefix(answer, "There is no city with code 99") {
+		t.Errorf("Expected unknown city code, got %q", answer)
+	}
+	if answer := menu.Press('*'); answer != ivrMainMenu {
+		t.Errorf("Expected main menu, got %q", answer)
+	}
+
+	if turkishProvinces[34] != "İstanbul" || turkishProvinces[81] != "Düzce" {
+		t.Errorf("Unexpected province table")
+	}
+}
+
+func TestSpeechText(t *testing.T) {
+	text := speechText("İstanbul:\n☀️ +21°C\n↗ 11km/h 67%")
+	if text != "İstanbul: 21 degrees. 11 kilometers per hour 67 percent." {
+		t.Errorf("Unexpected speech text %q", text)
+	}
+
+	words := speechWords("Press 12 for Çorum")
+	if strings.Join(words, " ") != "press 1 2 for çorum" {
+		t.Errorf("Unexpected words %q", words)
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"neo146/models"
+)
+
+// KISS framing bytes
+const (
+	kissFEND  = 0xc0
+	kissFESC  = 0xdb
+	kissTFEND = 0xdc
+	kissTFESC = 0xdd
+	// kissData is the command byte of a data frame on port 0
+	kissData = 0x00
+)
+
+// AX.25 UI frame fields
+const (
+	ax25Control = 0x03
+	ax25PID     = 0xf0
+)
+
+// kissTransport exchanges APRS packets with a KISS TNC, wrapping them in
+// AX.25 UI frames
+type kissTransport struct {
+	conn   io.ReadWriteCloser
+	reader *bufio.Reader
+}
+
+// newKISSTransport creates a transport on a connection to a KISS TNC
+func newKISSTransport(conn io.ReadWriteCloser) *kissTransport {
+	return &kissTransport{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// ReadPacket returns the next APRS packet, skipping frames that are not
+// APRS UI frames
+func (t *kissTransport) ReadPacket() (models.APRSPacket, error) {
+	for {
+		frame, err := t.readFrame()
+		if err != nil {
+			return models.APRSPacket{}, err
+		}
+		if len(frame) < 2 || frame[0]&0x0f != kissData {
+			continue
+		}
+		if packet, err := decodeAX25(frame[1:]); err == nil {
+			return packet, nil
+		}
+	}
+}
+
+// WritePacket sends an APRS packet as a KISS data frame
+func (t *kissTransport) WritePacket(packet models.APRSPacket) error {
+	frame, err := encodeAX25(packet)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn.Write(kissEncode(frame))
+	return err
+}
+
+// Close closes the connection to the TNC
+func (t *kissTransport) Close() error {
+	return t.conn.Close()
+}
+
+// readFrame reads the next non-empty KISS frame and removes its escaping
+func (t *kissTransport) readFrame() ([]byte, error) {
+	var frame []byte
+	escaped := false
+	for {
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case b == kissFEND:
+			if len(frame) > 0 {
+				return frame, nil
+			}
+		case escaped:
+			escaped = false
+			switch b {
+			case kissTFEND:
+				frame = append(frame, kissFEND)
+			case kissTFESC:
+				frame = append(frame, kissFESC)
+			}
+		case b == kissFESC:
+			escaped = true
+		default:
+			frame = append(frame, b)
+		}
+	}
+}
+
+// kissEncode wraps an AX.25 frame in a KISS data frame
+func kissEncode(frame []byte) []byte {
+	encoded := []byte{kissFEND, kissData}
+	for _, b := range frame {
+		switch b {
+		case kissFEND:
+			encoded = append(encoded, kissFESC, kissTFEND)
+		case kissFESC:
+			encoded = append(encoded, kissFESC, kissTFESC)
+		default:
+			encoded = append(encoded, b)
+		}
+	}
+	return append(encoded, kissFEND)
+}
+
+// encodeAX25 builds an AX.25 UI frame for an APRS packet
+func encodeAX25(packet models.APRSPacket) ([]byte, error) {
+	calls := append([]string{packet.Destination, packet.Source}, packet.Path...)
+	if len(calls) > 10 {
+		return nil, errors.New("too many digipeaters")
+	}
+
+	var frame []byte
+	for i, call := range calls {
+		repeated := strings.HasSuffix(call, "*")
+		address, err := encodeAX25Address(strings.TrimSuffix(call, "*"))
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case i == 0:
+			// Command frame: C bit set on the destination
+			address[6] |= 0x80
+		case i > 1 && repeated:
+			address[6] |= 0x80
+		}
+		if i == len(calls)-1 {
+			address[6] |= 0x01
+		}
+		frame = append(frame, address...)
+	}
+
+	frame = append(frame, ax25Control, ax25PID)
+	return append(frame, packet.Info...), nil
+}
+
+// encodeAX25Address encodes a callsign with optional SSID, e.g. TA1ABC-7
+func encodeAX25Address(call string) ([]byte, error) {
+	callsign, ssidText, _ := strings.Cut(strings.ToUpper(call), "-")
+	ssid := 0
+	if ssidText != "" {
+		var err error
+		if ssid, err = strconv.Atoi(ssidText); err != nil || ssid > 15 {
+			return nil, fmt.Errorf("invalid SSID: %s", call)
+		}
+	}
+	if callsign == "" || len(callsign) > 6 {
+		return nil, fmt.Errorf("invalid callsign: %s", call)
+	}
+
+	address := make([]byte, 7)
+	for i := 0; i < 6; i++ {
+		c := byte(' ')
+		if i < len(callsign) {
+			c = callsign[i]
+		}
+		address[i] = c << 1
+	}
+	address[6] = 0x60 | byte(ssid)<<1
+	return address, nil
+}
+
+// decodeAX25 parses an AX.25 UI frame carrying an APRS packet
+func decodeAX25(frame []byte) (models.APRSPacket, error) {
+	var calls []string
+	offset := 0
+	for {
+		if offset+7 > len(frame) || len(calls) == 10 {
+			return models.APRSPacket{}, errors.New("invalid AX.25 address field")
+		}
+		address := frame[offset : offset+7]
+		offset += 7
+
+		var callsign []byte
+		for _, b := range address[:6] {
+			callsign = append(callsign, b>>1)
+		}
+		call := strings.TrimSpace(string(callsign))
+		if ssid := address[6] >> 1 & 0x0f; ssid != 0 {
+			call += "-" + strconv.Itoa(int(ssid))
+		}
+		// The H bit marks digipeaters that repeated the frame
+		if len(calls) >= 2 && address[6]&0x80 != 0 {
+			call += "*"
+		}
+		calls = append(calls, call)
+
+		if address[6]&0x01 != 0 {
+			break
+		}
+	}
+
+	if len(calls) < 2 || offset+2 > len(frame) || frame[offset] != ax25Control || frame[offset+1] != ax25PID {
+		return models.APRSPacket{}, errors.New("not an APRS UI frame")
+	}
+
+	return models.APRSPacket{
+		Destination: calls[0],
+		Source:      calls[1],
+		Path:        calls[2:],
+		Info:        string(frame[offset+2:]),
+	}, nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"neo146/models"
+)
+
+func TestAX25RoundTrip(t *testing.T) {
+	packet := models.APRSPacket{
+		Source:      "TA1ABC-7",
+		Destination: "APZ146",
+		Path:        []string{"WIDE1-1*", "WIDE2-1"},
+		Info:        ":N0CALL-10:news{1",
+	}
+
+	frame, err := encodeAX25(packet)
+	if err != nil {
+		t.Fatalf("Error encoding frame: %v", err)
+	}
+	// Callsigns are shifted left by one bit
+	if frame[0] != 'A'<<1 || frame[13]&0x1e != 7<<1 {
+		t.Errorf("Unexpected address encoding: % x", frame[:14])
+	}
+
+	decoded, err := decodeAX25(frame)
+	if err != nil {
+		t.Fatalf("Error decoding frame: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, packet) {
+		t.Errorf("Expected %+v, got %+v", packet, decoded)
+	}
+
+	if _, err := encodeAX25Address("TOOLONGCALL"); err == nil {
+		t.Errorf("Expected error for a callsign longer than 6 characters")
+	}
+}
+
+func TestKISSTransport(t *testing.T) {
+	client, tnc := net.Pipe()
+	transport := newKISSTransport(client)
+	defer transport.Close()
+
+	packet := models.APRSPacket{Source: "N0CALL-10", Destination: "APZ146", Path: []string{"WIDE2-1"}, Info: ":TA1ABC-7 :\xc0\xdb{1"}
+	go transport.WritePacket(packet)
+
+	buf := make([]byte, 256)
+	n, _ := tnc.Read(buf)
+	written := buf[:n]
+	if written[0] != kissFEND || written[1] != kissData || written[n-1] != kissFEND {
+		t.Fatalf("Expected a KISS data frame, got % x", written)
+	}
+	if bytes.Count(written, []byte{kissFEND}) != 2 || !bytes.Contains(written, []byte{kissFESC, kissTFEND, kissFESC, kissTFESC}) {
+		t.Errorf("Expected special bytes to be escaped: % x", written)
+	}
+
+	// Frames for other ports and empty frames are skipped
+	go func() {
+		tnc.Write([]byte{kissFEND, kissFEND, 0x10, 0x01, kissFEND})
+		tnc.Write(written)
+	}()
+
+	received, err := transport.ReadPacket()
+	if err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+	if !reflect.DeepEqual(received, packet) {
+		t.Errorf("Expected %+v, got %+v", packet, received)
+	}
+}
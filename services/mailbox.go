@@ -0,0 +1,421 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/models"
+)
+
+const (
+	// mailboxMessageLength is the maximum length of a stored message
+	mailboxMessageLength = 300
+	// mailboxPreviewLength is the length of message previews in the inbox
+	mailboxPreviewLength = 40
+	// mailboxInboxSize is the maximum number of messages listed in the inbox
+	mailboxInboxSize = 10
+	// mailboxSendWindow is the window used to rate limit senders
+	mailboxSendWindow = time.Hour
+	// mailboxLinkAttempts is the number of wrong link codes after which a
+	// handle is locked
+	mailboxLinkAttempts = 5
+	// mailboxLinkLockout is how long a handle stays locked
+	mailboxLinkLockout = time.Hour
+	// linkCodeLength is the number of characters of a link code
+	linkCodeLength = 10
+	// linkCodeAlphabet leaves out characters that are easily confused
+	linkCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// handlePattern matches valid mailbox handles
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,20}$`)
+
+// MailboxStore persists mailbox handles, their linked addresses and messages
+type MailboxStore interface {
+	CreateMailboxHandle(handle, linkCodeHash string) (int64, error)
+	GetMailboxHandle(handle string) (*models.MailboxHandle, error)
+	GetMailboxHandleByIdentity(channel, address string) (*models.MailboxHandle, error)
+	LinkMailboxIdentity(handleID int64, channel, address string) error
+	SaveMailboxMessage(message *models.MailboxMessage) error
+	ListMailboxMessages(handleID int64) ([]models.MailboxMessage, error)
+	MarkMailboxMessageRead(id int64) error
+	CountMailboxMessagesSince(senderHandleID int64, since time.Time) (int, error)
+}
+
+// MailboxService handles store-and-forward messages between gateway users.
+// Users register a pseudonymous handle and can link it to their addresses on
+// other channels, so messages can be read wherever they are.
+type MailboxService struct {
+	store     MailboxStore
+	ttl       time.Duration
+	sendLimit int
+	now       func() time.Time
+
+	// Wrong link codes per handle, so codes cannot be guessed
+	mu           sync.Mutex
+	linkFailures map[string]*linkFailures
+}
+
+// linkFailures counts the wrong link codes sent for a handle
+type linkFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// NewMailboxService creates a new mailbox service. Messages expire after ttl
+// and a handle can send at most sendLimit messages per hour.
+func NewMailboxService(store MailboxStore, ttl time.Duration, sendLimit int) *MailboxService {
+	return &MailboxService{
+		store:        store,
+		ttl:          ttl,
+		sendLimit:    sendLimit,
+		now:          time.Now,
+		linkFailures: make(map[string]*linkFailures),
+	}
+}
+
+// IsMailboxCommand checks if command is handled by the mailbox
+func IsMailboxCommand(command string) bool {
+	switch strings.ToLower(command) {
+	case "register", "link", "msg", "inbox", "read":
+		return true
+	}
+	return false
+}
+
+// Execute runs a mailbox command for an address on a channel and returns the
+// reply. Mistakes of the user are answered in the reply, only internal
+// failures are returned as errors.
+func (s *MailboxService) Execute(channel, address, command, args string) (string, error) {
+	args = strings.TrimSpace(args)
+
+	switch strings.ToLower(command) {
+	case "register":
+		return s.Register(channel, address, args)
+	case "link":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return "Usage: link <handle> <code>", nil
+		}
+		return s.Link(channel, address, fields[0], fields[1])
+	case "msg":
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+			return "Usage: msg <handle> <text>", nil
+		}
+		return s.Send(channel, address, fields[0], fields[1])
+	case "inbox":
+		return s.Inbox(channel, address)
+	case "read":
+		n := 0
+		if args != "" {
+			var err error
+			if n, err = strconv.Atoi(args); err != nil || n < 1 {
+				return "Usage: read [n]", nil
+			}
+		}
+		return s.Read(channel, address, n)
+	}
+	return "", fmt.Errorf("unknown mailbox command: %s", command)
+}
+
+// Register creates a new handle for an address and returns the reply with
+// the code needed to link other addresses to it
+func (s *MailboxService) Register(channel, address, handle string) (string, error) {
+	handle = normalizeHandle(handle)
+	if !handlePattern.MatchString(handle) {
+		return "Handles are 3-20 characters of a-z, 0-9 and _. Usage: register <handle>", nil
+	}
+
+	current, err := s.handleOf(channel, address)
+	if err != nil {
+		return "", err
+	}
+	if current != nil {
+		return fmt.Sprintf("You are already registered as %s.", current.Handle), nil
+	}
+
+	if _, err := s.store.GetMailboxHandle(handle); err == nil {
+		return fmt.Sprintf("The handle %s is taken.", handle), nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("error looking up handle: %v", err)
+	}
+
+	code, err := generateLinkCode()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := s.store.CreateMailboxHandle(handle, hashLinkCode(handle, code))
+	if err != nil {
+		return "", fmt.Errorf("error creating handle: %v", err)
+	}
+	if err := s.store.LinkMailboxIdentity(id, channel, address); err != nil {
+		return "", fmt.Errorf("error linking handle: %v", err)
+	}
+
+	return fmt.Sprintf("You are now %s. To read your messages on another channel, send \"link %s %s\" from there. Keep this code private.",
+		handle, handle, code), nil
+}
+
+// Link links an address to an existing handle using its link code
+func (s *MailboxService) Link(channel, address, handle, code string) (string, error) {
+	handle = normalizeHandle(handle)
+
+	mailboxHandle, err := s.store.GetMailboxHandle(handle)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "Unknown handle or wrong code.", nil
+		}
+		return "", fmt.Errorf("error looking up handle: %v", err)
+	}
+	if s.linkLocked(handle) {
+		return "Too many wrong codes for this handle. Try again later.", nil
+	}
+	if !linkCodeMatches(handle, code, mailboxHandle.LinkCodeHash) {
+		s.linkFailed(handle)
+		return "Unknown handle or wrong code.", nil
+	}
+	s.linkSucceeded(handle)
+
+	if err := s.store.LinkMailboxIdentity(mailboxHandle.ID, channel, address); err != nil {
+		return "", fmt.Errorf("error linking handle: %v", err)
+	}
+	return fmt.Sprintf("Linked to %s. Send \"inbox\" to see your messages.", handle), nil
+}
+
+// Send stores a message for the recipient handle
+func (s *MailboxService) Send(channel, address, recipient, text string) (string, error) {
+	sender, err := s.handleOf(channel, address)
+	if err != nil {
+		return "", err
+	}
+	if sender == nil {
+		return "Register a handle first: register <handle>", nil
+	}
+
+	recipient = normalizeHandle(recipient)
+	recipientHandle, err := s.store.GetMailboxHandle(recipient)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Sprintf("Unknown handle: %s", recipient), nil
+		}
+		return "", fmt.Errorf("error looking up handle: %v", err)
+	}
+
+	now := s.now()
+	sent, err := s.store.CountMailboxMessagesSince(sender.ID, now.Add(-mailboxSendWindow))
+	if err != nil {
+		return "", fmt.Errorf("error counting sent messages: %v", err)
+	}
+	if sent >= s.sendLimit {
+		return "You have sent too many messages. Please try again later.", nil
+	}
+
+	message := &models.MailboxMessage{
+		RecipientHandleID: recipientHandle.ID,
+		SenderHandleID:    sender.ID,
+		SenderHandle:      sender.Handle,
+		Body:              truncateRunes(strings.TrimSpace(text), mailboxMessageLength),
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(s.ttl),
+	}
+	if err := s.store.SaveMailboxMessage(message); err != nil {
+		return "", fmt.Errorf("error saving message: %v", err)
+	}
+
+	return fmt.Sprintf("Message for %s stored. It expires in %s if unread.", recipient, formatAge(s.ttl)), nil
+}
+
+// Inbox lists the unexpired messages of the address' handle
+func (s *MailboxService) Inbox(channel, address string) (string, error) {
+	handle, err := s.handleOf(channel, address)
+	if err != nil {
+		return "", err
+	}
+	if handle == nil {
+		return "Register a handle first: register <handle>", nil
+	}
+
+	messages, err := s.store.ListMailboxMessages(handle.ID)
+	if err != nil {
+		return "", fmt.Errorf("error listing messages: %v", err)
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("No messages for %s.", handle.Handle), nil
+	}
+
+	unread := 0
+	for _, message := range messages {
+		if message.ReadAt == nil {
+			unread++
+		}
+	}
+
+	lines := []string{fmt.Sprintf("Inbox of %s (%d new):", handle.Handle, unread)}
+	now := s.now()
+	for i, message := range messages {
+		if i >= mailboxInboxSize {
+			break
+		}
+		marker := ""
+		if message.ReadAt == nil {
+			marker = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s%s %s: %s", i+1, marker, message.SenderHandle,
+			formatAge(now.Sub(message.CreatedAt)), truncateRunes(message.Body, mailboxPreviewLength)))
+	}
+	lines = append(lines, "Reply \"read <n>\" to read a message.")
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Read returns the nth (1-based) message of the inbox in full and marks it
+// as read. If n is 0 the oldest unread message is returned.
+func (s *MailboxService) Read(channel, address string, n int) (string, error) {
+	handle, err := s.handleOf(channel, address)
+	if err != nil {
+		return "", err
+	}
+	if handle == nil {
+		return "Register a handle first: register <handle>", nil
+	}
+
+	messages, err := s.store.ListMailboxMessages(handle.ID)
+	if err != nil {
+		return "", fmt.Errorf("error listing messages: %v", err)
+	}
+
+	index := n - 1
+	if n == 0 {
+		index = -1
+		for i, message := range messages {
+			if message.ReadAt == nil {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return "No new messages.", nil
+		}
+	}
+	if index < 0 || index >= len(messages) {
+		return fmt.Sprintf("Message %d not found. Send \"inbox\" to list your messages.", n), nil
+	}
+
+	message := messages[index]
+	if message.ReadAt == nil {
+		if err := s.store.MarkMailboxMessageRead(message.ID); err != nil {
+			return "", fmt.Errorf("error marking message as read: %v", err)
+		}
+	}
+
+	return fmt.Sprintf("From %s, %s:\n%s\nReply with \"msg %s <text>\".", message.SenderHandle,
+		message.CreatedAt.Format("2006-01-02 15:04"), message.Body, message.SenderHandle), nil
+}
+
+// handleOf returns the handle linked to an address, or nil if there is none
+func (s *MailboxService) handleOf(channel, address string) (*models.MailboxHandle, error) {
+	handle, err := s.store.GetMailboxHandleByIdentity(channel, address)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error looking up handle: %v", err)
+	}
+	return handle, nil
+}
+
+// normalizeHandle lowercases a handle and removes a leading @
+func normalizeHandle(handle string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(handle)), "@")
+}
+
+// linkLocked checks if a handle is locked after too many wrong link codes
+func (s *MailboxService) linkLocked(handle string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures, exists := s.linkFailures[handle]
+	return exists && s.now().Before(failures.lockedUntil)
+}
+
+// linkFailed counts a wrong link code for a handle and locks the handle once
+// there are too many
+func (s *MailboxService) linkFailed(handle string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired lockouts so the map does not grow unbounded
+	now := s.now()
+	for key, failures := range s.linkFailures {
+		if !failures.lockedUntil.IsZero() && now.After(failures.lockedUntil) {
+			delete(s.linkFailures, key)
+		}
+	}
+
+	failures, exists := s.linkFailures[handle]
+	if !exists {
+		failures = &linkFailures{}
+		s.linkFailures[handle] = failures
+	}
+	failures.count++
+	if failures.count >= mailboxLinkAttempts {
+		failures.lockedUntil = now.Add(mailboxLinkLockout)
+	}
+}
+
+// linkSucceeded forgets the wrong link codes of a handle
+func (s *MailboxService) linkSucceeded(handle string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.linkFailures, handle)
+}
+
+// generateLinkCode returns a random code of linkCodeLength characters
+func generateLinkCode() (string, error) {
+	code := make([]byte, linkCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(linkCodeAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("error generating link code: %v", err)
+		}
+		code[i] = linkCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// hashLinkCode hashes a link code, salted with its handle, so it is not
+// stored in plain text
+func hashLinkCode(handle, code string) string {
+	sum := sha256.Sum256([]byte(handle + ":" + strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// linkCodeMatches checks a link code against the stored hash
+func linkCodeMatches(handle, code, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashLinkCode(handle, code)), []byte(hash)) == 1
+}
+
+// formatAge formats a duration compactly, e.g. 5m, 3h or 2d
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/models"
+)
+
+// mockMailboxStore implements MailboxStore in memory for testing
+type mockMailboxStore struct {
+	handles    []models.MailboxHandle
+	identities map[string]int64
+	messages   []models.MailboxMessage
+	now        func() time.Time
+}
+
+func newMockMailboxStore(now func() time.Time) *mockMailboxStore {
+	return &mockMailboxStore{identities: make(map[string]int64), now: now}
+}
+
+func (m *mockMailboxStore) CreateMailboxHandle(handle, linkCodeHash string) (int64, error) {
+	id := int64(len(m.handles) + 1)
+	m.handles = append(m.handles, models.MailboxHandle{ID: id, Handle: handle, LinkCodeHash: linkCodeHash})
+	return id, nil
+}
+
+func (m *mockMailboxStore) GetMailboxHandle(handle string) (*models.MailboxHandle, error) {
+	for _, mailboxHandle := range m.handles {
+		if mailboxHandle.Handle == handle {
+			return &mailboxHandle, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockMailboxStore) GetMailboxHandleByIdentity(channel, address string) (*models.MailboxHandle, error) {
+	id, exists := m.identities[channel+":"+address]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	handle := m.handles[id-1]
+	return &handle, nil
+}
+
+func (m *mockMailboxStore) LinkMailboxIdentity(handleID int64, channel, address string) error {
+	m.identities[channel+":"+address] = handleID
+	return nil
+}
+
+func (m *mockMailboxStore) SaveMailboxMessage(message *models.MailboxMessage) error {
+	message.ID = int64(len(m.messages) + 1)
+	m.messages = append(m.messages, *message)
+	return nil
+}
+
+func (m *mockMailboxStore) ListMailboxMessages(handleID int64) ([]models.MailboxMessage, error) {
+	var messages []models.MailboxMessage
+	for _, message := range m.messages {
+		if message.RecipientHandleID == handleID && message.ExpiresAt.After(m.now()) {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (m *mockMailboxStore) MarkMailboxMessageRead(id int64) error {
+	readAt := m.now()
+	m.messages[id-1].ReadAt = &readAt
+	return nil
+}
+
+func (m *mockMailboxStore) CountMailboxMessagesSince(senderHandleID int64, since time.Time) (int, error) {
+	count := 0
+	for _, message := range m.messages {
+		if message.SenderHandleID == senderHandleID && message.CreatedAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func newTestMailboxService(sendLimit int) (*MailboxService, *time.Time) {
+	now := time.Date(2025, 3, 19, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	service := NewMailboxService(newMockMailboxStore(clock), 72*time.Hour, sendLimit)
+	service.now = clock
+	return service, &now
+}
+
+func TestMailboxService_SendAndReadAcrossChannels(t *testing.T) {
+	service, _ := newTestMailboxService(10)
+
+	reply, err := service.Execute(models.ChannelSMS, "+905551112233", "register", "Alice")
+	if err != nil {
+		t.Fatalf("Expected register to succeed, got error: %v", err)
+	}
+	code := regexp.MustCompile(`link alice ([a-z2-9]{10})`).FindStringSubmatch(reply)
+	if code == nil {
+		t.Fatalf("Expected link code in reply, got: %s", reply)
+	}
+
+	if reply, _ := service.Execute(models.ChannelTelegram, "42", "register", "alice"); !strings.Contains(reply, "taken") {
+		t.Errorf("Expected handle to be taken, got: %s", reply)
+	}
+	if _, err := service.Execute(models.ChannelTelegram, "99", "register", "bob"); err != nil {
+		t.Fatalf("Expected register to succeed, got error: %v", err)
+	}
+
+	reply, _ = service.Execute(models.ChannelTelegram, "99", "msg", "@alice Meet at the square at 5")
+	if !strings.Contains(reply, "stored") {
+		t.Fatalf("Expected message to be stored, got: %s", reply)
+	}
+
+	// Alice reads the message on Telegram after linking her handle
+	if reply, _ := service.Execute(models.ChannelTelegram, "42", "link", "alice 000000"); !strings.Contains(reply, "wrong code") {
+		t.Errorf("Expected wrong code to be rejected, got: %s", reply)
+	}
+	if reply, _ := service.Execute(models.ChannelTelegram, "42", "link", "alice "+code[1]); !strings.Contains(reply, "Linked") {
+		t.Fatalf("Expected link to succeed, got: %s", reply)
+	}
+
+	inbox, _ := service.Execute(models.ChannelTelegram, "42", "inbox", "")
+	if !strings.Contains(inbox, "(1 new)") || !strings.Contains(inbox, "1. *bob 0m: Meet at the square at 5") {
+		t.Errorf("Unexpected inbox:\n%s", inbox)
+	}
+
+	message, _ := service.Execute(models.ChannelTelegram, "42", "read", "")
+	if !strings.Contains(message, "From bob") || !strings.Contains(message, "Meet at the square at 5") {
+		t.Errorf("Unexpected message:\n%s", message)
+	}
+
+	// The message was read on Telegram, so SMS has nothing new
+	if reply, _ := service.Execute(models.ChannelSMS, "+905551112233", "read", ""); reply != "No new messages." {
+		t.Errorf("Expected no new messages, got: %s", reply)
+	}
+	if reply, _ := service.Execute(models.ChannelSMS, "+905551112233", "read", "1"); !strings.Contains(reply, "From bob") {
+		t.Errorf("Expected to reread message 1, got: %s", reply)
+	}
+}
+
+func TestMailboxService_Expiry(t *testing.T) {
+	service, now := newTestMailboxService(10)
+
+	service.Execute(models.ChannelSMS, "1", "register", "alice")
+	service.Execute(models.ChannelSMS, "2", "register", "bob")
+	service.Execute(models.ChannelSMS, "2", "msg", "alice hello")
+
+	*now = now.Add(73 * time.Hour)
+
+	if reply, _ := service.Execute(models.ChannelSMS, "1", "inbox", ""); reply != "No messages for alice." {
+		t.Errorf("Expected expired message to be gone, got: %s", reply)
+	}
+}
+
+func TestMailboxService_SendLimit(t *testing.T) {
+	service, now := newTestMailboxService(2)
+
+	service.Execute(models.ChannelSMS, "1", "register", "alice")
+	service.Execute(models.ChannelSMS, "2", "register", "bob")
+
+	for i := 0; i < 2; i++ {
+		if reply, _ := service.Execute(models.ChannelSMS, "2", "msg", "alice hi"); !strings.Contains(reply, "stored") {
+			t.Fatalf("Expected message %d to be stored, got: %s", i+1, reply)
+		}
+	}
+	if reply, _ := service.Execute(models.ChannelSMS, "2", "msg", "alice hi"); !strings.Contains(reply, "too many") {
+		t.Errorf("Expected sender to be rate limited, got: %s", reply)
+	}
+
+	*now = now.Add(61 * time.Minute)
+
+	if reply, _ := service.Execute(models.ChannelSMS, "2", "msg", "alice hi"); !strings.Contains(reply, "stored") {
+		t.Errorf("Expected limit to reset after an hour, got: %s", reply)
+	}
+}
+
+func TestMailboxService_LinkLockout(t *testing.T) {
+	service, now := newTestMailboxService(10)
+
+	reply, _ := service.Execute(models.ChannelSMS, "1", "register", "alice")
+	code := regexp.MustCompile(`link alice ([a-z2-9]{10})`).FindStringSubmatch(reply)
+	if code == nil {
+		t.Fatalf("Expected link code in reply, got: %s", reply)
+	}
+
+	for i := 0; i < mailboxLinkAttempts; i++ {
+		if reply, _ := service.Execute(models.ChannelTelegram, "42", "link", "alice wrongcode"); !strings.Contains(reply, "wrong code") {
+			t.Fatalf("Expected attempt %d to be rejected, got: %s", i+1, reply)
+		}
+	}
+
+	// Even the right code is refused while the handle is locked
+	if reply, _ := service.Execute(models.ChannelTelegram, "42", "link", "alice "+code[1]); !strings.Contains(reply, "Too many wrong codes") {
+		t.Errorf("Expected handle to be locked, got: %s", reply)
+	}
+
+	*now = now.Add(mailboxLinkLockout + time.Minute)
+
+	if reply, _ := service.Execute(models.ChannelTelegram, "42", "link", "alice "+strings.ToUpper(code[1])); !strings.Contains(reply, "Linked") {
+		t.Errorf("Expected link to succeed after the lockout, got: %s", reply)
+	}
+}
+
+func TestLinkCodeMatches(t *testing.T) {
+	if !linkCodeMatches("alice", "abc234", hashLinkCode("alice", "abc234")) {
+		t.Errorf("Expected code to match its hash")
+	}
+	if linkCodeMatches("bob", "abc234", hashLinkCode("alice", "abc234")) {
+		t.Errorf("Expected hash to be salted with the handle")
+	}
+}
+
+func TestMailboxService_Validation(t *testing.T) {
+	service, _ := newTestMailboxService(10)
+
+	testCases := []struct {
+		command  string
+		args     string
+		expected string
+	}{
+		{"register", "a", "Handles are 3-20 characters"},
+		{"register", "bad handle!", "Handles are 3-20 characters"},
+		{"msg", "alice", "Usage: msg <handle> <text>"},
+		{"msg", "alice hi", "Register a handle first"},
+		{"inbox", "", "Register a handle first"},
+		{"read", "x", "Usage: read [n]"},
+		{"link", "alice", "Usage: link <handle> <code>"},
+	}
+
+	for _, tc := range testCases {
+		reply, err := service.Execute(models.ChannelSMS, "1", tc.command, tc.args)
+		if err != nil {
+			t.Errorf("%s %q: unexpected error: %v", tc.command, tc.args, err)
+			continue
+		}
+		if !strings.Contains(reply, tc.expected) {
+			t.Errorf("%s %q: expected %q, got: %s", tc.command, tc.args, tc.expected, reply)
+		}
+	}
+
+	service.Execute(models.ChannelSMS, "1", "register", "alice")
+	if reply, _ := service.Execute(models.ChannelSMS, "1", "msg", "nobody hi"); reply != "Unknown handle: nobody" {
+		t.Errorf("Expected unknown handle, got: %s", reply)
+	}
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// matrixSyncTimeout is how long a /sync request waits for new events
+	matrixSyncTimeout = 30 * time.Second
+	// matrixMessageLength is the maximum length of a single Matrix message
+	matrixMessageLength = 4000
+	// matrixSyncFilter limits /sync to the events the bot acts on
+	matrixSyncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},"room":{"state":{"lazy_load_members":true},"ephemeral":{"types":[]},"timeline":{"types":["m.room.message"]}}}`
+)
+
+// MatrixService handles the Matrix bot channel using the client-server API
+type MatrixService struct {
+	homeserverURL string
+	accessToken   string
+	httpClient    *http.Client
+	commands      *CommandService
+	limiter       *RateLimiter
+
+	userID string
+	since  string
+	txnID  atomic.Int64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Direct chats of the bot by user ID, as kept in the m.direct account
+	// data, so alerts reach users in private
+	mu          sync.Mutex
+	directRooms map[string][]string
+}
+
+// NewMatrixService creates a new Matrix bot for the account of accessToken
+// on the homeserver. The limiter is keyed by Matrix user ID. The HTTP client
+// must allow requests longer than the sync timeout.
+func NewMatrixService(homeserverURL, accessToken string, httpClient *http.Client, commands *CommandService, limiter *RateLimiter) *MatrixService {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &MatrixService{
+		homeserverURL: strings.TrimRight(homeserverURL, "/"),
+		accessToken:   accessToken,
+		httpClient:    httpClient,
+		commands:      commands,
+		limiter:       limiter,
+		ctx:           ctx,
+		cancel:        cancel,
+		directRooms:   make(map[string][]string),
+	}
+	s.txnID.Store(time.Now().UnixNano())
+	return s
+}
+
+// Start logs in and handles messages until Stop is called. It returns an
+// error if the homeserver cannot be reached, and can be called again to
+// resume where it stopped.
+func (s *MatrixService) Start() error {
+	if s.userID == "" {
+		var whoami struct {
+			UserID string `json:"user_id"`
+		}
+		if err := s.do(http.MethodGet, "/account/whoami", nil, nil, &whoami); err != nil {
+			return fmt.Errorf("error logging in to Matrix: %v", err)
+		}
+		s.userID = whoami.UserID
+		slog.Info("Matrix bot logged in", "account", s.userID)
+
+		// The account data is missing until the first direct chat
+		s.mu.Lock()
+		if err := s.do(http.MethodGet, s.directRoomsPath(), nil, nil, &s.directRooms); err != nil {
+			slog.Warn("Error loading Matrix direct chats", "error", err)
+		}
+		s.mu.Unlock()
+	}
+
+	// Skip the messages sent before the bot started
+	if s.since == "" {
+		response, err := s.sync(0)
+		if err != nil {
+			return err
+		}
+		s.since = response.NextBatch
+		s.joinInvitedRooms(response)
+	}
+
+	for {
+		response, err := s.sync(matrixSyncTimeout)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		s.since = response.NextBatch
+		s.joinInvitedRooms(response)
+		s.handleSync(response)
+	}
+}
+
+// Stop stops handling messages
+func (s *MatrixService) Stop() {
+	s.cancel()
+}
+
+// SendAlert sends a broadcast alert to a user in their direct chat with the
+// bot, which is created if there is none
+func (s *MatrixService) SendAlert(address, message string) error {
+	if !strings.HasPrefix(address, "@") {
+		return fmt.Errorf("%s is not a Matrix user ID", address)
+	}
+	roomID, err := s.directRoom(address)
+	if err != nil {
+		return err
+	}
+	return s.sendMessage(roomID, message)
+}
+
+// handleSync handles the new messages of a sync response
+func (s *MatrixService) handleSync(response *models.MatrixSyncResponse) {
+	for roomID, room := range response.Rooms.Join {
+		for _, event := range room.Timeline.Events {
+			if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Sender == s.userID {
+				continue
+			}
+			s.handleMessage(roomID, event.Sender, event.Content.Body)
+		}
+	}
+}
+
+// handleMessage runs a command and replies in the room it was sent in
+func (s *MatrixService) handleMessage(roomID, sender, body string) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return
+	}
+	utils.InboundMessages.Inc(models.ChannelMatrix, CommandName(body))
+
+	command := strings.ToLower(strings.TrimLeft(body, "/!"))
+	if command == "help" || command == "start" {
+		s.reply(roomID, s.commands.Help())
+		return
+	}
+
+	if !s.limiter.Allow(sender) {
+		utils.RateLimitRejections.Inc(models.ChannelMatrix)
+		s.reply(roomID, "You have reached the rate limit. Please try again later.")
+		return
+	}
+
+	// The sender is the address, as anyone in a room can send to it
+	content, err := s.commands.Execute(models.ChannelMatrix, sender, body)
+	if err != nil {
+		content = fmt.Sprintf("Error: %v", err)
+	}
+
+	// Send content in chunks to avoid message length limits
+	chunks := splitIntoChunks(content, matrixMessageLength)
+	for i, chunk := range chunks {
+		s.reply(roomID, chunk)
+		if i < len(chunks)-1 {
+			time.Sleep(100 * time.Millisecond) // Small delay between messages
+		}
+	}
+}
+
+// reply sends a message to a room and logs failures
+func (s *MatrixService) reply(roomID, text string) {
+	if err := s.sendMessage(roomID, text); err != nil {
+		slog.Error("Error sending Matrix message", "error", err)
+	}
+}
+
+// sendMessage sends a plain text message to a room
+func (s *MatrixService) sendMessage(roomID, text string) error {
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%d", url.PathEscape(roomID), s.txnID.Add(1))
+	return s.do(http.MethodPut, path, nil, models.MatrixMessage{MsgType: "m.text", Body: text}, nil)
+}
+
+// joinInvitedRooms accepts the invites to direct chats of a sync response
+// and rejects the others, as replies in shared rooms are read by everyone
+func (s *MatrixService) joinInvitedRooms(response *models.MatrixSyncResponse) {
+	for roomID, room := range response.Rooms.Invite {
+		inviter := s.directInviter(room)
+		if inviter == "" {
+			if err := s.do(http.MethodPost, "/rooms/"+url.PathEscape(roomID)+"/leave", nil, struct{}{}, nil); err != nil {
+				slog.Error("Error rejecting Matrix invite", "error", err)
+			}
+			continue
+		}
+
+		if err := s.do(http.MethodPost, "/rooms/"+url.PathEscape(roomID)+"/join", nil, struct{}{}, nil); err != nil {
+			slog.Error("Error joining Matrix room", "error", err)
+			continue
+		}
+		s.mu.Lock()
+		s.addDirectRoom(inviter, roomID)
+		s.mu.Unlock()
+	}
+}
+
+// directInviter returns the user who invited the bot to a direct chat, or
+// an empty string if the invite is not to a direct chat
+func (s *MatrixService) directInviter(room models.MatrixInvitedRoom) string {
+	for _, event := range room.InviteState.Events {
+		if event.Type == "m.room.member" && event.StateKey == s.userID &&
+			event.Content.Membership == "invite" && event.Content.IsDirect {
+			return event.Sender
+		}
+	}
+	return ""
+}
+
+// directRoom returns the direct chat of the bot with a user, and creates it
+// if there is none
+func (s *MatrixService) directRoom(userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rooms := s.directRooms[userID]; len(rooms) > 0 {
+		return rooms[len(rooms)-1], nil
+	}
+
+	request := map[string]interface{}{
+		"invite":    []string{userID},
+		"is_direct": true,
+		"preset":    "trusted_private_chat",
+	}
+	var created struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := s.do(http.MethodPost, "/createRoom", nil, request, &created); err != nil {
+		return "", fmt.Errorf("error creating Matrix direct chat: %v", err)
+	}
+	s.addDirectRoom(userID, created.RoomID)
+	return created.RoomID, nil
+}
+
+// addDirectRoom records a direct chat with a user in the account data so it
+// is found again after a restart. The caller must hold s.mu.
+func (s *MatrixService) addDirectRoom(userID, roomID string) {
+	s.directRooms[userID] = append(s.directRooms[userID], roomID)
+	if err := s.do(http.MethodPut, s.directRoomsPath(), nil, s.directRooms, nil); err != nil {
+		slog.Error("Error saving Matrix direct chats", "error", err)
+	}
+}
+
+// directRoomsPath is the path of the m.direct account data of the bot
+func (s *MatrixService) directRoomsPath() string {
+	return "/user/" + url.PathEscape(s.userID) + "/account_data/m.direct"
+}
+
+// sync fetches the events since the last sync, waiting up to timeout for
+// new ones
+func (s *MatrixService) sync(timeout time.Duration) (*models.MatrixSyncResponse, error) {
+	query := url.Values{}
+	query.Set("timeout", fmt.Sprintf("%d", timeout.Milliseconds()))
+	query.Set("filter", matrixSyncFilter)
+	if s.since != "" {
+		query.Set("since", s.since)
+	}
+
+	var response models.MatrixSyncResponse
+	if err := s.do(http.MethodGet, "/sync", query, nil, &response); err != nil {
+		return nil, fmt.Errorf("error syncing with Matrix: %v", err)
+	}
+	return &response, nil
+}
+
+// do sends an authenticated request to the client-server API and decodes
+// the JSON response into result, if set
+func (s *MatrixService) do(method, path string, query url.Values, body, result interface{}) error {
+	endpoint := s.homeserverURL + "/_matrix/client/v3" + path
+	if query != nil {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var matrixError struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&matrixError)
+		return fmt.Errorf("homeserver returned status %d: %s %s", resp.StatusCode, matrixError.ErrCode, matrixError.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
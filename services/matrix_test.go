@@ -0,0 +1,206 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHomeserver is an in-process Matrix homeserver serving scripted sync
+// responses and recording sent messages
+type fakeHomeserver struct {
+	t           *testing.T
+	syncs       []string
+	mu          sync.Mutex
+	joined      []string
+	left        []string
+	directRooms string
+	sent        chan string
+}
+
+func (h *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid token"}`)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+	switch {
+	case path == "/account/whoami":
+		fmt.Fprint(w, `{"user_id":"@neo146:hs.test"}`)
+	case path == "/sync":
+		h.mu.Lock()
+		if len(h.syncs) == 0 {
+			h.mu.Unlock()
+			// Long poll without new events until the client gives up
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			fmt.Fprintf(w, `{"next_batch":"%s"}`, r.URL.Query().Get("since"))
+			return
+		}
+		response := h.syncs[0]
+		h.syncs = h.syncs[1:]
+		h.mu.Unlock()
+		fmt.Fprint(w, response)
+	case strings.HasSuffix(path, "/join"):
+		h.mu.Lock()
+		h.joined = append(h.joined, strings.Split(path, "/")[2])
+		h.mu.Unlock()
+		fmt.Fprint(w, `{}`)
+	case strings.HasSuffix(path, "/leave"):
+		h.mu.Lock()
+		h.left = append(h.left, strings.Split(path, "/")[2])
+		h.mu.Unlock()
+		fmt.Fprint(w, `{}`)
+	case path == "/createRoom":
+		fmt.Fprint(w, `{"room_id":"!new:hs.test"}`)
+	case path == "/user/@neo146:hs.test/account_data/m.direct":
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if r.Method == http.MethodPut {
+			data, _ := io.ReadAll(r.Body)
+			h.directRooms = string(data)
+			fmt.Fprint(w, `{}`)
+		} else if h.directRooms == "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errcode":"M_NOT_FOUND","error":"Account data not found"}`)
+		} else {
+			fmt.Fprint(w, h.directRooms)
+		}
+	case strings.Contains(path, "/send/m.room.message/"):
+		var message struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			h.t.Errorf("Invalid message: %v", err)
+		}
+		h.sent <- strings.Split(path, "/")[2] + " " + message.Body
+		fmt.Fprint(w, `{"event_id":"$sent"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func matrixSync(nextBatch, room, sender, body string) string {
+	return fmt.Sprintf(`{"next_batch":"%s","rooms":{"join":{"%s":{"timeline":{"events":[
+		{"type":"m.room.message","sender":"%s","event_id":"$1","content":{"msgtype":"m.text","body":"%s"}}]}}}}}`,
+		nextBatch, room, sender, body)
+}
+
+func matrixInvite(nextBatch, room, sender string, direct bool) string {
+	return fmt.Sprintf(`{"next_batch":"%s","rooms":{"invite":{"%s":{"invite_state":{"events":[
+		{"type":"m.room.member","sender":"%s","state_key":"@neo146:hs.test","content":{"membership":"invite","is_direct":%t}}]}}}}}`,
+		nextBatch, room, sender, direct)
+}
+
+func TestMatrixService(t *testing.T) {
+	homeserver := &fakeHomeserver{
+		t: t,
+		syncs: []string{
+			// Messages from before the bot started are skipped
+			matrixSync("s1", "!old:hs.test", "@alice:hs.test", "register old"),
+			matrixInvite("s2", "!dm:hs.test", "@alice:hs.test", true),
+			matrixInvite("s2a", "!group:hs.test", "@mallory:hs.test", false),
+			matrixSync("s3", "!dm:hs.test", "@alice:hs.test", "!register alice"),
+			matrixSync("s4", "!dm:hs.test", "@neo146:hs.test", "inbox"),
+			// The mailbox follows the user, not the room
+			matrixSync("s5", "!other:hs.test", "@alice:hs.test", "/inbox"),
+			matrixSync("s6", "!dm:hs.test", "@alice:hs.test", "inbox"),
+		},
+		sent: make(chan string, 10),
+	}
+	server := httptest.NewServer(homeserver)
+	defer server.Close()
+
+	now := time.Date(2025, 3, 19, 10, 0, 0, 0, time.UTC)
+	smsService := &SMSService{
+		MailboxService: NewMailboxService(newMockMailboxStore(func() time.Time { return now }), time.Hour, 10),
+	}
+	service := NewMatrixService(server.URL+"/", "secret", server.Client(), NewCommandService(smsService), NewRateLimiter(2, time.Hour))
+
+	done := make(chan error)
+	go func() { done <- service.Start() }()
+
+	expected := []string{
+		"!dm:hs.test You are now alice.",
+		"!other:hs.test No messages for alice.",
+		"!dm:hs.test You have reached the rate limit.",
+		"!dm:hs.test Alert for alice",
+		"!new:hs.test Alert for bob",
+	}
+	for i, prefix := range expected {
+		// Alerts go to the direct chat with the user, which is created if
+		// there is none
+		switch i {
+		case 3:
+			if err := service.SendAlert("@alice:hs.test", "Alert for alice"); err != nil {
+				t.Fatalf("Expected alert to be sent, got error: %v", err)
+			}
+		case 4:
+			if err := service.SendAlert("@bob:hs.test", "Alert for bob"); err != nil {
+				t.Fatalf("Expected alert to be sent, got error: %v", err)
+			}
+		}
+
+		select {
+		case message := <-homeserver.sent:
+			if !strings.HasPrefix(message, prefix) {
+				t.Errorf("Expected message starting with %q, got %q", prefix, message)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", prefix)
+		}
+	}
+
+	// Alerts are only sent to users
+	if err := service.SendAlert("!old:hs.test", "Alert for a room"); err == nil {
+		t.Error("Expected an error for a room ID")
+	}
+	select {
+	case message := <-homeserver.sent:
+		t.Errorf("Expected no message for a room ID, got %q", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	service.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean stop, got error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the bot to stop")
+	}
+
+	homeserver.mu.Lock()
+	defer homeserver.mu.Unlock()
+	if len(homeserver.joined) != 1 || homeserver.joined[0] != "!dm:hs.test" {
+		t.Errorf("Expected invite to be accepted, joined: %v", homeserver.joined)
+	}
+	if len(homeserver.left) != 1 || homeserver.left[0] != "!group:hs.test" {
+		t.Errorf("Expected invite to a shared room to be rejected, left: %v", homeserver.left)
+	}
+	if homeserver.directRooms != `{"@alice:hs.test":["!dm:hs.test"],"@bob:hs.test":["!new:hs.test"]}` {
+		t.Errorf("Unexpected direct chats: %s", homeserver.directRooms)
+	}
+}
+
+func TestMatrixService_InvalidToken(t *testing.T) {
+	server := httptest.NewServer(&fakeHomeserver{t: t, sent: make(chan string)})
+	defer server.Close()
+
+	service := NewMatrixService(server.URL, "wrong", server.Client(), NewCommandService(&SMSService{}), NewRateLimiter(5, time.Hour))
+	err := service.Start()
+	if err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Errorf("Expected login to fail, got: %v", err)
+	}
+}
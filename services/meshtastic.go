@@ -0,0 +1,537 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// meshtasticChunkSize is the maximum payload of a reply packet, below
+	// the LoRa payload limit
+	meshtasticChunkSize = 200
+	// meshtasticMaxChunks caps the packets sent for a reply, to keep the
+	// mesh usable for others
+	meshtasticMaxChunks = 10
+	// meshtasticHopLimit is the hop limit of reply packets
+	meshtasticHopLimit = 3
+	// meshtasticHeartbeat is the interval of heartbeats keeping the API
+	// connection open
+	meshtasticHeartbeat = 5 * time.Minute
+	// meshtasticDefaultPort is the port of the TCP API
+	meshtasticDefaultPort = "4403"
+	// meshtasticMaxFrame is the maximum length of a stream API frame
+	meshtasticMaxFrame = 512
+)
+
+// Meshtastic stream API frame start bytes
+const (
+	meshtasticStart1 = 0x94
+	meshtasticStart2 = 0xc3
+)
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// MeshtasticConfig holds the settings of the Meshtastic channel
+type MeshtasticConfig struct {
+	// Addr is the node to connect to, host[:port] for the TCP API or the
+	// path of a serial device
+	Addr string
+}
+
+// MeshtasticService handles the Meshtastic channel. It connects to a node
+// over its stream API and answers direct text messages.
+type MeshtasticService struct {
+	config   MeshtasticConfig
+	commands *CommandService
+	limiter  *RateLimiter
+
+	// chunkDelay spaces reply packets, so they do not fill the node's
+	// transmit queue
+	chunkDelay time.Duration
+
+	mu      sync.Mutex
+	conn    io.ReadWriteCloser
+	nodeNum uint32
+	writeMu sync.Mutex
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+// NewMeshtasticService creates a new Meshtastic channel. The limiter is
+// keyed by node ID.
+func NewMeshtasticService(config MeshtasticConfig, commands *CommandService, limiter *RateLimiter) *MeshtasticService {
+	return &MeshtasticService{
+		config:     config,
+		commands:   commands,
+		limiter:    limiter,
+		chunkDelay: 2 * time.Second,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start connects to the node and handles packets until the connection is
+// lost or Stop is called. It returns nil only after Stop, so it can be
+// called in a loop to reconnect.
+func (s *MeshtasticService) Start() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+
+	conn, serial, err := s.dial()
+	if err != nil {
+		return err
+	}
+	if !s.setConn(conn) {
+		conn.Close()
+		return nil
+	}
+	defer s.setConn(nil)
+	defer conn.Close()
+
+	// A serial node is woken up by a run of start bytes
+	if serial {
+		if _, err := conn.Write([]byte(strings.Repeat("\xc3", 32))); err != nil {
+			return err
+		}
+	}
+	// Asking for the configuration makes the node send its node number and
+	// start forwarding packets
+	if err := s.write(protoAppendVarint(nil, 3, uint64(rand.Uint32()))); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeat(done)
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readMeshtasticFrame(reader)
+		if err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+				return fmt.Errorf("Meshtastic connection lost: %v", err)
+			}
+		}
+		if err := s.handleFrame(frame); err != nil {
+			slog.Error("Error handling Meshtastic frame", "error", err)
+		}
+	}
+}
+
+// Stop disconnects and stops handling messages
+func (s *MeshtasticService) Stop() {
+	s.stopped.Do(func() {
+		close(s.stop)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	})
+}
+
+// SendAlert sends a broadcast alert to a node, addressed as !<node id>
+func (s *MeshtasticService) SendAlert(address, message string) error {
+	to, err := parseMeshtasticNodeID(address)
+	if err != nil {
+		return err
+	}
+	return s.reply(to, 0, message)
+}
+
+// dial connects to the node, reporting whether it is a serial device
+func (s *MeshtasticService) dial() (io.ReadWriteCloser, bool, error) {
+	address := s.config.Addr
+	if strings.HasPrefix(address, "/") {
+		// Serial devices must be set up beforehand, e.g. with stty
+		conn, err := os.OpenFile(address, os.O_RDWR, 0)
+		if err != nil {
+			return nil, false, fmt.Errorf("error opening Meshtastic serial device: %v", err)
+		}
+		slog.Info("Meshtastic channel connected", "device", address)
+		return conn, true, nil
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, meshtasticDefaultPort)
+	}
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, false, fmt.Errorf("error connecting to Meshtastic node: %v", err)
+	}
+	slog.Info("Meshtastic channel connected", "device", address)
+	return conn, false, nil
+}
+
+// handleFrame handles a FromRadio message
+func (s *MeshtasticService) handleFrame(frame []byte) error {
+	return protoParse(frame, func(field int, wireType int, value uint64, data []byte) error {
+		switch {
+		case field == 3 && wireType == protoBytes:
+			// MyNodeInfo: the node number of the connected node
+			return protoParse(data, func(field int, wireType int, value uint64, data []byte) error {
+				if field == 1 && wireType == protoVarint {
+					s.mu.Lock()
+					s.nodeNum = uint32(value)
+					s.mu.Unlock()
+				}
+				return nil
+			})
+		case field == 2 && wireType == protoBytes:
+			packet, err := decodeMeshPacket(data)
+			if err != nil {
+				return err
+			}
+			s.handlePacket(packet)
+		}
+		return nil
+	})
+}
+
+// handlePacket runs the commands of direct text messages to the node
+func (s *MeshtasticService) handlePacket(packet models.MeshtasticPacket) {
+	s.mu.Lock()
+	nodeNum := s.nodeNum
+	s.mu.Unlock()
+
+	// Channel messages to everyone are left to the humans
+	if packet.PortNum != models.MeshtasticPortText || nodeNum == 0 || packet.To != nodeNum || packet.From == nodeNum {
+		return
+	}
+
+	text := strings.TrimSpace(string(packet.Payload))
+	if text == "" {
+		return
+	}
+	go s.handleMessage(packet.From, packet.Channel, text)
+}
+
+// handleMessage runs a command and replies to the sender
+func (s *MeshtasticService) handleMessage(from, channel uint32, text string) {
+	sender := formatMeshtasticNodeID(from)
+	utils.InboundMessages.Inc(models.ChannelMeshtastic, CommandName(text))
+
+	command := strings.ToLower(strings.TrimLeft(text, "/!"))
+	if command == "help" {
+		s.reply(from, channel, s.commands.Help())
+		return
+	}
+
+	if !s.limiter.Allow(sender) {
+		utils.RateLimitRejections.Inc(models.ChannelMeshtastic)
+		s.reply(from, channel, "You have reached the rate limit. Please try again later.")
+		return
+	}
+
+	content, err := s.commands.Execute(models.ChannelMeshtastic, sender, text)
+	if err != nil {
+		content = fmt.Sprintf("Error: %v", err)
+	}
+	if err := s.reply(from, channel, content); err != nil {
+		slog.Error("Error replying to Meshtastic node", "node", sender, "error", err)
+	}
+}
+
+// reply sends text to a node in packets that fit the LoRa payload limit
+func (s *MeshtasticService) reply(to, channel uint32, text string) error {
+	chunks := meshtasticChunks(text)
+	for i, chunk := range chunks {
+		if i > 0 {
+			select {
+			case <-time.After(s.chunkDelay):
+			case <-s.stop:
+				return errors.New("Meshtastic channel stopped")
+			}
+		}
+
+		packet := models.MeshtasticPacket{
+			ID:      rand.Uint32(),
+			To:      to,
+			Channel: channel,
+			PortNum: models.MeshtasticPortText,
+			Payload: []byte(chunk),
+			WantAck: true,
+		}
+		// ToRadio with the packet
+		if err := s.write(protoAppendBytes(nil, 1, encodeMeshPacket(packet))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heartbeat keeps the API connection open until done is closed
+func (s *MeshtasticService) heartbeat(done chan struct{}) {
+	ticker := time.NewTicker(meshtasticHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// ToRadio with an empty Heartbeat
+			s.write(protoAppendBytes(nil, 7, nil))
+		}
+	}
+}
+
+// write sends a ToRadio message in a stream API frame
+func (s *MeshtasticService) write(message []byte) error {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return errors.New("not connected to Meshtastic node")
+	}
+
+	frame := []byte{meshtasticStart1, meshtasticStart2}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(message)))
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := conn.Write(append(frame, message...))
+	return err
+}
+
+// setConn replaces the current connection. It reports false after Stop.
+func (s *MeshtasticService) setConn(conn io.ReadWriteCloser) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.stop:
+		return false
+	default:
+	}
+	s.conn = conn
+	if conn == nil {
+		s.nodeNum = 0
+	}
+	return true
+}
+
+// readMeshtasticFrame reads the next stream API frame. Bytes outside frames
+// are debug output of the node and are skipped.
+func readMeshtasticFrame(reader *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != meshtasticStart1 {
+			continue
+		}
+		if b, err = reader.ReadByte(); err != nil {
+			return nil, err
+		}
+		if b != meshtasticStart2 {
+			reader.UnreadByte()
+			continue
+		}
+
+		var header [2]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[:]))
+		// A corrupted length means the start bytes were part of debug output
+		if length > meshtasticMaxFrame {
+			continue
+		}
+
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+}
+
+// decodeMeshPacket decodes a MeshPacket message
+func decodeMeshPacket(data []byte) (models.MeshtasticPacket, error) {
+	var packet models.MeshtasticPacket
+	err := protoParse(data, func(field int, wireType int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			packet.From = uint32(value)
+		case 2:
+			packet.To = uint32(value)
+		case 3:
+			packet.Channel = uint32(value)
+		case 4:
+			// Data, the decoded payload. Packets the node could not decrypt
+			// carry an encrypted payload instead.
+			return protoParse(data, func(field int, wireType int, value uint64, data []byte) error {
+				switch field {
+				case 1:
+					packet.PortNum = uint32(value)
+				case 2:
+					packet.Payload = append([]byte(nil), data...)
+				}
+				return nil
+			})
+		case 6:
+			packet.ID = uint32(value)
+		case 10:
+			packet.WantAck = value != 0
+		}
+		return nil
+	})
+	return packet, err
+}
+
+// encodeMeshPacket encodes a MeshPacket message
+func encodeMeshPacket(packet models.MeshtasticPacket) []byte {
+	var decoded []byte
+	decoded = protoAppendVarint(decoded, 1, uint64(packet.PortNum))
+	decoded = protoAppendBytes(decoded, 2, packet.Payload)
+
+	var data []byte
+	if packet.From != 0 {
+		data = protoAppendFixed32(data, 1, packet.From)
+	}
+	data = protoAppendFixed32(data, 2, packet.To)
+	if packet.Channel != 0 {
+		data = protoAppendVarint(data, 3, uint64(packet.Channel))
+	}
+	data = protoAppendBytes(data, 4, decoded)
+	data = protoAppendFixed32(data, 6, packet.ID)
+	data = protoAppendVarint(data, 9, meshtasticHopLimit)
+	if packet.WantAck {
+		data = protoAppendVarint(data, 10, 1)
+	}
+	return data
+}
+
+// meshtasticChunks splits text into chunks of up to meshtasticChunkSize
+// bytes, preferring to break at line ends and spaces
+func meshtasticChunks(text string) []string {
+	var chunks []string
+	text = strings.TrimSpace(text)
+	for text != "" && len(chunks) < meshtasticMaxChunks {
+		if len(text) <= meshtasticChunkSize {
+			chunks = append(chunks, text)
+			break
+		}
+
+		cut := meshtasticChunkSize
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if i := strings.LastIndexAny(text[:cut], "\n "); i > meshtasticChunkSize/2 {
+			cut = i
+		}
+		chunks = append(chunks, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	return chunks
+}
+
+// formatMeshtasticNodeID formats a node number as a node ID, e.g. !0a0b0c0d
+func formatMeshtasticNodeID(nodeNum uint32) string {
+	return fmt.Sprintf("!%08x", nodeNum)
+}
+
+// parseMeshtasticNodeID parses a node ID
+func parseMeshtasticNodeID(id string) (uint32, error) {
+	nodeNum, err := strconv.ParseUint(strings.TrimPrefix(id, "!"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Meshtastic node ID: %s", id)
+	}
+	return uint32(nodeNum), nil
+}
+
+// protoParse calls fn for each field of a protobuf message. Varint and
+// fixed-size values are passed as value, length-delimited ones as data.
+func protoParse(message []byte, fn func(field int, wireType int, value uint64, data []byte) error) error {
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		message = message[n:]
+
+		field, wireType := int(key>>3), int(key&7)
+		var value uint64
+		var data []byte
+		switch wireType {
+		case protoVarint:
+			if value, n = binary.Uvarint(message); n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			message = message[n:]
+		case protoFixed64:
+			if len(message) < 8 {
+				return errors.New("truncated protobuf fixed64")
+			}
+			value = binary.LittleEndian.Uint64(message)
+			message = message[8:]
+		case protoFixed32:
+			if len(message) < 4 {
+				return errors.New("truncated protobuf fixed32")
+			}
+			value = uint64(binary.LittleEndian.Uint32(message))
+			message = message[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return errors.New("truncated protobuf bytes")
+			}
+			data = message[n : n+int(length)]
+			message = message[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+
+		if err := fn(field, wireType, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoAppendVarint appends a varint field to a protobuf message
+func protoAppendVarint(message []byte, field int, value uint64) []byte {
+	message = binary.AppendUvarint(message, uint64(field)<<3|protoVarint)
+	return binary.AppendUvarint(message, value)
+}
+
+// protoAppendFixed32 appends a fixed32 field to a protobuf message
+func protoAppendFixed32(message []byte, field int, value uint32) []byte {
+	message = binary.AppendUvarint(message, uint64(field)<<3|protoFixed32)
+	return binary.LittleEndian.AppendUint32(message, value)
+}
+
+// protoAppendBytes appends a length-delimited field to a protobuf message
+func protoAppendBytes(message []byte, field int, data []byte) []byte {
+	message = binary.AppendUvarint(message, uint64(field)<<3|protoBytes)
+	message = binary.AppendUvarint(message, uint64(len(data)))
+	return append(message, data...)
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/models"
+)
+
+// meshtasticCapture is the stream a node sends after a client connects and
+// asks for its configuration
+var meshtasticCapture = mustDecodeHex(
+	// debug output
+	"494e464f20207c2031323a30303a3030205b526f757465725d20426f6f7465640d0a" +
+		// my_info, node !0a0b0c0d
+		"94c3000b08011a07088d98ac50401e" +
+		// config_complete_id
+		"94c300040802382a" +
+		// broadcast text on channel 0
+		"94c3002a080312260d4433221115ffffffff220e0801120a68656c6c6f206d65736835640000003d0078e7684803" +
+		// position to the gateway
+		"94c30025080412210d44332211150d0c0b0a2209080312050d0000000035650000003d0078e7684803" +
+		// direct text to the gateway on channel 1
+		"94c30034080512300d44332211150d0c0b0a18012214080112107765617468657220697374616e62756c35660000003d0078e76848035001",
+)
+
+func mustDecodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// readToRadioPacket reads a ToRadio frame and decodes its mesh packet
+func readToRadioPacket(t *testing.T, reader *bufio.Reader) models.MeshtasticPacket {
+	frame, err := readMeshtasticFrame(reader)
+	if err != nil {
+		t.Fatalf("Error reading frame: %v", err)
+	}
+
+	var packet models.MeshtasticPacket
+	found := false
+	err = protoParse(frame, func(field int, wireType int, value uint64, data []byte) error {
+		if field != 1 {
+			return nil
+		}
+		found = true
+		packet, err = decodeMeshPacket(data)
+		return err
+	})
+	if err != nil || !found {
+		t.Fatalf("Expected a ToRadio packet, got % x (error: %v)", frame, err)
+	}
+	return packet
+}
+
+func TestMeshtasticService_Replay(t *testing.T) {
+	forecast := "İstanbul:\n" + strings.Repeat("☀️ +21°C clear skies over the Bosphorus, light breeze from the north.\n", 6)
+	weatherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, forecast)
+	}))
+	defer weatherServer.Close()
+
+	weatherService := NewWeatherService(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", weatherServer.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake node: %v", err)
+	}
+	defer listener.Close()
+
+	service := NewMeshtasticService(MeshtasticConfig{Addr: listener.Addr().String()},
+		NewCommandService(&SMSService{WeatherService: weatherService}), NewRateLimiter(1, time.Minute))
+	service.chunkDelay = 0
+
+	done := make(chan error)
+	go func() { done <- service.Start() }()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting connection: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// The client asks for the configuration first
+	frame, err := readMeshtasticFrame(reader)
+	if err != nil || len(frame) == 0 || frame[0] != 3<<3 {
+		t.Fatalf("Expected want_config_id, got % x (error: %v)", frame, err)
+	}
+
+	conn.Write(meshtasticCapture)
+
+	var chunks []string
+	for len(strings.Join(chunks, "\n")) < len(strings.TrimSpace(forecast)) {
+		packet := readToRadioPacket(t, reader)
+		if packet.To != 0x11223344 || packet.Channel != 1 || packet.PortNum != models.MeshtasticPortText || !packet.WantAck {
+			t.Fatalf("Unexpected reply packet: %+v", packet)
+		}
+		if len(packet.Payload) > meshtasticChunkSize {
+			t.Errorf("Chunk of %d bytes exceeds the payload limit", len(packet.Payload))
+		}
+		chunks = append(chunks, string(packet.Payload))
+	}
+
+	if len(chunks) < 3 {
+		t.Errorf("Expected the forecast in several chunks, got %d", len(chunks))
+	}
+	if reply := strings.Join(chunks, " "); strings.Join(strings.Fields(reply), " ") != strings.Join(strings.Fields(forecast), " ") {
+		t.Errorf("Expected chunks to carry the forecast, got %q", chunks)
+	}
+
+	// The node is over its limit now, and the broadcast was not answered
+	conn.Write(meshtasticCapture[len(meshtasticCapture)-56:])
+	if packet := readToRadioPacket(t, reader); !strings.Contains(string(packet.Payload), "rate limit") {
+		t.Errorf("Expected rate limit reply, got %q", packet.Payload)
+	}
+
+	service.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Expected Start to return nil after Stop, got %v", err)
+	}
+}
+
+func TestMeshtasticChunks(t *testing.T) {
+	text := strings.Repeat("ğ", 150)
+	chunks := meshtasticChunks(text)
+	if len(chunks) != 2 || len(chunks[0]) != 200 || chunks[0]+chunks[1] != text {
+		t.Errorf("Expected multibyte text to be split on character boundaries, got %d chunks", len(chunks))
+	}
+
+	if chunks := meshtasticChunks(strings.Repeat("word ", 1000)); len(chunks) != meshtasticMaxChunks {
+		t.Errorf("Expected %d chunks at most, got %d", meshtasticMaxChunks, len(chunks))
+	}
+
+	if id := formatMeshtasticNodeID(0x0a0b0c0d); id != "!0a0b0c0d" {
+		t.Errorf("Unexpected node ID %s", id)
+	}
+	if nodeNum, err := parseMeshtasticNodeID("!0a0b0c0d"); err != nil || nodeNum != 0x0a0b0c0d {
+		t.Errorf("Unexpected node number %x, error: %v", nodeNum, err)
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"neo146/utils"
+)
+
+const (
+	// modemChannel namespaces the search sessions of modem clients
+	modemChannel = "modem"
+	// modemFrameSize is the maximum payload of a reply frame in bytes
+	modemFrameSize = 256
+	// modemMaxRequests is the maximum number of requests in one recording
+	modemMaxRequests = 3
+)
+
+// ModemConfig holds the settings of the AFSK modem gateway
+type ModemConfig struct {
+	// MaxResponse is the maximum size of a reply in bytes, at 1200 baud
+	// about 150 bytes take a second of audio
+	MaxResponse int
+}
+
+// ModemService answers requests sent as Bell 202 AFSK audio. Each HDLC
+// frame of a recording carries a command, and the replies are sent back as
+// frames in a recording of the same sample rate.
+type ModemService struct {
+	config   ModemConfig
+	commands *CommandService
+}
+
+// NewModemService creates a new modem service
+func NewModemService(config ModemConfig, commands *CommandService) *ModemService {
+	return &ModemService{
+		config:   config,
+		commands: commands,
+	}
+}
+
+// HandleWAV decodes the requests of a WAV recording sent from address and
+// returns the replies as a WAV recording
+func (s *ModemService) HandleWAV(data []byte, address string) ([]byte, error) {
+	samples, sampleRate, err := utils.ReadWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading WAV: %v", err)
+	}
+	if sampleRate < utils.AFSKMinSampleRate {
+		return nil, fmt.Errorf("sample rate of %d Hz is too low, use at least %d Hz", sampleRate, utils.AFSKMinSampleRate)
+	}
+
+	requests := modemRequests(utils.DecodeAFSK(samples, sampleRate))
+	if len(requests) == 0 {
+		return nil, errors.New("no valid frames in the recording")
+	}
+
+	var replies []string
+	for _, request := range requests {
+		replies = append(replies, s.execute(request, address))
+	}
+
+	frames := modemFrames(s.truncate(strings.Join(replies, "\n\n")))
+	return utils.WriteWAV(utils.EncodeAFSK(frames, sampleRate), sampleRate), nil
+}
+
+// execute runs a command and returns the reply, or the error message
+func (s *ModemService) execute(request, address string) string {
+	utils.InboundMessages.Inc(modemChannel, CommandName(request))
+
+	// Anyone can send from an address, so commands that keep state for the
+	// sender are not offered
+	if IsStatefulCommand(request) {
+		return "Alerts and messages are not available over the modem."
+	}
+
+	content, err := s.commands.Execute(modemChannel, address, request)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	return content
+}
+
+// truncate shortens a reply to the configured size
+func (s *ModemService) truncate(reply string) string {
+	const marker = "\n[truncated]"
+	if s.config.MaxResponse <= 0 || len(reply) <= s.config.MaxResponse {
+		return reply
+	}
+
+	cut := s.config.MaxResponse - len(marker)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(reply[cut]) {
+		cut--
+	}
+	return reply[:cut] + marker
+}
+
+// modemRequests returns the commands carried by frames. Senders may repeat
+// a frame for reliability, so repeated frames are only run once.
+func modemRequests(frames [][]byte) []string {
+	var requests []string
+	seen := make(map[string]bool)
+	for _, frame := range frames {
+		request := strings.TrimSpace(string(frame))
+		if request == "" || !utf8.ValidString(request) || seen[request] {
+			continue
+		}
+		seen[request] = true
+		requests = append(requests, request)
+		if len(requests) == modemMaxRequests {
+			break
+		}
+	}
+	return requests
+}
+
+// modemFrames splits a reply into frames without splitting characters.
+// Receivers join the frame payloads in order.
+func modemFrames(reply string) [][]byte {
+	var frames [][]byte
+	for reply != "" {
+		cut := len(reply)
+		if cut > modemFrameSize {
+			cut = modemFrameSize
+			for cut > 0 && !utf8.RuneStart(reply[cut]) {
+				cut--
+			}
+		}
+		frames = append(frames, []byte(reply[:cut]))
+		reply = reply[cut:]
+	}
+	return frames
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"neo146/utils"
+)
+
+func TestModemService_HandleWAV(t *testing.T) {
+	forecast := "İstanbul:\n" + strings.Repeat("☀️ +21°C clear skies over the Bosphorus.\n", 12)
+	weatherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, forecast)
+	}))
+	defer weatherServer.Close()
+
+	weatherService := NewWeatherService(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", weatherServer.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+	service := NewModemService(ModemConfig{MaxResponse: 2000}, NewCommandService(&SMSService{WeatherService: weatherService}))
+
+	// The request is repeated, as senders do on noisy links
+	request := []byte("weather istanbul")
+	wav := utils.WriteWAV(utils.EncodeAFSK([][]byte{request, request, []byte("inbox")}, 11025), 11025)
+
+	reply, err := service.HandleWAV(wav, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Error handling request: %v", err)
+	}
+
+	samples, sampleRate, err := utils.ReadWAV(reply)
+	if err != nil || sampleRate != 11025 {
+		t.Fatalf("Expected a reply at the request sample rate, got %d Hz (error: %v)", sampleRate, err)
+	}
+	frames := utils.DecodeAFSK(samples, sampleRate)
+	if len(frames) < 2 {
+		t.Fatalf("Expected the reply split into frames, got %d", len(frames))
+	}
+	for _, frame := range frames {
+		if len(frame) > modemFrameSize {
+			t.Errorf("Frame of %d bytes exceeds the frame size", len(frame))
+		}
+	}
+
+	text := string(bytes.Join(frames, nil))
+	if !strings.HasPrefix(text, strings.TrimSpace(forecast)) || strings.Count(text, "İstanbul") != 1 {
+		t.Errorf("Expected the forecast once, got %q", text)
+	}
+	if !strings.HasSuffix(text, "not available over the modem.") {
+		t.Errorf("Expected mailbox commands to be refused, got %q", text)
+	}
+
+	if _, err := service.HandleWAV(utils.WriteWAV(make([]int16, 8000), 8000), "192.0.2.1"); err == nil {
+		t.Errorf("Expected error for a recording without frames")
+	}
+}
+
+func TestModemFrames(t *testing.T) {
+	text := strings.Repeat("ş", 200)
+	frames := modemFrames(text)
+	if len(frames) != 2 || len(frames[0]) != modemFrameSize || string(frames[0])+string(frames[1]) != text {
+		t.Errorf("Expected text split on character boundaries, got %d frames", len(frames))
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"neo146/models"
+)
+
+const (
+	// portalIndexEntries is the number of headlines per section in the index
+	portalIndexEntries = 3
+	// portalSectionEntries is the number of entries listed for a section
+	portalSectionEntries = 5
+	// portalSnippetLength is the length of entry bodies in section listings
+	portalSnippetLength = 160
+)
+
+// PortalStore persists portal entries
+type PortalStore interface {
+	SavePortalEntry(entry *models.PortalEntry) error
+	UpdatePortalEntry(entry *models.PortalEntry) error
+	DeletePortalEntry(id int64) error
+	GetPortalEntry(id int64) (*models.PortalEntry, error)
+	ListPortalEntries(section string, limit int) ([]models.PortalEntry, error)
+}
+
+// PortalSection groups the entries of a portal section
+type PortalSection struct {
+	Name    string
+	Entries []models.PortalEntry
+}
+
+// PortalService handles the operator-managed content portal
+type PortalService struct {
+	store PortalStore
+}
+
+// NewPortalService creates a new portal service
+func NewPortalService(store PortalStore) *PortalService {
+	return &PortalService{
+		store: store,
+	}
+}
+
+// AddEntry adds a new entry to a portal section
+func (s *PortalService) AddEntry(section, title, body string) (*models.PortalEntry, error) {
+	entry := &models.PortalEntry{
+		Section: strings.ToLower(strings.TrimSpace(section)),
+		Title:   strings.TrimSpace(title),
+		Body:    strings.TrimSpace(body),
+	}
+	if err := validatePortalEntry(entry); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.SavePortalEntry(entry); err != nil {
+		return nil, fmt.Errorf("error saving portal entry: %v", err)
+	}
+	return entry, nil
+}
+
+// UpdateEntry replaces the section, title and body of an existing entry
+func (s *PortalService) UpdateEntry(id int64, section, title, body string) (*models.PortalEntry, error) {
+	entry, err := s.store.GetPortalEntry(id)
+	if err != nil {
+		return nil, fmt.Errorf("portal entry %d not found", id)
+	}
+
+	entry.Section = strings.ToLower(strings.TrimSpace(section))
+	entry.Title = strings.TrimSpace(title)
+	entry.Body = strings.TrimSpace(body)
+	if err := validatePortalEntry(entry); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.UpdatePortalEntry(entry); err != nil {
+		return nil, fmt.Errorf("error updating portal entry: %v", err)
+	}
+	return entry, nil
+}
+
+// DeleteEntry removes an entry from the portal
+func (s *PortalService) DeleteEntry(id int64) error {
+	if err := s.store.DeletePortalEntry(id); err != nil {
+		return fmt.Errorf("error deleting portal entry %d: %v", id, err)
+	}
+	return nil
+}
+
+// ListEntries returns all entries of a section, or of all sections if
+// section is empty
+func (s *PortalService) ListEntries(section string) ([]models.PortalEntry, error) {
+	return s.store.ListPortalEntries(strings.ToLower(section), -1)
+}
+
+// Sections returns the non-empty portal sections in display order with up to
+// limit entries each. If section is set, only that section is returned.
+func (s *PortalService) Sections(section string, limit int) ([]PortalSection, error) {
+	names := models.PortalSections
+	if section != "" {
+		section = strings.ToLower(section)
+		if !isPortalSection(section) {
+			return nil, fmt.Errorf("unknown portal section: %s", section)
+		}
+		names = []string{section}
+	}
+
+	var sections []PortalSection
+	for _, name := range names {
+		entries, err := s.store.ListPortalEntries(name, limit)
+		if err != nil {
+			return nil, fmt.Errorf("error listing portal entries: %v", err)
+		}
+		if len(entries) > 0 {
+			sections = append(sections, PortalSection{Name: name, Entries: entries})
+		}
+	}
+	return sections, nil
+}
+
+// FormatIndex formats the latest headlines of all sections for SMS, followed
+// by a hint on how to read an entry
+func (s *PortalService) FormatIndex(readHint string) (string, error) {
+	sections, err := s.Sections("", portalIndexEntries)
+	if err != nil {
+		return "", err
+	}
+	if len(sections) == 0 {
+		return "The portal has no content yet.", nil
+	}
+
+	lines := []string{"# neo146 portal"}
+	for _, section := range sections {
+		lines = append(lines, strings.ToUpper(section.Name))
+		for i, entry := range section.Entries {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, entry.Title))
+		}
+	}
+	if readHint != "" {
+		lines = append(lines, readHint)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// FormatSection formats the latest entries of a section with short bodies
+func (s *PortalService) FormatSection(section string) (string, error) {
+	sections, err := s.Sections(section, portalSectionEntries)
+	if err != nil {
+		return "", err
+	}
+	if len(sections) == 0 {
+		return fmt.Sprintf("No %s at the moment.", strings.ToLower(section)), nil
+	}
+
+	parts := []string{fmt.Sprintf("# %s", strings.ToUpper(sections[0].Name))}
+	for i, entry := range sections[0].Entries {
+		part := fmt.Sprintf("%d. %s", i+1, entry.Title)
+		if entry.Body != "" {
+			part += "\n" + truncateRunes(entry.Body, portalSnippetLength)
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// FormatEntry formats the nth (1-based) entry of a section in full
+func (s *PortalService) FormatEntry(section string, n int) (string, error) {
+	sections, err := s.Sections(section, n)
+	if err != nil {
+		return "", err
+	}
+	if len(sections) == 0 || n < 1 || n > len(sections[0].Entries) {
+		return "", fmt.Errorf("%s entry %d not found", section, n)
+	}
+
+	entry := sections[0].Entries[n-1]
+	return fmt.Sprintf("# %s\n%s\n\n%s", entry.Title, entry.UpdatedAt.Format("2006-01-02 15:04"), entry.Body), nil
+}
+
+// FormatCommand answers a portal command: without arguments the index,
+// with a section its latest entries and with a number that entry in full
+func (s *PortalService) FormatCommand(args string, readHint string) (string, error) {
+	section, n := ParsePortalCommand(args)
+	if section == "" {
+		return s.FormatIndex(readHint)
+	}
+	if n > 0 {
+		return s.FormatEntry(section, n)
+	}
+	return s.FormatSection(section)
+}
+
+// ParsePortalCommand splits portal command arguments into the section and an
+// optional entry number, e.g. "news 2" returns ("news", 2)
+func ParsePortalCommand(args string) (string, int) {
+	return parseNumberedArgs(args)
+}
+
+// validatePortalEntry checks that an entry has a known section and a title
+func validatePortalEntry(entry *models.PortalEntry) error {
+	if !isPortalSection(entry.Section) {
+		return fmt.Errorf("section must be one of: %s", strings.Join(models.PortalSections, ", "))
+	}
+	if entry.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	return nil
+}
+
+// isPortalSection checks if name is a known portal section
+func isPortalSection(name string) bool {
+	for _, section := range models.PortalSections {
+		if section == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"neo146/services/servicestest"
+)
+
+func TestPortalService_AddEntry(t *testing.T) {
+	service := NewPortalService(&servicestest.PortalStore{})
+
+	if _, err := service.AddEntry("sports", "Title", ""); err == nil {
+		t.Error("Expected error for unknown section, got nil")
+	}
+	if _, err := service.AddEntry("news", "  ", ""); err == nil {
+		t.Error("Expected error for empty title, got nil")
+	}
+
+	entry, err := service.AddEntry(" News ", "Headline", "Body")
+	if err != nil {
+		t.Fatalf("Expected entry to be added, got error: %v", err)
+	}
+	if entry.Section != "news" || entry.ID == 0 {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+
+	updated, err := service.UpdateEntry(entry.ID, "announcements", "Changed", "")
+	if err != nil {
+		t.Fatalf("Expected entry to be updated, got error: %v", err)
+	}
+	if updated.Section != "announcements" || updated.Title != "Changed" {
+		t.Errorf("Unexpected updated entry: %+v", updated)
+	}
+
+	if err := service.DeleteEntry(entry.ID); err != nil {
+		t.Errorf("Expected entry to be deleted, got error: %v", err)
+	}
+	if err := service.DeleteEntry(entry.ID); err == nil {
+		t.Error("Expected error when deleting missing entry, got nil")
+	}
+}
+
+func TestPortalService_FormatCommand(t *testing.T) {
+	service := NewPortalService(&servicestest.PortalStore{})
+
+	empty, _ := service.FormatCommand("", "")
+	if empty != "The portal has no content yet." {
+		t.Errorf("Unexpected empty portal output: %s", empty)
+	}
+
+	service.AddEntry("news", "Old news", "Old body")
+	service.AddEntry("news", "Latest news", strings.Repeat("x", 300))
+	service.AddEntry("emergency", "Assembly point", "Go to the park")
+
+	index, err := service.FormatCommand("", "Reply to read.")
+	if err != nil {
+		t.Fatalf("Expected index, got error: %v", err)
+	}
+	expected := "# neo146 portal\nEMERGENCY\n1. Assembly point\nNEWS\n1. Latest news\n2. Old news\nReply to read."
+	if index != expected {
+		t.Errorf("Unexpected index:\n%s", index)
+	}
+
+	section, err := service.FormatCommand("NEWS", "")
+	if err != nil {
+		t.Fatalf("Expected section, got error: %v", err)
+	}
+	if !strings.Contains(section, "2. Old news\nOld body") || strings.Contains(section, strings.Repeat("x", 300)) {
+		t.Errorf("Expected truncated section listing, got:\n%s", section)
+	}
+
+	entry, err := service.FormatCommand("news 2", "")
+	if err != nil {
+		t.Fatalf("Expected entry, got error: %v", err)
+	}
+	if !strings.HasPrefix(entry, "# Old news\n") || !strings.HasSuffix(entry, "Old body") {
+		t.Errorf("Unexpected entry:\n%s", entry)
+	}
+
+	if _, err := service.FormatCommand("news 3", ""); err == nil {
+		t.Error("Expected error for missing entry, got nil")
+	}
+	if _, err := service.FormatCommand("sports", ""); err == nil {
+		t.Error("Expected error for unknown section, got nil")
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows a number of requests per key within a sliding window
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+	mu     sync.Mutex
+	now    func() time.Time
+
+	lastCleanup time.Time
+}
+
+// NewRateLimiter creates a rate limiter allowing limit requests per key
+// within window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Allow records a request for key and reports whether it is within the limit
+func (r *RateLimiter) Allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	cutoff := now.Add(-r.window)
+
+	// Forget idle keys once per window, so addresses are not kept in memory
+	// longer than needed
+	if now.Sub(r.lastCleanup) > r.window {
+		r.cleanup(cutoff)
+		r.lastCleanup = now
+	}
+
+	// Drop requests that left the window
+	recent := r.hits[key][:0]
+	for _, hit := range r.hits[key] {
+		if hit.After(cutoff) {
+			recent = append(recent, hit)
+		}
+	}
+
+	if len(recent) >= r.limit {
+		r.hits[key] = recent
+		return false
+	}
+
+	r.hits[key] = append(recent, now)
+	return true
+}
+
+// cleanup forgets keys without requests after cutoff
+func (r *RateLimiter) cleanup(cutoff time.Time) {
+	for key, hits := range r.hits {
+		if len(hits) == 0 || !hits[len(hits)-1].After(cutoff) {
+			delete(r.hits, key)
+		}
+	}
+}
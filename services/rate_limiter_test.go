@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2025, 3, 19, 10, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, time.Hour)
+	limiter.now = func() time.Time { return now }
+
+	if !limiter.Allow("a") || !limiter.Allow("a") {
+		t.Fatal("Expected first two requests to be allowed")
+	}
+	if limiter.Allow("a") {
+		t.Error("Expected third request to be denied")
+	}
+	if !limiter.Allow("b") {
+		t.Error("Expected other keys to be allowed")
+	}
+
+	now = now.Add(61 * time.Minute)
+	if !limiter.Allow("a") {
+		t.Error("Expected requests to be allowed after the window")
+	}
+	if _, exists := limiter.hits["b"]; exists {
+		t.Error("Expected idle keys to be forgotten")
+	}
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/utils"
+)
+
+// moreHint is appended to replies cut to their segment budget
+const moreHint = "\n\nReply \"more\" for the rest."
+
+// NoRemainderReply answers "more" when there is no shortened reply
+const NoRemainderReply = "Nothing more to send."
+
+// replyRemainder holds the rest of a user's last shortened reply
+type replyRemainder struct {
+	text      string
+	sealed    bool
+	expiresAt time.Time
+}
+
+// ReplyRemainderStore keeps the rest of shortened replies per user in memory
+// so that it can be read with "more"
+type ReplyRemainderStore struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	remainders map[string]replyRemainder
+}
+
+// NewReplyRemainderStore creates a new remainder store with the given
+// lifetime
+func NewReplyRemainderStore(ttl time.Duration) *ReplyRemainderStore {
+	return &ReplyRemainderStore{
+		ttl:        ttl,
+		remainders: make(map[string]replyRemainder),
+	}
+}
+
+// Save stores the rest of a reply to the given user, replacing an earlier
+// one. Sealed remainders were cut from an encrypted reply.
+func (s *ReplyRemainderStore) Save(identity, text string, sealed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired remainders so the store does not grow unbounded
+	now := time.Now()
+	for key, remainder := range s.remainders {
+		if now.After(remainder.expiresAt) {
+			delete(s.remainders, key)
+		}
+	}
+
+	s.remainders[identity] = replyRemainder{
+		text:      text,
+		sealed:    sealed,
+		expiresAt: now.Add(s.ttl),
+	}
+}
+
+// Take removes and returns the rest of the user's last shortened reply. The
+// rest of an encrypted reply is only returned for a sealed reply.
+func (s *ReplyRemainderStore) Take(identity string, sealed bool) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remainder, exists := s.remainders[identity]
+	if !exists || time.Now().After(remainder.expiresAt) {
+		delete(s.remainders, identity)
+		return "", false
+	}
+	if remainder.sealed && !sealed {
+		return "", false
+	}
+	delete(s.remainders, identity)
+	return remainder.text, true
+}
+
+// ParseSegmentsSuffix splits a trailing number of segments off the arguments
+// of a command, e.g. "https://example.org 3". It returns 0 if there is none.
+func ParseSegmentsSuffix(args string) (string, int) {
+	args = strings.TrimSpace(args)
+	i := strings.LastIndex(args, " ")
+	if i < 0 {
+		return args, 0
+	}
+	segments, err := strconv.Atoi(args[i+1:])
+	if err != nil || segments < 1 {
+		return args, 0
+	}
+	return strings.TrimSpace(args[:i]), segments
+}
+
+// ParseURLCommand returns the URL and the number of segments requested by a
+// URL command, either a bare URL or "url <url>", with an optional number of
+// segments
+func ParseURLCommand(content string) (string, int) {
+	target, segments := ParseSegmentsSuffix(content)
+	if command, args, found := strings.Cut(target, " "); found && strings.EqualFold(command, "url") {
+		target = strings.TrimSpace(args)
+	}
+	return target, segments
+}
+
+// blockSeparator separates the paragraphs and headings of Markdown
+var blockSeparator = regexp.MustCompile(`\n\s*\n`)
+
+// trimReply cuts a Markdown reply to what fits. Headings with the first
+// paragraph of their section, and the first paragraph of the text, are kept
+// before other paragraphs. It returns the kept text and the rest, both in
+// the order of the text.
+func trimReply(text string, fits func(string) bool) (string, string) {
+	if fits(text) {
+		return text, ""
+	}
+
+	var blocks []string
+	for _, block := range blockSeparator.Split(strings.TrimSpace(text), -1) {
+		if block = strings.TrimSpace(block); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+
+	// Units are kept whole: a heading goes with the paragraph after it
+	var leads, others [][]int
+	for i := 0; i < len(blocks); i++ {
+		switch {
+		case isHeading(blocks[i]) && i+1 < len(blocks) && !isHeading(blocks[i+1]):
+			leads = append(leads, []int{i, i + 1})
+			i++
+		case isHeading(blocks[i]) || i == 0:
+			leads = append(leads, []int{i})
+		default:
+			others = append(others, []int{i})
+		}
+	}
+
+	// Units are added in order of priority until one does not fit
+	kept := make([]bool, len(blocks))
+fill:
+	for _, units := range [][][]int{leads, others} {
+		for _, unit := range units {
+			for _, i := range unit {
+				kept[i] = true
+			}
+			if !fits(joinBlocks(blocks, kept, true)) {
+				for _, i := range unit {
+					kept[i] = false
+				}
+				break fill
+			}
+		}
+	}
+	if joinBlocks(blocks, kept, true) != "" {
+		return joinBlocks(blocks, kept, true), joinBlocks(blocks, kept, false)
+	}
+
+	// Not even the first unit fits, so it is cut at the longest prefix that
+	// does
+	first := blocks[leads[0][0]]
+	if len(leads[0]) > 1 {
+		first += "\n\n" + blocks[leads[0][1]]
+	}
+	low, high := 0, len([]rune(first))
+	for low < high {
+		limit := (low + high + 1) / 2
+		if fits(shortenText(first, limit)) {
+			low = limit
+		} else {
+			high = limit - 1
+		}
+	}
+	cut := shortenText(first, low)
+	for _, i := range leads[0] {
+		kept[i] = true
+	}
+	rest := strings.TrimSpace(first[len(cut):])
+	if others := joinBlocks(blocks, kept, false); others != "" {
+		rest += "\n\n" + others
+	}
+	return cut, rest
+}
+
+// isHeading checks if a Markdown block is a heading
+func isHeading(block string) bool {
+	return strings.HasPrefix(block, "#")
+}
+
+// joinBlocks joins the blocks that are kept, or those that are not
+func joinBlocks(blocks []string, kept []bool, keep bool) string {
+	var joined []string
+	for i, block := range blocks {
+		if kept[i] == keep {
+			joined = append(joined, block)
+		}
+	}
+	return strings.Join(joined, "\n\n")
+}
+
+// encodedSize returns the number of GW parts and SMS segments of a reply
+// sent as encoded parts
+func encodedSize(text string) (int, int) {
+	parts := utils.SplitAndEncodeMessage(text, smsPartLength)
+	return len(parts), countSegments(parts)
+}
+
+// sealedSize returns the number of GW parts and SMS segments of a reply
+// sealed for an encrypted request
+func sealedSize(text string) (int, int) {
+	size := utils.E2ESealedSize(utils.E2ENonceSize + len(text))
+	parts := utils.SplitAndEncodeBytes(make([]byte, size), utils.E2EPartSize)
+	return len(parts), countSegments(parts)
+}
+
+// countSegments returns the number of SMS segments of messages
+func countSegments(messages []string) int {
+	segments := 0
+	for _, message := range messages {
+		segments += utils.CountSMSSegments(message)
+	}
+	return segments
+}
+
+// limitSegments returns the lower of two limits, where 0 is no limit
+func limitSegments(limit, other int) int {
+	if limit == 0 || (other > 0 && other < limit) {
+		return other
+	}
+	return limit
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseURLCommand(t *testing.T) {
+	tests := []struct {
+		content, url string
+		segments     int
+	}{
+		{"https://example.org", "https://example.org", 0},
+		{"https://example.org 3", "https://example.org", 3},
+		{"url https://example.org", "https://example.org", 0},
+		{"URL https://example.org/a 12", "https://example.org/a", 12},
+		{"url https://example.org 0", "https://example.org 0", 0},
+	}
+	for _, test := range tests {
+		url, segments := ParseURLCommand(test.content)
+		if url != test.url || segments != test.segments {
+			t.Errorf("ParseURLCommand(%q) = %q, %d, expected %q, %d", test.content, url, segments, test.url, test.segments)
+		}
+	}
+
+	if number, segments := ParseSegmentsSuffix(" 2 "); number != "2" || segments != 0 {
+		t.Errorf("Expected a lone number not to be a budget, got %q, %d", number, segments)
+	}
+}
+
+func TestTrimReply(t *testing.T) {
+	markdown := strings.Join([]string{
+		"Intro paragraph.",
+		"Second intro paragraph.",
+		"# First",
+		"First section lead.",
+		"First section details.",
+		"# Second",
+		"Second section lead.",
+	}, "\n\n")
+
+	fitsLength := func(limit int) func(string) bool {
+		return func(text string) bool { return len(text) <= limit }
+	}
+
+	kept, rest := trimReply(markdown, fitsLength(len(markdown)))
+	if kept != markdown || rest != "" {
+		t.Errorf("Expected the reply to be unchanged, got %q, %q", kept, rest)
+	}
+
+	kept, rest = trimReply(markdown, fitsLength(100))
+	expected := "Intro paragraph.\n\n# First\n\nFirst section lead.\n\n# Second\n\nSecond section lead."
+	if kept != expected {
+		t.Errorf("Expected headings and leads first, got %q", kept)
+	}
+	if rest != "Second intro paragraph.\n\nFirst section details." {
+		t.Errorf("Expected the other paragraphs to be kept for later, got %q", rest)
+	}
+
+	kept, rest = trimReply("One sentence. Another sentence that is long.\n\nMore.", fitsLength(20))
+	if kept != "One sentence." || rest != "Another sentence that is long.\n\nMore." {
+		t.Errorf("Expected the first paragraph to be cut, got %q, %q", kept, rest)
+	}
+}
+
+func TestSMSService_ApplySegmentBudget(t *testing.T) {
+	store := newMemoryCostStore()
+	store.subscribers["905550000000"] = true
+	service := newTestCostService(store, CostConfig{SegmentCost: 0.05, FreeSegments: 3, SubscriberSegments: 12})
+	smsService := &SMSService{CostService: service, Remainders: NewReplyRemainderStore(time.Hour)}
+
+	var paragraphs []string
+	for i := 0; i < 20; i++ {
+		paragraphs = append(paragraphs, strings.TrimSpace(strings.Repeat("Words of a paragraph. ", 10)))
+	}
+	markdown := strings.Join(paragraphs, "\n\n")
+
+	content, refused := smsService.applyBudget(markdown, "905551112233", 0, false)
+	if _, segments := encodedSize(content); refused || segments > 3 || !strings.HasSuffix(content, moreHint) {
+		t.Errorf("Expected the reply to take 3 segments with a hint, got %d: %q", segments, content)
+	}
+
+	subscriberContent, _ := smsService.applyBudget(markdown, "905550000000", 0, false)
+	requestedContent, _ := smsService.applyBudget(markdown, "905550000000", 6, false)
+	if len(subscriberContent) <= len(requestedContent) || len(requestedContent) <= len(content) {
+		t.Errorf("Expected subscribers to get more, up to the segments requested")
+	}
+	if _, segments := encodedSize(requestedContent); segments > 6 {
+		t.Errorf("Expected at most 6 segments, got %d", segments)
+	}
+
+	// The rest is read with "more", and is gone once read
+	rest, found := smsService.TakeRemainder("905551112233", false)
+	if !found || !strings.HasPrefix(markdown, strings.TrimSuffix(content, moreHint)) || !strings.HasSuffix(markdown, rest) {
+		t.Errorf("Expected the rest of the reply, got %q", rest)
+	}
+	if _, found := smsService.TakeRemainder("905551112233", false); found {
+		t.Errorf("Expected the rest to be read once")
+	}
+
+	// The rest of encrypted replies is not sent in plain text
+	smsService.applyBudget(markdown, "905551112233", 0, true)
+	if _, found := smsService.TakeRemainder("905551112233", false); found {
+		t.Errorf("Expected the rest of an encrypted reply to stay sealed")
+	}
+	if _, found := smsService.TakeRemainder("905551112233", true); !found {
+		t.Errorf("Expected the rest of an encrypted reply for an encrypted request")
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RTP constants (RFC 3550)
+const (
+	rtpVersion     = 2
+	rtpHeaderSize  = 12
+	rtpPayloadPCMU = 0
+	// rtpFrameSamples is the number of samples per packet, 20 ms at 8000 Hz
+	rtpFrameSamples = 160
+)
+
+// rtpPacket is an RTP packet
+type rtpPacket struct {
+	payloadType byte
+	marker      bool
+	sequence    uint16
+	timestamp   uint32
+	ssrc        uint32
+	payload     []byte
+}
+
+// parseRTP parses an RTP packet, skipping CSRCs, header extensions and
+// padding
+func parseRTP(data []byte) (rtpPacket, error) {
+	if len(data) < rtpHeaderSize || data[0]>>6 != rtpVersion {
+		return rtpPacket{}, errors.New("not an RTP packet")
+	}
+
+	packet := rtpPacket{
+		payloadType: data[1] & 0x7f,
+		marker:      data[1]&0x80 != 0,
+		sequence:    binary.BigEndian.Uint16(data[2:]),
+		timestamp:   binary.BigEndian.Uint32(data[4:]),
+		ssrc:        binary.BigEndian.Uint32(data[8:]),
+	}
+
+	offset := rtpHeaderSize + 4*int(data[0]&0x0f)
+	if data[0]&0x10 != 0 {
+		if len(data) < offset+4 {
+			return rtpPacket{}, errors.New("truncated RTP header extension")
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:]))
+	}
+	end := len(data)
+	if data[0]&0x20 != 0 && end > 0 {
+		end -= int(data[end-1])
+	}
+	if offset > end {
+		return rtpPacket{}, errors.New("truncated RTP packet")
+	}
+
+	packet.payload = data[offset:end]
+	return packet, nil
+}
+
+// marshal encodes the packet
+func (p rtpPacket) marshal() []byte {
+	data := make([]byte, rtpHeaderSize, rtpHeaderSize+len(p.payload))
+	data[0] = rtpVersion << 6
+	data[1] = p.payloadType & 0x7f
+	if p.marker {
+		data[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(data[2:], p.sequence)
+	binary.BigEndian.PutUint32(data[4:], p.timestamp)
+	binary.BigEndian.PutUint32(data[8:], p.ssrc)
+	return append(data, p.payload...)
+}
+
+// dtmfEventKeys maps telephone-event codes (RFC 4733, formerly RFC 2833) to
+// keys
+const dtmfEventKeys = "0123456789*#ABCD"
+
+// parseDTMFEvent returns the key of a telephone-event payload and whether
+// it is the end of the event
+func parseDTMFEvent(payload []byte) (byte, bool, bool) {
+	if len(payload) < 4 || int(payload[0]) >= len(dtmfEventKeys) {
+		return 0, false, false
+	}
+	return dtmfEventKeys[payload[0]], payload[1]&0x80 != 0, true
+}
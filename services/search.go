@@ -8,138 +8,211 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
+
+	"neo146/models"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
+const (
+	// maxSearchResults is the number of results returned for SMS
+	maxSearchResults = 5
+	// maxSnippetLength is the maximum length of a result snippet in runes
+	maxSnippetLength = 120
+)
+
 // SearchService handles searching using DuckDuckGo
 type SearchService struct {
 	httpClient *http.Client
+	searchURL  string
+	sessions   *SearchSessionStore
 }
 
 // NewSearchService creates a new instance of SearchService
 func NewSearchService(httpClient *http.Client) *SearchService {
 	return &SearchService{
 		httpClient: httpClient,
+		searchURL:  "https://lite.duckduckgo.com/lite/",
+		sessions:   NewSearchSessionStore(time.Hour),
 	}
 }
 
 // FetchDuckDuckGoResults fetches search results from DuckDuckGo
 func (s *SearchService) FetchDuckDuckGoResults(query string) (string, error) {
+	results, err := s.Search(query)
+	if err != nil {
+		return "", err
+	}
 
-	// Create form data for the POST request
+	// Return message if no results found
+	if len(results) == 0 {
+		return "No search results found. Please try a different query.", nil
+	}
 
-	formData := url.Values{}
+	return FormatSearchResults(results), nil
+}
 
+// Search fetches structured search results from DuckDuckGo Lite
+func (s *SearchService) Search(query string) ([]models.SearchResult, error) {
+	// Create form data for the POST request
+	formData := url.Values{}
 	formData.Set("q", query)
-
 	formData.Set("kl", "tr-tr") // TR results
 
 	// DuckDuckGo Lite expects a POST request with form data
-
-	resp, err := s.httpClient.PostForm("https://lite.duckduckgo.com/lite/", formData)
-
+	resp, err := s.httpClient.PostForm(s.searchURL, formData)
 	if err != nil {
-
-		return "", fmt.Errorf("error fetching DuckDuckGo results: %v", err)
-
+		return nil, fmt.Errorf("error fetching DuckDuckGo results: %v", err)
 	}
-
 	defer resp.Body.Close()
 
 	// Read the response body
-
 	body, err := io.ReadAll(resp.Body)
-
 	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
 
-		return "", fmt.Errorf("error reading response body: %v", err)
+	results := parseDuckDuckGoResults(string(body))
 
+	// Limit to 5 results maximum for SMS
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
 	}
 
-	htmlContent := string(body)
+	return results, nil
+}
 
-	// Extract search results
+// SearchForUser runs a search and remembers the results for the user's
+// session so that they can later be opened by number
+func (s *SearchService) SearchForUser(identity, query string) ([]models.SearchResult, error) {
+	results, err := s.Search(query)
+	if err != nil {
+		return nil, err
+	}
 
-	var results []string
+	if len(results) > 0 {
+		s.sessions.Save(identity, results)
+	}
 
-	// Parse the HTML
+	return results, nil
+}
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+// OpenResult returns the nth (1-based) result of the user's last search
+func (s *SearchService) OpenResult(identity string, n int) (models.SearchResult, error) {
+	return s.sessions.Get(identity, n)
+}
 
-	if err == nil {
+// FormatSearchResults formats search results as a compact numbered list
+func FormatSearchResults(results []models.SearchResult) string {
+	var parts []string
+	for i, result := range results {
+		entry := fmt.Sprintf("%d. %s", i+1, result.Title)
+		if result.Snippet != "" {
+			entry += "\n" + truncateRunes(result.Snippet, maxSnippetLength)
+		}
+		entry += "\n" + result.URL
+		parts = append(parts, entry)
+	}
+	return strings.Join(parts, "\n\n")
+}
 
-		// Find all links and extract those that point to external sites
+// FormatSearchSession formats results of a session search followed by a hint
+// on how to open a result with the given command
+func FormatSearchSession(results []models.SearchResult, openCommand string) string {
+	if len(results) == 0 {
+		return "No search results found. Please try a different query."
+	}
+	return fmt.Sprintf("%s\n\nReply \"%s <n>\" to read a result.", FormatSearchResults(results), openCommand)
+}
 
-		doc.Find("a").Each(func(i int, link *goquery.Selection) {
+// parseDuckDuckGoResults extracts search results from a DuckDuckGo Lite page
+func parseDuckDuckGoResults(htmlContent string) []models.SearchResult {
+	var results []models.SearchResult
+	seen := make(map[string]bool)
 
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err == nil {
+		// DuckDuckGo Lite renders each result as a row with the link followed
+		// by a row with the snippet
+		doc.Find("a.result-link").Each(func(i int, link *goquery.Selection) {
 			href, exists := link.Attr("href")
-
-			// Skip DuckDuckGo internal links
-
-			if exists && !strings.Contains(href, "duckduckgo.com") &&
-
-				strings.HasPrefix(href, "http") && link.Text() != "" {
-
-				title := strings.TrimSpace(link.Text())
-
-				// Skip navigation links like "Next" or very short titles
-
-				if len(title) > 5 &&
-
-					!strings.EqualFold(title, "Next") &&
-
-					!strings.EqualFold(title, "Previous") {
-
-					results = append(results, fmt.Sprintf("# %s\n%s", title, href))
-
-				}
-
+			if !exists {
+				return
 			}
-
+			href = resolveDuckDuckGoLink(href)
+			title := strings.TrimSpace(link.Text())
+			if !strings.HasPrefix(href, "http") || title == "" || seen[href] {
+				return
+			}
+			seen[href] = true
+
+			snippet := link.Closest("tr").NextAllFiltered("tr").Find("td.result-snippet").First().Text()
+			results = append(results, models.SearchResult{
+				Title:   title,
+				URL:     href,
+				Snippet: strings.Join(strings.Fields(snippet), " "),
+			})
 		})
 
+		// Older layouts do not mark result links, so fall back to all
+		// external links on the page
+		if len(results) == 0 {
+			doc.Find("a").Each(func(i int, link *goquery.Selection) {
+				href, exists := link.Attr("href")
+				// Skip DuckDuckGo internal links
+				if exists && !strings.Contains(href, "duckduckgo.com") &&
+					strings.HasPrefix(href, "http") && link.Text() != "" {
+					title := strings.TrimSpace(link.Text())
+
+					// Skip navigation links like "Next" or very short titles
+					if len(title) > 5 &&
+						!strings.EqualFold(title, "Next") &&
+						!strings.EqualFold(title, "Previous") && !seen[href] {
+						seen[href] = true
+						results = append(results, models.SearchResult{Title: title, URL: href})
+					}
+				}
+			})
+		}
 	}
 
 	// Fallback to regex extraction if no results found
-
 	if len(results) == 0 {
-
-		links := extractLinksFromHTML(htmlContent)
-
-		for _, link := range links {
-
+		for _, link := range extractLinksFromHTML(htmlContent) {
 			if !strings.Contains(link, "duckduckgo.com") {
-
-				results = append(results, fmt.Sprintf("# Search Result\n%s", link))
-
+				results = append(results, models.SearchResult{Title: "Search Result", URL: link})
 			}
-
 		}
-
 	}
 
-	// Return message if no results found
-
-	if len(results) == 0 {
-
-		return "No search results found. Please try a different query.", nil
+	return results
+}
 
+// resolveDuckDuckGoLink unwraps DuckDuckGo redirect links to the target URL
+func resolveDuckDuckGoLink(href string) string {
+	if !strings.Contains(href, "duckduckgo.com/l/") {
+		return href
 	}
-
-	// Limit to 5 results maximum for SMS
-
-	maxResults := 5
-
-	if len(results) > maxResults {
-
-		results = results[:maxResults]
-
+	parsed, err := url.Parse(href)
+	if err != nil {
+		return href
 	}
+	if target := parsed.Query().Get("uddg"); target != "" {
+		return target
+	}
+	return href
+}
 
-	return strings.Join(results, "\n\n"), nil
-
+// truncateRunes shortens text to at most maxLength runes, adding an ellipsis
+func truncateRunes(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:maxLength-3])) + "..."
 }
+
 func extractLinksFromHTML(html string) []string {
 
 	var links []string
@@ -256,8 +329,6 @@ func (s *SearchService) FetchWikipediaSummary(query string, langCode string) (st
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Println(resp.Request.URL)
-
 		return "", fmt.Errorf("wikipedia API returned status: %d", resp.StatusCode)
 
 	}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"neo146/models"
+)
+
+// searchSession holds the results of a user's last search
+type searchSession struct {
+	results   []models.SearchResult
+	expiresAt time.Time
+}
+
+// SearchSessionStore keeps the last search results per user in memory so that
+// results can be referred to by number in follow-up commands
+type SearchSessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]searchSession
+}
+
+// NewSearchSessionStore creates a new session store with the given lifetime
+func NewSearchSessionStore(ttl time.Duration) *SearchSessionStore {
+	return &SearchSessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]searchSession),
+	}
+}
+
+// Save stores the results of a search for the given user
+func (s *SearchSessionStore) Save(identity string, results []models.SearchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired sessions so the store does not grow unbounded
+	now := time.Now()
+	for key, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+
+	s.sessions[identity] = searchSession{
+		results:   results,
+		expiresAt: now.Add(s.ttl),
+	}
+}
+
+// Get returns the nth (1-based) result of the user's last search
+func (s *SearchSessionStore) Get(identity string, n int) (models.SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[identity]
+	if !exists || time.Now().After(session.expiresAt) {
+		delete(s.sessions, identity)
+		return models.SearchResult{}, fmt.Errorf("no recent search found, please search first")
+	}
+
+	if n < 1 || n > len(session.results) {
+		return models.SearchResult{}, fmt.Errorf("result number must be between 1 and %d", len(session.results))
+	}
+
+	return session.results[n-1], nil
+}
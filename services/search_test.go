@@ -0,0 +1,101 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const duckDuckGoLiteFixture = `<html><body><table>
+<tr><td>1.&nbsp;</td><td><a rel="nofollow" href="https://example.com/first" class='result-link'>First Result Title</a></td></tr>
+<tr><td>&nbsp;</td><td class='result-snippet'>The first   snippet
+ describes the page.</td></tr>
+<tr><td>&nbsp;</td><td><span class='link-text'>example.com/first</span></td></tr>
+<tr><td>2.&nbsp;</td><td><a rel="nofollow" href="//duckduckgo.com/l/?uddg=https%3A%2F%2Fexample.org%2Fsecond&rut=abc" class='result-link'>Second Result Title</a></td></tr>
+<tr><td>&nbsp;</td><td class='result-snippet'>Second snippet.</td></tr>
+<tr><td>&nbsp;</td><td><span class='link-text'>example.org/second</span></td></tr>
+</table></body></html>`
+
+func TestParseDuckDuckGoResults(t *testing.T) {
+	results := parseDuckDuckGoResults(duckDuckGoLiteFixture)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Title != "First Result Title" || results[0].URL != "https://example.com/first" {
+		t.Errorf("Unexpected first result: %+v", results[0])
+	}
+	if results[0].Snippet != "The first snippet describes the page." {
+		t.Errorf("Expected whitespace-normalized snippet, got %q", results[0].Snippet)
+	}
+
+	if results[1].URL != "https://example.org/second" {
+		t.Errorf("Expected redirect link to be resolved, got %s", results[1].URL)
+	}
+	if results[1].Snippet != "Second snippet." {
+		t.Errorf("Unexpected second snippet: %q", results[1].Snippet)
+	}
+}
+
+func TestFormatSearchResults(t *testing.T) {
+	results := parseDuckDuckGoResults(duckDuckGoLiteFixture)
+	formatted := FormatSearchResults(results)
+
+	expected := "1. First Result Title\nThe first snippet describes the page.\nhttps://example.com/first\n\n" +
+		"2. Second Result Title\nSecond snippet.\nhttps://example.org/second"
+	if formatted != expected {
+		t.Errorf("Unexpected formatted results:\n%s", formatted)
+	}
+
+	long := strings.Repeat("a", maxSnippetLength*2)
+	if got := truncateRunes(long, maxSnippetLength); len([]rune(got)) != maxSnippetLength {
+		t.Errorf("Expected snippet to be truncated to %d runes, got %d", maxSnippetLength, len([]rune(got)))
+	}
+
+	if !strings.Contains(FormatSearchSession(results, "open"), `Reply "open <n>"`) {
+		t.Error("Expected session output to contain an open hint")
+	}
+	if FormatSearchSession(nil, "open") != "No search results found. Please try a different query." {
+		t.Error("Expected no results message for empty results")
+	}
+}
+
+func TestSearchService_OpenResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, duckDuckGoLiteFixture)
+	}))
+	defer server.Close()
+
+	service := NewSearchService(server.Client())
+	service.searchURL = server.URL
+
+	// Opening before searching fails
+	if _, err := service.OpenResult("+1234567890", 1); err == nil {
+		t.Error("Expected error when opening without a previous search, got nil")
+	}
+
+	if _, err := service.SearchForUser("+1234567890", "test"); err != nil {
+		t.Fatalf("Expected successful search, got error: %v", err)
+	}
+
+	result, err := service.OpenResult("+1234567890", 2)
+	if err != nil {
+		t.Fatalf("Expected to open result 2, got error: %v", err)
+	}
+	if result.URL != "https://example.org/second" {
+		t.Errorf("Expected second result URL, got %s", result.URL)
+	}
+
+	// Sessions are per user
+	if _, err := service.OpenResult("+0987654321", 1); err == nil {
+		t.Error("Expected error when opening another user's results, got nil")
+	}
+
+	// Out of range result numbers fail
+	if _, err := service.OpenResult("+1234567890", 3); err == nil {
+		t.Error("Expected error for out of range result number, got nil")
+	}
+}
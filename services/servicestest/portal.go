@@ -0,0 +1,74 @@
+// Package servicestest provides in-memory stores for testing code built on
+// the services package
+package servicestest
+
+import (
+	"database/sql"
+	"time"
+
+	"neo146/models"
+)
+
+// PortalStore implements services.PortalStore in memory. The zero value is
+// an empty store.
+type PortalStore struct {
+	entries []models.PortalEntry
+	nextID  int64
+}
+
+// SavePortalEntry stores a new entry with the next ID and a fixed time
+func (m *PortalStore) SavePortalEntry(entry *models.PortalEntry) error {
+	m.nextID++
+	entry.ID = m.nextID
+	entry.CreatedAt = time.Date(2025, 3, 19, 12, 0, int(m.nextID), 0, time.UTC)
+	entry.UpdatedAt = entry.CreatedAt
+	m.entries = append(m.entries, *entry)
+	return nil
+}
+
+// UpdatePortalEntry replaces an entry
+func (m *PortalStore) UpdatePortalEntry(entry *models.PortalEntry) error {
+	for i := range m.entries {
+		if m.entries[i].ID == entry.ID {
+			m.entries[i] = *entry
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// DeletePortalEntry removes an entry
+func (m *PortalStore) DeletePortalEntry(id int64) error {
+	for i := range m.entries {
+		if m.entries[i].ID == id {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// GetPortalEntry returns an entry
+func (m *PortalStore) GetPortalEntry(id int64) (*models.PortalEntry, error) {
+	for _, entry := range m.entries {
+		if entry.ID == id {
+			return &entry, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListPortalEntries returns the newest entries of a section, or of all
+// sections if section is empty, up to limit unless it is negative
+func (m *PortalStore) ListPortalEntries(section string, limit int) ([]models.PortalEntry, error) {
+	var entries []models.PortalEntry
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if section == "" || m.entries[i].Section == section {
+			entries = append(entries, m.entries[i])
+		}
+		if limit >= 0 && len(entries) == limit {
+			break
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,848 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/utils"
+)
+
+const (
+	// sipMaxMessage is the maximum size of a SIP message that is read
+	sipMaxMessage = 8192
+	// sipT1 is the first retransmission interval of responses to INVITE
+	// (RFC 3261 timer T1), doubled up to sipT2 until the ACK arrives
+	sipT1 = 500 * time.Millisecond
+	sipT2 = 4 * time.Second
+	// sipAckTimeout is how long an answered call waits for its ACK
+	sipAckTimeout = 32 * time.Second
+	// sipMaxCallDuration ends calls that run too long
+	sipMaxCallDuration = 15 * time.Minute
+	// sipAllow lists the methods the user agent handles
+	sipAllow = "INVITE, ACK, BYE, CANCEL, OPTIONS"
+)
+
+// sipCompactHeaders maps compact header names to full names (RFC 3261)
+var sipCompactHeaders = map[string]string{
+	"v": "via",
+	"f": "from",
+	"t": "to",
+	"i": "call-id",
+	"m": "contact",
+	"l": "content-length",
+	"c": "content-type",
+}
+
+// SIPConfig holds the settings of the SIP voice channel
+type SIPConfig struct {
+	ListenAddr string
+	// PublicIP is the address announced for media when the server is behind
+	// NAT. If empty, the local address facing the caller is used.
+	PublicIP string
+	// MaxCalls is the number of simultaneous calls, further calls get a
+	// busy signal
+	MaxCalls int
+}
+
+// SIPServer is a minimal SIP user agent that answers calls with a DTMF
+// menu. Keys are read from RFC 4733 telephone events, or from the audio
+// with a Goertzel detector when the caller does not send events. Answers
+// are read out by a Speaker.
+type SIPServer struct {
+	config      SIPConfig
+	smsService  *SMSService
+	speaker     Speaker
+	limiter     *RateLimiter
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.PacketConn
+	calls  map[string]*sipCall
+	closed bool
+}
+
+// NewSIPServer creates a new SIP server using the services registered on
+// smsService. The limiter is keyed by caller IP and counts calls.
+func NewSIPServer(config SIPConfig, smsService *SMSService, speaker Speaker, limiter *RateLimiter) *SIPServer {
+	if config.MaxCalls <= 0 {
+		config.MaxCalls = 1
+	}
+	return &SIPServer{
+		config:      config,
+		smsService:  smsService,
+		speaker:     speaker,
+		limiter:     limiter,
+		idleTimeout: 2 * time.Minute,
+		calls:       make(map[string]*sipCall),
+	}
+}
+
+// ListenAndServe listens on the configured UDP address and answers calls
+// until Close is called
+func (s *SIPServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for sip: %v", err)
+	}
+	slog.Info("SIP server listening", "listen_addr", s.config.ListenAddr)
+	return s.Serve(conn)
+}
+
+// Serve answers SIP requests received on conn until Close is called
+func (s *SIPServer) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("server closed")
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	buf := make([]byte, sipMaxMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.handlePacket(buf[:n], addr)
+	}
+}
+
+// handlePacket answers a received packet. A packet that makes handling
+// panic is dropped instead of stopping the server.
+func (s *SIPServer) handlePacket(data []byte, addr net.Addr) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Panic handling SIP packet", "ip", addrIP(addr), "panic", r)
+		}
+	}()
+
+	message, err := parseSIPMessage(data)
+	if err != nil || message.method == "" {
+		// Responses only matter for our BYE, which is not retried
+		return
+	}
+	s.handle(message, addr)
+}
+
+// Close hangs up all calls and stops answering
+func (s *SIPServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	calls := make([]*sipCall, 0, len(s.calls))
+	for _, call := range s.calls {
+		calls = append(calls, call)
+	}
+	conn := s.conn
+	s.mu.Unlock()
+
+	for _, call := range calls {
+		s.hangUp(call)
+	}
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// handle answers a request
+func (s *SIPServer) handle(request *sipMessage, addr net.Addr) {
+	callID := request.get("call-id")
+	s.mu.Lock()
+	call := s.calls[callID]
+	s.mu.Unlock()
+
+	switch request.method {
+	case "INVITE":
+		if call != nil {
+			// Retransmission, or a re-INVITE refreshing the session
+			if request.get("cseq") == call.invite.get("cseq") {
+				s.send(call.response, addr)
+			} else {
+				s.send(sipResponse(request, addr, 200, "OK", call.localTag, call.answerHeaders(), call.answer), addr)
+			}
+			return
+		}
+		s.invite(request, addr)
+	case "ACK":
+		if call != nil {
+			call.ack()
+		}
+	case "BYE":
+		if call == nil {
+			s.send(sipResponse(request, addr, 481, "Call/Transaction Does Not Exist", "", nil, ""), addr)
+			return
+		}
+		s.send(sipResponse(request, addr, 200, "OK", call.localTag, nil, ""), addr)
+		s.endCall(call)
+	case "CANCEL":
+		if call == nil {
+			s.send(sipResponse(request, addr, 481, "Call/Transaction Does Not Exist", "", nil, ""), addr)
+			return
+		}
+		s.send(sipResponse(request, addr, 200, "OK", call.localTag, nil, ""), addr)
+		if !call.isAcked() {
+			s.send(sipResponse(call.invite, addr, 487, "Request Terminated", call.localTag, nil, ""), addr)
+			s.endCall(call)
+		}
+	case "OPTIONS":
+		s.send(sipResponse(request, addr, 200, "OK", "", []sipHeader{{"Allow", sipAllow}, {"Accept", "application/sdp"}}, ""), addr)
+	default:
+		s.send(sipResponse(request, addr, 405, "Method Not Allowed", "", []sipHeader{{"Allow", sipAllow}}, ""), addr)
+	}
+}
+
+// invite answers a new call
+func (s *SIPServer) invite(request *sipMessage, addr net.Addr) {
+	offer, err := parseSDPOffer(request.body)
+	if err != nil {
+		s.send(sipResponse(request, addr, 488, "Not Acceptable Here", "", nil, ""), addr)
+		return
+	}
+
+	s.mu.Lock()
+	busy := len(s.calls) >= s.config.MaxCalls || s.closed
+	s.mu.Unlock()
+	if busy {
+		s.send(sipResponse(request, addr, 486, "Busy Here", "", nil, ""), addr)
+		return
+	}
+	if !s.limiter.Allow(addrIP(addr)) {
+		utils.RateLimitRejections.Inc("sip")
+		s.send(sipResponse(request, addr, 403, "Rate Limit Exceeded", "", nil, ""), addr)
+		return
+	}
+
+	s.send(sipResponse(request, addr, 100, "Trying", "", nil, ""), addr)
+
+	localIP := s.localIP(addr)
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: s.listenIP()})
+	if err != nil {
+		slog.Error("Error opening RTP port", "error", err)
+		s.send(sipResponse(request, addr, 500, "Server Internal Error", "", nil, ""), addr)
+		return
+	}
+
+	call := newSIPCall(s, request, addr, offer, rtpConn)
+	call.contact = fmt.Sprintf("<sip:neo146@%s>", net.JoinHostPort(localIP, strconv.Itoa(addrPort(s.conn.LocalAddr()))))
+	call.answer = sdpAnswer(localIP, rtpConn.LocalAddr().(*net.UDPAddr).Port, offer.eventPayloadType)
+	call.response = sipResponse(request, addr, 200, "OK", call.localTag, call.answerHeaders(), call.answer)
+
+	s.mu.Lock()
+	s.calls[call.id] = call
+	s.mu.Unlock()
+
+	s.send(call.response, addr)
+	go s.retransmit(call)
+}
+
+// retransmit resends the answer of a call until it is acknowledged, and
+// starts the call then
+func (s *SIPServer) retransmit(call *sipCall) {
+	interval := sipT1
+	deadline := time.After(sipAckTimeout)
+	for {
+		select {
+		case <-call.acked:
+			call.run()
+			s.endCall(call)
+			return
+		case <-call.done:
+			return
+		case <-deadline:
+			s.endCall(call)
+			return
+		case <-time.After(interval):
+			s.send(call.response, call.remote)
+			interval = min(interval*2, sipT2)
+		}
+	}
+}
+
+// endCall releases a call
+func (s *SIPServer) endCall(call *sipCall) {
+	s.mu.Lock()
+	if s.calls[call.id] == call {
+		delete(s.calls, call.id)
+	}
+	s.mu.Unlock()
+	call.end()
+}
+
+// hangUp sends a BYE for a call and releases it
+func (s *SIPServer) hangUp(call *sipCall) {
+	if call.isAcked() {
+		s.send(call.bye(s.localIP(call.remote), addrPort(s.conn.LocalAddr())), call.remote)
+	}
+	s.endCall(call)
+}
+
+// send writes a message to addr
+func (s *SIPServer) send(message []byte, addr net.Addr) {
+	if _, err := s.conn.WriteTo(message, addr); err != nil {
+		slog.Error("Error sending SIP message", "error", err)
+	}
+}
+
+// listenIP returns the IP the server listens on, nil for all addresses
+func (s *SIPServer) listenIP() net.IP {
+	host, _, err := net.SplitHostPort(s.conn.LocalAddr().String())
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	return ip
+}
+
+// localIP returns the address announced to a caller
+func (s *SIPServer) localIP(addr net.Addr) string {
+	if s.config.PublicIP != "" {
+		return s.config.PublicIP
+	}
+	if ip := s.listenIP(); ip != nil {
+		return ip.String()
+	}
+	// The route to the caller tells which local address it reaches
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return addrIP(conn.LocalAddr())
+}
+
+// sipCall is an answered call
+type sipCall struct {
+	server   *SIPServer
+	id       string
+	invite   *sipMessage
+	remote   net.Addr
+	localTag string
+	contact  string
+	answer   string
+	response []byte
+	offer    sdpOffer
+	rtp      *net.UDPConn
+
+	acked    chan struct{}
+	ackOnce  sync.Once
+	done     chan struct{}
+	doneOnce sync.Once
+
+	mu sync.Mutex
+	// remoteRTP is where media is sent. It is only ever on the IP address
+	// of the signalling, and latched once to the first media received.
+	remoteRTP *net.UDPAddr
+	latched   bool
+	audio     []int16
+	newAudio  bool
+}
+
+// newSIPCall creates a call for an INVITE
+func newSIPCall(server *SIPServer, invite *sipMessage, remote net.Addr, offer sdpOffer, rtp *net.UDPConn) *sipCall {
+	// An offer naming another host would make us send media to it
+	var remoteRTP *net.UDPAddr
+	if offer.addr != nil && offer.addr.IP.Equal(net.ParseIP(addrIP(remote))) {
+		remoteRTP = offer.addr
+	}
+	return &sipCall{
+		server:    server,
+		id:        invite.get("call-id"),
+		invite:    invite,
+		remote:    remote,
+		localTag:  sipRandomToken(),
+		offer:     offer,
+		rtp:       rtp,
+		remoteRTP: remoteRTP,
+		acked:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// answerHeaders returns the headers of the answer to the INVITE
+func (c *sipCall) answerHeaders() []sipHeader {
+	return []sipHeader{{"Contact", c.contact}, {"Allow", sipAllow}}
+}
+
+func (c *sipCall) ack() {
+	c.ackOnce.Do(func() { close(c.acked) })
+}
+
+func (c *sipCall) isAcked() bool {
+	select {
+	case <-c.acked:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *sipCall) end() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+		c.rtp.Close()
+	})
+}
+
+// run plays the menu until the caller hangs up, stays idle too long or the
+// call reaches its maximum duration
+func (c *sipCall) run() {
+	keys := make(chan byte, 32)
+	go c.receive(keys)
+	go c.transmit()
+
+	menu := newIVRMenu(c.server.smsService)
+	c.say(menu.Welcome())
+
+	idle := time.NewTimer(c.server.idleTimeout)
+	defer idle.Stop()
+	maxDuration := time.NewTimer(sipMaxCallDuration)
+	defer maxDuration.Stop()
+
+	for {
+		select {
+		case key := <-keys:
+			idle.Reset(c.server.idleTimeout)
+			// Pressing a key interrupts the current answer
+			c.play(nil)
+			if answer := menu.Press(key); answer != "" {
+				c.say(answer)
+			}
+		case <-idle.C:
+			c.say("Goodbye.")
+			c.drain()
+			c.server.hangUp(c)
+			return
+		case <-maxDuration.C:
+			c.server.hangUp(c)
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// say speaks text to the caller
+func (c *sipCall) say(text string) {
+	audio, err := c.server.speaker.Speak(speechText(text))
+	if err != nil {
+		slog.Error("Error speaking SIP answer", "error", err)
+		return
+	}
+	c.play(audio)
+}
+
+// play replaces the audio being sent to the caller
+func (c *sipCall) play(audio []int16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.audio = audio
+	c.newAudio = len(audio) > 0
+}
+
+// drain waits until the audio has been sent
+func (c *sipCall) drain() {
+	for {
+		c.mu.Lock()
+		remaining := len(c.audio)
+		c.mu.Unlock()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// receive reads keys from the caller's RTP stream
+func (c *sipCall) receive(keys chan<- byte) {
+	detector := utils.NewDTMFDetector(SpeechSampleRate)
+	samples := make([]int16, 0, rtpFrameSamples)
+	eventsSeen := false
+	var lastEvent uint32
+	buf := make([]byte, 1500)
+
+	for {
+		n, addr, err := c.rtp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packet, err := parseRTP(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		// Symmetric RTP: answer where the caller's media comes from, which
+		// gets through NAT. Only the caller's host may send media, and the
+		// first address it sends from is kept for the call.
+		if !c.acceptMedia(addr) {
+			continue
+		}
+
+		var found []byte
+		switch {
+		case c.offer.eventPayloadType >= 0 && int(packet.payloadType) == c.offer.eventPayloadType:
+			// An event is sent in several packets with the same timestamp
+			key, _, ok := parseDTMFEvent(packet.payload)
+			if ok && (!eventsSeen || packet.timestamp != lastEvent) {
+				found = []byte{key}
+			}
+			eventsSeen = true
+			lastEvent = packet.timestamp
+		case packet.payloadType == rtpPayloadPCMU && !eventsSeen:
+			// Callers sending events remove the tones from the audio
+			samples = samples[:0]
+			for _, b := range packet.payload {
+				samples = append(samples, utils.MulawDecode(b))
+			}
+			found = detector.Process(samples)
+		}
+
+		for _, key := range found {
+			select {
+			case keys <- key:
+			default:
+			}
+		}
+	}
+}
+
+// acceptMedia checks if media from addr belongs to the call, latching the
+// address media is sent to on the first packet
+func (c *sipCall) acceptMedia(addr *net.UDPAddr) bool {
+	if !addr.IP.Equal(net.ParseIP(addrIP(c.remote))) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.latched {
+		c.remoteRTP = addr
+		c.latched = true
+		return true
+	}
+	return c.remoteRTP.IP.Equal(addr.IP) && c.remoteRTP.Port == addr.Port
+}
+
+// transmit sends the audio to the caller, silence when there is nothing to
+// say, every 20 ms
+func (c *sipCall) transmit() {
+	packet := rtpPacket{payloadType: rtpPayloadPCMU, ssrc: sipRandomUint32(), sequence: uint16(sipRandomUint32())}
+	packet.timestamp = sipRandomUint32()
+	payload := make([]byte, rtpFrameSamples)
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		frame := c.audio[:min(rtpFrameSamples, len(c.audio))]
+		c.audio = c.audio[len(frame):]
+		packet.marker = c.newAudio
+		c.newAudio = false
+		remote := c.remoteRTP
+		c.mu.Unlock()
+
+		for i := range payload {
+			payload[i] = 0xff
+			if i < len(frame) {
+				payload[i] = utils.MulawEncode(frame[i])
+			}
+		}
+		packet.payload = payload
+		if remote != nil {
+			c.rtp.WriteToUDP(packet.marshal(), remote)
+		}
+		packet.sequence++
+		packet.timestamp += rtpFrameSamples
+	}
+}
+
+// bye builds the BYE request ending the call from our side
+func (c *sipCall) bye(localIP string, localPort int) []byte {
+	target := c.invite.get("contact")
+	if target == "" {
+		target = c.invite.get("from")
+	}
+	uri := sipURI(target)
+
+	to := c.invite.get("to")
+	if !strings.Contains(strings.ToLower(to), ";tag=") {
+		to += ";tag=" + c.localTag
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "BYE %s SIP/2.0\r\n", uri)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP %s;branch=z9hG4bK%s;rport\r\n", net.JoinHostPort(localIP, strconv.Itoa(localPort)), sipRandomToken())
+	b.WriteString("Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "From: %s\r\n", to)
+	fmt.Fprintf(&b, "To: %s\r\n", c.invite.get("from"))
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", c.id)
+	b.WriteString("CSeq: 1 BYE\r\n")
+	b.WriteString("User-Agent: neo146\r\n")
+	b.WriteString("Content-Length: 0\r\n\r\n")
+	return []byte(b.String())
+}
+
+// sipHeader is a header of a SIP message
+type sipHeader struct {
+	name  string
+	value string
+}
+
+// sipMessage is a SIP request or response
+type sipMessage struct {
+	method     string
+	requestURI string
+	status     int
+	headers    []sipHeader
+	body       string
+}
+
+// parseSIPMessage parses a SIP message. Header names are stored in lower
+// case with compact forms expanded.
+func parseSIPMessage(data []byte) (*sipMessage, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	head, body, _ := strings.Cut(text, "\n\n")
+	lines := strings.Split(head, "\n")
+
+	message := &sipMessage{}
+	if strings.HasPrefix(lines[0], "SIP/2.0 ") {
+		fields := strings.Fields(lines[0])
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid SIP status line: %q", lines[0])
+		}
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIP status line: %q", lines[0])
+		}
+		message.status = status
+	} else {
+		fields := strings.Fields(lines[0])
+		if len(fields) != 3 || fields[2] != "SIP/2.0" {
+			return nil, fmt.Errorf("invalid SIP request line: %q", lines[0])
+		}
+		message.method = strings.ToUpper(fields[0])
+		message.requestURI = fields[1]
+	}
+
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		// Folded header lines continue the previous header
+		if (line[0] == ' ' || line[0] == '\t') && len(message.headers) > 0 {
+			message.headers[len(message.headers)-1].value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid SIP header: %q", line)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if full, ok := sipCompactHeaders[name]; ok {
+			name = full
+		}
+		message.headers = append(message.headers, sipHeader{name, strings.TrimSpace(value)})
+	}
+
+	if length, err := strconv.Atoi(message.get("content-length")); err == nil && length >= 0 && length < len(body) {
+		body = body[:length]
+	}
+	message.body = body
+	return message, nil
+}
+
+// get returns the first value of a header
+func (m *sipMessage) get(name string) string {
+	for _, header := range m.headers {
+		if header.name == name {
+			return header.value
+		}
+	}
+	return ""
+}
+
+// all returns all values of a header
+func (m *sipMessage) all(name string) []string {
+	var values []string
+	for _, header := range m.headers {
+		if header.name == name {
+			values = append(values, header.value)
+		}
+	}
+	return values
+}
+
+// sipResponse builds a response to a request received from addr
+func sipResponse(request *sipMessage, addr net.Addr, status int, reason, toTag string, headers []sipHeader, sdp string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", status, reason)
+	for i, via := range request.all("via") {
+		// Tell a caller behind NAT where its request came from (RFC 3581)
+		if i == 0 {
+			via = sipFillRport(via, addr)
+		}
+		fmt.Fprintf(&b, "Via: %s\r\n", via)
+	}
+
+	to := request.get("to")
+	if toTag != "" && !strings.Contains(strings.ToLower(to), ";tag=") {
+		to += ";tag=" + toTag
+	}
+	fmt.Fprintf(&b, "From: %s\r\n", request.get("from"))
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", request.get("call-id"))
+	fmt.Fprintf(&b, "CSeq: %s\r\n", request.get("cseq"))
+	for _, header := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", header.name, header.value)
+	}
+	b.WriteString("Server: neo146\r\n")
+	if sdp != "" {
+		b.WriteString("Content-Type: application/sdp\r\n")
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(sdp), sdp)
+	return []byte(b.String())
+}
+
+// sipFillRport sets the received and rport parameters of a Via header that
+// asks for them
+func sipFillRport(via string, addr net.Addr) string {
+	params := strings.Split(via, ";")
+	for i, param := range params {
+		if strings.EqualFold(strings.TrimSpace(param), "rport") {
+			params[i] = fmt.Sprintf("rport=%d;received=%s", addrPort(addr), addrIP(addr))
+			return strings.Join(params, ";")
+		}
+	}
+	return via
+}
+
+// sipURI extracts the URI of a name-addr header value, e.g.
+// "Alice" <sip:alice@example.org>;tag=1
+func sipURI(value string) string {
+	if start := strings.Index(value, "<"); start >= 0 {
+		if end := strings.Index(value[start:], ">"); end > 0 {
+			return value[start+1 : start+end]
+		}
+	}
+	uri, _, _ := strings.Cut(value, ";")
+	return strings.TrimSpace(uri)
+}
+
+// sdpOffer is the media offered by a caller
+type sdpOffer struct {
+	addr *net.UDPAddr
+	// eventPayloadType is the payload type of telephone events, -1 if the
+	// caller does not send them
+	eventPayloadType int
+}
+
+// parseSDPOffer reads the address of the first audio stream of an SDP
+// offer, which must include PCMU
+func parseSDPOffer(body string) (sdpOffer, error) {
+	offer := sdpOffer{eventPayloadType: -1}
+	var host string
+	port := 0
+	pcmu := false
+	inAudio := false
+
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "m="):
+			fields := strings.Fields(line[2:])
+			inAudio = len(fields) >= 4 && fields[0] == "audio" && port == 0
+			if inAudio {
+				port, _ = strconv.Atoi(fields[1])
+				for _, format := range fields[3:] {
+					if format == "0" {
+						pcmu = true
+					}
+				}
+			}
+		case strings.HasPrefix(line, "c=") && (inAudio || port == 0):
+			// Session-level, or overridden at the audio stream
+			fields := strings.Fields(line[2:])
+			if len(fields) == 3 {
+				host = fields[2]
+			}
+		case strings.HasPrefix(line, "a=rtpmap:") && inAudio:
+			payloadType, encoding, _ := strings.Cut(line[len("a=rtpmap:"):], " ")
+			if strings.HasPrefix(strings.ToLower(encoding), "telephone-event/8000") {
+				offer.eventPayloadType, _ = strconv.Atoi(payloadType)
+			}
+		}
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || port <= 0 || port > 65535 {
+		return offer, errors.New("no audio stream in SDP offer")
+	}
+	if !pcmu {
+		return offer, errors.New("PCMU not offered")
+	}
+	offer.addr = &net.UDPAddr{IP: ip, Port: port}
+	return offer, nil
+}
+
+// sdpAnswer builds the SDP answer accepting PCMU, and telephone events if
+// offered
+func sdpAnswer(ip string, port int, eventPayloadType int) string {
+	formats := "0"
+	attributes := "a=rtpmap:0 PCMU/8000\r\n"
+	if eventPayloadType >= 0 {
+		formats += " " + strconv.Itoa(eventPayloadType)
+		attributes += fmt.Sprintf("a=rtpmap:%d telephone-event/8000\r\na=fmtp:%d 0-15\r\n", eventPayloadType, eventPayloadType)
+	}
+
+	session := strconv.FormatInt(time.Now().Unix(), 10)
+	return "v=0\r\n" +
+		fmt.Sprintf("o=neo146 %s %s IN IP4 %s\r\n", session, session, ip) +
+		"s=neo146\r\n" +
+		fmt.Sprintf("c=IN IP4 %s\r\n", ip) +
+		"t=0 0\r\n" +
+		fmt.Sprintf("m=audio %d RTP/AVP %s\r\n", port, formats) +
+		attributes +
+		"a=ptime:20\r\n" +
+		"a=sendrecv\r\n"
+}
+
+// sipRandomToken returns a random tag or branch suffix
+func sipRandomToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sipRandomUint32 returns a random SSRC, sequence or timestamp start
+func sipRandomUint32() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
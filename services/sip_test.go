@@ -0,0 +1,336 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/models"
+	"neo146/services/servicestest"
+	"neo146/utils"
+)
+
+// fakeSpeaker records what is said and answers with a tone
+type fakeSpeaker struct {
+	texts chan string
+}
+
+func (f *fakeSpeaker) Speak(text string) ([]int16, error) {
+	f.texts <- text
+	audio := make([]int16, 1600)
+	for i := range audio {
+		audio[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/SpeechSampleRate))
+	}
+	return audio, nil
+}
+
+// next returns the next text said that contains want, failing after a
+// timeout
+func (f *fakeSpeaker) next(t *testing.T, want string) string {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case text := <-f.texts:
+			if strings.Contains(text, want) {
+				return text
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %q to be said", want)
+			return ""
+		}
+	}
+}
+
+// sipTestClient is a stub SIP phone
+type sipTestClient struct {
+	t      *testing.T
+	sip    *net.UDPConn
+	rtp    *net.UDPConn
+	server net.Addr
+	// timestamp is the RTP timestamp of the last event sent
+	timestamp uint32
+}
+
+func newSIPTestClient(t *testing.T, server net.Addr) *sipTestClient {
+	sipConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Error opening SIP client: %v", err)
+	}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Error opening RTP client: %v", err)
+	}
+	t.Cleanup(func() {
+		sipConn.Close()
+		rtpConn.Close()
+	})
+	return &sipTestClient{t: t, sip: sipConn, rtp: rtpConn, server: server}
+}
+
+func (c *sipTestClient) send(message string) {
+	if _, err := c.sip.WriteTo([]byte(strings.ReplaceAll(message, "\n", "\r\n")), c.server); err != nil {
+		c.t.Fatalf("Error sending SIP message: %v", err)
+	}
+}
+
+func (c *sipTestClient) read() *sipMessage {
+	buf := make([]byte, sipMaxMessage)
+	c.sip.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := c.sip.Read(buf)
+	if err != nil {
+		c.t.Fatalf("Error reading SIP message: %v", err)
+	}
+	message, err := parseSIPMessage(buf[:n])
+	if err != nil {
+		c.t.Fatalf("Error parsing SIP message: %v", err)
+	}
+	return message
+}
+
+// invite calls the server and returns its answer, after the 100 Trying
+func (c *sipTestClient) invite(callID string, events bool) *sipMessage {
+	formats, attributes := "0", "a=rtpmap:0 PCMU/8000\n"
+	if events {
+		formats, attributes = "0 96", attributes+"a=rtpmap:96 telephone-event/8000\na=fmtp:96 0-16\n"
+	}
+	sdp := strings.ReplaceAll(fmt.Sprintf("v=0\no=phone 1 1 IN IP4 127.0.0.1\ns=-\nc=IN IP4 127.0.0.1\nt=0 0\nm=audio %d RTP/AVP %s\n%s",
+		c.rtp.LocalAddr().(*net.UDPAddr).Port, formats, attributes), "\n", "\r\n")
+
+	c.send(fmt.Sprintf("INVITE sip:neo146@%s SIP/2.0\n"+
+		"Via: SIP/2.0/UDP %s;branch=z9hG4bK%s;rport\n"+
+		"Max-Forwards: 70\n"+
+		"f: <sip:phone@127.0.0.1>;tag=abc\n"+
+		"t: <sip:neo146@%s>\n"+
+		"i: %s\n"+
+		"CSeq: 1 INVITE\n"+
+		"m: <sip:phone@%s>\n"+
+		"c: application/sdp\n"+
+		"l: %d\n\n", c.server, c.sip.LocalAddr(), callID, c.server, callID, c.sip.LocalAddr(), len(sdp)) + strings.ReplaceAll(sdp, "\r\n", "\n"))
+
+	if trying := c.read(); trying.status != 100 {
+		return trying
+	}
+	return c.read()
+}
+
+// request sends an in-dialog request
+func (c *sipTestClient) request(method, callID, toTag string, cseq int) {
+	c.send(fmt.Sprintf("%s sip:neo146@%s SIP/2.0\n"+
+		"Via: SIP/2.0/UDP %s;branch=z9hG4bK%s%d\n"+
+		"From: <sip:phone@127.0.0.1>;tag=abc\n"+
+		"To: <sip:neo146@%s>;tag=%s\n"+
+		"Call-ID: %s\n"+
+		"CSeq: %d %s\n"+
+		"Content-Length: 0\n\n", method, c.server, c.sip.LocalAddr(), callID, cseq, c.server, toTag, callID, cseq, method))
+}
+
+// sendEvents sends keys as telephone events, each in several packets
+func (c *sipTestClient) sendEvents(media *net.UDPAddr, keys string) {
+	for i := 0; i < len(keys); i++ {
+		code := byte(strings.IndexByte(dtmfEventKeys, keys[i]))
+		c.timestamp += 1000
+		timestamp := c.timestamp
+		for j := 0; j < 5; j++ {
+			end := byte(0)
+			if j >= 2 {
+				end = 0x80
+			}
+			packet := rtpPacket{payloadType: 96, marker: j == 0, sequence: uint16(10*i + j), timestamp: timestamp,
+				payload: []byte{code, end | 10, 0, byte(160 * (j + 1))}}
+			c.rtp.WriteToUDP(packet.marshal(), media)
+		}
+	}
+}
+
+// sendAudio sends 8 kHz audio, e.g. of keys pressed, in PCMU
+func (c *sipTestClient) sendAudio(media *net.UDPAddr, samples []int16) {
+	for i := 0; i+rtpFrameSamples <= len(samples); i += rtpFrameSamples {
+		payload := make([]byte, rtpFrameSamples)
+		for j := range payload {
+			payload[j] = utils.MulawEncode(samples[i+j])
+		}
+		packet := rtpPacket{payloadType: rtpPayloadPCMU, sequence: uint16(i / rtpFrameSamples), timestamp: uint32(i), payload: payload}
+		c.rtp.WriteToUDP(packet.marshal(), media)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// toTag returns the tag the server added to the To header
+func toTag(t *testing.T, response *sipMessage) string {
+	_, tag, found := strings.Cut(response.get("to"), ";tag=")
+	if !found {
+		t.Fatalf("Expected a To tag in %q", response.get("to"))
+	}
+	return tag
+}
+
+func TestSIPServer(t *testing.T) {
+	weatherRequests := make(chan string, 10)
+	weatherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		weatherRequests <- r.URL.Path
+		fmt.Fprint(w, "Ankara:\n☀️ +21°C\n")
+	}))
+	defer weatherServer.Close()
+
+	weatherService := NewWeatherService(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", weatherServer.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})})
+	portalService := NewPortalService(&servicestest.PortalStore{})
+	portalService.AddEntry(models.PortalSectionNews, "Water distribution at noon", "Bring your own containers.")
+
+	speaker := &fakeSpeaker{texts: make(chan string, 100)}
+	server := NewSIPServer(SIPConfig{ListenAddr: "127.0.0.1:0", MaxCalls: 1},
+		&SMSService{WeatherService: weatherService, PortalService: portalService}, speaker, NewRateLimiter(5, time.Hour))
+	server.idleTimeout = time.Second
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	client := newSIPTestClient(t, conn.LocalAddr())
+
+	// A malformed status line is dropped without stopping the server
+	client.send("SIP/2.0 \n\n")
+
+	// A call with telephone events
+	answer := client.invite("call-1", true)
+	if answer.status != 200 || answer.get("call-id") != "call-1" || answer.get("contact") == "" {
+		t.Fatalf("Expected 200 OK, got %d %+v", answer.status, answer.headers)
+	}
+	if via := answer.get("via"); !strings.Contains(via, "received=127.0.0.1") {
+		t.Errorf("Expected rport to be filled in, got %q", via)
+	}
+	offer, err := parseSDPOffer(answer.body)
+	if err != nil || offer.eventPayloadType != 96 {
+		t.Fatalf("Expected an SDP answer with telephone events, got %q (error: %v)", answer.body, err)
+	}
+	tag := toTag(t, answer)
+	client.request("ACK", "call-1", tag, 1)
+
+	speaker.next(t, "Welcome to neo146")
+
+	// The answer is sent as audio
+	buf := make([]byte, 1500)
+	client.rtp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := client.rtp.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected RTP audio: %v", err)
+		}
+		packet, err := parseRTP(buf[:n])
+		if err == nil && packet.payloadType == rtpPayloadPCMU && len(packet.payload) == rtpFrameSamples && packet.payload[0] != 0xff {
+			break
+		}
+	}
+
+	client.sendEvents(offer.addr, "1")
+	speaker.next(t, "city code")
+	client.sendEvents(offer.addr, "06")
+	if text := speaker.next(t, "Ankara"); !strings.Contains(text, "21 degrees") {
+		t.Errorf("Expected the forecast to be read out, got %q", text)
+	}
+	if path := <-weatherRequests; !strings.Contains(path, "Ankara") {
+		t.Errorf("Expected a forecast for Ankara, got %q", path)
+	}
+
+	// Only one call at a time
+	client2 := newSIPTestClient(t, conn.LocalAddr())
+	if busy := client2.invite("call-2", true); busy.status != 486 {
+		t.Errorf("Expected 486 Busy Here, got %d", busy.status)
+	}
+
+	client.request("BYE", "call-1", tag, 2)
+	if ok := client.read(); ok.status != 200 || ok.get("cseq") != "2 BYE" {
+		t.Errorf("Expected 200 OK to BYE, got %d %q", ok.status, ok.get("cseq"))
+	}
+
+	// A call with tones in the audio, hung up by the server when idle
+	answer = client2.invite("call-3", false)
+	if answer.status != 200 || strings.Contains(answer.body, "telephone-event") {
+		t.Fatalf("Expected 200 OK without telephone events, got %d %q", answer.status, answer.body)
+	}
+	offer, _ = parseSDPOffer(answer.body)
+	client2.request("ACK", "call-3", toTag(t, answer), 1)
+	speaker.next(t, "Welcome to neo146")
+
+	// Key 2 as a phone sends it: off-frequency, with twist and noise
+	fixture, err := os.ReadFile(filepath.Join("testdata", "dtmf_2.wav"))
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+	samples, sampleRate, err := utils.ReadWAV(fixture)
+	if err != nil || sampleRate != SpeechSampleRate {
+		t.Fatalf("Error reading WAV fixture: %v (%d Hz)", err, sampleRate)
+	}
+	client2.sendAudio(offer.addr, samples)
+	speaker.next(t, "1: Water distribution at noon")
+	speaker.next(t, "Goodbye")
+
+	bye := client2.read()
+	if bye.method != "BYE" || bye.get("call-id") != "call-3" || !strings.Contains(bye.get("from"), ";tag=") {
+		t.Errorf("Expected BYE from the server, got %q %+v", bye.method, bye.headers)
+	}
+}
+
+func TestSIPCall_Media(t *testing.T) {
+	remote := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Error opening RTP port: %v", err)
+	}
+	defer rtpConn.Close()
+
+	// Media is never sent to a host other than the caller's
+	invite := &sipMessage{method: "INVITE"}
+	call := newSIPCall(nil, invite, remote, sdpOffer{addr: &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 4000}}, rtpConn)
+	if call.remoteRTP != nil {
+		t.Errorf("Expected no media address for another host, got %v", call.remoteRTP)
+	}
+	if call.acceptMedia(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 4000}) {
+		t.Errorf("Expected media from another host to be ignored")
+	}
+
+	// The first media from the caller is latched
+	if !call.acceptMedia(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4002}) || call.remoteRTP.Port != 4002 {
+		t.Errorf("Expected media from the caller to be accepted, got %v", call.remoteRTP)
+	}
+	if call.acceptMedia(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4004}) || call.remoteRTP.Port != 4002 {
+		t.Errorf("Expected the media address to stay latched, got %v", call.remoteRTP)
+	}
+}
+
+func TestParseSIPMessage(t *testing.T) {
+	message, err := parseSIPMessage([]byte("OPTIONS sip:neo146@example.org SIP/2.0\r\n" +
+		"v: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1\r\n" +
+		"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK2\r\n" +
+		"Subject: folded\r\n line\r\n" +
+		"Content-Length: 4\r\n\r\nbodyextra"))
+	if err != nil {
+		t.Fatalf("Error parsing message: %v", err)
+	}
+	if message.method != "OPTIONS" || len(message.all("via")) != 2 || message.get("subject") != "folded line" || message.body != "body" {
+		t.Errorf("Unexpected message %+v", message)
+	}
+
+	if _, err := parseSIPMessage([]byte("SIP/2.0 \r\n\r\n")); err == nil {
+		t.Errorf("Expected error for a status line without status")
+	}
+
+	if uri := sipURI(`"Phone" <sip:phone@192.0.2.1:5062>;tag=1`); uri != "sip:phone@192.0.2.1:5062" {
+		t.Errorf("Unexpected URI %q", uri)
+	}
+	if _, err := parseSDPOffer("v=0\r\nc=IN IP4 192.0.2.1\r\nm=audio 4000 RTP/AVP 8\r\n"); err == nil {
+		t.Errorf("Expected error for an offer without PCMU")
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"log/slog"
 	"neo146/providers"
 	"neo146/utils"
 	"os"
@@ -10,32 +11,75 @@ import (
 
 // SMSService handles SMS operations
 type SMSService struct {
-	ProviderManager *providers.Manager
-	MarkdownService *MarkdownService
-	TwitterService  *TwitterService
-	SearchService   *SearchService
-	WeatherService  *WeatherService
+	ProviderManager  *providers.Manager
+	MarkdownService  *MarkdownService
+	TwitterService   *TwitterService
+	SearchService    *SearchService
+	WeatherService   *WeatherService
+	FeedService      *FeedService
+	PortalService    *PortalService
+	BroadcastService *BroadcastService
+	MailboxService   *MailboxService
+	SummaryService   *SummaryService
+	// E2EService is nil when encrypted SMS are disabled
+	E2EService *E2EService
+	// CostService records spending and limits replies, nil to send
+	// without a budget
+	CostService *CostService
+	// Remainders keeps the rest of shortened replies for "more", nil to
+	// drop it
+	Remainders *ReplyRemainderStore
 }
 
+// smsPartLength is the length of the text in an encoded GW part
+const smsPartLength = 500
+
 // NewSMSService creates a new SMS service
 func NewSMSService(providerManager *providers.Manager) *SMSService {
 	return &SMSService{
 		ProviderManager: providerManager,
+		Remainders:      NewReplyRemainderStore(time.Hour),
 	}
 }
 
 // SendSMS sends SMS messages through the SMS provider
 func (s *SMSService) SendSMS(messages []providers.Message) error {
-	return s.ProviderManager.SendMessage(messages)
+	if err := s.ProviderManager.SendMessage(messages); err != nil {
+		return err
+	}
+	if s.CostService != nil {
+		if err := s.CostService.Record(s.ProviderManager.ProviderName(), messages); err != nil {
+			slog.Error("Error recording SMS cost", "error", err)
+		}
+	}
+	return nil
 }
 
-// PrepareAndSendSMS prepares and sends SMS messages
+// PrepareAndSendSMS prepares and sends SMS messages. Encoded messages are
+// long replies, limited by the budget of the destination.
 func (s *SMSService) PrepareAndSendSMS(content string, destinationAddr string, encode bool) error {
+	if encode {
+		return s.SendReply(content, destinationAddr, 0)
+	}
+	return s.sendContent(content, destinationAddr, false)
+}
+
+// SendReply sends a long reply in encoded GW parts, cut to the budget of the
+// destination or to segments if it is lower and not 0. The rest can be read
+// with "more".
+func (s *SMSService) SendReply(content string, destinationAddr string, segments int) error {
+	// A refusal is short, so it is sent as plain text
+	content, refused := s.applyBudget(content, destinationAddr, segments, false)
+	return s.sendContent(content, destinationAddr, !refused)
+}
+
+// sendContent sends content in encoded GW parts, or as plain text
+func (s *SMSService) sendContent(content string, destinationAddr string, encode bool) error {
 	var smsMessages []providers.Message
 
 	if encode {
 		// Split and encode the message
-		encodedParts := utils.SplitAndEncodeMessage(content, 500)
+		encodedParts := utils.SplitAndEncodeMessage(content, smsPartLength)
 
 		// Create response messages
 		for i, encoded := range encodedParts {
@@ -57,6 +101,88 @@ func (s *SMSService) PrepareAndSendSMS(content string, destinationAddr string, e
 	return s.SendSMS(smsMessages)
 }
 
+// SendSealedSMS encrypts the reply to an encrypted request and sends it in
+// GW parts
+func (s *SMSService) SendSealedSMS(content string, destinationAddr string, requestNonce *[utils.E2ENonceSize]byte) error {
+	// A refused reply is short, so it is still sealed
+	content, _ = s.applyBudget(content, destinationAddr, 0, true)
+	parts, err := s.E2EService.Seal(destinationAddr, requestNonce, content)
+	if err != nil {
+		return err
+	}
+
+	var smsMessages []providers.Message
+	for i, sealed := range parts {
+		smsMessages = append(smsMessages, providers.Message{
+			Msg:  sealed,
+			Dest: destinationAddr,
+			ID:   fmt.Sprintf("%d_%d", time.Now().Unix(), i),
+		})
+	}
+	return s.SendSMS(smsMessages)
+}
+
+// applyBudget shortens a long reply to the parts and segments the budget
+// allows, and to segments if it is lower and not 0, or replaces it with a
+// refusal, which it reports. The rest of a shortened reply is kept for
+// "more". Sealed replies are measured once encrypted.
+func (s *SMSService) applyBudget(content, destinationAddr string, segments int, sealed bool) (string, bool) {
+	var budget ReplyBudget
+	if s.CostService != nil {
+		var err error
+		if budget, err = s.CostService.ReplyBudget(destinationAddr); err != nil {
+			// Replies are not held back because the budget cannot be read
+			slog.Error("Error checking SMS budget", "error", err)
+			budget = ReplyBudget{}
+		}
+	}
+	if budget.Refuse {
+		return budgetExhaustedReply, true
+	}
+
+	maxSegments := limitSegments(budget.MaxSegments, segments)
+	size := encodedSize
+	if sealed {
+		size = sealedSize
+	}
+	fits := func(text string) bool {
+		parts, textSegments := size(text)
+		return (budget.MaxParts == 0 || parts <= budget.MaxParts) &&
+			(maxSegments == 0 || textSegments <= maxSegments)
+	}
+	if fits(content) {
+		return content, false
+	}
+
+	note := ""
+	if budget.MaxParts > 0 {
+		note = budgetLowNote
+	}
+	if s.Remainders != nil {
+		note += moreHint
+	}
+	kept, rest := trimReply(content, func(text string) bool { return fits(text + note) })
+	if s.Remainders != nil && rest != "" {
+		s.Remainders.Save(destinationAddr, rest, sealed)
+	}
+	return kept + note, false
+}
+
+// TakeRemainder returns the rest of the last shortened reply to a phone
+// number, for a sealed reply if it was cut from an encrypted one
+func (s *SMSService) TakeRemainder(destinationAddr string, sealed bool) (string, bool) {
+	if s.Remainders == nil {
+		return "", false
+	}
+	return s.Remainders.Take(destinationAddr, sealed)
+}
+
+// SendAlert sends a broadcast alert to a phone number. Alerts are sent as
+// plain text so that they can be read on any phone.
+func (s *SMSService) SendAlert(address, message string) error {
+	return s.PrepareAndSendSMS(message, address, false)
+}
+
 // CreateSMSRequest creates an SMS request payload
 func (s *SMSService) CreateSMSRequest(messages []providers.Message) map[string]interface{} {
 	return map[string]interface{}{
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	// maxEmailSize is the maximum size of an incoming email
+	maxEmailSize = 1 << 20
+	// maxEmailRecipients is the maximum number of recipients per email
+	maxEmailRecipients = 10
+	// smtpCommandTimeout is how long the server waits for the next command
+	smtpCommandTimeout = 5 * time.Minute
+)
+
+// smtpHandler receives an accepted email with its envelope
+type smtpHandler func(from string, to []string, data []byte)
+
+// smtpServer is a minimal SMTP server (RFC 5321) accepting mail for the
+// recipients allowed by accept. It is meant to sit behind the MTA of the
+// gateway domain, which takes care of TLS and spam filtering.
+type smtpServer struct {
+	tcpServer
+	hostname string
+	accept   func(recipient string) bool
+	handler  smtpHandler
+}
+
+// newSMTPServer creates a new SMTP server
+func newSMTPServer(hostname string, accept func(recipient string) bool, handler smtpHandler) *smtpServer {
+	s := &smtpServer{
+		hostname: hostname,
+		accept:   accept,
+		handler:  handler,
+	}
+	s.tcpServer.handler = s.handleConn
+	return s
+}
+
+// handleConn runs an SMTP session
+func (s *smtpServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) {
+		if err := text.PrintfLine(format, args...); err != nil {
+			slog.Error("Error writing SMTP reply", "error", err)
+		}
+	}
+
+	var from string
+	var to []string
+	inTransaction := false
+
+	reply("220 %s ESMTP neo146", s.hostname)
+	for {
+		conn.SetDeadline(time.Now().Add(smtpCommandTimeout))
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply("250 %s", s.hostname)
+		case "EHLO":
+			reply("250-%s", s.hostname)
+			reply("250-8BITMIME")
+			reply("250 SIZE %d", maxEmailSize)
+		case "MAIL":
+			address, ok := smtpPathArg(arg, "FROM:")
+			if !ok {
+				reply("501 Syntax: MAIL FROM:<address>")
+				continue
+			}
+			from, to, inTransaction = address, nil, true
+			reply("250 OK")
+		case "RCPT":
+			address, ok := smtpPathArg(arg, "TO:")
+			switch {
+			case !inTransaction:
+				reply("503 Need MAIL command")
+			case !ok:
+				reply("501 Syntax: RCPT TO:<address>")
+			case len(to) >= maxEmailRecipients:
+				reply("452 Too many recipients")
+			case !s.accept(address):
+				reply("550 No such user")
+			default:
+				to = append(to, address)
+				reply("250 OK")
+			}
+		case "DATA":
+			if len(to) == 0 {
+				reply("503 Need RCPT command")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			body := text.DotReader()
+			data, err := io.ReadAll(io.LimitReader(body, maxEmailSize+1))
+			if err != nil {
+				return
+			}
+			if len(data) > maxEmailSize {
+				// Discard the rest of the message before answering
+				io.Copy(io.Discard, body)
+				reply("552 Message too large")
+			} else {
+				reply("250 OK")
+				go s.handler(from, to, data)
+			}
+			from, to, inTransaction = "", nil, false
+		case "RSET":
+			from, to, inTransaction = "", nil, false
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// smtpPathArg extracts the address from a MAIL or RCPT argument such as
+// "FROM:<user@example.org> SIZE=100". The null path "<>" is returned as "".
+func smtpPathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	start := strings.Index(path, "<")
+	end := strings.Index(path, ">")
+	if start != 0 || end < start {
+		return "", false
+	}
+	return path[1:end], true
+}
+
+// smtpDomain returns the domain part of an email address
+func smtpDomain(address string) string {
+	if _, domain, found := strings.Cut(address, "@"); found {
+		return domain
+	}
+	return address
+}
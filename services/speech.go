@@ -0,0 +1,174 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"neo146/utils"
+)
+
+const (
+	// SpeechSampleRate is the sample rate of speech for telephone audio
+	SpeechSampleRate = 8000
+	// speechCommandTimeout is how long a speech synthesizer may run
+	speechCommandTimeout = 15 * time.Second
+)
+
+// Speaker turns text into audio at SpeechSampleRate
+type Speaker interface {
+	Speak(text string) ([]int16, error)
+}
+
+// SegmentSpeaker speaks text by joining pre-recorded segments: a WAV file
+// per word, named after the word in lower case (e.g. "weather.wav",
+// "ankara.wav", "7.wav"). Numbers are read digit by digit, and words without
+// a recording are skipped.
+type SegmentSpeaker struct {
+	segments map[string][]int16
+}
+
+// NewSegmentSpeaker loads the WAV files of a directory
+func NewSegmentSpeaker(dir string) (*SegmentSpeaker, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil {
+		return nil, err
+	}
+
+	speaker := &SegmentSpeaker{segments: make(map[string][]int16)}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		samples, sampleRate, err := utils.ReadWAV(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		word := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		speaker.segments[word] = utils.Resample(samples, sampleRate, SpeechSampleRate)
+	}
+	if len(speaker.segments) == 0 {
+		return nil, fmt.Errorf("no WAV files in %s", dir)
+	}
+	return speaker, nil
+}
+
+// Speak joins the recordings of the words of text with short pauses
+func (s *SegmentSpeaker) Speak(text string) ([]int16, error) {
+	pause := make([]int16, SpeechSampleRate/12)
+	var samples []int16
+	for _, word := range speechWords(text) {
+		if segment, ok := s.segments[word]; ok {
+			samples = append(samples, segment...)
+			samples = append(samples, pause...)
+		}
+	}
+	return samples, nil
+}
+
+// speechWords splits text into lower case words and single digits
+func speechWords(text string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsDigit(r):
+			flush()
+			words = append(words, string(r))
+		case unicode.IsLetter(r) || r == '\'':
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// CommandSpeaker speaks text with an external speech synthesizer that
+// writes a WAV file to standard output, e.g. "espeak-ng --stdout -v tr".
+// The text is passed as the last argument.
+type CommandSpeaker struct {
+	command []string
+}
+
+// NewCommandSpeaker creates a speaker running command, split on spaces
+func NewCommandSpeaker(command string) *CommandSpeaker {
+	return &CommandSpeaker{command: strings.Fields(command)}
+}
+
+// Speak runs the synthesizer and returns its audio
+func (s *CommandSpeaker) Speak(text string) ([]int16, error) {
+	if len(s.command) == 0 {
+		return nil, fmt.Errorf("no speech command configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), speechCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], append(s.command[1:], text)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running speech command: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	samples, sampleRate, err := utils.ReadWAV(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error reading speech audio: %v", err)
+	}
+	return utils.Resample(samples, sampleRate, SpeechSampleRate), nil
+}
+
+// speechText rewrites symbols that speech synthesizers read badly and drops
+// those they cannot read, such as emoji
+func speechText(text string) string {
+	// Lines become sentences, so they are read with a pause
+	var sentences []string
+	for _, line := range strings.Split(speechReplacements.Replace(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.ContainsAny(line[len(line)-1:], ".:!?,") {
+			line += "."
+		}
+		sentences = append(sentences, line)
+	}
+	text = strings.Join(sentences, " ")
+
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r):
+			b.WriteRune(r)
+		case strings.ContainsRune(".,:;!?'-", r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// speechReplacements spells out units used by the weather and news services
+var speechReplacements = strings.NewReplacer(
+	"°C", " degrees", "°F", " degrees Fahrenheit", "°", " degrees",
+	"km/h", " kilometers per hour", "%", " percent",
+	"+", " ", "#", " ", "*", " ",
+)
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// SSTVSampleRate is the sample rate of rendered audio, enough for the
+	// SSTV tones up to 2300 Hz while keeping files small
+	SSTVSampleRate = 11025
+	// sstvMaxImageSize is the maximum size of a fetched image in bytes
+	sstvMaxImageSize = 10 << 20
+	// sstvMaxPixels guards against images that are small files but decode
+	// to huge pictures, it allows 12 megapixel photos
+	sstvMaxPixels = 12_000_000
+	// sstvMaxJobs is the number of jobs kept, older finished jobs are
+	// dropped
+	sstvMaxJobs = 20
+	// sstvMaxRenders is the number of pictures rendered at once for requests
+	sstvMaxRenders = 2
+)
+
+// ErrSSTVBusy is returned by Render when too many pictures are being
+// rendered
+var ErrSSTVBusy = errors.New("too many SSTV pictures are being rendered, try again later")
+
+// SSTV text picture colors
+var (
+	sstvBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	sstvForeground = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	sstvTitleBar   = color.RGBA{0x10, 0x30, 0x80, 0xff}
+)
+
+// SSTVService renders pictures of web pages and images as SSTV audio, and
+// runs rendering jobs for radio operators in the background
+type SSTVService struct {
+	httpClient      *http.Client
+	markdownService *MarkdownService
+	jobs            map[int64]*sstvJob
+	nextID          int64
+	queue           chan int64
+	renders         chan struct{}
+	mu              sync.Mutex
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+// sstvJob is a job with its rendered audio
+type sstvJob struct {
+	models.SSTVJob
+	audio []byte
+}
+
+// NewSSTVService creates a new SSTV service. Images are fetched with
+// httpClient, other pages are rendered as text through markdownService.
+func NewSSTVService(httpClient *http.Client, markdownService *MarkdownService) *SSTVService {
+	return &SSTVService{
+		httpClient:      httpClient,
+		markdownService: markdownService,
+		jobs:            make(map[int64]*sstvJob),
+		queue:           make(chan int64, sstvMaxJobs),
+		renders:         make(chan struct{}, sstvMaxRenders),
+		stop:            make(chan struct{}),
+	}
+}
+
+// Render fetches uri and returns its picture as a WAV file in the named
+// mode, Robot36 if empty. At most sstvMaxRenders pictures are rendered at
+// once, further requests fail with ErrSSTVBusy.
+func (s *SSTVService) Render(uri, modeName string) ([]byte, error) {
+	select {
+	case s.renders <- struct{}{}:
+		defer func() { <-s.renders }()
+	default:
+		return nil, ErrSSTVBusy
+	}
+	return s.render(uri, modeName)
+}
+
+// render fetches uri and returns its picture as a WAV file
+func (s *SSTVService) render(uri, modeName string) ([]byte, error) {
+	mode, err := sstvMode(modeName)
+	if err != nil {
+		return nil, err
+	}
+
+	picture, err := s.Picture(uri, mode.Width, mode.Height)
+	if err != nil {
+		return nil, err
+	}
+	return utils.WriteWAV(utils.SSTVEncode(picture, mode, SSTVSampleRate), SSTVSampleRate), nil
+}
+
+// Picture fetches uri and returns it as a picture of the given size: images
+// are scaled down, and other pages are rendered as text
+func (s *SSTVService) Picture(uri string, width, height int) (image.Image, error) {
+	if !utils.IsURL(uri) {
+		return nil, errors.New("invalid URL")
+	}
+
+	// Only the headers are fetched to tell images from pages, so pages are
+	// downloaded once, by the Markdown converter
+	resp, err := s.httpClient.Head(uri)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", uri, resp.StatusCode)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return s.fetchImage(uri)
+	}
+
+	markdown, err := s.markdownService.FetchMarkdown(uri)
+	if err != nil {
+		return nil, err
+	}
+	title := uri
+	if parsed, err := url.Parse(uri); err == nil {
+		title = parsed.Host + parsed.Path
+	}
+	return renderSSTVText(title, sstvPlainText(markdown), width, height), nil
+}
+
+// fetchImage fetches and decodes an image
+func (s *SSTVService) fetchImage(uri string) (image.Image, error) {
+	resp, err := s.httpClient.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", uri, resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, fmt.Errorf("%s is not an image", uri)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, sstvMaxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > sstvMaxImageSize {
+		return nil, fmt.Errorf("image is larger than %d MB", sstvMaxImageSize>>20)
+	}
+	return decodeSSTVImage(data)
+}
+
+// QueueJob queues a job rendering uri in the named mode
+func (s *SSTVService) QueueJob(uri, modeName string) (*models.SSTVJob, error) {
+	mode, err := sstvMode(modeName)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.IsURL(uri) {
+		return nil, errors.New("invalid URL")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == cap(s.queue) {
+		return nil, errors.New("too many queued jobs, try again later")
+	}
+
+	s.nextID++
+	job := &sstvJob{SSTVJob: models.SSTVJob{
+		ID:        s.nextID,
+		URI:       uri,
+		Mode:      mode.Name,
+		Status:    models.SSTVJobStatusQueued,
+		CreatedAt: time.Now(),
+	}}
+	s.jobs[job.ID] = job
+	s.prune()
+	s.queue <- job.ID
+	utils.QueueDepth.Set(float64(len(s.queue)), "sstv")
+
+	copied := job.SSTVJob
+	return &copied, nil
+}
+
+// Job returns a job
+func (s *SSTVService) Job(id int64) (*models.SSTVJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("SSTV job %d not found", id)
+	}
+	copied := job.SSTVJob
+	return &copied, nil
+}
+
+// JobAudio returns the WAV file of a finished job
+func (s *SSTVService) JobAudio(id int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("SSTV job %d not found", id)
+	}
+	if job.Status != models.SSTVJobStatusDone {
+		return nil, fmt.Errorf("SSTV job %d is %s", id, job.Status)
+	}
+	return job.audio, nil
+}
+
+// Start runs queued jobs one at a time until Stop is called
+func (s *SSTVService) Start() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case id := <-s.queue:
+			utils.QueueDepth.Set(float64(len(s.queue)), "sstv")
+			s.run(id)
+		}
+	}
+}
+
+// Stop stops running jobs
+func (s *SSTVService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// run renders a job and stores the result
+func (s *SSTVService) run(id int64) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	audio, err := s.render(job.URI, job.Mode)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		slog.Error("Error rendering SSTV job", "job", id, "error", err)
+		job.Status = models.SSTVJobStatusFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Status = models.SSTVJobStatusDone
+	job.audio = audio
+}
+
+// prune drops the oldest finished jobs beyond sstvMaxJobs. Queued jobs are
+// limited by the queue.
+func (s *SSTVService) prune() {
+	for len(s.jobs) > sstvMaxJobs {
+		oldest := int64(0)
+		for id, job := range s.jobs {
+			if job.Status != models.SSTVJobStatusQueued && (oldest == 0 || id < oldest) {
+				oldest = id
+			}
+		}
+		if oldest == 0 {
+			return
+		}
+		delete(s.jobs, oldest)
+	}
+}
+
+// sstvMode looks up a mode by name, Robot36 if empty
+func sstvMode(name string) (*utils.SSTVMode, error) {
+	if name == "" {
+		return utils.SSTVRobot36, nil
+	}
+	mode, ok := utils.SSTVModeByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown SSTV mode %q, use robot36 or martin1", name)
+	}
+	return mode, nil
+}
+
+// decodeSSTVImage decodes a GIF, JPEG or PNG image, refusing huge ones
+// before decoding them
+func decodeSSTVImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+	if config.Width*config.Height > sstvMaxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+	return img, nil
+}
+
+// sstvPlainText reduces Markdown to the text worth showing in a picture,
+// without link lines and code fences
+func sstvPlainText(markdown string) string {
+	var lines []string
+	for _, line := range strings.Split(utils.MarkdownToGemtext(markdown), "\n") {
+		if strings.HasPrefix(line, "=>") || strings.HasPrefix(line, "```") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// renderSSTVText renders text like a screenshot: a title bar and the text
+// wrapped below it, as much as fits. The font only has ASCII, so other
+// characters are transliterated.
+func renderSSTVText(title, text string, width, height int) image.Image {
+	face := basicfont.Face7x13
+	columns := (width - 4) / face.Advance
+	rows := (height - face.Height - 6) / face.Height
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(sstvBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, width, face.Height+4), image.NewUniform(sstvTitleBar), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{Dst: img, Src: image.NewUniform(sstvBackground), Face: face}
+	drawLine := func(y int, line string) {
+		drawer.Dot = fixed.P(2, y+face.Ascent)
+		drawer.DrawString(line)
+	}
+
+	titleLines := wrapText(aprsASCII(title), columns)
+	if len(titleLines) > 0 {
+		drawLine(2, titleLines[0])
+	}
+
+	// Blank lines are kept for paragraphs, but not in runs
+	var lines []string
+	for _, line := range wrapText(aprsASCII(text), columns) {
+		if strings.TrimSpace(line) == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	drawer.Src = image.NewUniform(sstvForeground)
+	for i, line := range lines {
+		if i == rows {
+			break
+		}
+		drawLine(face.Height+6+i*face.Height, line)
+	}
+	return img
+}
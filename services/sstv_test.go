@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+// newSSTVTestService returns an SSTV service whose requests, including
+// those to the Markdown converter, are served by a local server, and the
+// requests made to the page
+func newSSTVTestService(t *testing.T) (*SSTVService, *[]string) {
+	logo := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := range logo.Pix {
+		logo.Pix[i] = 0xff
+	}
+	var logoPNG bytes.Buffer
+	png.Encode(&logoPNG, logo)
+
+	var mu sync.Mutex
+	var pageRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			mu.Lock()
+			pageRequests = append(pageRequests, r.Method)
+			mu.Unlock()
+		}
+
+		switch {
+		case r.Host == "urltomarkdown.herokuapp.com":
+			fmt.Fprint(w, "# Deprem bilgilendirme\n\nToplanma alanları [listesi](https://example.org/alanlar) güncellendi.\n\n\n\nSu dağıtımı 14:00'te.")
+		case r.URL.Path == "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(logoPNG.Bytes())
+		case r.URL.Path == "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<h1>Deprem bilgilendirme</h1>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", server.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	return NewSSTVService(client, NewMarkdownService(client)), &pageRequests
+}
+
+func TestSSTVService_Render(t *testing.T) {
+	service, _ := newSSTVTestService(t)
+
+	wav, err := service.Render("https://example.org/logo.png", "")
+	if err != nil {
+		t.Fatalf("Error rendering image: %v", err)
+	}
+	samples, sampleRate, err := utils.ReadWAV(wav)
+	if err != nil || sampleRate != SSTVSampleRate {
+		t.Fatalf("Expected WAV at %d Hz, got %d Hz (error: %v)", SSTVSampleRate, sampleRate, err)
+	}
+	if seconds := float64(len(samples)) / float64(sampleRate); seconds < 36.5 || seconds > 37.5 {
+		t.Errorf("Expected a Robot36 transmission of about 37 seconds, got %.1f", seconds)
+	}
+
+	if _, err := service.Render("https://example.org/logo.png", "scottie9"); err == nil {
+		t.Errorf("Expected error for an unknown mode")
+	}
+	if _, err := service.Render("https://example.org/missing.png", ""); err == nil {
+		t.Errorf("Expected error for a missing page")
+	}
+
+	// Requests beyond the renders in progress are refused
+	for i := 0; i < sstvMaxRenders; i++ {
+		service.renders <- struct{}{}
+	}
+	if _, err := service.Render("https://example.org/logo.png", ""); !errors.Is(err, ErrSSTVBusy) {
+		t.Errorf("Expected renders to be bounded, got: %v", err)
+	}
+}
+
+func TestSSTVService_RefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request to reach the loopback address")
+	}))
+	defer server.Close()
+
+	service := NewSSTVService(&http.Client{Transport: utils.NewPublicTransport()}, nil)
+	if _, err := service.Render(server.URL+"/logo.png", ""); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected image on 127.0.0.1 to be refused, got: %v", err)
+	}
+}
+
+func TestSSTVService_PictureText(t *testing.T) {
+	service, pageRequests := newSSTVTestService(t)
+
+	picture, err := service.Picture("https://example.org/page", 320, 256)
+	if err != nil {
+		t.Fatalf("Error rendering page: %v", err)
+	}
+	// The page itself is left to the Markdown converter
+	if len(*pageRequests) != 1 || (*pageRequests)[0] != http.MethodHead {
+		t.Errorf("Expected only a HEAD request for the page, got %v", *pageRequests)
+	}
+	if c := color.RGBAModel.Convert(picture.At(1, 1)); c != sstvTitleBar {
+		t.Errorf("Expected a title bar, got %v", c)
+	}
+
+	// Some text is drawn below the title bar
+	dark := 0
+	for y := 20; y < 60; y++ {
+		for x := 0; x < 320; x++ {
+			if r, _, _, _ := picture.At(x, y).RGBA(); r < 0x8000 {
+				dark++
+			}
+		}
+	}
+	if dark == 0 {
+		t.Errorf("Expected text below the title bar")
+	}
+
+	text := sstvPlainText("# Title\n\nSee [the list](https://example.org/a).\n```\ncode\n```")
+	if strings.Contains(text, "=>") || strings.Contains(text, "```") {
+		t.Errorf("Expected links and fences to be removed, got %q", text)
+	}
+}
+
+func TestSSTVService_Jobs(t *testing.T) {
+	service, _ := newSSTVTestService(t)
+
+	job, err := service.QueueJob("https://example.org/logo.png", "m1")
+	if err != nil {
+		t.Fatalf("Error queueing job: %v", err)
+	}
+	if job.Status != models.SSTVJobStatusQueued || job.Mode != "martin1" {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if _, err := service.JobAudio(job.ID); err == nil {
+		t.Errorf("Expected error for the audio of a queued job")
+	}
+
+	failing, _ := service.QueueJob("https://example.org/missing.png", "robot36")
+	service.run(<-service.queue)
+	service.run(<-service.queue)
+
+	if job, _ := service.Job(job.ID); job.Status != models.SSTVJobStatusDone || job.FinishedAt == nil {
+		t.Errorf("Expected job to be done, got %+v", job)
+	}
+	if wav, err := service.JobAudio(job.ID); err != nil || !bytes.HasPrefix(wav, []byte("RIFF")) {
+		t.Errorf("Expected WAV audio, error: %v", err)
+	}
+	if failing, _ := service.Job(failing.ID); failing.Status != models.SSTVJobStatusFailed || failing.Error == "" {
+		t.Errorf("Expected job to fail, got %+v", failing)
+	}
+
+	if _, err := service.QueueJob("not a url", ""); err == nil {
+		t.Errorf("Expected error for an invalid URL")
+	}
+	if _, err := service.Job(99); err == nil {
+		t.Errorf("Expected error for an unknown job")
+	}
+}
@@ -1,7 +1,7 @@
 package services
 
 import (
-	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -18,29 +18,28 @@ func NewSubscriptionService() *SubscriptionService {
 // SaveSubscription saves a new subscription
 func (s *SubscriptionService) SaveSubscription(subscriptionID string, email string, status string, expiryDate time.Time) error {
 	// In a real implementation, this would save to the database
-	fmt.Printf("Saving subscription: %s for %s with status %s until %s\n",
-		subscriptionID, email, status, expiryDate.Format(time.RFC3339))
+	slog.Info("Saving subscription", "subscription_id", subscriptionID, "email", email, "status", status, "expiry", expiryDate)
 	return nil
 }
 
 // UpdateSubscriptionStatus updates a subscription's status
 func (s *SubscriptionService) UpdateSubscriptionStatus(subscriptionID string, status string) error {
 	// In a real implementation, this would update the database
-	fmt.Printf("Updating subscription %s to status %s\n", subscriptionID, status)
+	slog.Info("Updating subscription", "subscription_id", subscriptionID, "status", status)
 	return nil
 }
 
 // LinkPhoneToSubscription links a phone number to a subscription
 func (s *SubscriptionService) LinkPhoneToSubscription(phoneNumber string, email string) error {
 	// In a real implementation, this would update the database
-	fmt.Printf("Linking phone %s to subscription for %s\n", phoneNumber, email)
+	slog.Info("Linking phone to subscription", "phone", phoneNumber, "email", email)
 	return nil
 }
 
 // UpdateRateLimitForPhone updates the rate limit for a phone number
 func (s *SubscriptionService) UpdateRateLimitForPhone(phoneNumber string, limit int) error {
 	// In a real implementation, this would update the database
-	fmt.Printf("Updating rate limit for phone %s to %d\n", phoneNumber, limit)
+	slog.Info("Updating rate limit", "phone", phoneNumber, "limit", limit)
 	return nil
 }
 
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// summarySentenceLimit is the number of sentences from the start of a page
+// that are ranked, which bounds the work on long pages
+const summarySentenceLimit = 200
+
+// textRankDamping is the damping factor of TextRank
+const textRankDamping = 0.85
+
+// SummaryService summarizes web pages by extracting their most central
+// sentences with TextRank, without any external service
+type SummaryService struct {
+	markdownService *MarkdownService
+	length          int
+}
+
+// NewSummaryService creates a new summary service whose summaries take at
+// most length characters
+func NewSummaryService(markdownService *MarkdownService, length int) *SummaryService {
+	return &SummaryService{
+		markdownService: markdownService,
+		length:          length,
+	}
+}
+
+// Summarize fetches a page and returns its summary
+func (s *SummaryService) Summarize(url string) (string, error) {
+	markdown, err := s.markdownService.FetchMarkdown(url)
+	if err != nil {
+		return "", err
+	}
+
+	summary := summarize(markdown, s.length)
+	if summary == "" {
+		return "", fmt.Errorf("no text to summarize")
+	}
+	return summary, nil
+}
+
+// summarize returns the sentences of Markdown text that rank highest with
+// TextRank, in their original order and within limit characters
+func summarize(markdown string, limit int) string {
+	sentences := splitSentences(markdownText(markdown))
+	if len(sentences) > summarySentenceLimit {
+		sentences = sentences[:summarySentenceLimit]
+	}
+	if len(sentences) == 0 {
+		return ""
+	}
+	if text := strings.Join(sentences, " "); len([]rune(text)) <= limit {
+		return text
+	}
+
+	turkish := isTurkish(strings.Join(sentences, " "))
+	words := make([][]string, len(sentences))
+	for i, sentence := range sentences {
+		words[i] = sentenceWords(sentence, turkish)
+	}
+	scores := textRank(words)
+
+	// Sentences are taken by rank, or by position at equal rank, while they
+	// fit
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	selected := make([]bool, len(sentences))
+	length := -1
+	for _, i := range order {
+		if sentenceLength := len([]rune(sentences[i])) + 1; length+sentenceLength <= limit {
+			selected[i] = true
+			length += sentenceLength
+		}
+	}
+	if length < 0 {
+		return shortenText(sentences[order[0]], limit)
+	}
+
+	var summary []string
+	for i, sentence := range sentences {
+		if selected[i] {
+			summary = append(summary, sentence)
+		}
+	}
+	return strings.Join(summary, " ")
+}
+
+// textRank scores sentences, given by their words, by how much they share
+// with other central sentences
+func textRank(words [][]string) []float64 {
+	n := len(words)
+	weights := make([][]float64, n)
+	for i := range weights {
+		weights[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			weights[i][j] = similarity(words[i], words[j])
+			weights[j][i] = weights[i][j]
+		}
+	}
+
+	totals := make([]float64, n)
+	for i := range weights {
+		for _, weight := range weights[i] {
+			totals[i] += weight
+		}
+	}
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1
+	}
+	for iteration := 0; iteration < 100; iteration++ {
+		next := make([]float64, n)
+		change := 0.0
+		for i := 0; i < n; i++ {
+			sum := 0.0
+			for j := 0; j < n; j++ {
+				if weights[j][i] > 0 {
+					sum += weights[j][i] / totals[j] * scores[j]
+				}
+			}
+			next[i] = 1 - textRankDamping + textRankDamping*sum
+			change = math.Max(change, math.Abs(next[i]-scores[i]))
+		}
+		scores = next
+		if change < 1e-6 {
+			break
+		}
+	}
+	return scores
+}
+
+// similarity is the TextRank similarity of two sentences: the words they
+// share, normalized by their lengths
+func similarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for _, word := range a {
+		for _, other := range b {
+			if word == other {
+				shared++
+				break
+			}
+		}
+	}
+	if shared == 0 {
+		return 0
+	}
+	return float64(shared) / (math.Log(float64(len(a))+1) + math.Log(float64(len(b))+1))
+}
+
+var (
+	codeBlockPattern = regexp.MustCompile("(?s)```.*?```")
+	imagePattern     = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	linkPattern      = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	bareURLPattern   = regexp.MustCompile(`https?://\S+`)
+	listItemPattern  = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+	emphasisReplacer = strings.NewReplacer("**", "", "__", "", "*", "", "`", "", "~~", "")
+)
+
+// markdownText returns the paragraphs of Markdown as plain text lines,
+// without headings, tables, code and link targets
+func markdownText(markdown string) string {
+	markdown = codeBlockPattern.ReplaceAllString(markdown, "")
+	markdown = imagePattern.ReplaceAllString(markdown, "")
+	markdown = linkPattern.ReplaceAllString(markdown, "$1")
+	markdown = bareURLPattern.ReplaceAllString(markdown, "")
+
+	var lines []string
+	for _, line := range strings.Split(markdown, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "|") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimLeft(line, "> "))
+		line = listItemPattern.ReplaceAllString(line, "")
+		line = strings.Join(strings.Fields(emphasisReplacer.Replace(line)), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// abbreviations are English and Turkish abbreviations whose period does not
+// end a sentence, lowercase and without their last period
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true,
+	"jr": true, "sr": true, "vs": true, "etc": true, "e.g": true, "i.e": true,
+	"no": true, "inc": true, "ltd": true, "co": true, "fig": true, "approx": true,
+	"doç": true, "av": true, "vb": true, "vd": true, "bkz": true, "örn": true,
+	"sn": true, "yy": true, "şti": true, "a.ş": true, "cad": true, "sok": true,
+	"mah": true, "t.c": true, "m.ö": true, "m.s": true, "yrd": true, "alb": true,
+}
+
+// splitSentences splits lines of text into sentences. A period after an
+// abbreviation, an initial or an ordinal such as the Turkish "19. yüzyıl"
+// does not end a sentence.
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		start := 0
+		for i := 0; i < len(runes); i++ {
+			if !strings.ContainsRune(".!?…", runes[i]) {
+				continue
+			}
+			// Closing punctuation and quotes belong to the sentence
+			end := i + 1
+			for end < len(runes) && strings.ContainsRune(".!?…\"'”’)»", runes[end]) {
+				end++
+			}
+			if end < len(runes) && !isSentenceBreak(runes, start, i, end) {
+				i = end - 1
+				continue
+			}
+			sentences = appendSentence(sentences, string(runes[start:end]), true)
+			start, i = end, end-1
+		}
+		sentences = appendSentence(sentences, string(runes[start:]), false)
+	}
+	return sentences
+}
+
+// isSentenceBreak checks if the punctuation at i, followed by closing
+// punctuation up to end, ends a sentence that began at start
+func isSentenceBreak(runes []rune, start, i, end int) bool {
+	if !unicode.IsSpace(runes[end]) {
+		return false
+	}
+	next := end
+	for next < len(runes) && unicode.IsSpace(runes[next]) {
+		next++
+	}
+	if next == len(runes) {
+		return true
+	}
+	if !unicode.IsUpper(runes[next]) && !unicode.IsDigit(runes[next]) && !strings.ContainsRune("\"'“‘(«", runes[next]) {
+		return false
+	}
+	if runes[i] != '.' || end != i+1 {
+		return true
+	}
+
+	// The word before the period decides
+	wordStart := i
+	for wordStart > start && !unicode.IsSpace(runes[wordStart-1]) {
+		wordStart--
+	}
+	word := strings.ToLower(strings.TrimLeft(string(runes[wordStart:i]), "(\"'“‘«"))
+	if abbreviations[word] {
+		return false
+	}
+	if letters := []rune(word); len(letters) == 1 && unicode.IsLetter(letters[0]) {
+		return false
+	}
+	if len(word) > 0 && len(word) <= 2 && strings.Trim(word, "0123456789") == "" {
+		return false
+	}
+	return true
+}
+
+// appendSentence appends a sentence if it has at least three words, or
+// eight without a final punctuation, as shorter ones are mostly navigation,
+// list items and captions
+func appendSentence(sentences []string, sentence string, terminated bool) []string {
+	words := 0
+	for _, field := range strings.Fields(sentence) {
+		if strings.IndexFunc(field, unicode.IsLetter) >= 0 {
+			words++
+		}
+	}
+	if words < 3 || (!terminated && words < 8) {
+		return sentences
+	}
+	return append(sentences, strings.TrimSpace(sentence))
+}
+
+// englishStopWords and turkishStopWords are common words that carry no
+// meaning for ranking, and tell the language of a text
+var (
+	englishStopWords = stopWords("a an and are as at be been but by can could for from had has have he her his if in into is it its more most not of on or our she so such than that the their them there these they this to was we were which who will with would you")
+	turkishStopWords = stopWords("ama ancak ayrıca bir biri bu bunu çok da daha de değil diye en gibi göre hem her için ile ise kadar ki mi ne o olan olarak olduğu olduğunu sonra şu tarafından ve veya ya yani yok var")
+)
+
+func stopWords(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// isTurkish checks if text has more Turkish than English stop words
+func isTurkish(text string) bool {
+	turkish, english := 0, 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isWordSeparator) {
+		if turkishStopWords[word] {
+			turkish++
+		}
+		if englishStopWords[word] {
+			english++
+		}
+	}
+	return turkish > english
+}
+
+// sentenceWords returns the distinct stems of the words of a sentence,
+// without stop words
+func sentenceWords(sentence string, turkish bool) []string {
+	if turkish {
+		sentence = strings.ToLowerSpecial(unicode.TurkishCase, sentence)
+	} else {
+		sentence = strings.ToLower(sentence)
+	}
+
+	seen := make(map[string]bool)
+	var words []string
+	for _, word := range strings.FieldsFunc(sentence, isWordSeparator) {
+		// Suffixes after an apostrophe, e.g. "İstanbul'da", are dropped
+		word, _, _ = strings.Cut(strings.ReplaceAll(word, "’", "'"), "'")
+		if englishStopWords[word] || turkishStopWords[word] || len([]rune(word)) < 2 {
+			continue
+		}
+		word = stem(word, turkish)
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// isWordSeparator checks if a rune separates words, apostrophes are kept
+// within words
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '’'
+}
+
+// stem reduces a word to a crude stem. Turkish words are cut to their first
+// five letters, which is known to work well for its suffixes, English words
+// lose their plural.
+func stem(word string, turkish bool) string {
+	runes := []rune(word)
+	if turkish {
+		if len(runes) > 5 {
+			return string(runes[:5])
+		}
+		return word
+	}
+	switch {
+	case len(runes) > 4 && strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case len(runes) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
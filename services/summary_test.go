@@ -0,0 +1,63 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := map[string][]string{
+		"Dr. Smith arrived at noon. He met Mr. J. R. Jones, e.g. the mayor. Was it late? Yes it was!": {
+			"Dr. Smith arrived at noon.",
+			"He met Mr. J. R. Jones, e.g. the mayor.",
+			"Was it late?",
+			"Yes it was!",
+		},
+		"He said \"the radios worked.\" Then he left in 1999. It rained after that": {
+			"He said \"the radios worked.\"",
+			"Then he left in 1999.",
+		},
+		"Doç. Dr. Ayşe Demir konuştu. 1. Dünya Savaşı'ndan sonra, 19. yüzyıldaki gibi telsiz kullanıldı. İzmir'de A.Ş. kuruldu.": {
+			"Doç. Dr. Ayşe Demir konuştu.",
+			"1. Dünya Savaşı'ndan sonra, 19. yüzyıldaki gibi telsiz kullanıldı.",
+			"İzmir'de A.Ş. kuruldu.",
+		},
+		"Home | News\nKeep messages short": nil,
+	}
+	for text, expected := range tests {
+		if sentences := splitSentences(text); !reflect.DeepEqual(sentences, expected) {
+			t.Errorf("splitSentences(%q) = %q, expected %q", text, sentences, expected)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	for _, language := range []string{"en", "tr"} {
+		markdown, err := os.ReadFile(filepath.Join("testdata", "summary_"+language+".md"))
+		if err != nil {
+			t.Fatalf("Error reading fixture: %v", err)
+		}
+		expected, err := os.ReadFile(filepath.Join("testdata", "summary_"+language+".txt"))
+		if err != nil {
+			t.Fatalf("Error reading fixture: %v", err)
+		}
+
+		summary := summarize(string(markdown), 480)
+		if summary != strings.TrimSpace(string(expected)) {
+			t.Errorf("Unexpected %s summary: %q", language, summary)
+		}
+		if len([]rune(summary)) > 480 || strings.Contains(summary, "http") || strings.Contains(summary, "#") {
+			t.Errorf("Expected a plain %s summary within 480 characters, got %q", language, summary)
+		}
+	}
+
+	if summary := summarize("# Title\n\nA short page with one sentence.", 480); summary != "A short page with one sentence." {
+		t.Errorf("Expected short pages to be returned whole, got %q", summary)
+	}
+	if summary := summarize("One long sentence that does not fit the limit at all.\n\nAnother long sentence that does not fit.", 20); summary != "One long sentence" {
+		t.Errorf("Expected the best sentence to be cut, got %q", summary)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// tcpServer accepts connections and hands each of them to a handler in its
+// own goroutine. It is shared by the plain TCP protocol listeners.
+type tcpServer struct {
+	handler func(conn net.Conn)
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	closed    bool
+}
+
+// Serve accepts connections on listener until Close is called
+func (s *tcpServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("server closed")
+	}
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.handler(conn)
+	}
+}
+
+// Close stops accepting connections
+func (s *tcpServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	return nil
+}
+
+// remoteIP returns the IP address of the client of a connection
+func remoteIP(conn net.Conn) string {
+	return addrIP(conn.RemoteAddr())
+}
+
+// addrIP returns the IP address of a network address
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// addrPort returns the port of a network address, 0 if it has none
+func addrPort(addr net.Addr) int {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
@@ -2,12 +2,14 @@ package services
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"neo146/models"
 	"neo146/utils"
 
 	"github.com/PuerkitoBio/goquery"
@@ -59,7 +61,7 @@ func (t *TelegramService) Start() error {
 	if err := utils.AcquireLock(); err != nil {
 		// If we get a "bot is already running" error, try to force cleanup
 		if err.Error() == "bot is already running" {
-			log.Println("Force cleaning up stale lock file...")
+			slog.Warn("Force cleaning up stale lock file")
 			utils.ReleaseLock()
 			// Try to acquire lock again
 			if err := utils.AcquireLock(); err != nil {
@@ -80,6 +82,7 @@ func (t *TelegramService) Start() error {
 		if update.Message == nil {
 			continue
 		}
+		utils.InboundMessages.Inc(models.ChannelTelegram, CommandName(update.Message.Text))
 
 		// Handle commands
 		if update.Message.IsCommand() {
@@ -125,8 +128,17 @@ https://buymeacoffee.com/ooguz`)
 /url <url> - Convert webpage to Markdown
 /twitter <username> - Get last 5 tweets
 /search <query> - Search the web
+/open <n> - Open result n of your last search
 /wiki <lang> <query> - Get Wikipedia summary
 /weather <location> - Get weather forecast
+/feed <name-or-url> [n] - Read news feed headlines or item n
+/portal [section] [n] - Read news, announcements and emergency info
+/alerts [off] - Receive urgent announcements, or stop receiving them
+/register <handle> - Register a handle to receive messages
+/link <handle> <code> - Read the messages of your handle here
+/msg <handle> <text> - Leave a message for another user
+/inbox - List your messages
+/read [n] - Read message n, or your oldest unread message
 /subscribe <email> - Subscribe to the service`)
 	case "url":
 		url := message.CommandArguments()
@@ -149,6 +161,13 @@ https://buymeacoffee.com/ooguz`)
 			return
 		}
 		t.handleSearch(chatID, query)
+	case "open":
+		arg := message.CommandArguments()
+		if arg == "" {
+			t.sendMessage(chatID, "Please provide a result number. Usage: /open <n>")
+			return
+		}
+		t.handleOpen(chatID, arg)
 	case "wiki":
 		args := strings.SplitN(message.CommandArguments(), " ", 2)
 		if len(args) != 2 {
@@ -163,6 +182,14 @@ https://buymeacoffee.com/ooguz`)
 			return
 		}
 		t.handleWeather(chatID, location)
+	case "feed":
+		t.handleFeed(chatID, message.CommandArguments())
+	case "portal":
+		t.handlePortal(chatID, message.CommandArguments())
+	case "alerts":
+		t.handleAlerts(chatID, message.CommandArguments())
+	case "register", "link", "msg", "inbox", "read":
+		t.handleMailbox(chatID, message.Command(), message.CommandArguments())
 	case "subscribe":
 		email := message.CommandArguments()
 		if email == "" {
@@ -275,21 +302,43 @@ func (t *TelegramService) handleSearch(chatID int64, query string) {
 		return
 	}
 
-	// Get more detailed search results
-	results, err := t.smsService.SearchService.FetchDuckDuckGoResults(query)
+	// Get search results and remember them for /open
+	results, err := t.smsService.SearchService.SearchForUser(telegramIdentity(chatID), query)
 	if err != nil {
 		t.sendMessage(chatID, fmt.Sprintf("Error fetching search results: %v", err))
 		return
 	}
 
 	// Send results in chunks to avoid message length limits
-	chunks := splitIntoChunks(results, 4000)
+	chunks := splitIntoChunks(FormatSearchSession(results, "/open"), 4000)
 	for _, chunk := range chunks {
 		t.sendMessage(chatID, chunk)
 		time.Sleep(100 * time.Millisecond) // Small delay between messages
 	}
 }
 
+// handleOpen processes requests to open a result of the last search
+func (t *TelegramService) handleOpen(chatID int64, arg string) {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil {
+		t.sendMessage(chatID, "Please provide a result number. Usage: /open <n>")
+		return
+	}
+
+	result, err := t.smsService.SearchService.OpenResult(telegramIdentity(chatID), n)
+	if err != nil {
+		t.sendMessage(chatID, fmt.Sprintf("Error opening result: %v", err))
+		return
+	}
+
+	t.handleURL(chatID, result.URL)
+}
+
+// telegramIdentity returns the identity used to key per-user state for a chat
+func telegramIdentity(chatID int64) string {
+	return fmt.Sprintf("tg:%d", chatID)
+}
+
 // fetchDetailedWikiContent fetches comprehensive Wikipedia content
 func (t *TelegramService) fetchDetailedWikiContent(langCode, query string) (string, error) {
 	// Base URL for Wikipedia REST API
@@ -412,6 +461,118 @@ func (t *TelegramService) handleWeather(chatID int64, location string) {
 	}
 }
 
+// handleFeed processes RSS/Atom feed requests
+func (t *TelegramService) handleFeed(chatID int64, args string) {
+	source, item := ParseFeedCommand(args)
+	if source == "" {
+		t.sendMessage(chatID, t.smsService.FeedService.FormatAliases()+"\n\nUsage: /feed <name-or-url> [n]")
+		return
+	}
+
+	// Check rate limit
+	if !t.checkRateLimit(chatID) {
+		t.sendMessage(chatID, "Rate limit exceeded. Please try again later.")
+		return
+	}
+
+	var content string
+	var err error
+	if item > 0 {
+		content, err = t.smsService.FeedService.ReadItem(source, item)
+	} else {
+		// Telegram has room for more headlines than SMS
+		content, err = t.smsService.FeedService.FetchHeadlines(source, 10)
+		if err == nil {
+			content += "\n\n" + FeedReadHint("/feed", source)
+		}
+	}
+	if err != nil {
+		t.sendMessage(chatID, fmt.Sprintf("Error fetching feed: %v", err))
+		return
+	}
+
+	// Send content in chunks to avoid message length limits
+	chunks := splitIntoChunks(sanitizeContent(content), 4000)
+	for _, chunk := range chunks {
+		t.sendMessage(chatID, chunk)
+		time.Sleep(100 * time.Millisecond) // Small delay between messages
+	}
+}
+
+// handlePortal processes portal requests
+func (t *TelegramService) handlePortal(chatID int64, args string) {
+	// Check rate limit
+	if !t.checkRateLimit(chatID) {
+		t.sendMessage(chatID, "Rate limit exceeded. Please try again later.")
+		return
+	}
+
+	content, err := t.smsService.PortalService.FormatCommand(args, "Send /portal <section> <n> to read.")
+	if err != nil {
+		t.sendMessage(chatID, fmt.Sprintf("Error fetching portal: %v", err))
+		return
+	}
+
+	// Send content in chunks to avoid message length limits
+	chunks := splitIntoChunks(content, 4000)
+	for _, chunk := range chunks {
+		t.sendMessage(chatID, chunk)
+		time.Sleep(100 * time.Millisecond) // Small delay between messages
+	}
+}
+
+// handleAlerts opts the chat in to or out of broadcast alerts
+func (t *TelegramService) handleAlerts(chatID int64, args string) {
+	address := strconv.FormatInt(chatID, 10)
+
+	if strings.EqualFold(strings.TrimSpace(args), "off") {
+		if err := t.smsService.BroadcastService.Leave(models.ChannelTelegram, address); err != nil {
+			t.sendMessage(chatID, fmt.Sprintf("Error leaving alerts: %v", err))
+			return
+		}
+		t.sendMessage(chatID, "You will no longer receive alerts.")
+		return
+	}
+
+	if err := t.smsService.BroadcastService.Join(models.ChannelTelegram, address); err != nil {
+		t.sendMessage(chatID, fmt.Sprintf("Error joining alerts: %v", err))
+		return
+	}
+	t.sendMessage(chatID, "You will now receive urgent announcements. Send /alerts off to stop.")
+}
+
+// handleMailbox processes store-and-forward mailbox commands
+func (t *TelegramService) handleMailbox(chatID int64, command, args string) {
+	// Check rate limit
+	if !t.checkRateLimit(chatID) {
+		t.sendMessage(chatID, "Rate limit exceeded. Please try again later.")
+		return
+	}
+
+	reply, err := t.smsService.MailboxService.Execute(models.ChannelTelegram, strconv.FormatInt(chatID, 10), command, args)
+	if err != nil {
+		t.sendMessage(chatID, fmt.Sprintf("Error handling %s: %v", command, err))
+		return
+	}
+
+	// Replies contain text written by users, so they are sent without Markdown
+	if _, err := t.bot.Send(tgbotapi.NewMessage(chatID, sanitizeContent(reply))); err != nil {
+		slog.Error("Error sending Telegram message", "error", err)
+	}
+}
+
+// SendAlert sends a broadcast alert to a chat
+func (t *TelegramService) SendAlert(address, message string) error {
+	chatID, err := strconv.ParseInt(address, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID: %s", address)
+	}
+
+	// Alerts are sent without Markdown so operator text is never rejected
+	_, err = t.bot.Send(tgbotapi.NewMessage(chatID, sanitizeContent(message)))
+	return err
+}
+
 // handleSubscribe processes subscription requests
 func (t *TelegramService) handleSubscribe(chatID int64, email string) {
 	// Generate a unique ID for the subscription
@@ -447,6 +608,7 @@ func (t *TelegramService) checkRateLimit(chatID int64) bool {
 	if time.Since(lastMsgTime) < time.Hour {
 		// If less than an hour has passed, check the count
 		if t.rateLimits[chatID] <= 0 {
+			utils.RateLimitRejections.Inc(models.ChannelTelegram)
 			return false
 		}
 		t.rateLimits[chatID]--
@@ -471,7 +633,7 @@ func (t *TelegramService) sendMessage(chatID int64, text string) {
 	// Send message
 	_, err := t.bot.Send(msg)
 	if err != nil {
-		log.Printf("Error sending Telegram message: %v", err)
+		slog.Error("Error sending Telegram message", "error", err)
 	}
 
 	// Update last message time
@@ -0,0 +1,412 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// telnetWidth and telnetHeight are the terminal size output is paged for
+	telnetWidth  = 80
+	telnetHeight = 24
+	// telnetIdleTimeout closes sessions without input
+	telnetIdleTimeout = 10 * time.Minute
+	// telnetMaxLine is the maximum length of an input line
+	telnetMaxLine = 1024
+)
+
+// Telnet commands (RFC 854)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWill = 251
+	telnetDont = 254
+	telnetIAC  = 255
+)
+
+const telnetMainMenu = `
+neo146 BBS - infogate
+
+  1) Web page
+  2) Search
+  3) Wikipedia
+  4) Weather
+  5) Portal: emergency info, news and announcements
+  0) Quit
+`
+
+// TelnetConfig holds the settings of the telnet server
+type TelnetConfig struct {
+	ListenAddr string
+}
+
+// TelnetServer serves a menu-driven BBS interface over telnet
+type TelnetServer struct {
+	tcpServer
+	config     TelnetConfig
+	smsService *SMSService
+	limiter    *RateLimiter
+}
+
+// NewTelnetServer creates a new telnet server using the services registered
+// on smsService. The limiter is keyed by client IP, so reconnecting does not
+// reset the allowance of content requests.
+func NewTelnetServer(config TelnetConfig, smsService *SMSService, limiter *RateLimiter) *TelnetServer {
+	s := &TelnetServer{
+		config:     config,
+		smsService: smsService,
+		limiter:    limiter,
+	}
+	s.tcpServer.handler = s.handleConn
+	return s
+}
+
+// ListenAndServe listens on the configured address and serves sessions
+// until Close is called
+func (s *TelnetServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for telnet: %v", err)
+	}
+	slog.Info("Telnet server listening", "listen_addr", s.config.ListenAddr)
+	return s.Serve(listener)
+}
+
+// handleConn runs a BBS session until the user quits or the connection ends
+func (s *TelnetServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	session := &telnetSession{
+		server: s,
+		ip:     remoteIP(conn),
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	session.run()
+}
+
+// telnetSession is the state of a single telnet connection
+type telnetSession struct {
+	server *TelnetServer
+	ip     string
+	conn   net.Conn
+	reader *bufio.Reader
+	// skipLF is set after a CR, whose following LF or NUL is not a new line
+	skipLF bool
+}
+
+// run shows the main menu until the user quits or input fails
+func (t *telnetSession) run() {
+	for {
+		t.print(telnetMainMenu)
+		choice, err := t.readLine("Choice: ")
+		if err != nil {
+			return
+		}
+
+		switch strings.ToLower(choice) {
+		case "1":
+			err = t.web()
+		case "2":
+			err = t.search()
+		case "3":
+			err = t.wiki()
+		case "4":
+			err = t.weather()
+		case "5":
+			err = t.portal()
+		case "0", "q", "quit", "exit":
+			t.print("Goodbye.")
+			return
+		case "":
+		default:
+			t.print("Unknown choice.")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// web converts a web page to text
+func (t *telnetSession) web() error {
+	uri, err := t.readLine("URL (empty to go back): ")
+	if err != nil || uri == "" || !t.allow() {
+		return err
+	}
+	return t.page(t.server.smsService.MarkdownService.FetchMarkdown(uri))
+}
+
+// search lists search results and opens the chosen ones
+func (t *telnetSession) search() error {
+	query, err := t.readLine("Search (empty to go back): ")
+	if err != nil || query == "" || !t.allow() {
+		return err
+	}
+
+	results, err := t.server.smsService.SearchService.Search(query)
+	if err != nil {
+		return t.page("", fmt.Errorf("error searching: %v", err))
+	}
+	if len(results) == 0 {
+		t.print("No results found.")
+		return nil
+	}
+
+	for {
+		if err := t.page(FormatSearchResults(results), nil); err != nil {
+			return err
+		}
+
+		result, err := t.choose("Open result number (empty to go back): ", len(results))
+		if err != nil || result == 0 {
+			return err
+		}
+		if !t.allow() {
+			return nil
+		}
+		if err := t.page(t.server.smsService.MarkdownService.FetchMarkdown(results[result-1].URL)); err != nil {
+			return err
+		}
+	}
+}
+
+// wiki shows a Wikipedia summary
+func (t *telnetSession) wiki() error {
+	lang, err := t.readLine("Language [en]: ")
+	if err != nil {
+		return err
+	}
+	if lang == "" {
+		lang = "en"
+	}
+
+	query, err := t.readLine("Wikipedia search (empty to go back): ")
+	if err != nil || query == "" || !t.allow() {
+		return err
+	}
+	return t.page(t.server.smsService.SearchService.FetchWikipediaSummary(query, lang))
+}
+
+// weather shows a weather forecast
+func (t *telnetSession) weather() error {
+	location, err := t.readLine("Location (empty to go back): ")
+	if err != nil || location == "" || !t.allow() {
+		return err
+	}
+	return t.page(t.server.smsService.WeatherService.FetchWeatherForecast(location))
+}
+
+// portal lists the portal headlines and opens the chosen entries
+func (t *telnetSession) portal() error {
+	portalService := t.server.smsService.PortalService
+
+	sections, err := portalService.Sections("", portalSectionEntries)
+	if err != nil {
+		return t.page("", err)
+	}
+
+	var lines []string
+	var entries []models.PortalEntry
+	for _, section := range sections {
+		lines = append(lines, strings.ToUpper(section.Name))
+		for _, entry := range section.Entries {
+			entries = append(entries, entry)
+			lines = append(lines, fmt.Sprintf("  %d. %s", len(entries), entry.Title))
+		}
+		lines = append(lines, "")
+	}
+	if len(entries) == 0 {
+		t.print("The portal has no content yet.")
+		return nil
+	}
+
+	for {
+		if err := t.page(strings.Join(lines, "\n"), nil); err != nil {
+			return err
+		}
+
+		n, err := t.choose("Read entry number (empty to go back): ", len(entries))
+		if err != nil || n == 0 {
+			return err
+		}
+		entry := entries[n-1]
+		content := fmt.Sprintf("%s\n%s\n\n%s", entry.Title, entry.UpdatedAt.Format("2006-01-02 15:04"), entry.Body)
+		if err := t.page(content, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// choose asks for a number between 1 and max, returning 0 for empty input
+func (t *telnetSession) choose(prompt string, max int) (int, error) {
+	for {
+		answer, err := t.readLine(prompt)
+		if err != nil || answer == "" {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= max {
+			return n, nil
+		}
+		t.print(fmt.Sprintf("Please enter a number between 1 and %d.", max))
+	}
+}
+
+// allow checks the rate limit of the client before fetching content
+func (t *telnetSession) allow() bool {
+	if t.server.limiter.Allow(t.ip) {
+		return true
+	}
+	utils.RateLimitRejections.Inc("telnet")
+	t.print("Rate limit reached, please try again in a minute.")
+	return false
+}
+
+// page shows content wrapped to the terminal width, one screen at a time,
+// or an error
+func (t *telnetSession) page(content string, err error) error {
+	if err != nil {
+		t.print("Error: " + err.Error())
+		return nil
+	}
+
+	lines := wrapText(content, telnetWidth-1)
+	// Keep the last line of the screen for the prompt
+	for start := 0; start < len(lines); start += telnetHeight - 1 {
+		end := start + telnetHeight - 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		t.print(strings.Join(lines[start:end], "\n"))
+
+		if end < len(lines) {
+			answer, err := t.readLine(fmt.Sprintf("-- More (%d%%) -- Enter: next page, q: stop ", end*100/len(lines)))
+			if err != nil {
+				return err
+			}
+			if strings.EqualFold(answer, "q") {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// print writes text with telnet line endings
+func (t *telnetSession) print(text string) {
+	t.write(strings.ReplaceAll(text, "\n", "\r\n") + "\r\n")
+}
+
+// write sends raw text to the client
+func (t *telnetSession) write(text string) {
+	t.conn.SetWriteDeadline(time.Now().Add(telnetIdleTimeout))
+	t.conn.Write([]byte(text))
+}
+
+// readLine shows a prompt and reads a line of input, skipping telnet
+// commands and applying backspaces
+func (t *telnetSession) readLine(prompt string) (string, error) {
+	t.write(prompt)
+	t.conn.SetReadDeadline(time.Now().Add(telnetIdleTimeout))
+
+	var line []byte
+	for {
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		skipLF := t.skipLF
+		t.skipLF = false
+
+		switch {
+		case b == telnetIAC:
+			if err := t.skipCommand(); err != nil {
+				return "", err
+			}
+		case (b == '\n' || b == 0) && skipLF:
+		case b == '\r' || b == '\n':
+			t.skipLF = b == '\r'
+			return strings.TrimSpace(string(line)), nil
+		case b == '\b' || b == 127:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+			}
+		case b < ' ':
+			// Ignore other control characters
+		case len(line) < telnetMaxLine:
+			line = append(line, b)
+		}
+	}
+}
+
+// skipCommand skips a telnet command following IAC. Option negotiations are
+// ignored, which leaves clients in their default line mode with local echo.
+func (t *telnetSession) skipCommand() error {
+	command, err := t.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case command >= telnetWill && command <= telnetDont:
+		_, err = t.reader.ReadByte()
+	case command == telnetSB:
+		// Skip the subnegotiation up to IAC SE
+		var previous byte
+		for {
+			b, err := t.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			if previous == telnetIAC && b == telnetSE {
+				return nil
+			}
+			previous = b
+		}
+	}
+	return err
+}
+
+// wrapText wraps text at word boundaries to lines of at most width runes,
+// keeping the indentation of the first line of each paragraph
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := paragraph[:len(paragraph)-len(strings.TrimLeft(paragraph, " \t"))]
+		for _, word := range strings.Fields(paragraph) {
+			// Break words longer than a line, e.g. URLs
+			for utf8.RuneCountInString(word) > width {
+				if strings.TrimSpace(line) != "" {
+					lines = append(lines, line)
+				}
+				line = ""
+				runes := []rune(word)
+				lines = append(lines, string(runes[:width]))
+				word = string(runes[width:])
+			}
+
+			switch {
+			case strings.TrimSpace(line) == "":
+				line += word
+			case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/services/servicestest"
+)
+
+// runTelnetSession sends input to a new session and returns its output
+func runTelnetSession(t *testing.T, server *TelnetServer, input string) string {
+	client, conn := net.Pipe()
+	go server.handleConn(conn)
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(client)
+		output <- string(data)
+	}()
+
+	if _, err := client.Write([]byte(input)); err != nil {
+		t.Fatalf("Error writing input: %v", err)
+	}
+
+	select {
+	case data := <-output:
+		return data
+	case <-time.After(5 * time.Second):
+		client.Close()
+		t.Fatalf("Session did not end, output:\n%s", <-output)
+		return ""
+	}
+}
+
+func newTestTelnetServer(t *testing.T, limit int) *TelnetServer {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, duckDuckGoLiteFixture)
+	}))
+	t.Cleanup(server.Close)
+
+	searchService := NewSearchService(server.Client())
+	searchService.searchURL = server.URL
+
+	portalService := NewPortalService(&servicestest.PortalStore{})
+	portalService.AddEntry("news", "Bridge closed", strings.Repeat("The bridge is closed for repairs. ", 100))
+
+	smsService := &SMSService{
+		SearchService: searchService,
+		PortalService: portalService,
+	}
+	return NewTelnetServer(TelnetConfig{}, smsService, NewRateLimiter(limit, time.Minute))
+}
+
+func TestTelnetServer_Menus(t *testing.T) {
+	server := newTestTelnetServer(t, 5)
+
+	// Telnet option negotiation is skipped, and a CR NUL ends a line like CR LF
+	input := "\xff\xfd\x01\xff\xfa\x18\x00xterm\xff\xf02\r\x00neo146\r\n\r\n" +
+		"5\r\n1\r\n\r\nq\r\n\r\n" +
+		"9\n0\n"
+	output := runTelnetSession(t, server, input)
+
+	if !strings.Contains(output, "  2) Search\r\n") {
+		t.Errorf("Expected main menu:\n%s", output)
+	}
+	if !strings.Contains(output, "2. Second Result Title\r\nSecond snippet.\r\nhttps://example.org/second\r\n") {
+		t.Errorf("Expected search results:\n%s", output)
+	}
+	if !strings.Contains(output, "NEWS\r\n  1. Bridge closed\r\n") {
+		t.Errorf("Expected portal headlines:\n%s", output)
+	}
+	if !strings.Contains(output, "-- More (") {
+		t.Errorf("Expected long entry to be paged:\n%s", output)
+	}
+	if !strings.Contains(output, "Unknown choice.") || !strings.HasSuffix(output, "Goodbye.\r\n") {
+		t.Errorf("Expected unknown choice and goodbye:\n%s", output)
+	}
+
+	for _, line := range strings.Split(output, "\r\n") {
+		// Input is not echoed here, so prompts run into the next line
+		if _, after, found := strings.Cut(line, "q: stop "); found {
+			line = after
+		}
+		if len(line) > telnetWidth {
+			t.Errorf("Line longer than the terminal: %q", line)
+		}
+	}
+}
+
+func TestTelnetServer_RateLimit(t *testing.T) {
+	server := newTestTelnetServer(t, 1)
+
+	output := runTelnetSession(t, server, "2\r\nneo146\r\n\r\n2\r\nneo146\r\n0\r\n")
+	if !strings.Contains(output, "Rate limit reached") {
+		t.Errorf("Expected second search to be rate limited:\n%s", output)
+	}
+
+	// Reconnecting from the same address does not reset the limit
+	output = runTelnetSession(t, server, "2\r\nneo146\r\n\r\n0\r\n")
+	if !strings.Contains(output, "Rate limit reached") {
+		t.Errorf("Expected new session to be rate limited:\n%s", output)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	lines := wrapText("one two three\n  four five\n"+strings.Repeat("x", 12), 9)
+	expected := []string{"one two", "three", "  four", "five", "xxxxxxxxx", "xxx"}
+
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+}
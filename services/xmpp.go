@@ -0,0 +1,485 @@
+package services
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neo146/models"
+	"neo146/utils"
+)
+
+const (
+	// xmppMessageLength is the maximum length of a single XMPP reply
+	xmppMessageLength = 4000
+	// xmppKeepAlive is the interval of whitespace keepalives
+	xmppKeepAlive = time.Minute
+	// xmppResource is the resource the bot binds to
+	xmppResource = "neo146"
+	// xmppMaxHandlers is the number of messages handled at once, further
+	// messages are dropped
+	xmppMaxHandlers = 16
+)
+
+// XMPPConfig holds the settings of the XMPP channel
+type XMPPConfig struct {
+	// JID is the bare JID of the bot account, e.g. neo146@example.org
+	JID      string
+	Password string
+	// Server overrides the host:port to connect to, otherwise it is looked
+	// up with DNS SRV records of the JID domain
+	Server string
+	// TLSConfig is used for STARTTLS, ServerName defaults to the JID domain
+	TLSConfig *tls.Config
+}
+
+// XMPPService handles the XMPP bot channel. It logs in with SASL PLAIN
+// after STARTTLS and answers one-to-one chat messages.
+type XMPPService struct {
+	config   XMPPConfig
+	commands *CommandService
+	limiter  *RateLimiter
+	handlers chan struct{}
+
+	conn    net.Conn
+	decoder *xml.Decoder
+	writeMu sync.Mutex
+	connMu  sync.Mutex
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+// NewXMPPService creates a new XMPP bot. The limiter is keyed by bare JID.
+func NewXMPPService(config XMPPConfig, commands *CommandService, limiter *RateLimiter) *XMPPService {
+	return &XMPPService{
+		config:   config,
+		commands: commands,
+		limiter:  limiter,
+		handlers: make(chan struct{}, xmppMaxHandlers),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start connects, logs in and handles messages until the connection is lost
+// or Stop is called. It returns nil only after Stop, so it can be called in
+// a loop to reconnect.
+func (s *XMPPService) Start() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+
+	if err := s.connect(); err != nil {
+		s.closeConn()
+		return err
+	}
+	slog.Info("XMPP bot logged in", "account", s.config.JID)
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(done)
+
+	err := s.serve()
+	s.closeConn()
+
+	select {
+	case <-s.stop:
+		return nil
+	default:
+		return err
+	}
+}
+
+// Stop disconnects and stops handling messages
+func (s *XMPPService) Stop() {
+	s.stopped.Do(func() {
+		close(s.stop)
+		s.connMu.Lock()
+		defer s.connMu.Unlock()
+		if s.conn != nil {
+			s.write("</stream:stream>")
+			s.conn.Close()
+		}
+	})
+}
+
+// SendAlert sends a broadcast alert to a JID
+func (s *XMPPService) SendAlert(address, message string) error {
+	return s.sendMessage(address, message)
+}
+
+// connect opens the stream, secures it with STARTTLS, authenticates and
+// binds a resource
+func (s *XMPPService) connect() error {
+	localpart, domain, found := strings.Cut(s.config.JID, "@")
+	if !found {
+		return fmt.Errorf("invalid JID: %s", s.config.JID)
+	}
+
+	conn, err := net.DialTimeout("tcp", s.serverAddress(domain), 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("error connecting to XMPP server: %v", err)
+	}
+	s.setConn(conn)
+
+	features, err := s.openStream(domain)
+	if err != nil {
+		return err
+	}
+
+	// Credentials are never sent over an unencrypted connection
+	if features.StartTLS == nil {
+		return errors.New("XMPP server does not offer STARTTLS")
+	}
+	if err := s.write("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	if name, err := s.nextElement(); err != nil {
+		return err
+	} else if name != "proceed" {
+		return fmt.Errorf("STARTTLS failed: %s", name)
+	}
+
+	tlsConfig := &tls.Config{}
+	if s.config.TLSConfig != nil {
+		tlsConfig = s.config.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = domain
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	s.setConn(tlsConn)
+
+	if features, err = s.openStream(domain); err != nil {
+		return err
+	}
+	if !containsFold(features.Mechanisms, "PLAIN") {
+		return errors.New("XMPP server does not support SASL PLAIN")
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + localpart + "\x00" + s.config.Password))
+	if err := s.write("<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='PLAIN'>" + credentials + "</auth>"); err != nil {
+		return err
+	}
+	if name, err := s.nextElement(); err != nil {
+		return err
+	} else if name != "success" {
+		return errors.New("XMPP authentication failed")
+	}
+
+	if _, err := s.openStream(domain); err != nil {
+		return err
+	}
+	if err := s.write("<iq type='set' id='bind1'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><resource>" + xmppResource + "</resource></bind></iq>"); err != nil {
+		return err
+	}
+
+	var iq models.XMPPIQ
+	if err := s.decodeNext(&iq); err != nil {
+		return err
+	}
+	if iq.Type != "result" || iq.Bind == nil {
+		return errors.New("XMPP resource binding failed")
+	}
+
+	// Announce availability, so users can see the bot is online
+	return s.write("<presence/>")
+}
+
+// serve handles incoming stanzas until the stream ends. Messages received
+// while xmppMaxHandlers are being handled are dropped.
+func (s *XMPPService) serve() error {
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("XMPP connection lost: %v", err)
+		}
+
+		switch element := token.(type) {
+		case xml.EndElement:
+			if element.Name.Local == "stream" {
+				return errors.New("XMPP server closed the stream")
+			}
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "message":
+				var message models.XMPPMessage
+				if err := s.decoder.DecodeElement(&message, &element); err != nil {
+					return err
+				}
+				select {
+				case s.handlers <- struct{}{}:
+					go func() {
+						defer func() { <-s.handlers }()
+						s.handleMessage(message)
+					}()
+				default:
+					slog.Debug("XMPP bot is busy, dropping message")
+				}
+			case "presence":
+				var presence models.XMPPPresence
+				if err := s.decoder.DecodeElement(&presence, &element); err != nil {
+					return err
+				}
+				s.handlePresence(presence)
+			case "iq":
+				var iq models.XMPPIQ
+				if err := s.decoder.DecodeElement(&iq, &element); err != nil {
+					return err
+				}
+				s.handleIQ(iq)
+			case "error":
+				return errors.New("XMPP stream error")
+			default:
+				if err := s.decoder.Skip(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handleMessage runs the command of a chat message and replies to the sender
+func (s *XMPPService) handleMessage(message models.XMPPMessage) {
+	body := strings.TrimSpace(message.Body)
+	if body == "" || message.Type == "error" || message.Type == "groupchat" {
+		return
+	}
+
+	sender := bareJID(message.From)
+	if strings.EqualFold(sender, s.config.JID) {
+		return
+	}
+	utils.InboundMessages.Inc(models.ChannelXMPP, CommandName(body))
+
+	command := strings.ToLower(strings.TrimLeft(body, "/!"))
+	if command == "help" || command == "start" {
+		s.reply(message.From, s.commands.Help())
+		return
+	}
+
+	if !s.limiter.Allow(sender) {
+		utils.RateLimitRejections.Inc(models.ChannelXMPP)
+		s.reply(message.From, "You have reached the rate limit. Please try again later.")
+		return
+	}
+
+	content, err := s.commands.Execute(models.ChannelXMPP, sender, body)
+	if err != nil {
+		content = fmt.Sprintf("Error: %v", err)
+	}
+
+	// Send content in chunks to avoid message length limits
+	chunks := splitIntoChunks(content, xmppMessageLength)
+	for i, chunk := range chunks {
+		s.reply(message.From, chunk)
+		if i < len(chunks)-1 {
+			time.Sleep(100 * time.Millisecond) // Small delay between messages
+		}
+	}
+}
+
+// handlePresence accepts subscription requests, so users can add the bot to
+// their contacts
+func (s *XMPPService) handlePresence(presence models.XMPPPresence) {
+	if presence.Type != "subscribe" {
+		return
+	}
+
+	to := xmlAttr(bareJID(presence.From))
+	if err := s.write("<presence to='" + to + "' type='subscribed'/><presence to='" + to + "' type='subscribe'/>"); err != nil {
+		slog.Error("Error accepting XMPP subscription", "error", err)
+	}
+}
+
+// handleIQ answers pings and rejects other requests as the protocol requires
+func (s *XMPPService) handleIQ(iq models.XMPPIQ) {
+	if iq.Type != "get" && iq.Type != "set" {
+		return
+	}
+
+	var response string
+	if iq.Ping != nil {
+		response = fmt.Sprintf("<iq type='result' id='%s' to='%s'/>", xmlAttr(iq.ID), xmlAttr(iq.From))
+	} else {
+		response = fmt.Sprintf("<iq type='error' id='%s' to='%s'><error type='cancel'><service-unavailable xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></iq>",
+			xmlAttr(iq.ID), xmlAttr(iq.From))
+	}
+	if err := s.write(response); err != nil {
+		slog.Error("Error answering XMPP iq", "error", err)
+	}
+}
+
+// reply sends a message and logs failures
+func (s *XMPPService) reply(to, text string) {
+	if err := s.sendMessage(to, text); err != nil {
+		slog.Error("Error sending XMPP message", "error", err)
+	}
+}
+
+// sendMessage sends a chat message to a JID
+func (s *XMPPService) sendMessage(to, text string) error {
+	var body strings.Builder
+	xml.EscapeText(&body, []byte(text))
+	return s.write("<message to='" + xmlAttr(to) + "' type='chat'><body>" + body.String() + "</body></message>")
+}
+
+// keepAlive sends whitespace regularly so idle connections are not dropped
+func (s *XMPPService) keepAlive(done chan struct{}) {
+	ticker := time.NewTicker(xmppKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.write(" "); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// openStream opens a new XML stream and returns the offered features
+func (s *XMPPService) openStream(domain string) (*models.XMPPStreamFeatures, error) {
+	s.decoder = xml.NewDecoder(s.conn)
+
+	header := "<?xml version='1.0'?><stream:stream to='" + xmlAttr(domain) +
+		"' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"
+	if err := s.write(header); err != nil {
+		return nil, err
+	}
+
+	if name, err := s.nextElement(); err != nil {
+		return nil, err
+	} else if name != "stream" {
+		return nil, fmt.Errorf("unexpected XMPP element: %s", name)
+	}
+
+	var features models.XMPPStreamFeatures
+	if err := s.decodeNext(&features); err != nil {
+		return nil, err
+	}
+	return &features, nil
+}
+
+// nextElement reads up to the next start element and returns its name. The
+// element is skipped unless it is the stream header.
+func (s *XMPPService) nextElement() (string, error) {
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("error reading from XMPP server: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "stream" {
+				if err := s.decoder.Skip(); err != nil {
+					return "", err
+				}
+			}
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// decodeNext decodes the next element into v
+func (s *XMPPService) decodeNext(v interface{}) error {
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("error reading from XMPP server: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return s.decoder.DecodeElement(v, &start)
+		}
+	}
+}
+
+// write sends raw XML to the server
+func (s *XMPPService) write(data string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.conn == nil {
+		return errors.New("not connected to XMPP server")
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := io.WriteString(s.conn, data)
+	return err
+}
+
+// serverAddress returns the host:port of the XMPP server of a domain
+func (s *XMPPService) serverAddress(domain string) string {
+	if s.config.Server != "" {
+		return s.config.Server
+	}
+	if _, records, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(records) > 0 {
+		return net.JoinHostPort(strings.TrimSuffix(records[0].Target, "."), strconv.Itoa(int(records[0].Port)))
+	}
+	return net.JoinHostPort(domain, "5222")
+}
+
+// setConn replaces the current connection. Connections made after Stop are
+// closed right away.
+func (s *XMPPService) setConn(conn net.Conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	select {
+	case <-s.stop:
+		conn.Close()
+	default:
+	}
+	s.conn = conn
+}
+
+// closeConn closes the current connection
+func (s *XMPPService) closeConn() {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// bareJID removes the resource from a JID
+func bareJID(jid string) string {
+	bare, _, _ := strings.Cut(jid, "/")
+	return strings.ToLower(bare)
+}
+
+// xmlAttr escapes a value for use in an XML attribute
+func xmlAttr(value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
+
+// containsFold checks if values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"neo146/models"
+)
+
+// fakeXMPPServer is an in-process XMPP server that accepts one client,
+// walks it through STARTTLS, SASL PLAIN and binding and then exchanges
+// scripted stanzas
+type fakeXMPPServer struct {
+	t        *testing.T
+	listener net.Listener
+	cert     tls.Certificate
+	password string
+	conn     net.Conn
+	decoder  *xml.Decoder
+	ready    chan struct{}
+}
+
+func startFakeXMPPServer(t *testing.T, password string) *fakeXMPPServer {
+	// Reuse the self-signed certificate of an httptest TLS server
+	tlsServer := httptest.NewTLSServer(nil)
+	cert := tlsServer.TLS.Certificates[0]
+	tlsServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake XMPP server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeXMPPServer{t: t, listener: listener, cert: cert, password: password, ready: make(chan struct{})}
+	go server.accept()
+	return server
+}
+
+func (f *fakeXMPPServer) accept() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	f.conn = conn
+	f.decoder = xml.NewDecoder(conn)
+
+	f.openStream("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>")
+	f.expect("starttls")
+	f.send("<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{f.cert}})
+	if err := tlsConn.Handshake(); err != nil {
+		f.t.Errorf("Fake XMPP server TLS handshake failed: %v", err)
+		return
+	}
+	f.conn = tlsConn
+	f.decoder = xml.NewDecoder(tlsConn)
+
+	f.openStream("<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></mechanisms>")
+	var auth struct {
+		Mechanism string `xml:"mechanism,attr"`
+		Data      string `xml:",chardata"`
+	}
+	f.decode("auth", &auth)
+	credentials, _ := base64.StdEncoding.DecodeString(auth.Data)
+	if auth.Mechanism != "PLAIN" || string(credentials) != "\x00neo146\x00"+f.password {
+		f.send("<failure xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><not-authorized/></failure>")
+		return
+	}
+	f.send("<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>")
+
+	f.decoder = xml.NewDecoder(f.conn)
+	f.openStream("<bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/>")
+	var iq models.XMPPIQ
+	f.decode("iq", &iq)
+	f.send(fmt.Sprintf("<iq type='result' id='%s'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><jid>neo146@xmpp.test/neo146</jid></bind></iq>", iq.ID))
+	f.expect("presence")
+
+	close(f.ready)
+}
+
+// openStream reads the stream header of the client and answers with
+// features
+func (f *fakeXMPPServer) openStream(features string) {
+	f.expect("stream")
+	f.send("<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' from='xmpp.test' version='1.0'>" +
+		"<stream:features>" + features + "</stream:features>")
+}
+
+func (f *fakeXMPPServer) send(data string) {
+	if _, err := io.WriteString(f.conn, data); err != nil {
+		f.t.Errorf("Fake XMPP server write failed: %v", err)
+	}
+}
+
+// next returns the next start element sent by the client
+func (f *fakeXMPPServer) next() (xml.StartElement, error) {
+	for {
+		token, err := f.decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+func (f *fakeXMPPServer) expect(name string) {
+	start, err := f.next()
+	if err != nil || start.Name.Local != name {
+		f.t.Errorf("Expected <%s>, got <%s> (%v)", name, start.Name.Local, err)
+		return
+	}
+	if name != "stream" {
+		f.decoder.Skip()
+	}
+}
+
+func (f *fakeXMPPServer) decode(name string, v interface{}) {
+	start, err := f.next()
+	if err != nil || start.Name.Local != name {
+		f.t.Errorf("Expected <%s>, got <%s> (%v)", name, start.Name.Local, err)
+		return
+	}
+	f.decoder.DecodeElement(v, &start)
+}
+
+func newTestXMPPService(server *fakeXMPPServer, password string, rateLimit int) *XMPPService {
+	now := time.Date(2025, 3, 19, 10, 0, 0, 0, time.UTC)
+	smsService := &SMSService{
+		MailboxService: NewMailboxService(newMockMailboxStore(func() time.Time { return now }), time.Hour, 10),
+	}
+
+	return NewXMPPService(XMPPConfig{
+		JID:       "neo146@xmpp.test",
+		Password:  password,
+		Server:    server.listener.Addr().String(),
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+	}, NewCommandService(smsService), NewRateLimiter(rateLimit, time.Hour))
+}
+
+func TestXMPPService(t *testing.T) {
+	server := startFakeXMPPServer(t, "secret")
+	service := newTestXMPPService(server, "secret", 2)
+
+	done := make(chan error)
+	go func() { done <- service.Start() }()
+
+	select {
+	case <-server.ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the bot to log in")
+	}
+
+	server.send("<presence from='alice@xmpp.test/phone' type='subscribe'/>")
+	server.send("<iq from='xmpp.test' type='get' id='ping1'><ping xmlns='urn:xmpp:ping'/></iq>")
+
+	var presence models.XMPPPresence
+	server.decode("presence", &presence)
+	if presence.Type != "subscribed" {
+		t.Errorf("Expected subscription to be accepted, got %q", presence.Type)
+	}
+	server.expect("presence")
+
+	var pong models.XMPPIQ
+	server.decode("iq", &pong)
+	if pong.Type != "result" || pong.ID != "ping1" {
+		t.Errorf("Expected ping result, got %+v", pong)
+	}
+
+	// Commands are answered one at a time to keep the order of replies
+	expected := []struct {
+		body  string
+		reply string
+	}{
+		{"register alice", "You are now alice."},
+		{"inbox", "No messages for alice."},
+		{"inbox", "You have reached the rate limit."},
+	}
+	for _, tc := range expected {
+		server.send("<message from='Alice@xmpp.test/phone' to='neo146@xmpp.test' type='chat'><body>" + tc.body + "</body></message>")
+
+		var reply models.XMPPMessage
+		server.decode("message", &reply)
+		if reply.To != "Alice@xmpp.test/phone" || !strings.HasPrefix(reply.Body, tc.reply) {
+			t.Errorf("Expected reply %q to %s, got %q to %s", tc.reply, "Alice@xmpp.test/phone", reply.Body, reply.To)
+		}
+	}
+
+	// Messages are dropped while every handler is busy
+	for i := 0; i < xmppMaxHandlers; i++ {
+		service.handlers <- struct{}{}
+	}
+	server.send("<message from='Alice@xmpp.test/phone' to='neo146@xmpp.test' type='chat'><body>register bob</body></message>")
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < xmppMaxHandlers; i++ {
+		<-service.handlers
+	}
+	server.send("<message from='Alice@xmpp.test/phone' to='neo146@xmpp.test' type='chat'><body>help</body></message>")
+
+	var reply models.XMPPMessage
+	server.decode("message", &reply)
+	if help := service.commands.Help(); reply.Body == "" || !strings.HasPrefix(help, reply.Body) {
+		t.Errorf("Expected only the help to be answered, got %q", reply.Body)
+	}
+
+	service.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean stop, got error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the bot to stop")
+	}
+}
+
+func TestXMPPService_AuthenticationFailure(t *testing.T) {
+	server := startFakeXMPPServer(t, "secret")
+	service := newTestXMPPService(server, "wrong", 5)
+
+	if err := service.Start(); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Expected authentication to fail, got: %v", err)
+	}
+}
+
+func TestBareJID(t *testing.T) {
+	if jid := bareJID("Alice@Example.org/phone"); jid != "alice@example.org" {
+		t.Errorf("Unexpected bare JID: %s", jid)
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Bell 202 AFSK parameters
+const (
+	// AFSKBaud is the bit rate of the modem
+	AFSKBaud = 1200
+	// AFSKMinSampleRate is the lowest sample rate that carries both tones
+	// with room to spare
+	AFSKMinSampleRate = 8000
+	// afskMark and afskSpace are the tone frequencies in Hz
+	afskMark  = 1200.0
+	afskSpace = 2200.0
+	// afskAmplitude is the peak level of generated audio
+	afskAmplitude = 0.5 * math.MaxInt16
+	// afskClockGain is the share of the timing error corrected at each tone
+	// change
+	afskClockGain = 0.2
+)
+
+// AFSKModulate generates Bell 202 audio for a sequence of tones, true for
+// mark and false for space. The phase is continuous across tone changes.
+func AFSKModulate(tones []bool, sampleRate int) []int16 {
+	samplesPerBit := float64(sampleRate) / AFSKBaud
+	samples := make([]int16, 0, int(float64(len(tones))*samplesPerBit)+1)
+
+	phase := 0.0
+	position := 0.0
+	for _, mark := range tones {
+		frequency := afskSpace
+		if mark {
+			frequency = afskMark
+		}
+		step := 2 * math.Pi * frequency / float64(sampleRate)
+
+		// Bits do not span a whole number of samples at every rate, so the
+		// bit boundaries are tracked as fractions
+		position += samplesPerBit
+		for float64(len(samples)) < position {
+			samples = append(samples, int16(afskAmplitude*math.Sin(phase)))
+			phase = math.Mod(phase+step, 2*math.Pi)
+		}
+	}
+	return samples
+}
+
+// AFSKDemodulate recovers the tones of Bell 202 audio, sampled in the
+// middle of each bit. The tone of each sample is decided by comparing the
+// energy of both frequencies over the last bit period, and the bit clock
+// is synchronized to the tone changes.
+func AFSKDemodulate(samples []int16, sampleRate int) []bool {
+	samplesPerBit := float64(sampleRate) / AFSKBaud
+	window := int(math.Round(samplesPerBit))
+	if window < 1 || len(samples) < window {
+		return nil
+	}
+
+	markStep := -2 * math.Pi * afskMark / float64(sampleRate)
+	spaceStep := -2 * math.Pi * afskSpace / float64(sampleRate)
+
+	// Sliding correlations with both tones over one bit period
+	var markSum, spaceSum complex128
+	markTerms := make([]complex128, window)
+	spaceTerms := make([]complex128, window)
+
+	var tones []bool
+	var previous bool
+	next := samplesPerBit / 2
+
+	for i, sample := range samples {
+		x := float64(sample)
+		markTerm := cmplx.Rect(x, markStep*float64(i%sampleRate))
+		spaceTerm := cmplx.Rect(x, spaceStep*float64(i%sampleRate))
+
+		slot := i % window
+		markSum += markTerm - markTerms[slot]
+		spaceSum += spaceTerm - spaceTerms[slot]
+		markTerms[slot] = markTerm
+		spaceTerms[slot] = spaceTerm
+
+		if i < window-1 {
+			continue
+		}
+
+		mark := cmplx.Abs(markSum) > cmplx.Abs(spaceSum)
+		position := float64(i - window + 1)
+		if mark != previous {
+			// Tone changes happen at bit boundaries, so the clock is pulled
+			// toward sampling half a bit later. A partial correction keeps
+			// noise near the middle of a bit from shifting the clock.
+			next += (position + samplesPerBit/2 - next) * afskClockGain
+			previous = mark
+		}
+		if position >= next {
+			tones = append(tones, mark)
+			next += samplesPerBit
+		}
+	}
+	return tones
+}
+
+// afskPreambleFlags is the number of flags sent before the first frame,
+// about 200 ms of audio
+const afskPreambleFlags = 30
+
+// EncodeAFSK frames data with HDLC and modulates it as Bell 202 audio
+func EncodeAFSK(frames [][]byte, sampleRate int) []int16 {
+	return AFSKModulate(HDLCEncode(frames, afskPreambleFlags), sampleRate)
+}
+
+// DecodeAFSK demodulates Bell 202 audio and returns the valid HDLC frames
+func DecodeAFSK(samples []int16, sampleRate int) [][]byte {
+	return HDLCDecode(AFSKDemodulate(samples, sampleRate))
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCRC16X25(t *testing.T) {
+	if crc := CRC16X25([]byte("123456789")); crc != 0x906e {
+		t.Errorf("Expected check value 0x906e, got %#04x", crc)
+	}
+}
+
+func TestHDLCRoundTrip(t *testing.T) {
+	frames := [][]byte{
+		[]byte("weather istanbul"),
+		{0x7e, 0xff, 0xff, 0x7d, 0x00, 0xfe},
+		bytes.Repeat([]byte{0xff}, 40),
+	}
+
+	tones := HDLCEncode(frames, 4)
+	decoded := HDLCDecode(tones)
+	if len(decoded) != len(frames) {
+		t.Fatalf("Expected %d frames, got %d", len(frames), len(decoded))
+	}
+	for i := range frames {
+		if !bytes.Equal(decoded[i], frames[i]) {
+			t.Errorf("Frame %d: expected % x, got % x", i, frames[i], decoded[i])
+		}
+	}
+
+	// A flipped tone breaks the CRC of the first frame only
+	tones[8*5+20] = !tones[8*5+20]
+	if decoded := HDLCDecode(tones); len(decoded) != len(frames)-1 {
+		t.Errorf("Expected the corrupted frame to be dropped, got %d frames", len(decoded))
+	}
+}
+
+func TestAFSKWAVLoop(t *testing.T) {
+	frames := [][]byte{[]byte("wiki tr Ankara"), []byte("İstanbul: ☀️ +21°C")}
+
+	for _, sampleRate := range []int{8000, 11025, 22050, 44100, 48000} {
+		samples := EncodeAFSK(frames, sampleRate)
+
+		// Noise and a gain change, as from a recording
+		rng := rand.New(rand.NewSource(int64(sampleRate)))
+		for i := range samples {
+			samples[i] = int16(float64(samples[i])*0.3 + rng.NormFloat64()*800)
+		}
+
+		wav := WriteWAV(samples, sampleRate)
+		read, rate, err := ReadWAV(wav)
+		if err != nil || rate != sampleRate || len(read) != len(samples) {
+			t.Fatalf("%d Hz: error reading WAV back: %v", sampleRate, err)
+		}
+
+		decoded := DecodeAFSK(read, rate)
+		if len(decoded) != len(frames) {
+			t.Fatalf("%d Hz: expected %d frames, got %d", sampleRate, len(frames), len(decoded))
+		}
+		for i := range frames {
+			if !bytes.Equal(decoded[i], frames[i]) {
+				t.Errorf("%d Hz: expected %q, got %q", sampleRate, frames[i], decoded[i])
+			}
+		}
+	}
+}
+
+func TestReadWAV(t *testing.T) {
+	// Stereo 8-bit with an extra chunk before the data
+	wav := []byte("RIFF\x00\x00\x00\x00WAVE" +
+		"fmt \x10\x00\x00\x00\x01\x00\x02\x00\x40\x1f\x00\x00\x80\x3e\x00\x00\x02\x00\x08\x00" +
+		"LIST\x03\x00\x00\x00abc\x00" +
+		"data\x04\x00\x00\x00\xff\x80\x00\x80")
+
+	samples, rate, err := ReadWAV(wav)
+	if err != nil || rate != 8000 {
+		t.Fatalf("Unexpected rate %d, error: %v", rate, err)
+	}
+	if len(samples) != 2 || samples[0] != 127<<8 || samples[1] != -128<<8 {
+		t.Errorf("Expected the first channel as 16-bit samples, got %v", samples)
+	}
+
+	if _, _, err := ReadWAV([]byte("RIFF\x00\x00\x00\x00AVI ")); err == nil {
+		t.Errorf("Expected error for a non-WAV file")
+	}
+}
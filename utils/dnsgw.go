@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DNSPagePrefix starts the optional page label of a DNS gateway query.
+	// The hyphen is not part of the base32 alphabet, so it cannot be
+	// mistaken for query data.
+	DNSPagePrefix = "p-"
+	// dnsMaxLabel is the maximum length of a DNS label
+	dnsMaxLabel = 63
+	// dnsMaxName is the maximum length of a DNS name in text form
+	dnsMaxName = 253
+)
+
+// dnsEncoding is base32 without padding. It is case insensitive once the
+// input is upper-cased, which resolvers randomizing the case of names rely on.
+var dnsEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// DNSGatewayName returns the name to look up for a page (1-based) of a DNS
+// gateway query: [p-<page>.]<base32 query labels>.<service>.<zone>, where the
+// page label is left out for the first page
+func DNSGatewayName(zone, service, query string, page int) (string, error) {
+	if query == "" {
+		return "", fmt.Errorf("empty query")
+	}
+	encoded := strings.ToLower(dnsEncoding.EncodeToString([]byte(query)))
+
+	var labels []string
+	if page > 1 {
+		labels = append(labels, DNSPagePrefix+strconv.Itoa(page))
+	}
+	for len(encoded) > dnsMaxLabel {
+		labels = append(labels, encoded[:dnsMaxLabel])
+		encoded = encoded[dnsMaxLabel:]
+	}
+	labels = append(labels, encoded, service, strings.Trim(zone, "."))
+
+	name := strings.Join(labels, ".")
+	if len(name) > dnsMaxName {
+		return "", fmt.Errorf("query too long for a DNS name")
+	}
+	return name, nil
+}
+
+// DecodeDNSGatewayLabels decodes the query of a DNS gateway name from the
+// labels between the optional page label and the service
+func DecodeDNSGatewayLabels(labels []string) (string, error) {
+	data, err := dnsEncoding.DecodeString(strings.ToUpper(strings.Join(labels, "")))
+	if err != nil {
+		return "", fmt.Errorf("invalid base32 query: %v", err)
+	}
+	return string(data), nil
+}
+
+// FormatDNSGatewayRecord formats a page of a response as TXT record data,
+// "<page>/<pages>:<data>" with 1-based page numbers
+func FormatDNSGatewayRecord(page, pages int, data string) string {
+	return fmt.Sprintf("%d/%d:%s", page, pages, data)
+}
+
+// ParseDNSGatewayRecord splits TXT record data into the page number, the
+// number of pages and the data of the page
+func ParseDNSGatewayRecord(record string) (int, int, string, error) {
+	sequence, data, found := strings.Cut(record, ":")
+	if !found {
+		return 0, 0, "", fmt.Errorf("missing sequence label")
+	}
+	pageText, pagesText, found := strings.Cut(sequence, "/")
+	page, pageErr := strconv.Atoi(pageText)
+	pages, pagesErr := strconv.Atoi(pagesText)
+	if !found || pageErr != nil || pagesErr != nil || page < 1 || page > pages {
+		return 0, 0, "", fmt.Errorf("invalid sequence label: %s", sequence)
+	}
+	return page, pages, data, nil
+}
+
+// QueryDNSGateway runs a query through a DNS gateway and reassembles the
+// response from its pages. Service is "w" (weather), "k" (wiki) or "s"
+// (search). A nil resolver uses the system resolver.
+func QueryDNSGateway(ctx context.Context, resolver *net.Resolver, zone, service, query string) (string, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var response strings.Builder
+	for page, pages := 1, 1; page <= pages; page++ {
+		name, err := DNSGatewayName(zone, service, query, page)
+		if err != nil {
+			return "", err
+		}
+
+		records, err := resolver.LookupTXT(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("error looking up page %d: %v", page, err)
+		}
+		if len(records) != 1 {
+			return "", fmt.Errorf("expected one TXT record for page %d, got %d", page, len(records))
+		}
+
+		n, total, data, err := ParseDNSGatewayRecord(records[0])
+		if err != nil {
+			return "", err
+		}
+		if n != page {
+			return "", fmt.Errorf("expected page %d, got %d", page, n)
+		}
+		pages = total
+		response.WriteString(data)
+	}
+
+	return response.String(), nil
+}
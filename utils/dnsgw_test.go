@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDNSGatewayName(t *testing.T) {
+	name, err := DNSGatewayName("gw.example.org.", "w", "istanbul", 1)
+	if err != nil || name != "nfzxiylomj2wy.w.gw.example.org" {
+		t.Errorf("Unexpected name %q, error: %v", name, err)
+	}
+
+	name, _ = DNSGatewayName("gw.example.org", "k", strings.Repeat("Ankara ", 10), 3)
+	labels := strings.Split(name, ".")
+	if labels[0] != "p-3" || len(labels[1]) != 63 {
+		t.Errorf("Expected page label and split query labels, got %q", name)
+	}
+
+	query, err := DecodeDNSGatewayLabels([]string{strings.ToUpper(labels[1]), labels[2]})
+	if err != nil || query != strings.Repeat("Ankara ", 10) {
+		t.Errorf("Expected query to decode regardless of case, got %q, error: %v", query, err)
+	}
+
+	if _, err := DNSGatewayName("gw.example.org", "s", strings.Repeat("x", 200), 1); err == nil {
+		t.Errorf("Expected error for a query too long for a DNS name")
+	}
+}
+
+func TestParseDNSGatewayRecord(t *testing.T) {
+	page, pages, data, err := ParseDNSGatewayRecord(FormatDNSGatewayRecord(2, 3, "a:b"))
+	if err != nil || page != 2 || pages != 3 || data != "a:b" {
+		t.Errorf("Unexpected record %d/%d %q, error: %v", page, pages, data, err)
+	}
+
+	for _, record := range []string{"no sequence", "4/3:x", "0/1:x", "a/b:x"} {
+		if _, _, _, err := ParseDNSGatewayRecord(record); err == nil {
+			t.Errorf("Expected error for %q", record)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package utils
+
+import "math"
+
+// DTMF frequencies in Hz, rows and columns of the keypad
+var (
+	dtmfRows    = [4]float64{697, 770, 852, 941}
+	dtmfColumns = [4]float64{1209, 1336, 1477, 1633}
+	dtmfKeys    = [4][4]byte{
+		{'1', '2', '3', 'A'},
+		{'4', '5', '6', 'B'},
+		{'7', '8', '9', 'C'},
+		{'*', '0', '#', 'D'},
+	}
+)
+
+const (
+	// dtmfBlock is the number of samples per detection block at 8000 Hz,
+	// the classic size that places the DTMF frequencies near Goertzel bins
+	dtmfBlock = 205
+	// dtmfMinRMS is the level below which a block is treated as silence
+	dtmfMinRMS = 150
+	// dtmfMinTonePower is the share of the block energy both tones must
+	// hold, so speech and noise are not taken as digits
+	dtmfMinTonePower = 0.6
+	// dtmfMaxTwist is the maximum power ratio between the two tones
+	dtmfMaxTwist = 6.3
+	// dtmfMinPeakRatio is how much stronger the strongest row and column
+	// must be than the others of their group
+	dtmfMinPeakRatio = 4
+)
+
+// DTMFDetector detects DTMF digits in audio with the Goertzel algorithm. A
+// digit is reported once when it is heard in two blocks in a row, and again
+// only after a pause.
+type DTMFDetector struct {
+	sampleRate int
+	blockSize  int
+	block      []float64
+	// previous is the key heard in the previous block, 0 for none
+	previous byte
+	// reported is the key reported for the current tone, 0 for none
+	reported byte
+}
+
+// NewDTMFDetector creates a DTMF detector for audio at sampleRate
+func NewDTMFDetector(sampleRate int) *DTMFDetector {
+	blockSize := dtmfBlock * sampleRate / 8000
+	return &DTMFDetector{
+		sampleRate: sampleRate,
+		blockSize:  blockSize,
+		block:      make([]float64, 0, blockSize),
+	}
+}
+
+// Process feeds audio to the detector and returns the digits completed in it
+func (d *DTMFDetector) Process(samples []int16) []byte {
+	var digits []byte
+	for _, sample := range samples {
+		d.block = append(d.block, float64(sample))
+		if len(d.block) < d.blockSize {
+			continue
+		}
+
+		key := d.detect(d.block)
+		d.block = d.block[:0]
+
+		if key == 0 {
+			d.reported = 0
+		} else if key == d.previous && key != d.reported {
+			digits = append(digits, key)
+			d.reported = key
+		}
+		d.previous = key
+	}
+	return digits
+}
+
+// detect returns the key whose tones are in a block, or 0
+func (d *DTMFDetector) detect(block []float64) byte {
+	energy := 0.0
+	for _, x := range block {
+		energy += x * x
+	}
+	n := float64(len(block))
+	if math.Sqrt(energy/n) < dtmfMinRMS {
+		return 0
+	}
+
+	row, rowPower, rowRatio := d.strongest(block, dtmfRows)
+	column, columnPower, columnRatio := d.strongest(block, dtmfColumns)
+	if rowRatio < dtmfMinPeakRatio || columnRatio < dtmfMinPeakRatio {
+		return 0
+	}
+	if rowPower > columnPower*dtmfMaxTwist || columnPower > rowPower*dtmfMaxTwist {
+		return 0
+	}
+	// A pure tone of the block's energy has a Goertzel power of energy*n/2
+	if (rowPower+columnPower)/(energy*n/2) < dtmfMinTonePower {
+		return 0
+	}
+	return dtmfKeys[row][column]
+}
+
+// strongest returns the index and power of the strongest frequency in a
+// block, and its ratio to the second strongest
+func (d *DTMFDetector) strongest(block []float64, frequencies [4]float64) (int, float64, float64) {
+	best, first, second := 0, 0.0, 0.0
+	for i, frequency := range frequencies {
+		power := goertzel(block, frequency, d.sampleRate)
+		if power > first {
+			best, first, second = i, power, first
+		} else if power > second {
+			second = power
+		}
+	}
+	if second == 0 {
+		return best, first, math.Inf(1)
+	}
+	return best, first, first / second
+}
+
+// goertzel returns the power of a frequency in a block
+func goertzel(block []float64, frequency float64, sampleRate int) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*frequency/float64(sampleRate))
+	var s1, s2 float64
+	for _, x := range block {
+		s1, s2 = x+coeff*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+// DTMFGenerate generates the tones of a sequence of keys, 100 ms for each
+// key followed by 100 ms of silence. Other characters are skipped.
+func DTMFGenerate(keys string, sampleRate int) []int16 {
+	length := sampleRate / 10
+	var samples []int16
+	for i := 0; i < len(keys); i++ {
+		row, column := -1, -1
+		for r := range dtmfKeys {
+			for c := range dtmfKeys[r] {
+				if dtmfKeys[r][c] == keys[i] {
+					row, column = r, c
+				}
+			}
+		}
+		if row < 0 {
+			continue
+		}
+
+		for j := 0; j < length; j++ {
+			t := float64(j) / float64(sampleRate)
+			value := math.Sin(2*math.Pi*dtmfRows[row]*t) + math.Sin(2*math.Pi*dtmfColumns[column]*t)
+			samples = append(samples, int16(value*0.25*math.MaxInt16))
+		}
+		samples = append(samples, make([]int16, length)...)
+	}
+	return samples
+}
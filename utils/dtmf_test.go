@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMulaw(t *testing.T) {
+	if b := MulawEncode(0); b != 0xff {
+		t.Errorf("Expected silence to encode as 0xff, got %#02x", b)
+	}
+	for _, sample := range []int16{1000, -1000, 12345, -32768, 32767} {
+		decoded := MulawDecode(MulawEncode(sample))
+		if diff := math.Abs(float64(decoded) - float64(sample)); diff > math.Abs(float64(sample))/16+8 {
+			t.Errorf("Sample %d decoded as %d", sample, decoded)
+		}
+	}
+}
+
+func TestDTMFDetector(t *testing.T) {
+	const keys = "0123456789*#ABCD"
+
+	// Through the telephone codec, with noise, and as a WAV fixture
+	samples := DTMFGenerate(keys, 8000)
+	rng := rand.New(rand.NewSource(1))
+	for i := range samples {
+		noisy := float64(samples[i]) + rng.NormFloat64()*300
+		samples[i] = MulawDecode(MulawEncode(int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, noisy)))))
+	}
+	samples, sampleRate, err := ReadWAV(WriteWAV(samples, 8000))
+	if err != nil {
+		t.Fatalf("Error reading WAV: %v", err)
+	}
+
+	// Fed in packet-sized pieces
+	detector := NewDTMFDetector(sampleRate)
+	var digits []byte
+	for len(samples) > 0 {
+		n := min(160, len(samples))
+		digits = append(digits, detector.Process(samples[:n])...)
+		samples = samples[n:]
+	}
+	if string(digits) != keys {
+		t.Errorf("Expected %q, got %q", keys, digits)
+	}
+
+	// A held key is one digit, and other sounds are none
+	held := DTMFGenerate("5", 16000)
+	held = append(held[:1600:1600], held[:1600]...)
+	if digits := NewDTMFDetector(16000).Process(held); string(digits) != "5" {
+		t.Errorf("Expected one digit for a held key, got %q", digits)
+	}
+
+	var voice []int16
+	for i := 0; i < 8000; i++ {
+		x := float64(i) / 8000
+		voice = append(voice, int16(4000*(math.Sin(2*math.Pi*220*x)+0.6*math.Sin(2*math.Pi*440*x)+0.4*math.Sin(2*math.Pi*880*x)+0.3*math.Sin(2*math.Pi*1320*x))))
+	}
+	if digits := NewDTMFDetector(8000).Process(voice); len(digits) != 0 {
+		t.Errorf("Expected no digits in a voice-like sound, got %q", digits)
+	}
+}
+
+func TestResample(t *testing.T) {
+	samples := []int16{0, 100, 200, 300}
+	if out := Resample(samples, 8000, 16000); len(out) != 8 || out[1] != 50 {
+		t.Errorf("Unexpected upsampled audio %v", out)
+	}
+	if out := Resample(samples, 16000, 8000); len(out) != 2 || out[1] != 200 {
+		t.Errorf("Unexpected downsampled audio %v", out)
+	}
+}
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// End-to-end encrypted SMS use NaCl box (X25519, XSalsa20 and Poly1305)
+// between a key registered by the client and the key of the gateway.
+//
+// A sealed message is a version byte, the 24-byte nonce and the box. Nonces
+// start with the Unix time in seconds as a big-endian 64-bit integer,
+// followed by 16 random bytes, so that the gateway can refuse old requests.
+//
+//	key <base64 client public key>   register the client key
+//	e2e <base64 sealed command>      encrypted request
+//
+// The reply to a request is the request nonce followed by the reply text,
+// sealed by the gateway and sent in GW parts of E2EPartSize bytes, each
+// "GW<n>|" followed by the base64 of the part.
+const (
+	// E2EKeySize is the size of public and private keys
+	E2EKeySize = 32
+	// E2ENonceSize is the size of nonces
+	E2ENonceSize = 24
+	// E2EPartSize is the number of sealed bytes carried by a GW part, 500
+	// characters once encoded
+	E2EPartSize = 375
+	// E2ERequestCommand and E2EKeyCommand start encrypted requests and key
+	// registrations
+	E2ERequestCommand = "e2e"
+	E2EKeyCommand     = "key"
+	// e2eVersion is the first byte of sealed messages
+	e2eVersion = 1
+)
+
+// GenerateE2EKey generates a new key pair
+func GenerateE2EKey() (publicKey, privateKey *[E2EKeySize]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+// E2EPublicKey returns the public key of a private key
+func E2EPublicKey(privateKey *[E2EKeySize]byte) *[E2EKeySize]byte {
+	public, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		// Only low order points fail, the base point is not one of them
+		panic(err)
+	}
+	return (*[E2EKeySize]byte)(public)
+}
+
+// FormatE2EKey encodes a key in base64
+func FormatE2EKey(key *[E2EKeySize]byte) string {
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+// ParseE2EKey decodes a base64 key
+func ParseE2EKey(text string) (*[E2EKeySize]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding: %v", err)
+	}
+	if len(data) != E2EKeySize {
+		return nil, fmt.Errorf("invalid key size %d", len(data))
+	}
+	return (*[E2EKeySize]byte)(data), nil
+}
+
+// LoadOrCreateE2EKey reads a base64 private key from a file, or generates
+// one and saves it if the file does not exist
+func LoadOrCreateE2EKey(path string) (*[E2EKeySize]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ParseE2EKey(string(data))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading key: %v", err)
+	}
+
+	_, privateKey, err := GenerateE2EKey()
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(FormatE2EKey(privateKey)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("error saving key: %v", err)
+	}
+	return privateKey, nil
+}
+
+// NewE2ENonce returns a nonce carrying the given time
+func NewE2ENonce(now time.Time) (*[E2ENonceSize]byte, error) {
+	var nonce [E2ENonceSize]byte
+	binary.BigEndian.PutUint64(nonce[:8], uint64(now.Unix()))
+	if _, err := rand.Read(nonce[8:]); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	return &nonce, nil
+}
+
+// E2ENonceTime returns the time carried by a nonce
+func E2ENonceTime(nonce *[E2ENonceSize]byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(nonce[:8])), 0)
+}
+
+// SealE2E encrypts and authenticates a message for a peer
+func SealE2E(message []byte, nonce *[E2ENonceSize]byte, peersPublicKey, privateKey *[E2EKeySize]byte) []byte {
+	sealed := append([]byte{e2eVersion}, nonce[:]...)
+	return box.Seal(sealed, message, nonce, peersPublicKey, privateKey)
+}
+
+// E2ESealedSize returns the size of a message of messageSize bytes once
+// sealed
+func E2ESealedSize(messageSize int) int {
+	return 1 + E2ENonceSize + box.Overhead + messageSize
+}
+
+// OpenE2E decrypts a message sealed by a peer and returns it with its nonce
+func OpenE2E(sealed []byte, peersPublicKey, privateKey *[E2EKeySize]byte) ([]byte, *[E2ENonceSize]byte, error) {
+	if len(sealed) < 1+E2ENonceSize+box.Overhead {
+		return nil, nil, fmt.Errorf("sealed message too short")
+	}
+	if sealed[0] != e2eVersion {
+		return nil, nil, fmt.Errorf("unsupported version %d", sealed[0])
+	}
+
+	nonce := (*[E2ENonceSize]byte)(sealed[1 : 1+E2ENonceSize])
+	message, ok := box.Open(nil, sealed[1+E2ENonceSize:], nonce, peersPublicKey, privateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("message authentication failed")
+	}
+	return message, nonce, nil
+}
+
+// E2EClient builds encrypted requests and opens their replies. It is the
+// reference implementation of the client side of encrypted SMS.
+type E2EClient struct {
+	ServerKey  *[E2EKeySize]byte
+	PublicKey  *[E2EKeySize]byte
+	PrivateKey *[E2EKeySize]byte
+}
+
+// NewE2EClient creates a client for the gateway with the given public key
+func NewE2EClient(serverKey, privateKey *[E2EKeySize]byte) *E2EClient {
+	return &E2EClient{
+		ServerKey:  serverKey,
+		PublicKey:  E2EPublicKey(privateKey),
+		PrivateKey: privateKey,
+	}
+}
+
+// RegisterCommand returns the SMS that registers the client key
+func (c *E2EClient) RegisterCommand() string {
+	return E2EKeyCommand + " " + FormatE2EKey(c.PublicKey)
+}
+
+// Request returns the SMS carrying an encrypted command, and the nonce its
+// reply is matched with
+func (c *E2EClient) Request(command string) (string, *[E2ENonceSize]byte, error) {
+	nonce, err := NewE2ENonce(time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+	sealed := SealE2E([]byte(command), nonce, c.ServerKey, c.PrivateKey)
+	return E2ERequestCommand + " " + base64.StdEncoding.EncodeToString(sealed), nonce, nil
+}
+
+// Response decrypts the GW parts of a reply, in any order. The reply must
+// answer the request with requestNonce, unless it is nil.
+func (c *E2EClient) Response(parts []string, requestNonce *[E2ENonceSize]byte) (string, error) {
+	sealed, err := DecodeGWParts(parts)
+	if err != nil {
+		return "", err
+	}
+	message, _, err := OpenE2E(sealed, c.ServerKey, c.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	if len(message) < E2ENonceSize {
+		return "", fmt.Errorf("reply too short")
+	}
+	if requestNonce != nil && !bytes.Equal(message[:E2ENonceSize], requestNonce[:]) {
+		return "", fmt.Errorf("reply to another request")
+	}
+	return string(message[E2ENonceSize:]), nil
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// e2eVectors are the test vectors of encrypted SMS, shared with client
+// implementations in other languages
+type e2eVectors struct {
+	ServerPrivateKey string `json:"server_private_key"`
+	ServerPublicKey  string `json:"server_public_key"`
+	ClientPrivateKey string `json:"client_private_key"`
+	ClientPublicKey  string `json:"client_public_key"`
+	Register         string `json:"register"`
+	Requests         []struct {
+		Nonce   string `json:"nonce"`
+		Command string `json:"command"`
+		SMS     string `json:"sms"`
+	} `json:"requests"`
+	Responses []struct {
+		RequestNonce string   `json:"request_nonce"`
+		Nonce        string   `json:"nonce"`
+		Reply        string   `json:"reply"`
+		Parts        []string `json:"parts"`
+	} `json:"responses"`
+}
+
+func mustParseKey(t *testing.T, text string) *[E2EKeySize]byte {
+	t.Helper()
+	key, err := ParseE2EKey(text)
+	if err != nil {
+		t.Fatalf("Error parsing key %q: %v", text, err)
+	}
+	return key
+}
+
+func mustParseNonce(t *testing.T, text string) *[E2ENonceSize]byte {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(data) != E2ENonceSize {
+		t.Fatalf("Invalid nonce %q", text)
+	}
+	return (*[E2ENonceSize]byte)(data)
+}
+
+func TestE2EVectors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "e2e_vectors.json"))
+	if err != nil {
+		t.Fatalf("Error reading vectors: %v", err)
+	}
+	var vectors e2eVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Error decoding vectors: %v", err)
+	}
+
+	serverPrivate := mustParseKey(t, vectors.ServerPrivateKey)
+	serverPublic := mustParseKey(t, vectors.ServerPublicKey)
+	clientPrivate := mustParseKey(t, vectors.ClientPrivateKey)
+	clientPublic := mustParseKey(t, vectors.ClientPublicKey)
+	if *E2EPublicKey(serverPrivate) != *serverPublic || *E2EPublicKey(clientPrivate) != *clientPublic {
+		t.Fatal("Public keys do not match the private keys")
+	}
+
+	client := NewE2EClient(serverPublic, clientPrivate)
+	if got := client.RegisterCommand(); got != vectors.Register {
+		t.Errorf("Expected registration %q, got %q", vectors.Register, got)
+	}
+
+	for _, request := range vectors.Requests {
+		nonce := mustParseNonce(t, request.Nonce)
+		sealed := SealE2E([]byte(request.Command), nonce, serverPublic, clientPrivate)
+		if got := E2ERequestCommand + " " + base64.StdEncoding.EncodeToString(sealed); got != request.SMS {
+			t.Errorf("Expected request %q, got %q", request.SMS, got)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(request.SMS, E2ERequestCommand+" "))
+		if err != nil {
+			t.Fatalf("Error decoding request: %v", err)
+		}
+		command, opened, err := OpenE2E(data, clientPublic, serverPrivate)
+		if err != nil {
+			t.Fatalf("Error opening request: %v", err)
+		}
+		if string(command) != request.Command || *opened != *nonce {
+			t.Errorf("Expected command %q, got %q", request.Command, command)
+		}
+	}
+
+	for _, response := range vectors.Responses {
+		requestNonce := mustParseNonce(t, response.RequestNonce)
+		message := append(requestNonce[:], response.Reply...)
+		sealed := SealE2E(message, mustParseNonce(t, response.Nonce), clientPublic, serverPrivate)
+		parts := SplitAndEncodeBytes(sealed, E2EPartSize)
+		if strings.Join(parts, "\n") != strings.Join(response.Parts, "\n") {
+			t.Errorf("Expected parts %q, got %q", response.Parts, parts)
+		}
+
+		reply, err := client.Response(response.Parts, requestNonce)
+		if err != nil {
+			t.Fatalf("Error opening reply: %v", err)
+		}
+		if reply != response.Reply {
+			t.Errorf("Expected reply %q, got %q", response.Reply, reply)
+		}
+	}
+}
+
+func TestE2EClientResponse(t *testing.T) {
+	serverPublic, serverPrivate, err := GenerateE2EKey()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	_, clientPrivate, err := GenerateE2EKey()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	client := NewE2EClient(serverPublic, clientPrivate)
+
+	sms, requestNonce, err := client.Request("weather ankara")
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	if since := time.Since(E2ENonceTime(requestNonce)); since < 0 || since > time.Minute {
+		t.Errorf("Expected the nonce to carry the current time, got %v", E2ENonceTime(requestNonce))
+	}
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sms, "e2e "))
+	if _, _, err := OpenE2E(data, client.PublicKey, serverPrivate); err != nil {
+		t.Fatalf("Error opening request: %v", err)
+	}
+
+	reply := strings.Repeat("uzun yanıt ", 100)
+	nonce, _ := NewE2ENonce(time.Now())
+	sealed := SealE2E(append(requestNonce[:], reply...), nonce, client.PublicKey, serverPrivate)
+	parts := SplitAndEncodeBytes(sealed, E2EPartSize)
+	if len(parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d", len(parts))
+	}
+
+	// Parts may arrive in any order
+	reversed := []string{parts[3], parts[2], parts[1], parts[0]}
+	if got, err := client.Response(reversed, requestNonce); err != nil || got != reply {
+		t.Errorf("Expected the reply, got %q, %v", got, err)
+	}
+	if _, err := client.Response(parts[:3], requestNonce); err == nil {
+		t.Error("Expected an error for a missing part")
+	}
+
+	otherNonce, _ := NewE2ENonce(time.Now())
+	if _, err := client.Response(parts, otherNonce); err == nil {
+		t.Error("Expected an error for the reply to another request")
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := client.Response(SplitAndEncodeBytes(tampered, E2EPartSize), requestNonce); err == nil {
+		t.Error("Expected an error for a tampered reply")
+	}
+}
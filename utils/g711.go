@@ -0,0 +1,42 @@
+package utils
+
+// G.711 μ-law constants
+const (
+	mulawBias = 0x84
+	mulawClip = 32635
+)
+
+// MulawEncode compresses a 16-bit sample to G.711 μ-law, the PCMU codec of
+// telephone audio
+func MulawEncode(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > mulawClip {
+		s = mulawClip
+	}
+	s += mulawBias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := s >> (exponent + 3) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// MulawDecode expands a G.711 μ-law byte to a 16-bit sample
+func MulawDecode(b byte) int16 {
+	b = ^b
+	exponent := int(b>>4) & 0x07
+	mantissa := int(b & 0x0f)
+	s := (mantissa<<3 + mulawBias) << exponent
+	s -= mulawBias
+	if b&0x80 != 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// markdownLinkPattern matches inline links and images, e.g. [text](url)
+	markdownLinkPattern = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	// markdownHeadingPattern matches ATX headings
+	markdownHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	// markdownListPattern matches unordered list items
+	markdownListPattern = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	// markdownStrongPattern matches bold text
+	markdownStrongPattern = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+)
+
+// MarkdownToGemtext converts Markdown to gemtext (text/gemini). Gemtext has
+// no inline links, so links are kept as text and listed as link lines after
+// the line they appear in.
+func MarkdownToGemtext(markdown string) string {
+	var out []string
+	preformatted := false
+
+	for _, line := range strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		// Code blocks map to preformatted text and are copied as is
+		if strings.HasPrefix(trimmed, "```") {
+			preformatted = !preformatted
+			out = append(out, "```")
+			continue
+		}
+		if preformatted {
+			out = append(out, line)
+			continue
+		}
+
+		text, links := extractMarkdownLinks(trimmed)
+		text = markdownStrongPattern.ReplaceAllString(text, "$2")
+
+		switch {
+		case markdownHeadingPattern.MatchString(text):
+			match := markdownHeadingPattern.FindStringSubmatch(text)
+			// Gemtext has three heading levels
+			level := len(match[1])
+			if level > 3 {
+				level = 3
+			}
+			text = strings.Repeat("#", level) + " " + match[2]
+		case markdownListPattern.MatchString(text):
+			text = "* " + markdownListPattern.FindStringSubmatch(text)[1]
+		case strings.HasPrefix(text, "=>"):
+			// A line starting like a link line must stay text
+			text = " " + text
+		}
+
+		// Keep blank lines, but not the ones left over by image-only lines
+		if text != "" || len(links) == 0 {
+			out = append(out, text)
+		}
+		out = append(out, links...)
+	}
+
+	if preformatted {
+		out = append(out, "```")
+	}
+	return strings.Join(out, "\n")
+}
+
+// extractMarkdownLinks replaces the links of a line by their text and
+// returns them as gemtext link lines
+func extractMarkdownLinks(line string) (string, []string) {
+	var links []string
+	text := markdownLinkPattern.ReplaceAllStringFunc(line, func(link string) string {
+		match := markdownLinkPattern.FindStringSubmatch(link)
+		label := strings.TrimSpace(match[2])
+		if match[1] == "!" {
+			if label == "" {
+				label = "Image"
+			} else {
+				label = "Image: " + label
+			}
+			links = append(links, "=> "+match[3]+" "+label)
+			return ""
+		}
+
+		if label == "" {
+			label = match[3]
+		}
+		links = append(links, "=> "+match[3]+" "+label)
+		return label
+	})
+	return strings.TrimSpace(text), links
+}
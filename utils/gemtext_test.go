@@ -0,0 +1,40 @@
+package utils
+
+import "testing"
+
+func TestMarkdownToGemtext(t *testing.T) {
+	markdown := "# Title\n" +
+		"#### Deep heading ####\n" +
+		"Read **the** [docs](https://example.org/docs) and [faq](https://example.org/faq \"FAQ\").\n" +
+		"\n" +
+		"![Logo](https://example.org/logo.png)\n" +
+		"- first\n" +
+		"  * second\n" +
+		"=> not a link\n" +
+		"```\n" +
+		"- [kept](as is)\n" +
+		"```"
+
+	expected := "# Title\n" +
+		"### Deep heading\n" +
+		"Read the docs and faq.\n" +
+		"=> https://example.org/docs docs\n" +
+		"=> https://example.org/faq faq\n" +
+		"\n" +
+		"=> https://example.org/logo.png Image: Logo\n" +
+		"* first\n" +
+		"* second\n" +
+		" => not a link\n" +
+		"```\n" +
+		"- [kept](as is)\n" +
+		"```"
+
+	if got := MarkdownToGemtext(markdown); got != expected {
+		t.Errorf("Unexpected gemtext:\n%s\n\nExpected:\n%s", got, expected)
+	}
+
+	// Unterminated code blocks are closed
+	if got := MarkdownToGemtext("```\ncode"); got != "```\ncode\n```" {
+		t.Errorf("Expected code block to be closed, got:\n%s", got)
+	}
+}
@@ -0,0 +1,155 @@
+package utils
+
+const (
+	// hdlcFlag delimits frames
+	hdlcFlag = 0x7e
+	// hdlcMaxFrame is the maximum length of a received frame
+	hdlcMaxFrame = 4096
+)
+
+// HDLCEncode frames data for transmission: each frame gets a CRC and is
+// bit stuffed between flags, and the bits are NRZI encoded into tones (a
+// zero bit changes the tone, a one keeps it). Preamble flags give the
+// receiver time to synchronize.
+func HDLCEncode(frames [][]byte, preambleFlags int) []bool {
+	var bits []bool
+	appendFlag := func() {
+		for i := 0; i < 8; i++ {
+			bits = append(bits, hdlcFlag>>i&1 == 1)
+		}
+	}
+
+	for i := 0; i < preambleFlags; i++ {
+		appendFlag()
+	}
+	appendFlag()
+	for _, frame := range frames {
+		crc := CRC16X25(frame)
+		data := append(append([]byte(nil), frame...), byte(crc), byte(crc>>8))
+
+		ones := 0
+		for _, b := range data {
+			for i := 0; i < 8; i++ {
+				bit := b>>i&1 == 1
+				bits = append(bits, bit)
+				if !bit {
+					ones = 0
+					continue
+				}
+				// Five ones in a row are followed by a zero, so data never
+				// looks like a flag
+				if ones++; ones == 5 {
+					bits = append(bits, false)
+					ones = 0
+				}
+			}
+		}
+		appendFlag()
+	}
+	// Trailing flags let the receiver finish the last frame
+	appendFlag()
+	appendFlag()
+
+	tones := make([]bool, len(bits))
+	mark := true
+	for i, bit := range bits {
+		if !bit {
+			mark = !mark
+		}
+		tones[i] = mark
+	}
+	return tones
+}
+
+// HDLCDecode finds the frames with a valid CRC in a sequence of NRZI tones
+// and returns them without the CRC
+func HDLCDecode(tones []bool) [][]byte {
+	var frames [][]byte
+	var bits []bool
+	var shift byte
+	ones := 0
+	inFrame := false
+
+	for i := 1; i < len(tones); i++ {
+		bit := tones[i] == tones[i-1]
+
+		shift >>= 1
+		if bit {
+			shift |= 0x80
+		}
+
+		if shift == hdlcFlag {
+			// The flag's other bits were taken as data
+			if inFrame && len(bits) >= 7 {
+				if frame := hdlcBytes(bits[:len(bits)-7]); frame != nil {
+					frames = append(frames, frame)
+				}
+			}
+			bits = bits[:0]
+			ones = 0
+			inFrame = true
+			continue
+		}
+		if !inFrame {
+			continue
+		}
+
+		if !bit {
+			if ones == 5 {
+				// Stuffed bit
+				ones = 0
+				continue
+			}
+			ones = 0
+		} else if ones++; ones > 6 {
+			// Seven ones abort the frame
+			inFrame = false
+			bits = bits[:0]
+			continue
+		}
+
+		if len(bits) < hdlcMaxFrame*8+16 {
+			bits = append(bits, bit)
+		}
+	}
+	return frames
+}
+
+// hdlcBytes packs the bits of a received frame into bytes and checks the
+// CRC, returning nil for invalid frames
+func hdlcBytes(bits []bool) []byte {
+	if len(bits)%8 != 0 || len(bits) < 24 {
+		return nil
+	}
+
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+
+	frame := data[:len(data)-2]
+	crc := CRC16X25(frame)
+	if data[len(data)-2] != byte(crc) || data[len(data)-1] != byte(crc>>8) {
+		return nil
+	}
+	return frame
+}
+
+// CRC16X25 computes the CRC-16/X.25 of data, the frame check sequence of
+// HDLC and AX.25
+func CRC16X25(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// IdentityKeys pseudonymizes the identities of users (phone numbers, chat
+// IDs, email addresses) before they are stored. Identities that are only
+// looked up are stored as a keyed hash, and addresses that messages are
+// sent to are encrypted.
+//
+// The first secret is the current one. Previous secrets are kept after a
+// rotation so that rows stored with them can still be found and moved to
+// the current secret.
+type IdentityKeys struct {
+	hashKeys [][]byte
+	aeads    []cipher.AEAD
+}
+
+// NewIdentityKeys creates identity keys from the current secret and the
+// secrets it replaced, newest first
+func NewIdentityKeys(secrets ...string) (*IdentityKeys, error) {
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("no identity secret")
+	}
+
+	keys := &IdentityKeys{}
+	for _, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("empty identity secret")
+		}
+		keys.hashKeys = append(keys.hashKeys, deriveIdentityKey(secret, "neo146 identity hash"))
+
+		block, err := aes.NewCipher(deriveIdentityKey(secret, "neo146 identity encryption"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keys.aeads = append(keys.aeads, aead)
+	}
+	return keys, nil
+}
+
+// deriveIdentityKey derives a 256-bit key for one use of a secret
+func deriveIdentityKey(secret, label string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// LoadOrCreateIdentitySecrets reads identity secrets from a file, one per
+// line with the current secret first, or generates one and saves it if the
+// file does not exist
+func LoadOrCreateIdentitySecrets(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("error generating identity secret: %v", err)
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(secret) + "\n")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("error saving identity secret: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading identity secrets: %v", err)
+	}
+
+	var secrets []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			secrets = append(secrets, line)
+		}
+	}
+	return secrets, nil
+}
+
+// Rotated reports whether previous secrets are kept
+func (k *IdentityKeys) Rotated() bool {
+	return len(k.hashKeys) > 1
+}
+
+// Hash returns the pseudonym of an identity under the current secret
+func (k *IdentityKeys) Hash(identity string) string {
+	return hashIdentity(k.hashKeys[0], identity)
+}
+
+// PreviousHashes returns the pseudonyms of an identity under the previous
+// secrets
+func (k *IdentityKeys) PreviousHashes(identity string) []string {
+	var hashes []string
+	for _, key := range k.hashKeys[1:] {
+		hashes = append(hashes, hashIdentity(key, identity))
+	}
+	return hashes
+}
+
+// hashIdentity returns the keyed hash of an identity
+func hashIdentity(key []byte, identity string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(identity))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Seal encrypts an address with the current secret
+func (k *IdentityKeys) Seal(address string) (string, error) {
+	aead := k.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(address)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(address), nil)), nil
+}
+
+// Open decrypts an address sealed with any of the secrets, and reports
+// whether it was sealed with the current one
+func (k *IdentityKeys) Open(sealed string) (string, bool, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid sealed address: %v", err)
+	}
+	for i, aead := range k.aeads {
+		if len(data) < aead.NonceSize() {
+			break
+		}
+		address, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+		if err == nil {
+			return string(address), i == 0, nil
+		}
+	}
+	return "", false, fmt.Errorf("sealed address does not match any identity secret")
+}
+
+// logIdentityKey keys the pseudonyms of identities in logs. It is random for
+// each run, so logs can be correlated within a run but not with the
+// database or with other runs.
+var logIdentityKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// RedactIdentity returns a short pseudonym of an identity to log instead of
+// the identity itself
+func RedactIdentity(identity string) string {
+	if identity == "" {
+		return ""
+	}
+	return "id:" + hashIdentity(logIdentityKey, identity)[:10]
+}
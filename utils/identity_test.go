@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIdentityKeysRotation(t *testing.T) {
+	old, err := NewIdentityKeys("old secret")
+	if err != nil {
+		t.Fatalf("Error creating keys: %v", err)
+	}
+	rotated, err := NewIdentityKeys("new secret", "old secret")
+	if err != nil {
+		t.Fatalf("Error creating keys: %v", err)
+	}
+
+	if old.Hash("+905551112233") != old.Hash("+905551112233") {
+		t.Error("Expected hashes to be stable")
+	}
+	if old.Hash("+905551112233") == old.Hash("+905551112234") {
+		t.Error("Expected different identities to have different hashes")
+	}
+	if strings.Contains(old.Hash("+905551112233"), "5551112233") {
+		t.Error("Expected the hash not to contain the identity")
+	}
+	if old.Rotated() || !rotated.Rotated() {
+		t.Error("Expected only the keys with previous secrets to be rotated")
+	}
+
+	if rotated.Hash("+905551112233") == old.Hash("+905551112233") {
+		t.Error("Expected the new secret to give a new hash")
+	}
+	previous := rotated.PreviousHashes("+905551112233")
+	if len(previous) != 1 || previous[0] != old.Hash("+905551112233") {
+		t.Errorf("Expected the hash of the old secret, got %v", previous)
+	}
+
+	sealed, err := old.Seal("12345")
+	if err != nil {
+		t.Fatalf("Error sealing address: %v", err)
+	}
+	if address, current, err := old.Open(sealed); err != nil || address != "12345" || !current {
+		t.Errorf("Expected the current address, got %q, %v, %v", address, current, err)
+	}
+	if address, current, err := rotated.Open(sealed); err != nil || address != "12345" || current {
+		t.Errorf("Expected the address of a previous secret, got %q, %v, %v", address, current, err)
+	}
+	if _, _, err := mustIdentityKeys(t, "other secret").Open(sealed); err == nil {
+		t.Error("Expected an error for an unknown secret")
+	}
+}
+
+func mustIdentityKeys(t *testing.T, secrets ...string) *IdentityKeys {
+	t.Helper()
+	keys, err := NewIdentityKeys(secrets...)
+	if err != nil {
+		t.Fatalf("Error creating keys: %v", err)
+	}
+	return keys
+}
+
+func TestLoadOrCreateIdentitySecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.secret")
+
+	secrets, err := LoadOrCreateIdentitySecrets(path)
+	if err != nil || len(secrets) != 1 {
+		t.Fatalf("Expected a generated secret, got %v, %v", secrets, err)
+	}
+	again, err := LoadOrCreateIdentitySecrets(path)
+	if err != nil || len(again) != 1 || again[0] != secrets[0] {
+		t.Fatalf("Expected the saved secret, got %v, %v", again, err)
+	}
+
+	// Rotating adds the new secret first
+	if err := os.WriteFile(path, []byte("new\n# rotated\n"+secrets[0]+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := LoadOrCreateIdentitySecrets(path)
+	if err != nil || len(rotated) != 2 || rotated[0] != "new" || rotated[1] != secrets[0] {
+		t.Errorf("Expected the new and previous secrets, got %v, %v", rotated, err)
+	}
+}
+
+func TestRedactIdentity(t *testing.T) {
+	redacted := RedactIdentity("+905551112233")
+	if strings.Contains(redacted, "555") || !strings.HasPrefix(redacted, "id:") {
+		t.Errorf("Expected a pseudonym, got %q", redacted)
+	}
+	if RedactIdentity("+905551112233") != redacted {
+		t.Error("Expected the pseudonym to be stable within a run")
+	}
+	if RedactIdentity("") != "" {
+		t.Error("Expected an empty identity to stay empty")
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logIdentityAttrs are the attribute keys whose values are identities of
+// users. They are replaced with pseudonyms.
+var logIdentityAttrs = map[string]bool{
+	"phone":     true,
+	"address":   true,
+	"sender":    true,
+	"recipient": true,
+	"email":     true,
+	"chat_id":   true,
+	"user":      true,
+	"jid":       true,
+	"callsign":  true,
+	"node":      true,
+	"ip":        true,
+}
+
+var (
+	// logSecretAttr matches the attribute keys whose values are secrets
+	logSecretAttr = regexp.MustCompile(`(?i)password|passwd|secret|token|authorization|cookie|api_?key|signature`)
+
+	// Secrets in free text: JSON fields, query parameters, bearer tokens and
+	// Telegram bot tokens, which start with the numeric bot ID
+	logSecretJSON  = regexp.MustCompile(`(?i)("(?:password|passwd|secret|token|api_?key)"\s*:\s*)"[^"]*"`)
+	logSecretParam = regexp.MustCompile(`(?i)\b((?:password|passwd|secret|token|access_token|api_?key)=)[^&\s"]+`)
+	logBearer      = regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	logBotToken    = regexp.MustCompile(`\d{6,12}:[A-Za-z0-9_-]{30,}\b`)
+
+	// Identities in free text
+	logEmail = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	logPhone = regexp.MustCompile(`\+?\b\d{10,15}\b`)
+)
+
+// RedactText replaces secrets, email addresses and phone numbers in text
+func RedactText(text string) string {
+	text = logSecretJSON.ReplaceAllString(text, `$1"[redacted]"`)
+	text = logSecretParam.ReplaceAllString(text, "${1}[redacted]")
+	text = logBearer.ReplaceAllString(text, "${1}[redacted]")
+	text = logBotToken.ReplaceAllString(text, "[redacted]")
+	text = logEmail.ReplaceAllStringFunc(text, RedactIdentity)
+	return logPhone.ReplaceAllStringFunc(text, RedactIdentity)
+}
+
+// NewLogger creates a structured logger writing records at or above level
+// as text or JSON. Secrets and identities of users are redacted from
+// messages and attributes.
+func NewLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if format == LogFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(&redactingHandler{handler})
+}
+
+// redactingHandler redacts records before passing them to a handler
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, RedactText(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &redactingHandler{h.Handler.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr redacts the value of an attribute by its key, or the secrets
+// and identities in its text
+func redactAttr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	key := strings.ToLower(attr.Key)
+
+	switch {
+	case attr.Value.Kind() == slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, groupAttr := range group {
+			redacted[i] = redactAttr(groupAttr)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	case logSecretAttr.MatchString(key):
+		return slog.String(attr.Key, "[redacted]")
+	case logIdentityAttrs[key]:
+		return slog.String(attr.Key, RedactIdentity(attr.Value.String()))
+	case attr.Value.Kind() == slog.KindString:
+		return slog.String(attr.Key, RedactText(attr.Value.String()))
+	case attr.Value.Kind() == slog.KindAny:
+		// Errors often quote what failed, e.g. an address or a URL
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, RedactText(err.Error()))
+		}
+	}
+	return attr
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactText(t *testing.T) {
+	tests := []struct {
+		text, leaked string
+	}{
+		{`{"username":"neo","password":"hunter2"}`, "hunter2"},
+		{"GET /send?to=1&api_key=abc123", "abc123"},
+		{"Authorization: Bearer eyJhbGciOi.x", "eyJhbGciOi"},
+		{"https://api.telegram.org/bot123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw/getMe", "AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"},
+		{"message from user@example.com", "user@example.com"},
+		{"message from +905551112233", "5551112233"},
+	}
+	for _, test := range tests {
+		if redacted := RedactText(test.text); strings.Contains(redacted, test.leaked) {
+			t.Errorf("Expected %q to be redacted, got %q", test.leaked, redacted)
+		}
+	}
+
+	if RedactText("broadcast 42 sent to 3 channels") != "broadcast 42 sent to 3 channels" {
+		t.Error("Expected text without secrets to be unchanged")
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LogFormatJSON, slog.LevelInfo).With("phone", "+905551112233")
+
+	logger.Debug("Hidden")
+	logger.Info("Sending to user@example.com",
+		"password", "hunter2",
+		"count", 2,
+		"error", errors.New("dial 905551112233: refused"),
+		slog.Group("request", "email", "user@example.com"),
+	)
+
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("Expected one record above the level, got %q", buf.String())
+	}
+	for _, leaked := range []string{"hunter2", "5551112233", "user@example.com"} {
+		if strings.Contains(buf.String(), leaked) {
+			t.Errorf("Expected %q to be redacted, got %s", leaked, buf.String())
+		}
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record: %v", err)
+	}
+	if record["phone"] != RedactIdentity("+905551112233") {
+		t.Errorf("Expected the phone to be pseudonymized, got %v", record["phone"])
+	}
+	if record["count"] != float64(2) {
+		t.Errorf("Expected other attributes to be kept, got %v", record["count"])
+	}
+}
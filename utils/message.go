@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
 )
 
 // IsURL checks if the text is a URL
@@ -65,6 +69,83 @@ func SplitAndEncodeMessage(message string, maxLength int) []string {
 	return encodedParts
 }
 
+// SplitAndEncodeBytes splits binary data into parts of maximum size and
+// encodes each part with a GW header, like SplitAndEncodeMessage
+func SplitAndEncodeBytes(data []byte, maxSize int) []string {
+	var encodedParts []string
+	for i := 0; i < len(data); i += maxSize {
+		end := Min(i+maxSize, len(data))
+		header := fmt.Sprintf("GW%d|", len(encodedParts)+1)
+		encodedParts = append(encodedParts, header+base64.StdEncoding.EncodeToString(data[i:end]))
+	}
+	return encodedParts
+}
+
+// DecodeGWParts joins the decoded content of GW parts, which may be given
+// in any order
+func DecodeGWParts(parts []string) ([]byte, error) {
+	decoded := make([][]byte, len(parts))
+	for _, part := range parts {
+		header, encoded, found := strings.Cut(strings.TrimSpace(part), "|")
+		n, err := strconv.Atoi(strings.TrimPrefix(header, "GW"))
+		if !found || !strings.HasPrefix(header, "GW") || err != nil {
+			return nil, fmt.Errorf("invalid part header %q", header)
+		}
+		if n < 1 || n > len(parts) || decoded[n-1] != nil {
+			return nil, fmt.Errorf("unexpected part %d of %d", n, len(parts))
+		}
+
+		decoded[n-1], err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid part %d: %v", n, err)
+		}
+	}
+	return bytes.Join(decoded, nil), nil
+}
+
+// gsm7Basic is the GSM 03.38 basic character set
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension is the GSM 03.38 extension table, each character takes two
+// septets
+const gsm7Extension = "^{}\\[~]|€\f"
+
+// CountSMSSegments returns the number of SMS segments needed to send a
+// message, using GSM 7-bit encoding when possible and UCS-2 otherwise
+func CountSMSSegments(message string) int {
+	if message == "" {
+		return 0
+	}
+
+	septets := 0
+	gsm7 := true
+	for _, r := range message {
+		if strings.ContainsRune(gsm7Basic, r) {
+			septets++
+		} else if strings.ContainsRune(gsm7Extension, r) {
+			septets += 2
+		} else {
+			gsm7 = false
+			break
+		}
+	}
+
+	if gsm7 {
+		if septets <= 160 {
+			return 1
+		}
+		return (septets + 152) / 153
+	}
+
+	// UCS-2 counts UTF-16 code units
+	units := len(utf16.Encode([]rune(message)))
+	if units <= 70 {
+		return 1
+	}
+	return (units + 66) / 67
+}
+
 // Min returns the minimum of two integers
 func Min(a, b int) int {
 	if a < b {
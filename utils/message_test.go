@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -169,3 +170,28 @@ func TestMin(t *testing.T) {
 		}
 	}
 }
+
+func TestCountSMSSegments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{"Empty message", "", 0},
+		{"Single GSM segment", strings.Repeat("a", 160), 1},
+		{"Two GSM segments", strings.Repeat("a", 161), 2},
+		{"Extension characters count double", strings.Repeat("€", 80), 1},
+		{"Extension characters overflow", strings.Repeat("€", 81), 2},
+		{"Single UCS-2 segment", strings.Repeat("ş", 70), 1},
+		{"Two UCS-2 segments", strings.Repeat("ş", 71), 2},
+		{"Three UCS-2 segments", "ğ" + strings.Repeat("a", 134), 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CountSMSSegments(tc.input); got != tc.expected {
+				t.Errorf("Expected %d segments, got %d", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of the gateway. Labels never hold identities of users, only
+// bounded values such as channel, command or service names.
+var (
+	DefaultMetrics = NewMetrics()
+
+	InboundMessages = DefaultMetrics.NewCounter("neo146_inbound_messages_total",
+		"Messages received from users, by channel and command.", "channel", "command")
+	OutboundSMSSegments = DefaultMetrics.NewCounter("neo146_outbound_sms_segments_total",
+		"SMS segments handed to a provider, by provider and result.", "provider", "result")
+	SMSCost = DefaultMetrics.NewCounter("neo146_sms_cost_total",
+		"Estimated cost of the SMS sent, by provider.", "provider")
+	UpstreamRequests = DefaultMetrics.NewCounter("neo146_upstream_requests_total",
+		"Requests to upstream services, by service and result.", "service", "result")
+	UpstreamDuration = DefaultMetrics.NewHistogram("neo146_upstream_request_duration_seconds",
+		"Latency of requests to upstream services.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "service")
+	RateLimitRejections = DefaultMetrics.NewCounter("neo146_rate_limit_rejections_total",
+		"Requests refused by a rate limit, by channel.", "channel")
+	CacheRequests = DefaultMetrics.NewCounter("neo146_cache_requests_total",
+		"Cache lookups, by cache and result (hit or miss).", "cache", "result")
+	QueueDepth = DefaultMetrics.NewGauge("neo146_queue_depth",
+		"Items waiting in a queue.", "queue")
+)
+
+// Metrics holds counters, gauges and histograms and writes them in the
+// Prometheus text format
+type Metrics struct {
+	mu       sync.Mutex
+	families []*metricFamily
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// metricFamily is a metric with one series per combination of label values
+type metricFamily struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// metricSeries holds the value of a counter or gauge, or the bucket counts,
+// sum and count of a histogram
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+// Counter is a value that only goes up
+type Counter struct{ family *metricFamily }
+
+// Gauge is a value that can go up and down
+type Gauge struct{ family *metricFamily }
+
+// Histogram counts observations in buckets
+type Histogram struct{ family *metricFamily }
+
+// NewCounter adds a counter with the given label names
+func (m *Metrics) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{m.add(name, help, "counter", labels, nil)}
+}
+
+// NewGauge adds a gauge with the given label names
+func (m *Metrics) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m.add(name, help, "gauge", labels, nil)}
+}
+
+// NewHistogram adds a histogram with the given upper bounds of its buckets,
+// in increasing order, and label names
+func (m *Metrics) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{m.add(name, help, "histogram", labels, buckets)}
+}
+
+func (m *Metrics) add(name, help, kind string, labels []string, buckets []float64) *metricFamily {
+	family := &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*metricSeries),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.families = append(m.families, family)
+	return family
+}
+
+// Inc adds one to the counter with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a non-negative value to the counter with the given label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.family.update(labelValues, func(series *metricSeries) {
+		series.value += value
+	})
+}
+
+// Set sets the gauge with the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.update(labelValues, func(series *metricSeries) {
+		series.value = value
+	})
+}
+
+// Observe records a value in the histogram with the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.family.update(labelValues, func(series *metricSeries) {
+		for i, bound := range h.family.buckets {
+			if value <= bound {
+				series.counts[i]++
+			}
+		}
+		series.value += value
+		series.count++
+	})
+}
+
+// update applies a change to the series with the given label values,
+// creating it if needed
+func (f *metricFamily) update(labelValues []string, change func(*metricSeries)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series, found := f.series[key]
+	if !found {
+		series = &metricSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = series
+	}
+	change(series)
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition
+// format, with series sorted by their label values
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	families := append([]*metricFamily(nil), m.families...)
+	m.mu.Unlock()
+
+	out := bufio.NewWriter(w)
+	for _, family := range families {
+		family.write(out)
+	}
+	return out.Flush()
+}
+
+func (f *metricFamily) write(out *bufio.Writer) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeMetricHelp(f.help), f.name, f.kind)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(out, "%s%s %s\n", f.name, f.formatLabels(series.labelValues, ""), formatMetricValue(series.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, f.formatLabels(series.labelValues, formatMetricValue(bound)), series.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, f.formatLabels(series.labelValues, "+Inf"), series.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", f.name, f.formatLabels(series.labelValues, ""), formatMetricValue(series.value))
+		fmt.Fprintf(out, "%s_count%s %d\n", f.name, f.formatLabels(series.labelValues, ""), series.count)
+	}
+}
+
+// formatLabels formats label pairs, with the le label of a histogram bucket
+// if given
+func (f *metricFamily) formatLabels(values []string, le string) string {
+	var pairs []string
+	for i, label := range f.labels {
+		pairs = append(pairs, label+`="`+escapeMetricLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatMetricValue formats a sample value as Prometheus expects it
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	metricHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	metricLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeMetricHelp(help string) string {
+	return metricHelpEscaper.Replace(help)
+}
+
+func escapeMetricLabel(value string) string {
+	return metricLabelEscaper.Replace(value)
+}
+
+// InstrumentClient returns a copy of client that records the latency and
+// result of its requests as those of an upstream service. Transport errors
+// and server errors count as errors.
+func InstrumentClient(client *http.Client, service string) *http.Client {
+	instrumented := *client
+	instrumented.Transport = &metricsTransport{base: client.Transport, service: service}
+	return &instrumented
+}
+
+// metricsTransport records metrics of the requests of an upstream service
+type metricsTransport struct {
+	base    http.RoundTripper
+	service string
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	UpstreamDuration.Observe(time.Since(start).Seconds(), t.service)
+
+	result := "ok"
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		result = "error"
+	}
+	UpstreamRequests.Inc(t.service, result)
+	return resp, err
+}
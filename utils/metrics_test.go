@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsWritePrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	counter := metrics.NewCounter("test_messages_total", "Messages received.", "channel")
+	gauge := metrics.NewGauge("test_queue_depth", "Items waiting.")
+	histogram := metrics.NewHistogram("test_duration_seconds", "Latency.", []float64{0.5, 1}, "service")
+
+	counter.Inc("sms")
+	counter.Add(2, "sms")
+	counter.Inc(`say "hi"`)
+	gauge.Set(4)
+	histogram.Observe(0.25, "search")
+	histogram.Observe(0.75, "search")
+	histogram.Observe(3, "search")
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatalf("Error writing metrics: %v", err)
+	}
+
+	expected := `# HELP test_messages_total Messages received.
+# TYPE test_messages_total counter
+test_messages_total{channel="say \"hi\""} 1
+test_messages_total{channel="sms"} 3
+# HELP test_queue_depth Items waiting.
+# TYPE test_queue_depth gauge
+test_queue_depth 4
+# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{service="search",le="0.5"} 1
+test_duration_seconds_bucket{service="search",le="1"} 2
+test_duration_seconds_bucket{service="search",le="+Inf"} 3
+test_duration_seconds_sum{service="search"} 4
+test_duration_seconds_count{service="search"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected metrics:\n%s", buf.String())
+	}
+}
+
+func TestInstrumentClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	client := InstrumentClient(server.Client(), "instrument-test")
+	for _, path := range []string{"/", "/missing", "/fail"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Error requesting %s: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	var buf bytes.Buffer
+	DefaultMetrics.WritePrometheus(&buf)
+	for _, line := range []string{
+		`neo146_upstream_requests_total{service="instrument-test",result="ok"} 2`,
+		`neo146_upstream_requests_total{service="instrument-test",result="error"} 1`,
+		`neo146_upstream_request_duration_seconds_count{service="instrument-test"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %q in metrics", line)
+		}
+	}
+}
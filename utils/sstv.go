@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// SSTV tone frequencies in Hz
+const (
+	sstvSync  = 1200.0
+	sstvBlack = 1500.0
+	sstvWhite = 2300.0
+	// sstvLeader is the calibration tone of the VIS header
+	sstvLeader = 1900.0
+	// sstvVISOne and sstvVISZero encode the bits of the VIS code
+	sstvVISOne  = 1100.0
+	sstvVISZero = 1300.0
+)
+
+// SSTVMode is an SSTV transmission mode
+type SSTVMode struct {
+	Name string
+	// VIS is the code that identifies the mode to receivers
+	VIS    byte
+	Width  int
+	Height int
+	// encode sends the scan lines of an image of the mode's size
+	encode func(t *sstvTones, img *image.RGBA)
+}
+
+// SSTVRobot36 sends 320x240 color in YUV in about 36 seconds, with the two
+// color differences sent on alternate lines
+var SSTVRobot36 = &SSTVMode{Name: "robot36", VIS: 8, Width: 320, Height: 240, encode: encodeRobot36}
+
+// SSTVMartinM1 sends 320x256 in GBR in about 114 seconds, for a better
+// picture on weak signals
+var SSTVMartinM1 = &SSTVMode{Name: "martin1", VIS: 44, Width: 320, Height: 256, encode: encodeMartinM1}
+
+// SSTVModeByName returns the mode with the given name, case-insensitively.
+// Martin M1 is also accepted as "m1".
+func SSTVModeByName(name string) (*SSTVMode, bool) {
+	switch strings.ToLower(name) {
+	case "robot36", "r36":
+		return SSTVRobot36, true
+	case "martin1", "martinm1", "m1":
+		return SSTVMartinM1, true
+	}
+	return nil, false
+}
+
+// SSTVEncode generates the SSTV audio of an image, including the VIS header.
+// Images of another size are scaled to fit the mode, keeping their aspect
+// ratio on a black background.
+func SSTVEncode(img image.Image, mode *SSTVMode, sampleRate int) []int16 {
+	t := &sstvTones{sampleRate: float64(sampleRate)}
+
+	// VIS header: leader, break, leader, start bit, 7 data bits from the
+	// least significant, even parity and stop bit
+	t.tone(sstvLeader, 300)
+	t.tone(sstvSync, 10)
+	t.tone(sstvLeader, 300)
+	t.tone(sstvSync, 30)
+	parity := 0
+	for i := 0; i < 7; i++ {
+		if mode.VIS>>i&1 == 1 {
+			t.tone(sstvVISOne, 30)
+			parity++
+		} else {
+			t.tone(sstvVISZero, 30)
+		}
+	}
+	if parity%2 == 1 {
+		t.tone(sstvVISOne, 30)
+	} else {
+		t.tone(sstvVISZero, 30)
+	}
+	t.tone(sstvSync, 30)
+
+	mode.encode(t, FitImage(img, mode.Width, mode.Height))
+	return t.samples
+}
+
+// FitImage scales an image to fit width x height, keeping its aspect ratio
+// and centering it on a black background
+func FitImage(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return dst
+	}
+	w, h := width, bounds.Dy()*width/bounds.Dx()
+	if h > height {
+		w, h = bounds.Dx()*height/bounds.Dy(), height
+	}
+	x, y := (width-w)/2, (height-h)/2
+	draw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// encodeRobot36 sends a line of luminance followed by one of the color
+// differences, R-Y on even lines and B-Y on odd lines, each averaged over
+// the pair of lines
+func encodeRobot36(t *sstvTones, img *image.RGBA) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	ys := make([]float64, width)
+	chroma := make([]float64, width)
+
+	for y := 0; y < height; y++ {
+		pair := y &^ 1
+		for x := 0; x < width; x++ {
+			luma, _, _ := sstvYUV(img.RGBAAt(x, y))
+			ys[x] = luma
+
+			_, u0, v0 := sstvYUV(img.RGBAAt(x, pair))
+			_, u1, v1 := sstvYUV(img.RGBAAt(x, min(pair+1, height-1)))
+			if y%2 == 0 {
+				chroma[x] = (v0 + v1) / 2
+			} else {
+				chroma[x] = (u0 + u1) / 2
+			}
+		}
+
+		t.tone(sstvSync, 9)
+		t.tone(sstvBlack, 3)
+		t.scan(ys, 88)
+		if y%2 == 0 {
+			t.tone(sstvBlack, 4.5)
+		} else {
+			t.tone(sstvWhite, 4.5)
+		}
+		t.tone(sstvLeader, 1.5)
+		t.scan(chroma, 44)
+	}
+}
+
+// encodeMartinM1 sends the green, blue and red components of each line
+func encodeMartinM1(t *sstvTones, img *image.RGBA) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	channels := [3][]float64{make([]float64, width), make([]float64, width), make([]float64, width)}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.RGBAAt(x, y)
+			channels[0][x] = float64(c.G)
+			channels[1][x] = float64(c.B)
+			channels[2][x] = float64(c.R)
+		}
+
+		t.tone(sstvSync, 4.862)
+		t.tone(sstvBlack, 0.572)
+		for _, values := range channels {
+			t.scan(values, 146.432)
+			t.tone(sstvBlack, 0.572)
+		}
+	}
+}
+
+// sstvYUV converts a color to the luminance and color differences of
+// ITU-R BT.601 in studio range, as used by Robot modes
+func sstvYUV(c color.RGBA) (y, u, v float64) {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	y = 16 + (65.738*r+129.057*g+25.064*b)/256
+	u = 128 + (-37.945*r-74.494*g+112.439*b)/256
+	v = 128 + (112.439*r-94.154*g-18.285*b)/256
+	return y, u, v
+}
+
+// sstvTones generates continuous-phase audio. Durations are accumulated
+// exactly, as receivers rely on the line timing to keep the picture
+// straight.
+type sstvTones struct {
+	samples    []int16
+	sampleRate float64
+	phase      float64
+	// elapsed is the time sent so far in milliseconds
+	elapsed float64
+}
+
+// tone sends a frequency for a duration in milliseconds
+func (t *sstvTones) tone(frequency, duration float64) {
+	t.elapsed += duration
+	step := 2 * math.Pi * frequency / t.sampleRate
+	for end := t.elapsed * t.sampleRate / 1000; float64(len(t.samples)) < end; {
+		t.samples = append(t.samples, int16(afskAmplitude*math.Sin(t.phase)))
+		t.phase = math.Mod(t.phase+step, 2*math.Pi)
+	}
+}
+
+// scan sends pixel values from 0 (black) to 255 (white) evenly over a
+// duration in milliseconds
+func (t *sstvTones) scan(values []float64, duration float64) {
+	pixel := duration / float64(len(values))
+	for _, value := range values {
+		value = math.Max(0, math.Min(255, value))
+		t.tone(sstvBlack+value*(sstvWhite-sstvBlack)/255, pixel)
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// measureFrequency estimates the frequency of audio between two times in
+// milliseconds by counting zero crossings
+func measureFrequency(samples []int16, sampleRate int, from, to float64) float64 {
+	start, end := int(from*float64(sampleRate)/1000), int(to*float64(sampleRate)/1000)
+	crossings := 0
+	first, last := -1, -1
+	for i := start + 1; i < end; i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			crossings++
+		}
+	}
+	if crossings < 2 {
+		return 0
+	}
+	return float64(crossings-1) / 2 / (float64(last-first) / float64(sampleRate))
+}
+
+func solidImage(width, height int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestSSTVEncode(t *testing.T) {
+	const sampleRate = 44100
+	testCases := []struct {
+		mode     *SSTVMode
+		duration float64
+	}{
+		{SSTVRobot36, 910 + 240*150},
+		{SSTVMartinM1, 910 + 256*446.446},
+	}
+
+	for _, tc := range testCases {
+		samples := SSTVEncode(solidImage(64, 64, color.RGBA{255, 0, 0, 255}), tc.mode, sampleRate)
+		if got := float64(len(samples)) * 1000 / sampleRate; math.Abs(got-tc.duration) > 1 {
+			t.Errorf("%s: expected %.0f ms of audio, got %.0f ms", tc.mode.Name, tc.duration, got)
+		}
+
+		// Read the VIS code back from the start bit on
+		if f := measureFrequency(samples, sampleRate, 612, 638); math.Abs(f-sstvSync) > 20 {
+			t.Errorf("%s: expected start bit at 1200 Hz, got %.0f Hz", tc.mode.Name, f)
+		}
+		var vis byte
+		for i := 0; i < 7; i++ {
+			from := 640 + 30*float64(i)
+			if measureFrequency(samples, sampleRate, from+2, from+28) < 1200 {
+				vis |= 1 << i
+			}
+		}
+		if vis != tc.mode.VIS {
+			t.Errorf("%s: expected VIS code %d, got %d", tc.mode.Name, tc.mode.VIS, vis)
+		}
+	}
+}
+
+func TestSSTVMartinM1Colors(t *testing.T) {
+	const sampleRate = 44100
+	samples := SSTVEncode(solidImage(320, 256, color.RGBA{255, 0, 0, 255}), SSTVMartinM1, sampleRate)
+
+	// Green, blue and red scans of the second line
+	line := 910 + 446.446 + 4.862 + 0.572
+	for i, want := range []float64{sstvBlack, sstvBlack, sstvWhite} {
+		from := line + float64(i)*147.004
+		if f := measureFrequency(samples, sampleRate, from+5, from+140); math.Abs(f-want) > 20 {
+			t.Errorf("Scan %d: expected %.0f Hz, got %.0f Hz", i, want, f)
+		}
+	}
+}
+
+func TestSSTVRobot36Colors(t *testing.T) {
+	const sampleRate = 44100
+	samples := SSTVEncode(solidImage(320, 240, color.White), SSTVRobot36, sampleRate)
+
+	// White has full luminance and no color difference
+	line := 910 + 150 + 12.0
+	if f := measureFrequency(samples, sampleRate, line+5, line+83); math.Abs(f-2237) > 20 {
+		t.Errorf("Expected luminance at 2237 Hz, got %.0f Hz", f)
+	}
+	if f := measureFrequency(samples, sampleRate, line+88, line+92); math.Abs(f-sstvWhite) > 60 {
+		t.Errorf("Expected odd line separator at 2300 Hz, got %.0f Hz", f)
+	}
+	if f := measureFrequency(samples, sampleRate, line+96, line+136); math.Abs(f-1901) > 20 {
+		t.Errorf("Expected B-Y at 1901 Hz, got %.0f Hz", f)
+	}
+}
+
+func TestFitImage(t *testing.T) {
+	fitted := FitImage(solidImage(100, 50, color.RGBA{0, 0, 255, 255}), 320, 256)
+	if c := fitted.RGBAAt(160, 10); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("Expected black bars above a wide image, got %v", c)
+	}
+	if c := fitted.RGBAAt(160, 128); c.B < 250 || c.R > 5 {
+		t.Errorf("Expected the image in the middle, got %v", c)
+	}
+	if _, ok := SSTVModeByName("M1"); !ok {
+		t.Errorf("Expected M1 to name Martin M1")
+	}
+}
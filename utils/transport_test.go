@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+		"255.255.255.255":  false,
+	} {
+		if IsPublicIP(net.ParseIP(address)) != expected {
+			t.Errorf("IsPublicIP(%s) = %t, expected %t", address, !expected, expected)
+		}
+	}
+}
+
+func TestNewPublicTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewPublicTransport()}
+	_, err := client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected loopback address to be refused, got: %v", err)
+	}
+}
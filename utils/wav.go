@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// WAV format codes
+const (
+	wavFormatPCM        = 1
+	wavFormatExtensible = 0xfffe
+)
+
+// ReadWAV decodes a PCM WAV file with 8 or 16 bits per sample. It returns
+// the samples of the first channel and the sample rate.
+func ReadWAV(data []byte) ([]int16, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var channels, bitsPerSample, blockAlign int
+	sampleRate := 0
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		offset += 8
+		if size > len(data)-offset {
+			// Streamed files may leave the size of the data chunk unset
+			size = len(data) - offset
+		}
+		chunk := data[offset : offset+size]
+
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, errors.New("invalid WAV format chunk")
+			}
+			format := binary.LittleEndian.Uint16(chunk)
+			if format != wavFormatPCM && format != wavFormatExtensible {
+				return nil, 0, fmt.Errorf("unsupported WAV format %d, expected PCM", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			sampleRate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			blockAlign = int(binary.LittleEndian.Uint16(chunk[12:]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(chunk[14:]))
+		case "data":
+			if sampleRate == 0 || channels == 0 {
+				return nil, 0, errors.New("WAV data before format chunk")
+			}
+			if bitsPerSample != 8 && bitsPerSample != 16 {
+				return nil, 0, fmt.Errorf("unsupported WAV sample size of %d bits", bitsPerSample)
+			}
+			if blockAlign < channels*bitsPerSample/8 {
+				blockAlign = channels * bitsPerSample / 8
+			}
+
+			samples := make([]int16, 0, len(chunk)/blockAlign)
+			for i := 0; i+blockAlign <= len(chunk); i += blockAlign {
+				if bitsPerSample == 8 {
+					// 8-bit samples are unsigned
+					samples = append(samples, int16(int(chunk[i])-128)<<8)
+				} else {
+					samples = append(samples, int16(binary.LittleEndian.Uint16(chunk[i:])))
+				}
+			}
+			return samples, sampleRate, nil
+		}
+
+		// Chunks are padded to an even size
+		offset += size + size%2
+	}
+
+	return nil, 0, errors.New("WAV file has no data")
+}
+
+// WriteWAV encodes samples as a mono 16-bit PCM WAV file
+func WriteWAV(samples []int16, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+
+	data := make([]byte, 0, 44+dataSize)
+	data = append(data, "RIFF"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(36+dataSize))
+	data = append(data, "WAVEfmt "...)
+	data = binary.LittleEndian.AppendUint32(data, 16)
+	data = binary.LittleEndian.AppendUint16(data, wavFormatPCM)
+	data = binary.LittleEndian.AppendUint16(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, uint32(sampleRate))
+	data = binary.LittleEndian.AppendUint32(data, uint32(sampleRate*2))
+	data = binary.LittleEndian.AppendUint16(data, 2)
+	data = binary.LittleEndian.AppendUint16(data, 16)
+	data = append(data, "data"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(dataSize))
+	for _, sample := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(sample))
+	}
+	return data
+}
+
+// Resample converts audio between sample rates by linear interpolation.
+// It does not filter, so it is meant for speech going to a similar or
+// lower rate.
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || from <= 0 || to <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	out := make([]int16, int(int64(len(samples))*int64(to)/int64(from)))
+	step := float64(from) / float64(to)
+	for i := range out {
+		position := float64(i) * step
+		j := int(position)
+		if j+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		fraction := position - float64(j)
+		out[i] = int16(float64(samples[j])*(1-fraction) + float64(samples[j+1])*fraction)
+	}
+	return out
+}